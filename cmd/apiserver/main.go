@@ -27,7 +27,7 @@ import (
 
 func main() {
 	builder := builder.NewServerBuilder().
-		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithResourceStorageFromFlags(&corev1alpha1.Manifest{}).
 		WithOpenAPIDefinitions("tilt", "0.1.0", tiltopenapi.GetOpenAPIDefinitions)
 
 	err := builder.ExecuteCommand()
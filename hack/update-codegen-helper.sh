@@ -33,6 +33,16 @@ kube::codegen::gen_helpers \
   --boilerplate "${SCRIPT_ROOT}"/hack/boilerplate.go.txt \
   ./pkg/apis
 
+# the request types of the subresources of filepath storage
+kube::codegen::gen_helpers \
+  --boilerplate "${SCRIPT_ROOT}"/hack/boilerplate.go.txt \
+  ./pkg/storage/filepath
+
+# the resources that the builder tests serve
+kube::codegen::gen_helpers \
+  --boilerplate "${SCRIPT_ROOT}"/hack/boilerplate.go.txt \
+  ./pkg/server/builder/internal
+
 rm -fR pkg/generated
 mkdir -p pkg/generated
 kube::codegen::gen_client \
@@ -53,6 +63,16 @@ kube::codegen::gen_openapi \
   --boilerplate "${SCRIPT_ROOT}"/hack/openapi-boilerplate.go.txt \
   ./pkg/apis
 
+mkdir -p pkg/server/builder/internal/testapi/openapi
+kube::codegen::gen_openapi \
+  --output-pkg github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/openapi \
+  --output-dir ./pkg/server/builder/internal/testapi/openapi \
+  --output-model-name-file zz_generated.model_name.go \
+  --report-filename "${SCRIPT_ROOT}"/hack/testapi_violations.list \
+  --update-report \
+  --boilerplate "${SCRIPT_ROOT}"/hack/openapi-boilerplate.go.txt \
+  ./pkg/server/builder/internal/testapi
+
 USER_ID=$(id -u)
 
 # uid = 0 means we're running in docker desktop with
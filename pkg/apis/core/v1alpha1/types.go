@@ -60,6 +60,7 @@ var _ resource.Object = &Manifest{}
 var _ resourcestrategy.Validater = &Manifest{}
 var _ resourcerest.ShortNamesProvider = &Manifest{}
 var _ resourcerest.SingularNameProvider = &Manifest{}
+var _ resourcestrategy.PrinterColumner = &Manifest{}
 
 func (in *Manifest) GetObjectMeta() *metav1.ObjectMeta {
 	return &in.ObjectMeta
@@ -97,6 +98,13 @@ func (in *Manifest) IsStorageVersion() bool {
 	return true
 }
 
+func (in *Manifest) PrinterColumns() []resourcestrategy.PrinterColumn {
+	return []resourcestrategy.PrinterColumn{
+		{Name: "Message", Type: "string", Description: "The message of the spec.", JSONPath: ".spec.message"},
+		{Name: "Status", Type: "string", Description: "The message of the status.", Priority: 1, JSONPath: ".status.message"},
+	}
+}
+
 func (in *Manifest) Validate(ctx context.Context) field.ErrorList {
 	// TODO(user): Modify it, adding your API validation here.
 	return nil
@@ -126,3 +134,14 @@ var _ resource.StatusSubResource = &ManifestStatus{}
 func (in ManifestStatus) CopyTo(parent resource.ObjectWithStatusSubResource) {
 	parent.(*Manifest).Status = in
 }
+
+// Manifest implements ObjectWithRollbackSubResource interface.
+var _ resource.ObjectWithRollbackSubResource = &Manifest{}
+
+func (in *Manifest) RevisionHistoryLimit() int {
+	return 10
+}
+
+func (in *Manifest) RollbackTo(revision runtime.Object) {
+	in.Spec = revision.(*Manifest).Spec
+}
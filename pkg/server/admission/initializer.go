@@ -0,0 +1,55 @@
+// Package admission has admission plugins that work with the storage of
+// tilt-apiserver, rather than with the core Kubernetes APIs that the plugins of
+// kube-apiserver depend on.
+package admission
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericadmission "k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/initializer"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Informers creates informers that read the objects of the server's resources
+// from storage.
+type Informers interface {
+	// ForResource returns the shared informer for the objects of the
+	// resource, which are of obj's type. It fails if the resource isn't served.
+	ForResource(gr schema.GroupResource, obj runtime.Object) (cache.SharedIndexInformer, error)
+
+	// OnStart calls fn when the informers are started, with a context that's
+	// canceled when the server stops.
+	OnStart(fn func(ctx context.Context))
+}
+
+// WantsInformers is implemented by plugins that watch objects.
+type WantsInformers interface {
+	SetInformers(Informers)
+	genericadmission.InitializationValidator
+}
+
+type pluginInitializer struct {
+	informers  Informers
+	authorizer authorizer.Authorizer
+}
+
+var _ genericadmission.PluginInitializer = pluginInitializer{}
+
+// NewPluginInitializer passes the server's dependencies to the plugins that
+// want them.
+func NewPluginInitializer(informers Informers, authz authorizer.Authorizer) genericadmission.PluginInitializer {
+	return pluginInitializer{informers: informers, authorizer: authz}
+}
+
+func (i pluginInitializer) Initialize(plugin genericadmission.Interface) {
+	if wants, ok := plugin.(WantsInformers); ok {
+		wants.SetInformers(i.informers)
+	}
+	if wants, ok := plugin.(initializer.WantsAuthorizer); ok {
+		wants.SetAuthorizer(i.authorizer)
+	}
+}
@@ -0,0 +1,80 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	genericadmission "k8s.io/apiserver/pkg/admission"
+)
+
+// PluginNamespaceLifecycle is the name of the NamespaceLifecycle plugin.
+const PluginNamespaceLifecycle = "NamespaceLifecycle"
+
+// NamespaceLifecycleConfig configures the NamespaceLifecycle plugin.
+type NamespaceLifecycleConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Namespaces are the namespaces that objects can be created in.
+	//
+	// If empty, objects can be created in any namespace that isn't terminating.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// TerminatingNamespaces are namespaces that objects can no longer be
+	// created in. Their existing objects can still be updated and deleted.
+	TerminatingNamespaces []string `json:"terminatingNamespaces,omitempty"`
+}
+
+// RegisterNamespaceLifecycle registers the NamespaceLifecycle plugin.
+func RegisterNamespaceLifecycle(plugins *genericadmission.Plugins) {
+	plugins.Register(PluginNamespaceLifecycle, func(config io.Reader) (genericadmission.Interface, error) {
+		c := NamespaceLifecycleConfig{}
+		if err := readConfig(PluginNamespaceLifecycle, config, &c); err != nil {
+			return nil, err
+		}
+		return NewNamespaceLifecycle(c), nil
+	})
+}
+
+// NamespaceLifecycle rejects the creation of objects in namespaces that don't
+// exist or are terminating, like the plugin of the same name in kube-apiserver.
+//
+// tilt-apiserver doesn't serve Namespace objects, so the namespaces and their
+// state come from the plugin's config. Cluster-scoped resources aren't
+// affected.
+type NamespaceLifecycle struct {
+	*genericadmission.Handler
+
+	namespaces  sets.String
+	terminating sets.String
+}
+
+var _ genericadmission.ValidationInterface = &NamespaceLifecycle{}
+
+func NewNamespaceLifecycle(config NamespaceLifecycleConfig) *NamespaceLifecycle {
+	return &NamespaceLifecycle{
+		Handler:     genericadmission.NewHandler(genericadmission.Create),
+		namespaces:  sets.NewString(config.Namespaces...),
+		terminating: sets.NewString(config.TerminatingNamespaces...),
+	}
+}
+
+func (l *NamespaceLifecycle) Validate(ctx context.Context, a genericadmission.Attributes, o genericadmission.ObjectInterfaces) error {
+	namespace := a.GetNamespace()
+	if namespace == "" {
+		return nil
+	}
+
+	if l.terminating.Has(namespace) {
+		return genericadmission.NewForbidden(a,
+			fmt.Errorf("unable to create new content in namespace %s because it is being terminated", namespace))
+	}
+	if l.namespaces.Len() != 0 && !l.namespaces.Has(namespace) {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package admission_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericadmission "k8s.io/apiserver/pkg/admission"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/admission"
+)
+
+func TestNamespaceLifecycle(t *testing.T) {
+	plugin := admission.NewNamespaceLifecycle(admission.NamespaceLifecycleConfig{
+		Namespaces:            []string{"default", "old"},
+		TerminatingNamespaces: []string{"old"},
+	})
+
+	validate := func(namespace string) error {
+		a := genericadmission.NewAttributesRecord(nil, nil,
+			schema.GroupVersionKind{Group: "core.tilt.dev", Version: "v1alpha1", Kind: "Manifest"},
+			namespace, "my-manifest",
+			schema.GroupVersionResource{Group: "core.tilt.dev", Version: "v1alpha1", Resource: "manifests"},
+			"", genericadmission.Create, nil, false, nil)
+		return plugin.Validate(context.Background(), a, nil)
+	}
+
+	assert.NoError(t, validate("default"))
+	assert.NoError(t, validate(""))
+
+	err := validate("old")
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+
+	err = validate("missing")
+	assert.True(t, apierrors.IsNotFound(err), "expected not found, got %v", err)
+}
@@ -0,0 +1,31 @@
+package admission
+
+import (
+	"fmt"
+	"io"
+
+	genericadmission "k8s.io/apiserver/pkg/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// RegisterAllAdmissionPlugins registers the plugins of this package.
+func RegisterAllAdmissionPlugins(plugins *genericadmission.Plugins) {
+	RegisterNamespaceLifecycle(plugins)
+	RegisterValidatingAdmissionPolicy(plugins)
+	RegisterResourceQuota(plugins)
+}
+
+// readConfig decodes the YAML or JSON config of a plugin, if it has any.
+func readConfig(name string, config io.Reader, into interface{}) error {
+	if config == nil {
+		return nil
+	}
+	data, err := io.ReadAll(config)
+	if err != nil {
+		return fmt.Errorf("reading %s config: %v", name, err)
+	}
+	if err := yaml.UnmarshalStrict(data, into); err != nil {
+		return fmt.Errorf("decoding %s config: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	genericadmission "k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/initializer"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/generic"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/matching"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/validating"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/matchconditions"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/cel/environment"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// PluginValidatingAdmissionPolicy is the name of the ValidatingAdmissionPolicy plugin.
+const PluginValidatingAdmissionPolicy = validating.PluginName
+
+var (
+	policiesResource = admissionregistrationv1.SchemeGroupVersion.WithResource("validatingadmissionpolicies").GroupResource()
+	bindingsResource = admissionregistrationv1.SchemeGroupVersion.WithResource("validatingadmissionpolicybindings").GroupResource()
+)
+
+// RegisterValidatingAdmissionPolicy registers the ValidatingAdmissionPolicy plugin.
+func RegisterValidatingAdmissionPolicy(plugins *genericadmission.Plugins) {
+	plugins.Register(PluginValidatingAdmissionPolicy, func(config io.Reader) (genericadmission.Interface, error) {
+		return NewValidatingAdmissionPolicy(), nil
+	})
+}
+
+// ValidatingAdmissionPolicy enforces the CEL rules of the
+// ValidatingAdmissionPolicy objects that are bound by
+// ValidatingAdmissionPolicyBinding objects, like the plugin of the same name in
+// kube-apiserver.
+//
+// Policies and bindings are read from the server's own storage, so they must be
+// served, e.g. with the builder's WithValidatingAdmissionPolicy. Policies with
+// params aren't supported. tilt-apiserver doesn't serve Namespace objects, so
+// the namespace of a request only has the kubernetes.io/metadata.name label.
+//
+// Policies take effect about a second after they're created or changed.
+type ValidatingAdmissionPolicy struct {
+	*genericadmission.Handler
+
+	informers  Informers
+	authorizer authorizer.Authorizer
+	source     generic.Source[validating.PolicyHook]
+	dispatcher generic.Dispatcher[validating.PolicyHook]
+}
+
+var _ genericadmission.ValidationInterface = &ValidatingAdmissionPolicy{}
+var _ WantsInformers = &ValidatingAdmissionPolicy{}
+var _ initializer.WantsAuthorizer = &ValidatingAdmissionPolicy{}
+
+func NewValidatingAdmissionPolicy() *ValidatingAdmissionPolicy {
+	return &ValidatingAdmissionPolicy{
+		Handler: genericadmission.NewHandler(
+			genericadmission.Connect, genericadmission.Create, genericadmission.Delete, genericadmission.Update),
+	}
+}
+
+func (p *ValidatingAdmissionPolicy) SetInformers(informers Informers) {
+	p.informers = informers
+}
+
+func (p *ValidatingAdmissionPolicy) SetAuthorizer(authz authorizer.Authorizer) {
+	p.authorizer = authz
+}
+
+// ValidateInitialization creates the informers of policies and bindings, which
+// start with the server.
+func (p *ValidatingAdmissionPolicy) ValidateInitialization() error {
+	if p.informers == nil {
+		return fmt.Errorf("missing informers")
+	}
+	if p.authorizer == nil {
+		return fmt.Errorf("missing authorizer")
+	}
+
+	policyInformer, err := p.informers.ForResource(policiesResource, &admissionregistrationv1.ValidatingAdmissionPolicy{})
+	if err != nil {
+		return fmt.Errorf("%s: %v", PluginValidatingAdmissionPolicy, err)
+	}
+	bindingInformer, err := p.informers.ForResource(bindingsResource, &admissionregistrationv1.ValidatingAdmissionPolicyBinding{})
+	if err != nil {
+		return fmt.Errorf("%s: %v", PluginValidatingAdmissionPolicy, err)
+	}
+
+	// without params, the informer factory and dynamic client aren't used, and
+	// the empty RESTMapper fails to find param kinds instead of panicking
+	p.source = generic.NewPolicySource(
+		policyInformer,
+		bindingInformer,
+		validating.NewValidatingAdmissionPolicyAccessor,
+		validating.NewValidatingAdmissionPolicyBindingAccessor,
+		compilePolicy,
+		nil,
+		nil,
+		meta.NewDefaultRESTMapper(nil))
+	p.dispatcher = validating.NewDispatcher(p.authorizer,
+		generic.NewPolicyMatcher(matching.NewMatcher(namespaceLister{}, nil)))
+
+	source, dispatcher := p.source, p.dispatcher
+	p.informers.OnStart(func(ctx context.Context) {
+		if err := dispatcher.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			utilruntime.HandleError(fmt.Errorf("policy dispatcher stopped: %w", err))
+		}
+		if err := source.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			utilruntime.HandleError(fmt.Errorf("policy source stopped: %w", err))
+		}
+	})
+	p.SetReadyFunc(source.HasSynced)
+	return nil
+}
+
+func (p *ValidatingAdmissionPolicy) Validate(ctx context.Context, a genericadmission.Attributes, o genericadmission.ObjectInterfaces) error {
+	// policies don't apply to policies and bindings, so they can always be fixed
+	gr := a.GetResource().GroupResource()
+	if gr == policiesResource || gr == bindingsResource {
+		return nil
+	}
+	if !p.WaitForReady() {
+		return genericadmission.NewForbidden(a, fmt.Errorf("not yet ready to handle request"))
+	}
+	return p.dispatcher.Dispatch(ctx, a, o, p.source.Hooks())
+}
+
+// compilePolicy compiles the CEL expressions of the policy, like the upstream
+// plugin does for policies without params.
+func compilePolicy(policy *admissionregistrationv1.ValidatingAdmissionPolicy) validating.Validator {
+	compiler, err := newPolicyCompiler()
+	if err != nil {
+		// the base environment is static, so this can't fail at runtime
+		panic(err)
+	}
+	optionalVars := plugincel.OptionalVariableDeclarations{HasParams: false, HasAuthorizer: true}
+	messageOptionalVars := plugincel.OptionalVariableDeclarations{HasParams: false, HasAuthorizer: false}
+	mode := environment.StoredExpressions
+
+	spec := policy.Spec
+	compiler.CompileAndStoreVariables(policyVariables(spec.Variables), optionalVars, mode)
+
+	var matcher matchconditions.Matcher
+	if len(spec.MatchConditions) > 0 {
+		matcher = matchconditions.NewMatcher(
+			compiler.CompileCondition(matchConditions(spec.MatchConditions), optionalVars, mode),
+			spec.FailurePolicy, "policy", "validate", policy.Name)
+	}
+	return validating.NewValidator(
+		compiler.CompileCondition(validationConditions(spec.Validations), optionalVars, mode),
+		matcher,
+		compiler.CompileCondition(auditAnnotationConditions(spec.AuditAnnotations), optionalVars, mode),
+		compiler.CompileCondition(messageExpressionConditions(spec.Validations), messageOptionalVars, mode),
+		spec.FailurePolicy)
+}
+
+func newPolicyCompiler() (*plugincel.CompositedCompiler, error) {
+	return plugincel.NewCompositedCompiler(environment.MustBaseEnvSet(environment.DefaultCompatibilityVersion()))
+}
+
+func policyVariables(variables []admissionregistrationv1.Variable) []plugincel.NamedExpressionAccessor {
+	result := make([]plugincel.NamedExpressionAccessor, len(variables))
+	for i, v := range variables {
+		result[i] = &validating.Variable{Name: v.Name, Expression: v.Expression}
+	}
+	return result
+}
+
+func matchConditions(conditions []admissionregistrationv1.MatchCondition) []plugincel.ExpressionAccessor {
+	result := make([]plugincel.ExpressionAccessor, len(conditions))
+	for i := range conditions {
+		result[i] = (*matchconditions.MatchCondition)(&conditions[i])
+	}
+	return result
+}
+
+func validationConditions(validations []admissionregistrationv1.Validation) []plugincel.ExpressionAccessor {
+	result := make([]plugincel.ExpressionAccessor, len(validations))
+	for i, v := range validations {
+		result[i] = &validating.ValidationCondition{Expression: v.Expression, Message: v.Message, Reason: v.Reason}
+	}
+	return result
+}
+
+// messageExpressionConditions returns nil accessors for validations without a
+// message expression, so that the results line up with the validations.
+func messageExpressionConditions(validations []admissionregistrationv1.Validation) []plugincel.ExpressionAccessor {
+	result := make([]plugincel.ExpressionAccessor, len(validations))
+	for i, v := range validations {
+		if v.MessageExpression != "" {
+			result[i] = &validating.MessageExpressionCondition{MessageExpression: v.MessageExpression}
+		}
+	}
+	return result
+}
+
+func auditAnnotationConditions(annotations []admissionregistrationv1.AuditAnnotation) []plugincel.ExpressionAccessor {
+	result := make([]plugincel.ExpressionAccessor, len(annotations))
+	for i, a := range annotations {
+		result[i] = &validating.AuditAnnotationCondition{Key: a.Key, ValueExpression: a.ValueExpression}
+	}
+	return result
+}
+
+// namespaceLister gets a namespace of any name, since tilt-apiserver doesn't
+// serve Namespace objects. Like in kube-apiserver, namespaces have the
+// kubernetes.io/metadata.name label, so policies can select them by name.
+type namespaceLister struct{}
+
+var _ corelisters.NamespaceLister = namespaceLister{}
+
+func (namespaceLister) List(selector labels.Selector) ([]*corev1.Namespace, error) {
+	return nil, nil
+}
+
+func (namespaceLister) Get(name string) (*corev1.Namespace, error) {
+	return &corev1.Namespace{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{corev1.LabelMetadataName: name},
+		},
+		Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+	}, nil
+}
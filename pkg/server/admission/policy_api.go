@@ -0,0 +1,372 @@
+package admission
+
+import (
+	"context"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/validating"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/matchconditions"
+	"k8s.io/apiserver/pkg/cel/environment"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+)
+
+// ValidatingAdmissionPolicyResource registers the validatingadmissionpolicies
+// resource of admissionregistration.k8s.io/v1 with the builder. Its objects
+// are ValidatingAdmissionPolicy objects of k8s.io/api.
+type ValidatingAdmissionPolicyResource struct {
+	admissionregistrationv1.ValidatingAdmissionPolicy
+}
+
+var _ resource.Object = &ValidatingAdmissionPolicyResource{}
+
+func (in *ValidatingAdmissionPolicyResource) GetObjectMeta() *metav1.ObjectMeta {
+	return &in.ObjectMeta
+}
+
+func (in *ValidatingAdmissionPolicyResource) NamespaceScoped() bool {
+	return false
+}
+
+func (in *ValidatingAdmissionPolicyResource) New() runtime.Object {
+	return &admissionregistrationv1.ValidatingAdmissionPolicy{}
+}
+
+func (in *ValidatingAdmissionPolicyResource) NewList() runtime.Object {
+	return &admissionregistrationv1.ValidatingAdmissionPolicyList{}
+}
+
+func (in *ValidatingAdmissionPolicyResource) GetSingularName() string {
+	return "validatingadmissionpolicy"
+}
+
+func (in *ValidatingAdmissionPolicyResource) GetGroupVersionResource() schema.GroupVersionResource {
+	return admissionregistrationv1.SchemeGroupVersion.WithResource(policiesResource.Resource)
+}
+
+func (in *ValidatingAdmissionPolicyResource) IsStorageVersion() bool {
+	return true
+}
+
+// ValidatingAdmissionPolicyBindingResource registers the
+// validatingadmissionpolicybindings resource of admissionregistration.k8s.io/v1
+// with the builder. Its objects are ValidatingAdmissionPolicyBinding objects of
+// k8s.io/api.
+type ValidatingAdmissionPolicyBindingResource struct {
+	admissionregistrationv1.ValidatingAdmissionPolicyBinding
+}
+
+var _ resource.Object = &ValidatingAdmissionPolicyBindingResource{}
+
+func (in *ValidatingAdmissionPolicyBindingResource) GetObjectMeta() *metav1.ObjectMeta {
+	return &in.ObjectMeta
+}
+
+func (in *ValidatingAdmissionPolicyBindingResource) NamespaceScoped() bool {
+	return false
+}
+
+func (in *ValidatingAdmissionPolicyBindingResource) New() runtime.Object {
+	return &admissionregistrationv1.ValidatingAdmissionPolicyBinding{}
+}
+
+func (in *ValidatingAdmissionPolicyBindingResource) NewList() runtime.Object {
+	return &admissionregistrationv1.ValidatingAdmissionPolicyBindingList{}
+}
+
+func (in *ValidatingAdmissionPolicyBindingResource) GetSingularName() string {
+	return "validatingadmissionpolicybinding"
+}
+
+func (in *ValidatingAdmissionPolicyBindingResource) GetGroupVersionResource() schema.GroupVersionResource {
+	return admissionregistrationv1.SchemeGroupVersion.WithResource(bindingsResource.Resource)
+}
+
+func (in *ValidatingAdmissionPolicyBindingResource) IsStorageVersion() bool {
+	return true
+}
+
+// AddPolicyDefaultsToScheme registers the defaults that kube-apiserver sets on
+// policies and bindings, e.g. the failure policy and empty selectors.
+func AddPolicyDefaultsToScheme(s *runtime.Scheme) error {
+	s.AddTypeDefaultingFunc(&admissionregistrationv1.ValidatingAdmissionPolicy{}, func(obj interface{}) {
+		policy := obj.(*admissionregistrationv1.ValidatingAdmissionPolicy)
+		if policy.Spec.FailurePolicy == nil {
+			fail := admissionregistrationv1.Fail
+			policy.Spec.FailurePolicy = &fail
+		}
+		setMatchResourcesDefaults(policy.Spec.MatchConstraints)
+	})
+	s.AddTypeDefaultingFunc(&admissionregistrationv1.ValidatingAdmissionPolicyBinding{}, func(obj interface{}) {
+		binding := obj.(*admissionregistrationv1.ValidatingAdmissionPolicyBinding)
+		setMatchResourcesDefaults(binding.Spec.MatchResources)
+	})
+	return nil
+}
+
+func setMatchResourcesDefaults(m *admissionregistrationv1.MatchResources) {
+	if m == nil {
+		return
+	}
+	if m.MatchPolicy == nil {
+		equivalent := admissionregistrationv1.Equivalent
+		m.MatchPolicy = &equivalent
+	}
+	if m.NamespaceSelector == nil {
+		m.NamespaceSelector = &metav1.LabelSelector{}
+	}
+	if m.ObjectSelector == nil {
+		m.ObjectSelector = &metav1.LabelSelector{}
+	}
+	for _, rules := range [][]admissionregistrationv1.NamedRuleWithOperations{m.ResourceRules, m.ExcludeResourceRules} {
+		for i := range rules {
+			if rules[i].Scope == nil {
+				all := admissionregistrationv1.AllScopes
+				rules[i].Scope = &all
+			}
+		}
+	}
+}
+
+// PolicyStrategy validates policies and bindings on create and update, on top
+// of the default strategy. Its signature matches the builder's WithStrategy.
+//
+// Policies must match resources and compile. Params aren't supported.
+func PolicyStrategy(defaultStrategy rest.Strategy) rest.Strategy {
+	return policyStrategy{Strategy: defaultStrategy}
+}
+
+type policyStrategy struct {
+	rest.Strategy
+}
+
+func (s policyStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	switch obj := obj.(type) {
+	case *admissionregistrationv1.ValidatingAdmissionPolicy:
+		return validatePolicy(obj)
+	case *admissionregistrationv1.ValidatingAdmissionPolicyBinding:
+		return validateBinding(obj)
+	}
+	return s.Strategy.Validate(ctx, obj)
+}
+
+func (s policyStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return s.Validate(ctx, obj)
+}
+
+func validatePolicy(policy *admissionregistrationv1.ValidatingAdmissionPolicy) field.ErrorList {
+	errs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+	spec := policy.Spec
+
+	if spec.ParamKind != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("paramKind"), "params are not supported"))
+	}
+	if spec.MatchConstraints == nil {
+		errs = append(errs, field.Required(specPath.Child("matchConstraints"), ""))
+	} else if len(spec.MatchConstraints.ResourceRules) == 0 {
+		errs = append(errs, field.Required(specPath.Child("matchConstraints", "resourceRules"), ""))
+	}
+	if spec.FailurePolicy != nil {
+		supported := sets.NewString(string(admissionregistrationv1.Fail), string(admissionregistrationv1.Ignore))
+		if !supported.Has(string(*spec.FailurePolicy)) {
+			errs = append(errs, field.NotSupported(specPath.Child("failurePolicy"), *spec.FailurePolicy, supported.List()))
+		}
+	}
+	if len(spec.Validations) == 0 && len(spec.AuditAnnotations) == 0 {
+		errs = append(errs, field.Required(specPath.Child("validations"), "validations or auditAnnotations must contain at least one item"))
+	}
+
+	return append(errs, validatePolicyExpressions(policy, specPath)...)
+}
+
+// validatePolicyExpressions checks that the CEL expressions of the policy
+// compile, with the variables declared before them.
+func validatePolicyExpressions(policy *admissionregistrationv1.ValidatingAdmissionPolicy, specPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	compiler, err := newPolicyCompiler()
+	if err != nil {
+		return append(errs, field.InternalError(specPath, err))
+	}
+	optionalVars := plugincel.OptionalVariableDeclarations{HasParams: false, HasAuthorizer: true}
+	messageOptionalVars := plugincel.OptionalVariableDeclarations{HasParams: false, HasAuthorizer: false}
+	mode := environment.NewExpressions
+
+	compile := func(path *field.Path, accessor plugincel.ExpressionAccessor, vars plugincel.OptionalVariableDeclarations) {
+		if accessor.GetExpression() == "" {
+			errs = append(errs, field.Required(path, ""))
+			return
+		}
+		result := compiler.CompileCELExpression(accessor, vars, mode)
+		if result.Error != nil {
+			errs = append(errs, field.Invalid(path, accessor.GetExpression(), result.Error.Detail))
+		}
+	}
+
+	spec := policy.Spec
+	for i, v := range spec.Variables {
+		path := specPath.Child("variables").Index(i)
+		if v.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		}
+		variable := &validating.Variable{Name: v.Name, Expression: v.Expression}
+		compile(path.Child("expression"), variable, optionalVars)
+		compiler.CompileAndStoreVariable(variable, optionalVars, mode)
+	}
+	for i := range spec.MatchConditions {
+		path := specPath.Child("matchConditions").Index(i)
+		compile(path.Child("expression"), (*matchconditions.MatchCondition)(&spec.MatchConditions[i]), optionalVars)
+	}
+	for i, v := range spec.Validations {
+		path := specPath.Child("validations").Index(i)
+		compile(path.Child("expression"),
+			&validating.ValidationCondition{Expression: v.Expression, Message: v.Message, Reason: v.Reason}, optionalVars)
+		if v.MessageExpression != "" {
+			compile(path.Child("messageExpression"),
+				&validating.MessageExpressionCondition{MessageExpression: v.MessageExpression}, messageOptionalVars)
+		}
+	}
+	for i, a := range spec.AuditAnnotations {
+		path := specPath.Child("auditAnnotations").Index(i)
+		if a.Key == "" {
+			errs = append(errs, field.Required(path.Child("key"), ""))
+		}
+		compile(path.Child("valueExpression"),
+			&validating.AuditAnnotationCondition{Key: a.Key, ValueExpression: a.ValueExpression}, optionalVars)
+	}
+	return errs
+}
+
+func validateBinding(binding *admissionregistrationv1.ValidatingAdmissionPolicyBinding) field.ErrorList {
+	errs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+	spec := binding.Spec
+
+	if spec.PolicyName == "" {
+		errs = append(errs, field.Required(specPath.Child("policyName"), ""))
+	}
+	if spec.ParamRef != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("paramRef"), "params are not supported"))
+	}
+
+	actionsPath := specPath.Child("validationActions")
+	if len(spec.ValidationActions) == 0 {
+		errs = append(errs, field.Required(actionsPath, "at least one validation action is required"))
+	}
+	supported := sets.NewString(string(admissionregistrationv1.Deny), string(admissionregistrationv1.Warn), string(admissionregistrationv1.Audit))
+	actions := sets.NewString()
+	for i, action := range spec.ValidationActions {
+		if !supported.Has(string(action)) {
+			errs = append(errs, field.NotSupported(actionsPath.Index(i), action, supported.List()))
+		}
+		if actions.Has(string(action)) {
+			errs = append(errs, field.Duplicate(actionsPath.Index(i), action))
+		}
+		actions.Insert(string(action))
+	}
+	if actions.Has(string(admissionregistrationv1.Deny)) && actions.Has(string(admissionregistrationv1.Warn)) {
+		errs = append(errs, field.Invalid(actionsPath, spec.ValidationActions, "must not contain both Deny and Warn"))
+	}
+	return errs
+}
+
+// GetPolicyOpenAPIDefinitions returns the OpenAPI definitions of policies and
+// bindings, which aren't generated for the resources.
+//
+// Their specs and statuses aren't described, so clients don't validate them.
+func GetPolicyOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	return map[string]common.OpenAPIDefinition{
+		admissionregistrationv1.ValidatingAdmissionPolicy{}.OpenAPIModelName(): objectDefinition(ref,
+			"ValidatingAdmissionPolicy describes the definition of an admission validation policy that accepts or rejects an object without changing it."),
+		admissionregistrationv1.ValidatingAdmissionPolicyList{}.OpenAPIModelName(): listDefinition(ref,
+			"ValidatingAdmissionPolicyList is a list of ValidatingAdmissionPolicy.",
+			admissionregistrationv1.ValidatingAdmissionPolicy{}.OpenAPIModelName()),
+		admissionregistrationv1.ValidatingAdmissionPolicyBinding{}.OpenAPIModelName(): objectDefinition(ref,
+			"ValidatingAdmissionPolicyBinding binds the ValidatingAdmissionPolicy with paramerized resources."),
+		admissionregistrationv1.ValidatingAdmissionPolicyBindingList{}.OpenAPIModelName(): listDefinition(ref,
+			"ValidatingAdmissionPolicyBindingList is a list of ValidatingAdmissionPolicyBinding.",
+			admissionregistrationv1.ValidatingAdmissionPolicyBinding{}.OpenAPIModelName()),
+	}
+}
+
+func objectDefinition(ref common.ReferenceCallback, description string) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: description,
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind":       stringSchema(),
+					"apiVersion": stringSchema(),
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref(metav1.ObjectMeta{}.OpenAPIModelName()),
+						},
+					},
+					"spec":   unknownFieldsSchema(),
+					"status": unknownFieldsSchema(),
+				},
+			},
+		},
+		Dependencies: []string{metav1.ObjectMeta{}.OpenAPIModelName()},
+	}
+}
+
+func listDefinition(ref common.ReferenceCallback, description, itemName string) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: description,
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind":       stringSchema(),
+					"apiVersion": stringSchema(),
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref(metav1.ListMeta{}.OpenAPIModelName()),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref(itemName),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{metav1.ListMeta{}.OpenAPIModelName(), itemName},
+	}
+}
+
+func stringSchema() spec.Schema {
+	return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"string"}}}
+}
+
+func unknownFieldsSchema() spec.Schema {
+	return spec.Schema{
+		SchemaProps: spec.SchemaProps{Type: []string{"object"}},
+		VendorExtensible: spec.VendorExtensible{
+			Extensions: spec.Extensions{"x-kubernetes-preserve-unknown-fields": true},
+		},
+	}
+}
@@ -0,0 +1,220 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericadmission "k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PluginResourceQuota is the name of the ResourceQuota plugin.
+const PluginResourceQuota = "ResourceQuota"
+
+// ResourceQuotaConfig configures the ResourceQuota plugin.
+type ResourceQuotaConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Limits cap the number of objects of resources.
+	Limits []ResourceQuotaLimit `json:"limits"`
+}
+
+// ResourceQuotaLimit caps the number of objects of a resource.
+type ResourceQuotaLimit struct {
+	// Resource is the group resource, e.g. manifests.core.tilt.dev.
+	Resource string `json:"resource"`
+
+	// Namespace limits the objects in one namespace.
+	//
+	// If empty, the limit applies to each namespace separately, or to all the
+	// objects of a cluster-scoped resource.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Max is the number of objects that can exist.
+	Max int `json:"max"`
+}
+
+// RegisterResourceQuota registers the ResourceQuota plugin.
+func RegisterResourceQuota(plugins *genericadmission.Plugins) {
+	plugins.Register(PluginResourceQuota, func(config io.Reader) (genericadmission.Interface, error) {
+		c := ResourceQuotaConfig{}
+		if err := readConfig(PluginResourceQuota, config, &c); err != nil {
+			return nil, err
+		}
+		return NewResourceQuota(c)
+	})
+}
+
+// ResourceQuota rejects the creation of objects once a resource has as many as
+// its limit allows, like object count quotas in kube-apiserver.
+//
+// Objects are counted by informers, which see a new object shortly after it's
+// created. Until then, the object is counted from its admission, so creates
+// can't exceed the limit. Dry-run creates are checked against the limit, but
+// aren't counted. A deleted object is counted until the informers see it's
+// gone.
+type ResourceQuota struct {
+	*genericadmission.Handler
+
+	limits    []resourceQuotaLimit
+	informers Informers
+	listers   map[schema.GroupResource]cache.Indexer
+
+	mu sync.Mutex
+	// admitted are the creates that have been admitted, but that the informers
+	// may not have seen yet, keyed by object key.
+	admitted map[schema.GroupResource]map[string]time.Time
+}
+
+type resourceQuotaLimit struct {
+	gr        schema.GroupResource
+	namespace string
+	max       int
+}
+
+// admittedTimeout is how long an admitted create is counted if the informers
+// don't see it, e.g. because it failed after admission.
+const admittedTimeout = 5 * time.Second
+
+var _ genericadmission.ValidationInterface = &ResourceQuota{}
+var _ WantsInformers = &ResourceQuota{}
+
+func NewResourceQuota(config ResourceQuotaConfig) (*ResourceQuota, error) {
+	limits := []resourceQuotaLimit{}
+	for _, limit := range config.Limits {
+		if limit.Resource == "" {
+			return nil, fmt.Errorf("%s: limits must have a resource", PluginResourceQuota)
+		}
+		if limit.Max < 0 {
+			return nil, fmt.Errorf("%s: max of %s must not be negative", PluginResourceQuota, limit.Resource)
+		}
+		limits = append(limits, resourceQuotaLimit{
+			gr:        schema.ParseGroupResource(limit.Resource),
+			namespace: limit.Namespace,
+			max:       limit.Max,
+		})
+	}
+	return &ResourceQuota{
+		Handler:  genericadmission.NewHandler(genericadmission.Create),
+		limits:   limits,
+		listers:  map[schema.GroupResource]cache.Indexer{},
+		admitted: map[schema.GroupResource]map[string]time.Time{},
+	}, nil
+}
+
+func (q *ResourceQuota) SetInformers(informers Informers) {
+	q.informers = informers
+}
+
+// ValidateInitialization creates the informers of the limited resources, which
+// start with the server.
+func (q *ResourceQuota) ValidateInitialization() error {
+	if q.informers == nil {
+		return fmt.Errorf("missing informers")
+	}
+
+	synced := []cache.InformerSynced{}
+	for _, limit := range q.limits {
+		if _, ok := q.listers[limit.gr]; ok {
+			continue
+		}
+		// objects are only counted, so the informer doesn't need their type
+		informer, err := q.informers.ForResource(limit.gr, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %v", PluginResourceQuota, err)
+		}
+		q.listers[limit.gr] = informer.GetIndexer()
+		synced = append(synced, informer.HasSynced)
+	}
+	q.SetReadyFunc(func() bool {
+		for _, hasSynced := range synced {
+			if !hasSynced() {
+				return false
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+func (q *ResourceQuota) Validate(ctx context.Context, a genericadmission.Attributes, o genericadmission.ObjectInterfaces) error {
+	// creates of subresources, e.g. actions, don't create objects
+	if a.GetSubresource() != "" {
+		return nil
+	}
+
+	gr := a.GetResource().GroupResource()
+	namespace := a.GetNamespace()
+	limits := []resourceQuotaLimit{}
+	for _, limit := range q.limits {
+		if limit.gr == gr && (limit.namespace == "" || limit.namespace == namespace) {
+			limits = append(limits, limit)
+		}
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+	if !q.WaitForReady() {
+		return genericadmission.NewForbidden(a, fmt.Errorf("not yet ready to handle request"))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	used := q.count(gr, namespace)
+	for _, limit := range limits {
+		if used >= limit.max {
+			return genericadmission.NewForbidden(a,
+				fmt.Errorf("exceeded quota: count/%s, used: %d, limited: %d", gr, used, limit.max))
+		}
+	}
+
+	if a.IsDryRun() {
+		return nil
+	}
+
+	// objects are validated after their names are generated, so the name of
+	// the object is the one the informers will see
+	name := a.GetName()
+	if name == "" {
+		accessor, err := meta.Accessor(a.GetObject())
+		if err != nil || accessor.GetName() == "" {
+			return nil
+		}
+		name = accessor.GetName()
+	}
+	if q.admitted[gr] == nil {
+		q.admitted[gr] = map[string]time.Time{}
+	}
+	q.admitted[gr][cache.NewObjectName(namespace, name).String()] = time.Now().Add(admittedTimeout)
+	return nil
+}
+
+// count counts the objects of the resource in the namespace, or all of them if
+// the namespace is empty, including the admitted creates that the informers
+// haven't seen yet. It must be called with the lock held.
+func (q *ResourceQuota) count(gr schema.GroupResource, namespace string) int {
+	lister := q.listers[gr]
+	keys := lister.ListKeys()
+	if namespace != "" {
+		keys, _ = lister.IndexKeys(cache.NamespaceIndex, namespace)
+	}
+	used := len(keys)
+
+	now := time.Now()
+	for key, expires := range q.admitted[gr] {
+		if _, exists, _ := lister.GetByKey(key); exists || now.After(expires) {
+			delete(q.admitted[gr], key)
+			continue
+		}
+		if ns, _, _ := cache.SplitMetaNamespaceKey(key); namespace == "" || ns == namespace {
+			used++
+		}
+	}
+	return used
+}
@@ -0,0 +1,88 @@
+package admission_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	genericadmission "k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/admission"
+)
+
+func TestResourceQuotaCountsAdmittedCreates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	plugin, err := admission.NewResourceQuota(admission.ResourceQuotaConfig{
+		Limits: []admission.ResourceQuotaLimit{{Resource: "manifests.core.tilt.dev", Max: 1}},
+	})
+	require.NoError(t, err)
+	informers := newFakeInformers(ctx)
+	plugin.SetInformers(informers)
+	require.NoError(t, plugin.ValidateInitialization())
+
+	validate := func(name, generatedName string, dryRun bool) error {
+		obj := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: generatedName}}
+		a := genericadmission.NewAttributesRecord(obj, nil,
+			schema.GroupVersionKind{Group: "core.tilt.dev", Version: "v1alpha1", Kind: "Manifest"},
+			"", name,
+			schema.GroupVersionResource{Group: "core.tilt.dev", Version: "v1alpha1", Resource: "manifests"},
+			"", genericadmission.Create, nil, dryRun, nil)
+		return plugin.Validate(ctx, a, nil)
+	}
+
+	// dry-run creates aren't counted
+	require.NoError(t, validate("manifest-1", "manifest-1", true))
+	require.NoError(t, validate("manifest-2", "manifest-2", true))
+
+	// a create with a generated name is counted once the informer sees it
+	require.NoError(t, validate("", "manifest-abcde", false))
+	require.NoError(t, informers.indexer.Add(&metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{Name: "manifest-abcde"},
+	}))
+	err = validate("manifest-3", "manifest-3", false)
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+	assert.Contains(t, err.Error(), "used: 1, limited: 1")
+}
+
+// fakeInformers serves one running informer that never lists any objects, so
+// that tests can add them to its indexer.
+type fakeInformers struct {
+	informer cache.SharedIndexInformer
+	indexer  cache.Indexer
+}
+
+func newFakeInformers(ctx context.Context) *fakeInformers {
+	informer := cache.NewSharedIndexInformer(emptyListerWatcher{&cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return &metav1.PartialObjectMetadataList{}, nil
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		},
+	}}, &metav1.PartialObjectMetadata{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	go informer.RunWithContext(ctx)
+	return &fakeInformers{informer: informer, indexer: informer.GetIndexer()}
+}
+
+func (i *fakeInformers) ForResource(gr schema.GroupResource, obj runtime.Object) (cache.SharedIndexInformer, error) {
+	return i.informer, nil
+}
+
+func (i *fakeInformers) OnStart(fn func(ctx context.Context)) {}
+
+// emptyListerWatcher makes reflectors list and watch, since its watches don't
+// send the bookmarks of the WatchList protocol.
+type emptyListerWatcher struct {
+	*cache.ListWatch
+}
+
+func (emptyListerWatcher) IsWatchListSemanticsUnSupported() bool { return true }
@@ -17,6 +17,8 @@ limitations under the License.
 package apiserver
 
 import (
+	"net/http"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -60,6 +62,13 @@ type ExtraConfig struct {
 	ServingInfo    *genericapiserver.SecureServingInfo
 	Version        *version.Info
 	ParameterCodec runtime.ParameterCodec
+
+	// Informers, if set, get the storage of the server's resources, and are
+	// started when the server starts.
+	Informers *Informers
+
+	// NonResourceHandlers serves custom endpoints, keyed by path.
+	NonResourceHandlers map[string]http.Handler
 }
 
 // Config defines the config for the apiserver
@@ -113,6 +122,20 @@ func (c completedConfig) New() (*TiltServer, error) {
 		if err := s.GenericAPIServer.InstallAPIGroup(apiGroup); err != nil {
 			return nil, err
 		}
+		if m := s.GenericAPIServer.AggregatedDiscoveryGroupManager; m != nil {
+			setVersionPriorities(m, apiGroup)
+		}
+	}
+	if informers := c.ExtraConfig.Informers; informers != nil {
+		informers.addStorage(apiGroups)
+		s.GenericAPIServer.AddPostStartHookOrDie("start-storage-informers", func(ctx genericapiserver.PostStartHookContext) error {
+			informers.start(ctx.Context)
+			return nil
+		})
+	}
+
+	for path, handler := range c.ExtraConfig.NonResourceHandlers {
+		s.GenericAPIServer.Handler.NonGoRestfulMux.Handle(path, handler)
 	}
 
 	return s, nil
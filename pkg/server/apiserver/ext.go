@@ -23,11 +23,15 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/sets"
+	discoveryendpoint "k8s.io/apiserver/pkg/endpoints/discovery/aggregated"
 	genericregistry "k8s.io/apiserver/pkg/registry/generic"
 	"k8s.io/apiserver/pkg/registry/rest"
 	pkgserver "k8s.io/apiserver/pkg/server"
 )
 
+// The group priority that aggregated discovery gives to groups by default.
+const defaultGroupPriorityMinimum = 1000
+
 type StorageProvider func(s *runtime.Scheme, g genericregistry.RESTOptionsGetter) (rest.Storage, error)
 
 func buildAPIGroupInfos(scheme *runtime.Scheme,
@@ -71,7 +75,62 @@ func buildAPIGroupInfos(scheme *runtime.Scheme,
 		}
 		apiGroupInfo := pkgserver.NewDefaultAPIGroupInfo(group, scheme, parameterCodec, codecs)
 		apiGroupInfo.VersionedResourcesStorageMap = apis
+		if !marshalsProtobuf(apis) {
+			// Clients that prefer protobuf, like most controllers, fall back
+			// to JSON instead of failing with 406 Not Acceptable.
+			apiGroupInfo.NegotiatedSerializer = withoutMediaType{
+				NegotiatedSerializer: apiGroupInfo.NegotiatedSerializer,
+				mediaType:            runtime.ContentTypeProtobuf,
+			}
+		}
 		apiGroups = append(apiGroups, &apiGroupInfo)
 	}
 	return apiGroups, nil
 }
+
+// setVersionPriorities makes aggregated discovery list the versions of the API
+// group in the order of the scheme's version priority. Otherwise, it lists
+// them in the order of Kubernetes version precedence.
+func setVersionPriorities(m discoveryendpoint.ResourceManager, apiGroupInfo *pkgserver.APIGroupInfo) {
+	for i, gv := range apiGroupInfo.PrioritizedVersions {
+		m.SetGroupVersionPriority(metav1.GroupVersion{Group: gv.Group, Version: gv.Version},
+			defaultGroupPriorityMinimum, len(apiGroupInfo.PrioritizedVersions)-i)
+	}
+}
+
+// marshalsProtobuf returns true if the objects of every resource in the API
+// group can be encoded as protobuf, i.e. their types have generated protobuf
+// marshalling.
+func marshalsProtobuf(apis map[string]map[string]rest.Storage) bool {
+	for _, resources := range apis {
+		for _, storage := range resources {
+			objs := []runtime.Object{storage.New()}
+			if lister, ok := storage.(rest.Lister); ok {
+				objs = append(objs, lister.NewList())
+			}
+			for _, obj := range objs {
+				if _, ok := obj.(interface{ Marshal() ([]byte, error) }); !ok {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// withoutMediaType is a serializer that doesn't negotiate one of the media
+// types of another.
+type withoutMediaType struct {
+	runtime.NegotiatedSerializer
+	mediaType string
+}
+
+func (s withoutMediaType) SupportedMediaTypes() []runtime.SerializerInfo {
+	result := []runtime.SerializerInfo{}
+	for _, info := range s.NegotiatedSerializer.SupportedMediaTypes() {
+		if info.MediaType != s.mediaType {
+			result = append(result, info)
+		}
+	}
+	return result
+}
@@ -0,0 +1,208 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	pkgserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Informers creates informers that list and watch the objects of the server's
+// resources in storage, rather than through the API.
+//
+// Informers can be created before the server, e.g. while admission plugins are
+// initialized. They're started when the server starts, and stop when it stops.
+type Informers struct {
+	mu        sync.Mutex
+	resources map[schema.GroupResource]bool
+	storage   map[schema.GroupResource]rest.Storage
+	informers map[schema.GroupResource]cache.SharedIndexInformer
+	onStart   []func(ctx context.Context)
+
+	// ctx is set when the informers are started.
+	ctx context.Context
+}
+
+// NewInformers creates informers for the resources of apis.
+func NewInformers(apis map[schema.GroupVersionResource]StorageProvider) *Informers {
+	resources := map[schema.GroupResource]bool{}
+	for gvr := range apis {
+		if !strings.Contains(gvr.Resource, "/") {
+			resources[gvr.GroupResource()] = true
+		}
+	}
+	return &Informers{
+		resources: resources,
+		storage:   map[schema.GroupResource]rest.Storage{},
+		informers: map[schema.GroupResource]cache.SharedIndexInformer{},
+	}
+}
+
+// ForResource returns the shared informer for the objects of the resource,
+// which are of obj's type.
+func (i *Informers) ForResource(gr schema.GroupResource, obj runtime.Object) (cache.SharedIndexInformer, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.resources[gr] {
+		return nil, fmt.Errorf("resource %s is not served", gr)
+	}
+	if informer, ok := i.informers[gr]; ok {
+		return informer, nil
+	}
+
+	informer := cache.NewSharedIndexInformer(&storageListerWatcher{informers: i, gr: gr}, obj, 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	i.informers[gr] = informer
+	if i.ctx != nil {
+		go informer.Run(i.ctx.Done())
+	}
+	return informer, nil
+}
+
+// OnStart calls fn when the informers are started, with a context that's
+// canceled when the server stops. If they're already started, fn is called
+// right away.
+func (i *Informers) OnStart(fn func(ctx context.Context)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.ctx != nil {
+		go fn(i.ctx)
+		return
+	}
+	i.onStart = append(i.onStart, fn)
+}
+
+// addStorage adds the storage of the resources of the API groups.
+func (i *Informers) addStorage(apiGroups []*pkgserver.APIGroupInfo) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for gr, storage := range resourceStorage(apiGroups) {
+		i.storage[gr] = storage
+	}
+}
+
+// start runs the informers until ctx is done.
+func (i *Informers) start(ctx context.Context) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.ctx != nil {
+		return
+	}
+	i.ctx = ctx
+	for _, informer := range i.informers {
+		go informer.Run(ctx.Done())
+	}
+	for _, fn := range i.onStart {
+		go fn(ctx)
+	}
+	i.onStart = nil
+}
+
+// resourceStorage returns the storage of the resources of the API groups,
+// without their subresources. Resources served in several versions share their
+// storage, so any version will do.
+func resourceStorage(apiGroups []*pkgserver.APIGroupInfo) map[schema.GroupResource]rest.Storage {
+	result := map[schema.GroupResource]rest.Storage{}
+	for _, apiGroup := range apiGroups {
+		if len(apiGroup.PrioritizedVersions) == 0 {
+			continue
+		}
+		group := apiGroup.PrioritizedVersions[0].Group
+		for _, resources := range apiGroup.VersionedResourcesStorageMap {
+			for resource, storage := range resources {
+				if strings.Contains(resource, "/") {
+					continue
+				}
+				result[schema.GroupResource{Group: group, Resource: resource}] = storage
+			}
+		}
+	}
+	return result
+}
+
+func (i *Informers) storageFor(gr schema.GroupResource) (rest.Storage, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	storage, ok := i.storage[gr]
+	if !ok {
+		return nil, fmt.Errorf("no storage for %s", gr)
+	}
+	return storage, nil
+}
+
+// storageListerWatcher lists and watches a resource in storage, in all
+// namespaces.
+type storageListerWatcher struct {
+	informers *Informers
+	gr        schema.GroupResource
+}
+
+var _ cache.ListerWatcher = &storageListerWatcher{}
+
+func (lw *storageListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	storage, err := lw.informers.storageFor(lw.gr)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := storage.(rest.Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage for %s can't list", lw.gr)
+	}
+	internalOptions, err := toInternalListOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return lister.List(genericapirequest.WithNamespace(context.Background(), ""), internalOptions)
+}
+
+func (lw *storageListerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	storage, err := lw.informers.storageFor(lw.gr)
+	if err != nil {
+		return nil, err
+	}
+	watcher, ok := storage.(rest.Watcher)
+	if !ok {
+		return nil, fmt.Errorf("storage for %s can't watch", lw.gr)
+	}
+	internalOptions, err := toInternalListOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	w, err := watcher.Watch(genericapirequest.WithNamespace(context.Background(), ""), internalOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// watch events may wrap objects to cache their serialization
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		if co, ok := in.Object.(runtime.CacheableObject); ok {
+			in.Object = co.GetObject()
+		}
+		return in, true
+	}), nil
+}
+
+// IsWatchListSemanticsUnSupported makes reflectors list and watch, since the
+// storage doesn't send the bookmarks of the WatchList protocol.
+func (lw *storageListerWatcher) IsWatchListSemanticsUnSupported() bool {
+	return true
+}
+
+func toInternalListOptions(options metav1.ListOptions) (*metainternalversion.ListOptions, error) {
+	out := &metainternalversion.ListOptions{}
+	err := metainternalversion.Convert_v1_ListOptions_To_internalversion_ListOptions(&options, out, nil)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,155 @@
+package builder_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/generated/clientset/versioned"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	testapiv1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/v1alpha1"
+)
+
+func TestActionSubResource(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Button{}, "data"))
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	client := dc.Resource((&testapiv1alpha1.Button{}).GetGroupVersionResource())
+
+	_, err = client.Create(f.ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1alpha1",
+		"kind":       "Button",
+		"metadata":   map[string]interface{}{"name": "my-button"},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	watcher, err := client.Watch(f.ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	defer watcher.Stop()
+	events := make(chan watch.Event, 10)
+	go func() {
+		for e := range watcher.ResultChan() {
+			events <- e
+		}
+	}()
+
+	click := func(config *rest.Config, name, reason string) (*testapiv1alpha1.ButtonClickResult, error) {
+		client, err := versioned.NewForConfig(config)
+		require.NoError(t, err)
+		body, err := json.Marshal(map[string]interface{}{
+			"apiVersion": "core.tilt.dev/v1alpha1",
+			"kind":       "ButtonClick",
+			"reason":     reason,
+		})
+		require.NoError(t, err)
+		out, err := client.CoreV1alpha1().RESTClient().Post().
+			Resource("buttons").
+			Name(name).
+			SubResource("click").
+			Body(body).
+			DoRaw(f.ctx)
+		if err != nil {
+			return nil, err
+		}
+		result := &testapiv1alpha1.ButtonClickResult{}
+		require.NoError(t, json.Unmarshal(out, result))
+		return result, nil
+	}
+
+	config := f.config.GenericConfig.LoopbackClientConfig
+	result, err := click(config, "my-button", "first")
+	require.NoError(t, err)
+	assert.Equal(t, "ButtonClickResult", result.Kind)
+	assert.Equal(t, int32(1), result.Clicks)
+	result, err = click(config, "my-button", "second")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), result.Clicks)
+
+	obj, err := client.Get(f.ctx, "my-button", metav1.GetOptions{})
+	require.NoError(t, err)
+	clicks, _, _ := unstructured.NestedInt64(obj.Object, "spec", "clicks")
+	reason, _, _ := unstructured.NestedString(obj.Object, "spec", "lastReason")
+	assert.Equal(t, int64(2), clicks)
+	assert.Equal(t, "second", reason)
+
+	// watchers of the button see each click
+	for i := int64(0); i <= 2; i++ {
+		select {
+		case e := <-events:
+			clicks, _, _ := unstructured.NestedInt64(e.Object.(*unstructured.Unstructured).Object, "spec", "clicks")
+			assert.Equal(t, i, clicks)
+		case <-f.ctx.Done():
+			t.Fatal("context canceled")
+		}
+	}
+
+	// handler errors are returned to the client
+	_, err = click(config, "my-button", "")
+	assert.True(t, apierrors.IsBadRequest(err), "expected bad request, got %v", err)
+
+	_, err = click(config, "missing-button", "first")
+	assert.True(t, apierrors.IsNotFound(err), "expected not found, got %v", err)
+
+	// actions are authorized like any other request
+	badConfig := rest.CopyConfig(config)
+	badConfig.BearerToken = "bad-bearer-token"
+	_, err = click(badConfig, "my-button", "first")
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+}
+
+func TestActionSubResourceAdmitted(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Button{}, "data").
+		WithAdmissionPlugin("ReasonValidator", func(io.Reader) (admission.Interface, error) {
+			return reasonValidator{Handler: admission.NewHandler(admission.Update)}, nil
+		}))
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	_, err = dc.Resource((&testapiv1alpha1.Button{}).GetGroupVersionResource()).Create(f.ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1alpha1",
+		"kind":       "Button",
+		"metadata":   map[string]interface{}{"name": "my-button"},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// the update of the button is admitted as an update of the button
+	err = f.client.CoreV1alpha1().RESTClient().Post().
+		Resource("buttons").
+		Name("my-button").
+		SubResource("click").
+		Body([]byte(`{"apiVersion":"core.tilt.dev/v1alpha1","kind":"ButtonClick","reason":"forbidden"}`)).
+		Do(f.ctx).
+		Error()
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+}
+
+// reasonValidator forbids updates of buttons that were last clicked for a
+// forbidden reason.
+type reasonValidator struct {
+	*admission.Handler
+}
+
+func (v reasonValidator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	button, ok := a.GetObject().(*testapiv1alpha1.Button)
+	if ok && a.GetSubresource() == "" && button.Spec.LastReason == "forbidden" {
+		return admission.NewForbidden(a, fmt.Errorf("reason is forbidden"))
+	}
+	return nil
+}
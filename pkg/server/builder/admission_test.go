@@ -0,0 +1,161 @@
+package builder_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/admission"
+
+	corev1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	tiltadmission "github.com/tilt-dev/tilt-apiserver/pkg/server/admission"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestAdmissionPlugin(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithAdmissionPlugin("Labeler", newLabeler).
+		WithAdmissionPluginConfig("Labeler", []byte("owner-team")))
+	defer f.tearDown()
+
+	manifests := f.client.CoreV1alpha1().Manifests()
+	obj, err := manifests.Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "hello"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "owner-team", obj.Labels["owner"])
+
+	_, err = manifests.Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-manifest"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "forbidden"},
+	}, metav1.CreateOptions{})
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+
+	// validation runs on updates too
+	obj.Spec.Message = "forbidden"
+	_, err = manifests.Update(f.ctx, obj, metav1.UpdateOptions{})
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+}
+
+func TestAdmissionPluginInTransaction(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithTransactions().
+		WithAdmissionPlugin("Labeler", newLabeler).
+		WithAdmissionPluginConfig("Labeler", []byte("owner-team")))
+	defer f.tearDown()
+
+	gr := (&corev1alpha1.Manifest{}).GetGroupVersionResource().GroupResource()
+	restClient := f.client.CoreV1alpha1().RESTClient()
+	allowed, err := filepath.NewCreateOperation(gr, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "hello"},
+	})
+	require.NoError(t, err)
+	_, err = filepath.CommitTransaction(f.ctx, restClient, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{allowed},
+	})
+	require.NoError(t, err)
+
+	obj, err := f.client.CoreV1alpha1().Manifests().Get(f.ctx, "my-manifest", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "owner-team", obj.Labels["owner"])
+
+	obj.Spec.Message = "forbidden"
+	forbidden, err := filepath.NewUpdateOperation(gr, obj)
+	require.NoError(t, err)
+	_, err = filepath.CommitTransaction(f.ctx, restClient, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{forbidden},
+	})
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+}
+
+func TestResourceQuota(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithAdmissionPluginsEnabled(tiltadmission.PluginResourceQuota).
+		WithAdmissionPluginConfig(tiltadmission.PluginResourceQuota, []byte(`
+limits:
+- resource: manifests.core.tilt.dev
+  max: 2
+`)))
+	defer f.tearDown()
+
+	manifests := f.client.CoreV1alpha1().Manifests()
+	create := func(name string) error {
+		_, err := manifests.Create(f.ctx, &corev1alpha1.Manifest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		}, metav1.CreateOptions{})
+		return err
+	}
+
+	require.NoError(t, create("manifest-1"))
+	require.NoError(t, create("manifest-2"))
+	err := create("manifest-3")
+	assert.True(t, apierrors.IsForbidden(err), "expected forbidden, got %v", err)
+	assert.Contains(t, err.Error(), "exceeded quota: count/manifests.core.tilt.dev, used: 2, limited: 2")
+
+	// deleted objects are counted until the informer sees they're gone
+	require.NoError(t, manifests.Delete(f.ctx, "manifest-1", metav1.DeleteOptions{}))
+	require.Eventually(t, func() bool {
+		return create("manifest-3") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestUnknownAdmissionPlugin(t *testing.T) {
+	_, err := builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithAdmissionPluginsEnabled("NoSuchPlugin").
+		ToServerOptions()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown admission plugin: NoSuchPlugin")
+}
+
+// labeler labels manifests with their owner, and forbids forbidden messages.
+type labeler struct {
+	*admission.Handler
+	owner string
+}
+
+var _ admission.MutationInterface = &labeler{}
+var _ admission.ValidationInterface = &labeler{}
+
+func newLabeler(config io.Reader) (admission.Interface, error) {
+	owner, err := io.ReadAll(config)
+	if err != nil {
+		return nil, err
+	}
+	return &labeler{
+		Handler: admission.NewHandler(admission.Create, admission.Update),
+		owner:   string(owner),
+	}, nil
+}
+
+func (l *labeler) Admit(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	manifest, ok := a.GetObject().(*corev1alpha1.Manifest)
+	if !ok || a.GetOperation() != admission.Create {
+		return nil
+	}
+	if manifest.Labels == nil {
+		manifest.Labels = map[string]string{}
+	}
+	manifest.Labels["owner"] = l.owner
+	return nil
+}
+
+func (l *labeler) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	manifest, ok := a.GetObject().(*corev1alpha1.Manifest)
+	if ok && manifest.Spec.Message == "forbidden" {
+		return admission.NewForbidden(a, fmt.Errorf("message is forbidden"))
+	}
+	return nil
+}
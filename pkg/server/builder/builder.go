@@ -20,9 +20,13 @@ import (
 	"flag"
 	"io"
 	"net"
+	"net/http"
 	"os"
 
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/apiserver"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource/resourcestrategy"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/options"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/start"
 	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
@@ -31,7 +35,9 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/registry/generic"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	openapicommon "k8s.io/kube-openapi/pkg/common"
 )
 
 // NewServerBuilder builds an apiserver to server Kubernetes resources and sub resources.
@@ -62,16 +68,30 @@ func NewServerBuilder() *Server {
 	openapiScheme := apiserver.NewScheme()
 
 	return &Server{
-		stdout:        os.Stdout,
-		stderr:        os.Stderr,
-		apiScheme:     apiScheme,
-		openapiScheme: openapiScheme,
-		codecs:        serializer.NewCodecFactory(apiScheme),
-		storage:       map[schema.GroupResource]*singletonProvider{},
-		apis:          map[schema.GroupVersionResource]apiserver.StorageProvider{},
+		stdout:              os.Stdout,
+		stderr:              os.Stderr,
+		apiScheme:           apiScheme,
+		openapiScheme:       openapiScheme,
+		codecs:              serializer.NewCodecFactory(apiScheme),
+		storage:             map[schema.GroupResource]*singletonProvider{},
+		histories:           map[schema.GroupResource]*filepath.RevisionHistory{},
+		logStores:           map[schema.GroupResource]*filepath.LogStore{},
+		storageAdmission:    filepath.NewAdmission(apiScheme),
+		printerColumns:      map[string][]resourcestrategy.PrinterColumn{},
+		validationRules:     map[string][]resourcestrategy.ValidationRule{},
+		typeValidators:      map[string]*typeValidators{},
+		resources:           map[schema.GroupVersionResource]resource.Object{},
+		patcher:             rest.NewStrategicMergePatcher(),
+		versions:            map[schema.GroupResource]*resourceVersions{},
+		preferredVersions:   map[string]string{},
+		storageCodecs:       map[schema.GroupResource]runtime.Codec{},
+		apis:                map[schema.GroupVersionResource]apiserver.StorageProvider{},
+		nonResourceHandlers: map[string]http.Handler{},
 		serving: &options.SecureServingOptions{
 			BindAddress: net.ParseIP("127.0.0.1"),
 		},
+		storageFlags: options.NewStorageOptions(),
+		admission:    options.NewAdmissionOptions(),
 	}
 }
 
@@ -86,9 +106,38 @@ type Server struct {
 	openapiSchemeBuilder runtime.SchemeBuilder
 
 	codecs               serializer.CodecFactory
+	serveCBOR            bool
+	storageMediaType     string
+	storageCodec         runtime.Codec
+	storageCodecs        map[schema.GroupResource]runtime.Codec
 	recommendedConfigFns []start.RecommendedConfigFn
+	openAPIName          string
+	openAPIVersion       string
+	openAPIDefinitions   []openapicommon.GetOpenAPIDefinitions
+	generatedDefinitions openapicommon.GetOpenAPIDefinitions
+	reflectDefinitions   bool
+	getDefinitions       openapicommon.GetOpenAPIDefinitions
+	printerColumns       map[string][]resourcestrategy.PrinterColumn
+	validationRules      map[string][]resourcestrategy.ValidationRule
+	typeValidators       map[string]*typeValidators
+	schemaValidation     bool
+	resources            map[schema.GroupVersionResource]resource.Object
+	patcher              *rest.StrategicMergePatcher
+	versions             map[schema.GroupResource]*resourceVersions
+	preferredVersions    map[string]string
 	apis                 map[schema.GroupVersionResource]apiserver.StorageProvider
 	memoryFS             *filepath.MemoryFS
+	realFS               *filepath.RealFS
+	storageFlags         *options.StorageOptions
+	admission            *options.AdmissionOptions
+	storageAdmission     *filepath.Admission
+	histories            map[schema.GroupResource]*filepath.RevisionHistory
+	logStores            map[schema.GroupResource]*filepath.LogStore
+	changelog            *filepath.Changelog
+	transactor           *filepath.Transactor
+	storageMigrator      *filepath.StorageMigrator
+	nonResourceHandlers  map[string]http.Handler
+	storageDecorator     generic.StorageDecorator
 	errs                 []error
 	storage              map[schema.GroupResource]*singletonProvider
 	groupVersions        map[schema.GroupVersion]bool
@@ -98,23 +147,14 @@ type Server struct {
 }
 
 func (a *Server) buildCodec() (runtime.Codec, error) {
+	a.resolveVersions()
 	registerGroupVersions := func(scheme *runtime.Scheme) error {
-		groupVersions := make(map[string]sets.String)
-		for gvr := range a.apis {
-			if groupVersions[gvr.Group] == nil {
-				groupVersions[gvr.Group] = sets.NewString()
-			}
-			groupVersions[gvr.Group].Insert(gvr.Version)
+		groups := sets.NewString()
+		for _, gv := range a.orderedGroupVersions {
+			groups.Insert(gv.Group)
 		}
-		for g, versions := range groupVersions {
-			gvs := []schema.GroupVersion{}
-			for _, v := range versions.List() {
-				gvs = append(gvs, schema.GroupVersion{
-					Group:   g,
-					Version: v,
-				})
-			}
-			err := scheme.SetVersionPriority(gvs...)
+		for _, g := range groups.List() {
+			err := scheme.SetVersionPriority(a.versionPriority(g)...)
 			if err != nil {
 				return err
 			}
@@ -124,7 +164,7 @@ func (a *Server) buildCodec() (runtime.Codec, error) {
 		}
 		return nil
 	}
-	a.apiSchemeBuilder.Register(registerGroupVersions)
+	a.apiSchemeBuilder.Register(registerGroupVersions, a.addVersionConversions)
 	if err := a.apiSchemeBuilder.AddToScheme(a.apiScheme); err != nil {
 		return nil, err
 	}
@@ -133,6 +173,12 @@ func (a *Server) buildCodec() (runtime.Codec, error) {
 		return nil, err
 	}
 
+	a.checkRoundTrips()
+	a.compileDeprecationWarnings()
+	defs, schemaOf := a.definitions()
+	a.compileValidators(defs, schemaOf)
+	a.compileStrategicMergePatch(defs, schemaOf)
+	a.buildStorageCodec()
 	if len(a.errs) != 0 {
 		return nil, errs{list: a.errs}
 	}
@@ -140,6 +186,31 @@ func (a *Server) buildCodec() (runtime.Codec, error) {
 	return a.codecs.LegacyCodec(a.orderedGroupVersions...), nil
 }
 
+func (a *Server) newServerOptions(codec runtime.Codec) *start.TiltServerOptions {
+	// writes that storage makes outside of the generic handlers, e.g. in
+	// transactions, are authorized and admitted by the configured server
+	storageAdmission := a.storageAdmission
+	recommendedConfigFns := append([]start.RecommendedConfigFn{}, a.recommendedConfigFns...)
+	recommendedConfigFns = append(recommendedConfigFns, func(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+		storageAdmission.SetChain(config.Authorization.Authorizer, config.AdmissionControl)
+		return config
+	})
+	o := start.NewTiltServerOptions(a.stdout, a.stderr, a.apiScheme,
+		a.codecs, codec, recommendedConfigFns, a.apis, a.serving, a.connProvider)
+	o.NonResourceHandlers = a.nonResourceHandlers
+	o.StorageOptions = a.storageFlags
+	o.AdmissionOptions = a.admission
+	o.ServeCBOR = a.serveCBOR
+	if a.storageDecorator == nil {
+		if a.memoryFS == nil {
+			a.memoryFS = filepath.NewMemoryFS()
+		}
+		a.storageDecorator = filepath.NewStorageDecorator(a.memoryFS, ".")
+	}
+	o.StorageDecorator = a.storageDecorator
+	return o
+}
+
 // Builds a server options interpreter that can run the server.
 // Intended when calling the server programatically.
 func (a *Server) ToServerOptions() (*start.TiltServerOptions, error) {
@@ -147,8 +218,7 @@ func (a *Server) ToServerOptions() (*start.TiltServerOptions, error) {
 	if err != nil {
 		return nil, err
 	}
-	return start.NewTiltServerOptions(a.stdout, a.stderr, a.apiScheme,
-		a.codecs, codec, a.recommendedConfigFns, a.apis, a.serving, a.connProvider), nil
+	return a.newServerOptions(codec), nil
 }
 
 // Builds a cobra command that runs the server.
@@ -159,8 +229,7 @@ func (a *Server) ToServerCommand() (*Command, error) {
 		return nil, err
 	}
 
-	o := start.NewTiltServerOptions(a.stdout, a.stderr, a.apiScheme,
-		a.codecs, codec, a.recommendedConfigFns, a.apis, a.serving, a.connProvider)
+	o := a.newServerOptions(codec)
 	cmd := start.NewCommandStartTiltServer(o, genericapiserver.SetupSignalContext())
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
 	return cmd, nil
@@ -1,12 +1,31 @@
 package builder
 
 import (
+	"fmt"
 	"io"
+	"net/http"
+	"reflect"
+	"sort"
 
+	tiltadmission "github.com/tilt-dev/tilt-apiserver/pkg/server/admission"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/apiserver"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource/resourcestrategy"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/options"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/start"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/runtime/serializer/cbor"
+	"k8s.io/apimachinery/pkg/runtime/serializer/versioning"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/cel/openapi/resolver"
+	genericapiserver "k8s.io/apiserver/pkg/server"
 	openapicommon "k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
 )
 
 // WithBindPort registers a default port to serve on.
@@ -27,6 +46,7 @@ func (a *Server) WithConnProvider(connProvider apiserver.ConnProvider) *Server {
 }
 
 // WithOpenAPIDefinitions registers resource OpenAPI definitions generated by openapi-gen.
+// They take precedence over definitions derived with WithOpenAPIDefinitionsFromTypes.
 //
 //    export K8sAPIS=k8s.io/apimachinery/pkg/api/resource,\
 //      k8s.io/apimachinery/pkg/apis/meta/v1,\
@@ -38,10 +58,384 @@ func (a *Server) WithConnProvider(connProvider apiserver.ConnProvider) *Server {
 //      -O zz_generated.openapi --output-base ../../.. --go-header-file ./hack/boilerplate.go.txt
 func (a *Server) WithOpenAPIDefinitions(
 	name, version string, openAPI openapicommon.GetOpenAPIDefinitions) *Server {
-	a.recommendedConfigFns = append(a.recommendedConfigFns, start.SetOpenAPIDefinitionFn(a.openapiScheme, name, version, openAPI))
+	a.generatedDefinitions = openAPI
+	return a.withOpenAPIInfo(name, version)
+}
+
+// withOpenAPIInfo serves the OpenAPI definitions of the resources, with the
+// given title and version.
+func (a *Server) withOpenAPIInfo(name, version string) *Server {
+	a.openAPIName, a.openAPIVersion = name, version
+	if a.getDefinitions == nil {
+		a.getDefinitions = a.getOpenAPIDefinitions
+		a.recommendedConfigFns = append(a.recommendedConfigFns, func(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+			return start.SetOpenAPIDefinitionFn(a.openapiScheme, a.openAPIName, a.openAPIVersion, a.getDefinitions)(config)
+		})
+	}
+	return a
+}
+
+// withOpenAPIDefinitions registers definitions for types that the builder
+// adds on its own (e.g., subresource request bodies), which won't appear in
+// the definitions generated for the resources.
+func (a *Server) withOpenAPIDefinitions(defs openapicommon.GetOpenAPIDefinitions) *Server {
+	a.openAPIDefinitions = append(a.openAPIDefinitions, defs)
+	return a
+}
+
+// getOpenAPIDefinitions merges the builder's own definitions and the
+// definitions derived from the Go types into the generated ones. The generated
+// definitions take precedence.
+func (a *Server) getOpenAPIDefinitions(ref openapicommon.ReferenceCallback) map[string]openapicommon.OpenAPIDefinition {
+	result := map[string]openapicommon.OpenAPIDefinition{}
+	if a.generatedDefinitions != nil {
+		result = a.generatedDefinitions(ref)
+	}
+	for _, defs := range a.openAPIDefinitions {
+		for name, def := range defs(ref) {
+			if _, ok := result[name]; !ok {
+				result[name] = def
+			}
+		}
+	}
+	if a.reflectDefinitions {
+		for name, def := range reflectOpenAPIDefinitions(a.openapiScheme, ref) {
+			if _, ok := result[name]; !ok {
+				result[name] = def
+			}
+		}
+	}
+	for name, columns := range a.printerColumns {
+		if def, ok := result[name]; ok {
+			def.Schema.AddExtension(rest.PrinterColumnsExtension, rest.PrinterColumnsExtensionValue(columns))
+			result[name] = def
+		}
+	}
+	for name, rules := range a.validationRules {
+		if def, ok := result[name]; ok {
+			value := rest.ValidationRulesExtensionValue(rules)
+			if existing, ok := def.Schema.Extensions[rest.ValidationRulesExtension].([]interface{}); ok {
+				value = append(append([]interface{}{}, existing...), value...)
+			}
+			def.Schema.AddExtension(rest.ValidationRulesExtension, value)
+			result[name] = def
+		}
+	}
+	return result
+}
+
+// withPrinterColumns publishes the printer columns of the resource, if it
+// declares any, in its OpenAPI definition.
+func (a *Server) withPrinterColumns(obj resource.Object) *Server {
+	pc, ok := obj.(resourcestrategy.PrinterColumner)
+	if !ok {
+		return a
+	}
+	a.printerColumns[openAPIModelName(obj)] = pc.PrinterColumns()
+	return a
+}
+
+// withValidationRules publishes the CEL validation rules of the resource, if it
+// declares any, in its OpenAPI definition.
+func (a *Server) withValidationRules(obj resource.Object) *Server {
+	vr, ok := obj.(resourcestrategy.ValidationRuler)
+	if !ok {
+		return a
+	}
+	a.validationRules[openAPIModelName(obj)] = vr.ValidationRules()
+	return a
+}
+
+// validators returns the validators of the resource's schema and of its CEL
+// validation rules, which are compiled by compileValidators.
+func (a *Server) validators(obj resource.Object) *typeValidators {
+	name := openAPIModelName(obj)
+	v, ok := a.typeValidators[name]
+	if !ok {
+		v = &typeValidators{
+			schema: &rest.SchemaValidator{},
+			cel:    &rest.CELValidator{},
+		}
+		a.typeValidators[name] = v
+	}
+	return v
+}
+
+// typeValidators are the validators of the objects of a type, which are shared
+// by the strategies of the resources that store it.
+type typeValidators struct {
+	schema *rest.SchemaValidator
+	cel    *rest.CELValidator
+}
+
+// WithSchemaValidation validates resources against their OpenAPI definitions,
+// i.e. their required fields, enums, patterns, bounds and formats, and sets the
+// defaults of the definitions on unset fields. Objects are defaulted and
+// validated by the strategy of the resource, after mutating admission, before
+// the Validate and ValidateUpdate functions of the type are called.
+//
+// Objects are checked once they're decoded into their Go types, so fields that
+// aren't omitted when empty are never unset. Fields that are required or have
+// defaults should be pointers, or be omitted when empty.
+//
+// The definitions must be registered with WithOpenAPIDefinitions. Resources
+// with a strategy that doesn't embed the default strategy aren't validated.
+func (a *Server) WithSchemaValidation() *Server {
+	a.schemaValidation = true
+	return a
+}
+
+// definitions returns the OpenAPI definitions of the resources, and a function
+// that looks them up by name for resolver.PopulateRefs.
+func (a *Server) definitions() (map[string]openapicommon.OpenAPIDefinition, func(string) (*spec.Schema, bool)) {
+	var defs map[string]openapicommon.OpenAPIDefinition
+	if a.getDefinitions != nil {
+		defs = a.getDefinitions(spec.MustCreateRef)
+	}
+	return defs, func(ref string) (*spec.Schema, bool) {
+		def, ok := defs[ref]
+		if !ok {
+			return nil, false
+		}
+		s := def.Schema
+		return &s, true
+	}
+}
+
+// compileValidators compiles the validators of the resources against their
+// OpenAPI definitions. The definitions include the CEL validation rules
+// declared with resourcestrategy.ValidationRuler.
+func (a *Server) compileValidators(defs map[string]openapicommon.OpenAPIDefinition, schemaOf func(string) (*spec.Schema, bool)) {
+	names := make([]string, 0, len(a.typeValidators))
+	for name := range a.typeValidators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := defs[name]; !ok {
+			if a.schemaValidation {
+				a.errs = append(a.errs, fmt.Errorf("%s: schema validation needs the OpenAPI definition of the type, "+
+					"registered with WithOpenAPIDefinitions", name))
+			} else if len(a.validationRules[name]) > 0 {
+				a.errs = append(a.errs, fmt.Errorf("%s: validation rules need the OpenAPI definition of the type, "+
+					"registered with WithOpenAPIDefinitions", name))
+			}
+			continue
+		}
+		schema, err := resolver.PopulateRefs(schemaOf, name)
+		if err != nil {
+			a.errs = append(a.errs, fmt.Errorf("%s: %v", name, err))
+			continue
+		}
+		if a.schemaValidation {
+			a.typeValidators[name].schema.Compile(schema)
+		}
+		if err := a.typeValidators[name].cel.Compile(schema); err != nil {
+			a.errs = append(a.errs, fmt.Errorf("%s: validation rules: %v", name, err))
+		}
+	}
+}
+
+// compileStrategicMergePatch registers the OpenAPI definitions of the
+// resources with the patcher, and merges strategic merge patches with it if any
+// of them declare patch metadata that the apiserver wouldn't see.
+func (a *Server) compileStrategicMergePatch(defs map[string]openapicommon.OpenAPIDefinition, schemaOf func(string) (*spec.Schema, bool)) {
+	for gvr, obj := range a.resources {
+		name := openAPIModelName(obj)
+		if _, ok := defs[name]; !ok {
+			continue
+		}
+		schema, err := resolver.PopulateRefs(schemaOf, name)
+		if err != nil {
+			a.errs = append(a.errs, fmt.Errorf("%s: %v", name, err))
+			continue
+		}
+		a.patcher.Register(gvr, schema, obj)
+	}
+	if !a.patcher.Enabled() {
+		return
+	}
+	a.recommendedConfigFns = append(a.recommendedConfigFns, func(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+		buildHandlerChain := config.BuildHandlerChainFunc
+		config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+			return buildHandlerChain(a.patcher.WithHandler(apiHandler), c)
+		}
+		return config
+	})
+}
+
+// openAPIModelName returns the name of the OpenAPI definition that openapi-gen
+// generates for obj's type.
+func openAPIModelName(obj runtime.Object) string {
+	if n, ok := obj.(interface{ OpenAPIModelName() string }); ok {
+		return n.OpenAPIModelName()
+	}
+	t := reflect.TypeOf(obj)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// WithChangelog appends a record of every successful create, update and delete
+// of resources in file or memory storage to the changelog.
+func (a *Server) WithChangelog(changelog *filepath.Changelog) *Server {
+	a.changelog = changelog
 	return a
 }
 
+// WithCBOR serves the resources as CBOR, including watch streams, to clients
+// that ask for it. CBOR is more compact than JSON, and faster to encode and
+// decode. JSON and YAML are still served, and protobuf for API groups whose
+// types have generated protobuf marshalling.
+//
+// It sets the CBORServingAndStorage feature gate, which is process-wide.
+func (a *Server) WithCBOR() *Server {
+	a.serveCBOR = true
+	return a
+}
+
+// WithStorageMediaType stores the resources in memory storage encoded as the
+// media type, e.g. runtime.ContentTypeCBOR, whatever media types are served.
+// The default is JSON. Storing resources as protobuf needs generated protobuf
+// marshalling for their types. File storage only stores JSON, since its files
+// are .json files, so servers with resources in file storage fail to start
+// with another media type.
+//
+// Objects stored in another encoding can still be read, and are stored in the
+// media type the next time they're written.
+func (a *Server) WithStorageMediaType(mediaType string) *Server {
+	a.storageMediaType = mediaType
+	return a
+}
+
+// buildStorageCodec builds the codec that file and memory storage encode the
+// resources with. It decodes every encoding, so that the media type can be
+// changed between runs. Resources with a storage version declared with
+// WithStorageVersion get a codec of their own, which encodes them in it.
+func (a *Server) buildStorageCodec() {
+	mediaType := a.storageMediaType
+	if mediaType == "" {
+		mediaType = runtime.ContentTypeJSON
+	}
+	codecs := serializer.NewCodecFactory(a.apiScheme, serializer.WithSerializer(cbor.NewSerializerInfo))
+	info, ok := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), mediaType)
+	if !ok {
+		a.errs = append(a.errs, fmt.Errorf("unsupported storage media type %q", mediaType))
+		return
+	}
+	if mediaType == runtime.ContentTypeProtobuf {
+		for _, obj := range a.resources {
+			if _, ok := obj.New().(interface{ Marshal() ([]byte, error) }); !ok {
+				a.errs = append(a.errs, fmt.Errorf("%s: storing resources as protobuf needs generated protobuf marshalling",
+					obj.GetGroupVersionResource().GroupResource()))
+			}
+		}
+	}
+	a.storageCodec = versioning.NewDefaultingCodecForScheme(a.apiScheme, info.Serializer, codecs.UniversalDeserializer(),
+		schema.GroupVersions(a.orderedGroupVersions), runtime.InternalGroupVersioner)
+	for gr, versions := range a.versions {
+		if versions.storage == "" {
+			continue
+		}
+		a.storageCodecs[gr] = versioning.NewDefaultingCodecForScheme(a.apiScheme, info.Serializer, codecs.UniversalDeserializer(),
+			gr.WithVersion(versions.storage).GroupVersion(), runtime.InternalGroupVersioner)
+	}
+}
+
+// WithTransactions serves an endpoint at filepath.TransactionPath that commits
+// batches of operations on resources in memory storage atomically.
+//
+// See filepath.CommitTransaction for the client.
+func (a *Server) WithTransactions() *Server {
+	if a.memoryFS == nil {
+		a.memoryFS = filepath.NewMemoryFS()
+	}
+	a.transactor = filepath.NewTransactor(a.memoryFS)
+	a.nonResourceHandlers[filepath.TransactionPath] = a.transactor
+	return a
+}
+
+// WithStorageMigration serves an endpoint at filepath.StorageMigrationPath that
+// rewrites the stored objects of file or memory storage in the current storage
+// version of their resource, and reports the progress.
+//
+// Migrations run in the background, one at a time, while the server runs. If
+// migrateOnStartup is true, every resource is migrated when the server starts.
+func (a *Server) WithStorageMigration(migrateOnStartup bool) *Server {
+	a.storageMigrator = filepath.NewStorageMigrator()
+	a.nonResourceHandlers[filepath.StorageMigrationPath] = a.storageMigrator
+
+	migrator := a.storageMigrator
+	a.recommendedConfigFns = append(a.recommendedConfigFns, func(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+		config.AddPostStartHookOrDie("storage-migration", func(ctx genericapiserver.PostStartHookContext) error {
+			if migrateOnStartup {
+				if _, err := migrator.Enqueue(migrator.Resources()...); err != nil {
+					return err
+				}
+			}
+			go migrator.Run(ctx.Context)
+			return nil
+		})
+		return config
+	})
+	return a
+}
+
+// WithAdmissionPlugin registers an admission plugin, which runs on requests for
+// every resource. The factory creates the plugin from its config, which is nil
+// unless it's set with WithAdmissionPluginConfig or the
+// --admission-control-config-file flag.
+//
+// The plugin is enabled unless it's disabled with the
+// --disable-admission-plugins flag. Plugins run in the order they're
+// registered, after NamespaceLifecycle and before ResourceQuota.
+func (a *Server) WithAdmissionPlugin(name string, factory admission.Factory) *Server {
+	if err := a.admission.AddPlugin(name, factory); err != nil {
+		a.errs = append(a.errs, err)
+	}
+	return a
+}
+
+// WithAdmissionPluginsEnabled enables the named plugins, e.g. the
+// NamespaceLifecycle and ResourceQuota plugins of pkg/server/admission, unless
+// they're disabled with the --disable-admission-plugins flag.
+func (a *Server) WithAdmissionPluginsEnabled(names ...string) *Server {
+	registered := sets.NewString(a.admission.Plugins.Registered()...)
+	for _, name := range names {
+		if !registered.Has(name) {
+			a.errs = append(a.errs, fmt.Errorf("unknown admission plugin: %s", name))
+			continue
+		}
+		a.admission.EnablePlugin(name)
+	}
+	return a
+}
+
+// WithAdmissionPluginConfig sets the config of the named admission plugin,
+// unless it's configured by the --admission-control-config-file flag.
+func (a *Server) WithAdmissionPluginConfig(name string, config []byte) *Server {
+	a.admission.PluginConfig[name] = config
+	return a
+}
+
+// WithValidatingAdmissionPolicy serves the ValidatingAdmissionPolicy and
+// ValidatingAdmissionPolicyBinding resources of
+// admissionregistration.k8s.io/v1, and enables the ValidatingAdmissionPolicy
+// admission plugin that enforces them.
+//
+// Policies and bindings are stored like the resources registered with
+// WithResourceStorageFromFlags. Policies with params aren't supported.
+func (a *Server) WithValidatingAdmissionPolicy() *Server {
+	a.apiSchemeBuilder.Register(tiltadmission.AddPolicyDefaultsToScheme)
+	a.withOpenAPIDefinitions(tiltadmission.GetPolicyOpenAPIDefinitions)
+	a.WithResourceStorageFromFlags(&tiltadmission.ValidatingAdmissionPolicyResource{},
+		WithStrategy(tiltadmission.PolicyStrategy))
+	a.WithResourceStorageFromFlags(&tiltadmission.ValidatingAdmissionPolicyBindingResource{},
+		WithStrategy(tiltadmission.PolicyStrategy))
+	return a.WithAdmissionPluginsEnabled(tiltadmission.PluginValidatingAdmissionPolicy)
+}
+
 // WithOutputWriter redirects output from both stdout and stderr to a custom writer.
 func (a *Server) WithOutputWriter(out io.Writer) *Server {
 	a.stdout = out
@@ -1,26 +1,24 @@
 package builder
 
 import (
+	"fmt"
+	"reflect"
+
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/apiserver"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/options"
 	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
 )
 
 // Registers a request handler for the resource that stores it on the file system.
 func (a *Server) WithResourceFileStorage(obj resource.Object, path string) *Server {
-	fs := filepath.NewRealFS()
-	ws := filepath.NewWatchSet()
-	strategy := rest.DefaultStrategy{
-		Object:      obj,
-		ObjectTyper: a.apiScheme,
-	}
-	sp := filepath.NewJSONFilepathStorageProvider(obj, path, fs, ws, strategy)
-	a.WithResourceAndHandler(obj, sp)
-	a.withSubresources(obj, path, fs, ws, strategy, sp)
-	return a
+	return a.WithResourceStorage(obj, path, filepath.NewRealFS())
 }
 
 // Registers a request handler for the resource that stores it in memory.
@@ -28,14 +26,190 @@ func (a *Server) WithResourceMemoryStorage(obj resource.Object, path string) *Se
 	if a.memoryFS == nil {
 		a.memoryFS = filepath.NewMemoryFS()
 	}
-	ws := filepath.NewWatchSet()
-	strategy := rest.DefaultStrategy{
-		Object:      obj,
-		ObjectTyper: a.apiScheme,
+	return a.WithResourceStorage(obj, path, a.memoryFS)
+}
+
+// Registers a request handler for the resource that stores it on the file
+// system, using the given strategy instead of the default one.
+//
+// The strategy is also used to match list and watch requests, to print
+// tables for kubectl get, and for warnings on create and update. Its
+// ObjectTyper must recognize obj, e.g. a scheme that obj's API group was
+// added to.
+func (a *Server) WithResourceFileStorageAndStrategy(obj resource.Object, path string, strategy rest.Strategy) *Server {
+	return a.WithResourceStorage(obj, path, filepath.NewRealFS(), replaceStrategy(strategy))
+}
+
+// Registers a request handler for the resource that stores it in memory,
+// using the given strategy instead of the default one.
+//
+// The strategy is used the same way as by WithResourceFileStorageAndStrategy.
+func (a *Server) WithResourceMemoryStorageAndStrategy(obj resource.Object, path string, strategy rest.Strategy) *Server {
+	if a.memoryFS == nil {
+		a.memoryFS = filepath.NewMemoryFS()
+	}
+	return a.WithResourceStorage(obj, path, a.memoryFS, replaceStrategy(strategy))
+}
+
+func replaceStrategy(strategy rest.Strategy) StorageOption {
+	return WithStrategy(func(rest.Strategy) rest.Strategy {
+		return strategy
+	})
+}
+
+// StorageOption configures the storage registered by WithResourceStorage.
+type StorageOption func(*storageConfig)
+
+type storageConfig struct {
+	watchSet *filepath.WatchSet
+	strategy func(defaultStrategy rest.Strategy) rest.Strategy
+}
+
+// WithWatchSet notifies the watchers of the given WatchSet of changes to the
+// resource, instead of a WatchSet of its own.
+//
+// Watches aren't filtered by resource, so a WatchSet should only be shared by
+// storage for the same resource.
+func WithWatchSet(ws *filepath.WatchSet) StorageOption {
+	return func(c *storageConfig) {
+		c.watchSet = ws
+	}
+}
+
+// WithStrategy replaces the default strategy for the resource with the one
+// returned by fn, which typically embeds the default strategy and overrides
+// some of its methods.
+//
+// The status subresource uses the same strategy, except that updates only
+// change the status.
+func WithStrategy(fn func(defaultStrategy rest.Strategy) rest.Strategy) StorageOption {
+	return func(c *storageConfig) {
+		c.strategy = fn
+	}
+}
+
+// WithResourceStorage registers a request handler for the resource that stores
+// it in the given FS under path.
+//
+// The FS may be shared with other resources, so that they're stored with the
+// same revisions. The status and generic subresources of the resource are
+// registered the same way as for WithResourceFileStorage.
+func (a *Server) WithResourceStorage(obj resource.Object, path string, fs filepath.FS, opts ...StorageOption) *Server {
+	config := storageConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	ws := config.watchSet
+	if ws == nil {
+		ws = filepath.NewWatchSet()
 	}
-	sp := filepath.NewJSONFilepathStorageProvider(obj, path, a.memoryFS, ws, strategy)
+	strategy := a.storageStrategy(obj, config)
+
+	location := staticStorageLocation(fs, path)
+	sp := a.filepathStorageProvider(obj, location, ws, strategy, a.resourceStorageOptions)
 	a.WithResourceAndHandler(obj, sp)
-	a.withSubresources(obj, path, a.memoryFS, ws, strategy, sp)
+	a.withSubresources(obj, location, ws, strategy, sp)
+	return a
+}
+
+// WithResourceStorageFromFlags registers a request handler for the resource
+// that stores it in the backend chosen by the --storage, --data-dir and
+// --storage-resource flags of the server command.
+//
+// The backend is chosen when the server starts. Without flags, e.g. when
+// running the server with ToServerOptions, the resource is stored in memory,
+// unless the StorageOptions of the server options are changed.
+//
+// The storage options are the same as for WithResourceStorage.
+func (a *Server) WithResourceStorageFromFlags(obj resource.Object, opts ...StorageOption) *Server {
+	config := storageConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	ws := config.watchSet
+	if ws == nil {
+		ws = filepath.NewWatchSet()
+	}
+	strategy := a.storageStrategy(obj, config)
+	location := a.flagStorageLocation(obj.GetGroupVersionResource().GroupResource())
+	sp := a.filepathStorageProvider(obj, location, ws, strategy, a.resourceStorageOptions)
+	a.WithResourceAndHandler(obj, sp)
+	a.withSubresources(obj, location, ws, strategy, sp)
+	return a
+}
+
+// storageStrategy returns the default strategy for the resource, or the one
+// that replaces it with the WithStrategy option.
+func (a *Server) storageStrategy(obj resource.Object, config storageConfig) rest.Strategy {
+	var strategy rest.Strategy = rest.DefaultStrategy{
+		Object:          obj,
+		ObjectTyper:     a.apiScheme,
+		TableConvertor:  a.tableConvertor(obj),
+		SchemaValidator: a.validators(obj).schema,
+		CELValidator:    a.validators(obj).cel,
+	}
+	if config.strategy != nil {
+		strategy = config.strategy(strategy)
+	}
+	return strategy
+}
+
+// tableConvertor returns the TableConvertor for objects of obj's type, and
+// records an error if its printer columns are invalid.
+func (a *Server) tableConvertor(obj resource.Object) registryrest.TableConvertor {
+	tc, err := rest.NewTableConvertor(obj)
+	if err != nil {
+		a.errs = append(a.errs, fmt.Errorf("%s: %v", obj.GetGroupVersionResource().GroupResource(), err))
+		return registryrest.NewDefaultTableConvertor(obj.GetGroupVersionResource().GroupResource())
+	}
+	return tc
+}
+
+// storageLocation returns the FS that a resource is stored in, and the path in it.
+//
+// It's called when the server starts.
+type storageLocation func() (filepath.FS, string, error)
+
+func staticStorageLocation(fs filepath.FS, path string) storageLocation {
+	return func() (filepath.FS, string, error) {
+		return fs, path, nil
+	}
+}
+
+// flagStorageLocation returns the location of the resource chosen by the
+// storage flags.
+//
+// The resource and its subresources share the location. File storage shares
+// one FS across resources, the same way memory storage does.
+func (a *Server) flagStorageLocation(gr schema.GroupResource) storageLocation {
+	return func() (filepath.FS, string, error) {
+		o := a.storageFlags
+		switch backend := o.BackendFor(gr); backend {
+		case options.StorageBackendMemory:
+			if a.memoryFS == nil {
+				a.memoryFS = filepath.NewMemoryFS()
+			}
+			return a.memoryFS, o.DataDir, nil
+		case options.StorageBackendFile:
+			if a.realFS == nil {
+				a.realFS = filepath.NewRealFS()
+			}
+			return a.realFS, o.DataDir, nil
+		default:
+			return nil, "", fmt.Errorf("unsupported storage backend %q for %s", backend, gr)
+		}
+	}
+}
+
+// WithRegistryStorage stores the resources served by an upstream
+// genericregistry.Store (e.g., registered with rest.New) in the file system
+// under path, or in memory if fs is a filepath.MemoryFS.
+//
+// Without it, those resources are stored in memory.
+func (a *Server) WithRegistryStorage(fs filepath.FS, path string) *Server {
+	a.storageDecorator = filepath.NewStorageDecorator(fs, path)
 	return a
 }
 
@@ -53,7 +227,10 @@ func (a *Server) WithResourceAndHandler(obj resource.Object, sp rest.ResourceHan
 		s.AddKnownTypes(obj.GetGroupVersionResource().GroupVersion(), obj.New(), obj.NewList())
 		return nil
 	})
-	return a.forGroupVersionResource(gvr, sp)
+	a.withPrinterColumns(obj)
+	a.withValidationRules(obj)
+	a.resources[gvr] = obj
+	return a.forGroupVersionResource(gvr, a.patcher.WithStorage(gvr, sp))
 }
 
 // WithResource registers a resource that is not backed by any storage.
@@ -98,6 +275,75 @@ func (a *Server) WithSubResourceAndHandler(
 	return a.forGroupVersionResource(gvr, sp)
 }
 
+// ComputedOption configures the subresource registered by WithComputedSubResource.
+type ComputedOption func(*computedConfig)
+
+type computedConfig struct {
+	watch bool
+}
+
+// WithComputedWatch lets clients watch the subresource of a parent object.
+// The result is recomputed each time the parent changes.
+func WithComputedWatch() ComputedOption {
+	return func(c *computedConfig) {
+		c.watch = true
+	}
+}
+
+// WithComputedSubResource registers a read-only subresource whose content is
+// computed from the parent object by compute, rather than stored.
+//
+// The parent must already be registered with filepath storage, e.g. with
+// WithResourceFileStorage. The result type is registered in the parent's
+// group version.
+func (a *Server) WithComputedSubResource(
+	parent resource.Object, name string, result runtime.Object, compute filepath.ComputeFunc, opts ...ComputedOption) *Server {
+	config := computedConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	gvr := parent.GetGroupVersionResource()
+	parentSP, ok := a.apis[gvr]
+	if !ok {
+		a.errs = append(a.errs, fmt.Errorf("%s/%s: parent resource is not registered", gvr.GroupResource(), name))
+		return a
+	}
+
+	addResultType := func(s *runtime.Scheme) error {
+		s.AddKnownTypes(gvr.GroupVersion(), result)
+		return nil
+	}
+	a.apiSchemeBuilder.Register(addResultType)
+	a.openapiSchemeBuilder.Register(addResultType)
+
+	newFunc := func() runtime.Object {
+		return reflect.New(reflect.TypeOf(result).Elem()).Interface().(runtime.Object)
+	}
+	return a.WithSubResourceAndHandler(parent, name,
+		filepath.NewComputedStorageProvider(parentSP, newFunc, compute, config.watch))
+}
+
+// WithLogSubResource registers the "log" subresource of the parent, which
+// serves the logs of each object from the store. Logs are read like with
+// `kubectl logs`, and appended by controllers, either with store.Append or by
+// POSTing to the subresource. The log of an object is removed when it's deleted.
+//
+// The parent must already be registered with filepath storage, e.g. with
+// WithResourceFileStorage. A store should only hold the logs of one resource.
+func (a *Server) WithLogSubResource(parent resource.Object, store *filepath.LogStore) *Server {
+	gvr := parent.GetGroupVersionResource()
+	parentSP, ok := a.apis[gvr]
+	if !ok {
+		a.errs = append(a.errs, fmt.Errorf("%s/log: parent resource is not registered", gvr.GroupResource()))
+		return a
+	}
+
+	a.logStores[gvr.GroupResource()] = store
+	a.apiSchemeBuilder.Register(filepath.AddLogOptionsToScheme(gvr.GroupVersion()))
+	return a.WithSubResourceAndHandler(parent, "log", filepath.NewLogStorageProvider(parentSP, store))
+}
+
 // WithSchemeInstallers registers functions to install resource types into the Scheme.
 func (a *Server) withGroupVersions(versions ...schema.GroupVersion) *Server {
 	if a.groupVersions == nil {
@@ -113,14 +359,125 @@ func (a *Server) withGroupVersions(versions ...schema.GroupVersion) *Server {
 	return a
 }
 
-func (a *Server) withSubresources(obj resource.Object, path string, fs filepath.FS, ws *filepath.WatchSet, strategy rest.DefaultStrategy, parentSP apiserver.StorageProvider) *Server {
+// filepathStorageProvider creates a provider of filepath storage for the resource
+// or one of its subresources.
+//
+// The storage options are resolved when the server starts, so that they reflect
+// builder calls made after the resource was registered.
+func (a *Server) filepathStorageProvider(
+	obj resource.Object,
+	location storageLocation,
+	ws *filepath.WatchSet,
+	strategy filepath.Strategy,
+	storageOptions func(obj resource.Object) []filepath.RESTOption,
+) rest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (registryrest.Storage, error) {
+		fs, path, err := location()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fs.(*filepath.RealFS); ok && a.storageMediaType != "" && a.storageMediaType != runtime.ContentTypeJSON {
+			// files are named and listed as .json
+			return nil, fmt.Errorf("%s: file storage only stores resources as JSON, not %s",
+				obj.GetGroupVersionResource().GroupResource(), a.storageMediaType)
+		}
+		sp := filepath.NewJSONFilepathStorageProvider(obj, path, fs, ws, strategy, storageOptions(obj)...)
+		return sp(scheme, getter)
+	}
+}
+
+// resourceStorageOptions returns the filepath storage options for the resource
+// itself, as opposed to its subresources.
+func (a *Server) resourceStorageOptions(obj resource.Object) []filepath.RESTOption {
+	opts := a.storageOptions(obj)
+	if a.transactor != nil {
+		opts = append(opts, filepath.WithTransactor(a.transactor))
+	}
+	if a.storageMigrator != nil {
+		opts = append(opts, filepath.WithStorageMigrator(a.storageMigrator))
+	}
+	return opts
+}
+
+// storageOptions returns the filepath storage options for the resource.
+//
+// A resource and its subresources get the same options.
+func (a *Server) storageOptions(obj resource.Object) []filepath.RESTOption {
+	opts := []filepath.RESTOption{filepath.WithAdmission(a.storageAdmission)}
+	if o, ok := obj.(resource.ObjectWithRevisionHistory); ok {
+		gr := obj.GetGroupVersionResource().GroupResource()
+		history, ok := a.histories[gr]
+		if !ok {
+			history = filepath.NewRevisionHistory(o.RevisionHistoryLimit())
+			a.histories[gr] = history
+		}
+		opts = append(opts, filepath.WithRevisionHistory(history))
+	}
+	if a.changelog != nil {
+		opts = append(opts, filepath.WithChangelog(a.changelog))
+	}
+	if codec, ok := a.storageCodecs[obj.GetGroupVersionResource().GroupResource()]; ok {
+		opts = append(opts, filepath.WithStorageCodec(codec))
+	} else if a.storageCodec != nil {
+		opts = append(opts, filepath.WithStorageCodec(a.storageCodec))
+	}
+	if store, ok := a.logStores[obj.GetGroupVersionResource().GroupResource()]; ok {
+		opts = append(opts, filepath.WithLogStore(store))
+	}
+	return opts
+}
+
+func (a *Server) withSubresources(obj resource.Object, location storageLocation, ws *filepath.WatchSet, strategy rest.Strategy, parentSP apiserver.StorageProvider) *Server {
 	if _, ok := obj.(resource.ObjectWithStatusSubResource); ok {
-		provider := filepath.NewJSONFilepathStorageProvider(
-			obj, path, fs, ws, rest.StatusSubResourceStrategy{Strategy: strategy})
+		provider := a.filepathStorageProvider(
+			obj, location, ws, rest.StatusSubResourceStrategy{Strategy: strategy}, a.storageOptions)
 		a.WithSubResourceAndHandler(obj, "status",
 			(&statusProvider{Provider: provider}).Get)
 	}
 
+	if _, ok := obj.(resource.ObjectWithScaleSubResource); ok {
+		a.apiSchemeBuilder.Register(filepath.AddScaleToScheme)
+		a.openapiSchemeBuilder.Register(func(s *runtime.Scheme) error {
+			s.AddKnownTypes(autoscalingv1.SchemeGroupVersion, &autoscalingv1.Scale{})
+			return nil
+		})
+		a.withOpenAPIDefinitions(filepath.GetScaleOpenAPIDefinitions)
+		a.WithSubResourceAndHandler(obj, "scale", filepath.NewScaleStorageProvider(parentSP))
+	}
+
+	if _, ok := obj.(resource.ObjectWithRevisionHistory); ok {
+		a.WithSubResourceAndHandler(obj, "history", filepath.NewHistoryStorageProvider(parentSP))
+	}
+
+	if _, ok := obj.(resource.ObjectWithRollbackSubResource); ok {
+		gv := obj.GetGroupVersionResource().GroupVersion()
+		addRollbackType := func(s *runtime.Scheme) error {
+			s.AddKnownTypes(gv, &filepath.RevisionRollback{})
+			return nil
+		}
+		a.apiSchemeBuilder.Register(addRollbackType)
+		a.openapiSchemeBuilder.Register(addRollbackType)
+		a.withOpenAPIDefinitions(filepath.GetOpenAPIDefinitions)
+		a.WithSubResourceAndHandler(obj, "rollback", filepath.NewRollbackStorageProvider(parentSP))
+	}
+
+	if owa, ok := obj.(resource.ObjectWithActionSubResources); ok {
+		gv := obj.GetGroupVersionResource().GroupVersion()
+		for _, action := range owa.ActionSubResources() {
+			action := action
+			addActionTypes := func(s *runtime.Scheme) error {
+				s.AddKnownTypes(gv, action.NewRequest())
+				if result := action.NewResult(); result != nil {
+					s.AddKnownTypes(gv, result)
+				}
+				return nil
+			}
+			a.apiSchemeBuilder.Register(addActionTypes)
+			a.openapiSchemeBuilder.Register(addActionTypes)
+			a.WithSubResourceAndHandler(obj, action.Name(), filepath.NewActionStorageProvider(parentSP, action))
+		}
+	}
+
 	if owas, ok := obj.(resource.ObjectWithGenericSubResource); ok {
 		for _, subResource := range owas.GenericSubResources() {
 			a.WithSubResourceAndHandler(obj, subResource.Name(), subResource.GetStorageProvider(obj, subResource.Name(), parentSP))
@@ -2,10 +2,14 @@ package builder_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	fp "path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,20 +17,31 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/transport"
+	"k8s.io/kube-openapi/pkg/common"
 
 	corev1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
 	"github.com/tilt-dev/tilt-apiserver/pkg/generated/clientset/versioned"
 	tiltopenapi "github.com/tilt-dev/tilt-apiserver/pkg/generated/openapi"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/apiserver"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	testapiopenapi "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/openapi"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource/resourcestrategy"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/options"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/start"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/testdata"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
 )
 
 const fakeBearerToken = "fake-bearer-token"
@@ -311,6 +326,44 @@ func TestPatch(t *testing.T) {
 	assert.Equal(t, "my-label-value", obj.GetLabels()["my-label"])
 }
 
+func TestHistoryAndRollback(t *testing.T) {
+	f := newFixture(t)
+	defer f.tearDown()
+
+	client := f.client
+	obj, err := client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "good"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	goodVersion := obj.ResourceVersion
+
+	obj.Spec.Message = "bad"
+	_, err = client.CoreV1alpha1().Manifests().Update(f.ctx, obj, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	history := &corev1alpha1.ManifestList{}
+	err = client.CoreV1alpha1().RESTClient().Get().
+		Resource("manifests").Name("my-server").SubResource("history").
+		Do(f.ctx).Into(history)
+	require.NoError(t, err)
+	require.Len(t, history.Items, 2)
+	assert.Equal(t, "bad", history.Items[0].Spec.Message)
+	assert.Equal(t, "good", history.Items[1].Spec.Message)
+
+	body := fmt.Sprintf(`{"apiVersion":"core.tilt.dev/v1alpha1","kind":"RevisionRollback","revision":%q}`, goodVersion)
+	restored := &corev1alpha1.Manifest{}
+	err = client.CoreV1alpha1().RESTClient().Post().
+		Resource("manifests").Name("my-server").SubResource("rollback").
+		Body([]byte(body)).Do(f.ctx).Into(restored)
+	require.NoError(t, err)
+	assert.Equal(t, "good", restored.Spec.Message)
+
+	obj, err = client.CoreV1alpha1().Manifests().Get(f.ctx, "my-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "good", obj.Spec.Message)
+}
+
 type createTestCase struct {
 	name       string
 	labelKey   string
@@ -318,6 +371,333 @@ type createTestCase struct {
 	error      string
 }
 
+func TestTransaction(t *testing.T) {
+	f := newFixture(t)
+	defer f.tearDown()
+
+	gr := (&corev1alpha1.Manifest{}).GetGroupVersionResource().GroupResource()
+	parent, err := filepath.NewCreateOperation(gr, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "parent"},
+	})
+	require.NoError(t, err)
+	child, err := filepath.NewCreateOperation(gr, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "child"},
+	})
+	require.NoError(t, err)
+
+	restClient := f.client.CoreV1alpha1().RESTClient()
+	result, err := filepath.CommitTransaction(f.ctx, restClient, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{parent, child},
+	})
+	require.NoError(t, err)
+	assert.Len(t, result.Objects, 2)
+
+	list, err := f.client.CoreV1alpha1().Manifests().List(f.ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, list.Items, 2)
+
+	_, err = filepath.CommitTransaction(f.ctx, restClient, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			filepath.NewDeleteOperation(gr, "", "parent", nil),
+			filepath.NewDeleteOperation(gr, "", "nonexistent", nil),
+		},
+	})
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsNotFound(err), err.Error())
+	}
+
+	_, err = f.client.CoreV1alpha1().Manifests().Get(f.ctx, "parent", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestStorageMigration(t *testing.T) {
+	f := newFixture(t)
+	defer f.tearDown()
+
+	_, err := f.client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	var statuses []filepath.MigrationStatus
+	result := f.client.CoreV1alpha1().RESTClient().Post().
+		AbsPath(filepath.StorageMigrationPath).
+		Param("resource", "manifests.core.tilt.dev").
+		Do(f.ctx)
+	raw, err := result.Raw()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, &statuses))
+	var code int
+	result.StatusCode(&code)
+	assert.Equal(t, http.StatusAccepted, code)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "manifests", statuses[0].Resource)
+
+	// the migration runs in the background, and its progress is reported by GET
+	require.Eventually(t, func() bool {
+		raw, err := f.client.CoreV1alpha1().RESTClient().Get().
+			AbsPath(filepath.StorageMigrationPath).
+			Do(f.ctx).Raw()
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(raw, &statuses))
+		return len(statuses) == 1 && statuses[0].CompletionTime != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "core.tilt.dev/v1alpha1", statuses[0].StorageVersion)
+	assert.Equal(t, 1, statuses[0].Total)
+	assert.Equal(t, 1, statuses[0].UpToDate)
+
+	err = f.client.CoreV1alpha1().RESTClient().Post().
+		AbsPath(filepath.StorageMigrationPath).
+		Param("resource", "nonexistent.core.tilt.dev").
+		Do(f.ctx).Error()
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsNotFound(err), err.Error())
+	}
+}
+
+func TestRegistryStorage(t *testing.T) {
+	obj := &corev1alpha1.Manifest{}
+	parent, path, _, statusHandler := builderrest.NewStatus(obj)
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithRegistryStorage(filepath.NewRealFS(), t.TempDir()).
+		WithResourceAndHandler(obj, builderrest.New(obj)).
+		WithSubResourceAndHandler(parent, path, statusHandler))
+	defer f.tearDown()
+
+	client := f.client
+	newObj, err := client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "spec message"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	watch, err := client.CoreV1alpha1().Manifests().Watch(f.ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	defer watch.Stop()
+
+	obj = f.nextResult(watch)
+	assert.Equal(t, "my-server", obj.Name)
+
+	newObj.Spec.Message = "ignored"
+	newObj.Status.Message = "status message"
+	_, err = client.CoreV1alpha1().Manifests().UpdateStatus(f.ctx, newObj, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	obj = f.nextResult(watch)
+	assert.Equal(t, "spec message", obj.Spec.Message)
+	assert.Equal(t, "status message", obj.Status.Message)
+
+	list, err := client.CoreV1alpha1().Manifests().List(f.ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+
+	err = client.CoreV1alpha1().Manifests().Delete(f.ctx, "my-server", metav1.DeleteOptions{})
+	require.NoError(t, err)
+
+	_, err = client.CoreV1alpha1().Manifests().Get(f.ctx, "my-server", metav1.GetOptions{})
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsNotFound(err), err.Error())
+	}
+}
+
+func TestResourceStorage(t *testing.T) {
+	fs := &countingFS{FS: filepath.NewMemoryFS()}
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceStorage(&corev1alpha1.Manifest{}, "data", fs,
+			builder.WithWatchSet(filepath.NewWatchSet()),
+			builder.WithStrategy(func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+				return labelingStrategy{Strategy: defaultStrategy}
+			})))
+	defer f.tearDown()
+
+	client := f.client
+	newObj, err := client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "spec message"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "strategy", newObj.Labels["created-by"])
+
+	newObj.Status.Message = "status message"
+	_, err = client.CoreV1alpha1().Manifests().UpdateStatus(f.ctx, newObj, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	obj, err := client.CoreV1alpha1().Manifests().Get(f.ctx, "my-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "spec message", obj.Spec.Message)
+	assert.Equal(t, "status message", obj.Status.Message)
+	assert.Equal(t, int32(2), fs.writes.Load())
+}
+
+// countingFS counts the writes to the FS.
+type countingFS struct {
+	filepath.FS
+	writes atomic.Int32
+}
+
+func (fs *countingFS) Write(ctx context.Context, encoder runtime.Encoder, path string, obj runtime.Object, storageVersion uint64) error {
+	fs.writes.Add(1)
+	return fs.FS.Write(ctx, encoder, path, obj, storageVersion)
+}
+
+// labelingStrategy labels the objects it creates.
+type labelingStrategy struct {
+	builderrest.Strategy
+}
+
+func (s labelingStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	s.Strategy.PrepareForCreate(ctx, obj)
+	obj.(*corev1alpha1.Manifest).Labels = map[string]string{"created-by": "strategy"}
+}
+
+func TestResourceStorageAndStrategy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1alpha1.AddToScheme(scheme))
+	strategy := customStrategy{Strategy: builderrest.DefaultStrategy{
+		Object:      &corev1alpha1.Manifest{},
+		ObjectTyper: scheme,
+	}}
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorageAndStrategy(&corev1alpha1.Manifest{}, "data", strategy))
+	defer f.tearDown()
+
+	warnings := &recordingWarningHandler{}
+	config := rest.CopyConfig(f.config.GenericConfig.LoopbackClientConfig)
+	config.WarningHandler = warnings
+	client, err := versioned.NewForConfig(config)
+	require.NoError(t, err)
+
+	// the strategy allows create on update
+	_, err = client.CoreV1alpha1().Manifests().Update(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+	}, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-hidden-server", Labels: map[string]string{"hidden": "true"}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"created my-server", "created my-hidden-server"}, warnings.get())
+
+	list, err := client.CoreV1alpha1().Manifests().List(f.ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	if assert.Len(t, list.Items, 1) {
+		assert.Equal(t, "my-server", list.Items[0].Name)
+	}
+
+	table := &metav1.Table{}
+	err = client.CoreV1alpha1().RESTClient().Get().
+		Resource("manifests").
+		SetHeader("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io").
+		Do(f.ctx).
+		Into(table)
+	require.NoError(t, err)
+	if assert.Len(t, table.ColumnDefinitions, 1) {
+		assert.Equal(t, "Greeting", table.ColumnDefinitions[0].Name)
+	}
+	if assert.Len(t, table.Rows, 1) {
+		assert.Equal(t, []interface{}{"hello my-server"}, table.Rows[0].Cells)
+	}
+}
+
+// customStrategy allows create on update, warns on create, hides objects
+// labeled hidden=true and prints a greeting for each object.
+type customStrategy struct {
+	builderrest.Strategy
+}
+
+func (s customStrategy) AllowCreateOnUpdate() bool {
+	return true
+}
+
+func (s customStrategy) WarningsOnCreate(ctx context.Context, obj runtime.Object) []string {
+	return []string{fmt.Sprintf("created %s", obj.(*corev1alpha1.Manifest).Name)}
+}
+
+func (s customStrategy) Match(label labels.Selector, field fields.Selector) storage.SelectionPredicate {
+	p := s.Strategy.Match(label, field)
+	hidden, _ := labels.Parse("hidden!=true")
+	p.Label = hidden
+	return p
+}
+
+func (s customStrategy) ConvertToTable(ctx context.Context, obj runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	table := &metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Greeting", Type: "string"}},
+	}
+	err := meta.EachListItem(obj, func(obj runtime.Object) error {
+		m := obj.(*corev1alpha1.Manifest)
+		table.Rows = append(table.Rows, metav1.TableRow{
+			Cells:  []interface{}{"hello " + m.Name},
+			Object: runtime.RawExtension{Object: m},
+		})
+		return nil
+	})
+	return table, err
+}
+
+// recordingWarningHandler records the warnings sent by the server.
+type recordingWarningHandler struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (h *recordingWarningHandler) HandleWarningHeader(code int, agent string, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.warnings = append(h.warnings, text)
+}
+
+func (h *recordingWarningHandler) get() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.warnings...)
+}
+
+func TestStorageFromFlags(t *testing.T) {
+	dataDir := t.TempDir()
+	f := newFixtureWithOptions(t, builder.NewServerBuilder().
+		WithResourceStorageFromFlags(&corev1alpha1.Manifest{}),
+		func(o *start.TiltServerOptions) {
+			o.StorageOptions.DataDir = dataDir
+			o.StorageOptions.ResourceBackends["manifests.core.tilt.dev"] = options.StorageBackendFile
+		})
+	defer f.tearDown()
+
+	_, err := f.client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.FileExists(t, fp.Join(dataDir, "core.tilt.dev", "manifests", "my-server.json"))
+
+	newObj, err := f.client.CoreV1alpha1().Manifests().Get(f.ctx, "my-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	newObj.Status.Message = "status message"
+	_, err = f.client.CoreV1alpha1().Manifests().UpdateStatus(f.ctx, newObj, metav1.UpdateOptions{})
+	require.NoError(t, err)
+}
+
+func TestStorageFlagsValidation(t *testing.T) {
+	o, err := builder.NewServerBuilder().
+		WithResourceStorageFromFlags(&corev1alpha1.Manifest{}).
+		WithOpenAPIDefinitions("tilt", "0.1.0", tiltopenapi.GetOpenAPIDefinitions).
+		ToServerOptions()
+	require.NoError(t, err)
+	cmd := start.NewCommandStartTiltServer(o, context.Background())
+	cmd.SetArgs([]string{
+		"--secure-port=9444",
+		"--storage=journal",
+		"--storage-resource=manifests.core.tilt.dev=tape",
+	})
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+
+	err = cmd.Execute()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `--storage "journal" must be one of: memory, file`)
+		assert.Contains(t, err.Error(), "--storage-resource manifests.core.tilt.dev=tape")
+	}
+}
+
 func TestCreateValidation(t *testing.T) {
 	f := newFixture(t)
 	defer f.tearDown()
@@ -394,6 +774,75 @@ func TestValidateOpenAPISpec(t *testing.T) {
 	assert.NotContains(t, content, `__internal`)
 }
 
+func TestPrinterColumns(t *testing.T) {
+	f := newFixture(t)
+	defer f.tearDown()
+
+	obj, err := f.client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "spec message"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	obj.Status.Message = "status message"
+	_, err = f.client.CoreV1alpha1().Manifests().UpdateStatus(f.ctx, obj, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	table := &metav1.Table{}
+	err = f.client.CoreV1alpha1().RESTClient().Get().
+		Resource("manifests").
+		SetHeader("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io").
+		Do(f.ctx).
+		Into(table)
+	require.NoError(t, err)
+
+	names := []string{}
+	priorities := []int32{}
+	for _, c := range table.ColumnDefinitions {
+		names = append(names, c.Name)
+		priorities = append(priorities, c.Priority)
+	}
+	assert.Equal(t, []string{"Name", "Message", "Status", "Age"}, names)
+	// kubectl only prints the Status column with -o wide
+	assert.Equal(t, []int32{0, 0, 1, 0}, priorities)
+	if assert.Len(t, table.Rows, 1) {
+		cells := table.Rows[0].Cells
+		assert.Equal(t, []interface{}{"my-server", "spec message", "status message"}, cells[:3])
+		assert.NotEmpty(t, cells[3])
+	}
+
+	// the columns are published in OpenAPI
+	trConfig, err := f.config.GenericConfig.LoopbackClientConfig.TransportConfig()
+	require.NoError(t, err)
+	tr, err := transport.New(trConfig)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: tr}).Get("https://127.0.0.1:443/openapi/v2")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(content),
+		`"x-printer-columns":[{"description":"The message of the spec.","jsonPath":".spec.message","name":"Message","type":"string"},`+
+			`{"description":"The message of the status.","jsonPath":".status.message","name":"Status","priority":1,"type":"string"}]`)
+}
+
+func TestInvalidPrinterColumns(t *testing.T) {
+	_, err := builder.NewServerBuilder().
+		WithResourceMemoryStorage(&badColumnsManifest{}, "data").
+		ToServerOptions()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `manifests.core.tilt.dev: printer columns: unrecognized column definition "{.spec"`)
+	}
+}
+
+// badColumnsManifest has a printer column that isn't valid JSONPath.
+type badColumnsManifest struct {
+	corev1alpha1.Manifest
+}
+
+func (in *badColumnsManifest) PrinterColumns() []resourcestrategy.PrinterColumn {
+	return []resourcestrategy.PrinterColumn{{Name: "Message", Type: "string", JSONPath: "{.spec"}}
+}
+
 func TestLabelSelector(t *testing.T) {
 	f := newFixture(t)
 	defer f.tearDown()
@@ -450,15 +899,30 @@ type fixture struct {
 }
 
 func newFixture(t *testing.T) *fixture {
-	connProvider := memConnProvider()
-	builder := builder.NewServerBuilder().
+	return newFixtureWithBuilder(t, builder.NewServerBuilder().
 		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
-		WithOpenAPIDefinitions("tilt", "0.1.0", tiltopenapi.GetOpenAPIDefinitions).
+		WithTransactions().
+		WithStorageMigration(false))
+}
+
+func newFixtureWithBuilder(t *testing.T, builder *builder.Server) *fixture {
+	return newFixtureWithOptions(t, builder, func(*start.TiltServerOptions) {})
+}
+
+func newFixtureWithOptions(t *testing.T, builder *builder.Server, configure func(o *start.TiltServerOptions)) *fixture {
+	return runFixture(t, builder.WithOpenAPIDefinitions("tilt", "0.1.0", testOpenAPIDefinitions), configure)
+}
+
+// runFixture runs the server without adding the test OpenAPI definitions.
+func runFixture(t *testing.T, builder *builder.Server, configure func(o *start.TiltServerOptions)) *fixture {
+	connProvider := memConnProvider()
+	builder = builder.
 		WithConnProvider(connProvider).
 		WithBearerToken(fakeBearerToken).
 		WithCertKey(testdata.CertKey())
 	options, err := builder.ToServerOptions()
 	require.NoError(t, err)
+	configure(options)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -481,6 +945,16 @@ func newFixture(t *testing.T) *fixture {
 	}
 }
 
+// testOpenAPIDefinitions adds the definitions of the resources that only tests
+// serve to the generated definitions.
+func testOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	defs := tiltopenapi.GetOpenAPIDefinitions(ref)
+	for name, def := range testapiopenapi.GetOpenAPIDefinitions(ref) {
+		defs[name] = def
+	}
+	return defs
+}
+
 func (f *fixture) nextResult(i watch.Interface) *corev1alpha1.Manifest {
 	select {
 	case e := <-i.ResultChan():
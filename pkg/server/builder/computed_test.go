@@ -0,0 +1,127 @@
+package builder_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+
+	corev1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	testapiv1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/v1alpha1"
+)
+
+func TestComputedSubResource(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithComputedSubResource(&corev1alpha1.Manifest{}, "summary", &testapiv1alpha1.ManifestSummary{}, summarize,
+			builder.WithComputedWatch()))
+	defer f.tearDown()
+
+	manifests := f.client.CoreV1alpha1().Manifests()
+	obj, err := manifests.Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "hello"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	restClient := f.client.CoreV1alpha1().RESTClient()
+	getSummary := func(name string) (*testapiv1alpha1.ManifestSummary, error) {
+		out, err := restClient.Get().
+			Resource("manifests").
+			Name(name).
+			SubResource("summary").
+			DoRaw(f.ctx)
+		if err != nil {
+			return nil, err
+		}
+		summary := &testapiv1alpha1.ManifestSummary{}
+		require.NoError(t, json.Unmarshal(out, summary))
+		return summary, nil
+	}
+
+	summary, err := getSummary("my-manifest")
+	require.NoError(t, err)
+	assert.Equal(t, "ManifestSummary", summary.Kind)
+	assert.Equal(t, "my-manifest", summary.Name)
+	assert.Equal(t, obj.ResourceVersion, summary.ResourceVersion)
+	assert.Equal(t, "HELLO", summary.Shout)
+	assert.Equal(t, int32(5), summary.Length)
+
+	_, err = getSummary("missing-manifest")
+	assert.True(t, apierrors.IsNotFound(err), "expected not found, got %v", err)
+
+	// the summary is read-only
+	err = restClient.Put().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("summary").
+		Body([]byte(`{"apiVersion":"core.tilt.dev/v1alpha1","kind":"ManifestSummary"}`)).
+		Do(f.ctx).Error()
+	assert.True(t, apierrors.IsMethodNotSupported(err), "expected method not supported, got %v", err)
+
+	// watchers of the summary see it recomputed when the manifest changes
+	stream, err := restClient.Get().
+		AbsPath("/apis/core.tilt.dev/v1alpha1/watch/manifests/my-manifest/summary").
+		Stream(f.ctx)
+	require.NoError(t, err)
+	defer stream.Close()
+	events := make(chan summaryEvent, 10)
+	go func() {
+		decoder := json.NewDecoder(stream)
+		for {
+			e := summaryEvent{}
+			if err := decoder.Decode(&e); err != nil {
+				return
+			}
+			events <- e
+		}
+	}()
+
+	obj.Spec.Message = "goodbye"
+	_, err = manifests.Update(f.ctx, obj, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	for i, shout := range []string{"HELLO", "GOODBYE"} {
+		select {
+		case e := <-events:
+			if i == 0 {
+				assert.Equal(t, watch.Added, e.Type)
+			} else {
+				assert.Equal(t, watch.Modified, e.Type)
+			}
+			assert.Equal(t, "ManifestSummary", e.Object.Kind)
+			assert.Equal(t, shout, e.Object.Shout)
+		case <-f.ctx.Done():
+			t.Fatal("context canceled")
+		}
+	}
+}
+
+func TestComputedSubResourceWithoutParent(t *testing.T) {
+	_, err := builder.NewServerBuilder().
+		WithComputedSubResource(&corev1alpha1.Manifest{}, "summary", &testapiv1alpha1.ManifestSummary{}, summarize).
+		ToServerOptions()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "manifests.core.tilt.dev/summary: parent resource is not registered")
+}
+
+type summaryEvent struct {
+	Type   watch.EventType                 `json:"type"`
+	Object testapiv1alpha1.ManifestSummary `json:"object"`
+}
+
+func summarize(ctx context.Context, parent runtime.Object) (runtime.Object, error) {
+	manifest := parent.(*corev1alpha1.Manifest)
+	return &testapiv1alpha1.ManifestSummary{
+		Shout:  strings.ToUpper(manifest.Spec.Message),
+		Length: int32(len(manifest.Spec.Message)),
+	}, nil
+}
@@ -0,0 +1,198 @@
+package builder_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/runtime/serializer/cbor"
+	"k8s.io/apimachinery/pkg/runtime/serializer/streaming"
+	"k8s.io/client-go/transport"
+
+	corev1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/testdata"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestCBOR(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithCBOR())
+	defer f.tearDown()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1alpha1.AddToScheme(scheme))
+	serializer := cbor.NewSerializer(scheme, scheme)
+
+	body := new(bytes.Buffer)
+	require.NoError(t, serializer.Encode(&corev1alpha1.Manifest{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "core.tilt.dev/v1alpha1", Kind: "Manifest"},
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+	}, body))
+	resp := f.do(t, http.MethodPost, "/apis/core.tilt.dev/v1alpha1/manifests", body.Bytes(), map[string]string{
+		"Content-Type": runtime.ContentTypeCBOR,
+		"Accept":       runtime.ContentTypeCBOR,
+	})
+	require.Equal(t, http.StatusCreated, resp.code, string(resp.body))
+	assert.Equal(t, runtime.ContentTypeCBOR, resp.contentType)
+	obj, _, err := serializer.Decode(resp.body, nil, &corev1alpha1.Manifest{})
+	require.NoError(t, err)
+	assert.Equal(t, "my-server", obj.(*corev1alpha1.Manifest).Name)
+
+	// watch streams are encoded as CBOR sequences
+	req, err := http.NewRequest(http.MethodGet, "https://127.0.0.1:443/apis/core.tilt.dev/v1alpha1/manifests?watch=true", nil)
+	require.NoError(t, err)
+	req = req.WithContext(f.ctx)
+	req.Header.Set("Accept", runtime.ContentTypeCBOR)
+	watchResp, err := f.httpClient(t).Do(req)
+	require.NoError(t, err)
+	defer watchResp.Body.Close()
+	require.Equal(t, http.StatusOK, watchResp.StatusCode)
+	assert.Equal(t, runtime.ContentTypeCBORSequence, watchResp.Header.Get("Content-Type"))
+
+	// the raw objects of events are decoded as CBOR, rather than transcoded to
+	// JSON
+	stream := cbor.NewSerializer(scheme, scheme, cbor.Transcode(false))
+	decoder := streaming.NewDecoder(cbor.NewFramer().NewFrameReader(watchResp.Body), stream)
+	event := &metav1.WatchEvent{}
+	_, _, err = decoder.Decode(nil, event)
+	require.NoError(t, err)
+	assert.Equal(t, "ADDED", event.Type)
+	obj, _, err = serializer.Decode(event.Object.Raw, nil, &corev1alpha1.Manifest{})
+	require.NoError(t, err)
+	assert.Equal(t, "my-server", obj.(*corev1alpha1.Manifest).Name)
+}
+
+func TestProtobufFallsBackToJSON(t *testing.T) {
+	f := newFixture(t)
+	defer f.tearDown()
+
+	_, err := f.client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// Manifest has no generated protobuf marshalling, so clients that prefer
+	// protobuf get JSON
+	resp := f.do(t, http.MethodGet, "/apis/core.tilt.dev/v1alpha1/manifests/my-server", nil, map[string]string{
+		"Accept": runtime.ContentTypeProtobuf + ", " + runtime.ContentTypeJSON,
+	})
+	require.Equal(t, http.StatusOK, resp.code, string(resp.body))
+	assert.Equal(t, runtime.ContentTypeJSON, resp.contentType)
+}
+
+func TestStorageMediaType(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1alpha1.AddToScheme(scheme))
+	jsonCodec := serializer.NewCodecFactory(scheme).LegacyCodec(corev1alpha1.SchemeGroupVersion)
+	newFunc := func() runtime.Object { return &corev1alpha1.Manifest{} }
+
+	fs := filepath.NewMemoryFS()
+	dir := fp.Join("data", "core.tilt.dev", "manifests")
+	require.NoError(t, fs.EnsureDir(ctx, dir))
+	require.NoError(t, fs.Write(ctx, jsonCodec, fp.Join(dir, "old-server.json"),
+		&corev1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "old-server"}}, 0))
+
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceStorage(&corev1alpha1.Manifest{}, "data", fs).
+		WithStorageMediaType(runtime.ContentTypeCBOR))
+	defer f.tearDown()
+
+	// objects stored as JSON are still read
+	_, err := f.client.CoreV1alpha1().Manifests().Get(f.ctx, "old-server", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	_, err = f.client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-server"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	content := &rawDecoder{}
+	_, _ = fs.Read(ctx, content, fp.Join(dir, "my-server.json"), newFunc)
+	// the self-described CBOR tag
+	assert.True(t, bytes.HasPrefix(content.data, []byte{0xd9, 0xd9, 0xf7}), "expected CBOR, got %q", content.data)
+
+	obj, err := f.client.CoreV1alpha1().Manifests().Get(f.ctx, "my-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "my-server", obj.Name)
+}
+
+func TestStorageMediaTypeNeedsJSONForFiles(t *testing.T) {
+	options, err := builder.NewServerBuilder().
+		WithResourceFileStorage(&corev1alpha1.Manifest{}, t.TempDir()).
+		WithStorageMediaType(runtime.ContentTypeCBOR).
+		WithConnProvider(memConnProvider()).
+		WithBearerToken(fakeBearerToken).
+		WithCertKey(testdata.CertKey()).
+		ToServerOptions()
+	require.NoError(t, err)
+	config, err := options.Config()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = options.RunTiltServerFromConfig(config.Complete(), ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "manifests.core.tilt.dev: file storage only stores resources as JSON")
+	}
+}
+
+// rawDecoder records the data it's asked to decode.
+type rawDecoder struct {
+	data []byte
+}
+
+func (d *rawDecoder) Decode(data []byte, _ *schema.GroupVersionKind, _ runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	d.data = data
+	return nil, nil, fmt.Errorf("not decoded")
+}
+
+func TestStorageMediaTypeProtobufNeedsMarshalling(t *testing.T) {
+	_, err := builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithStorageMediaType(runtime.ContentTypeProtobuf).
+		ToServerOptions()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "manifests.core.tilt.dev: storing resources as protobuf needs generated protobuf marshalling")
+	}
+}
+
+type rawResponse struct {
+	code        int
+	contentType string
+	header      http.Header
+	body        []byte
+}
+
+func (f *fixture) httpClient(t *testing.T) *http.Client {
+	trConfig, err := f.config.GenericConfig.LoopbackClientConfig.TransportConfig()
+	require.NoError(t, err)
+	tr, err := transport.New(trConfig)
+	require.NoError(t, err)
+	return &http.Client{Transport: tr}
+}
+
+// do sends a request to the server, and reads the response.
+func (f *fixture) do(t *testing.T, method, path string, body []byte, header map[string]string) rawResponse {
+	req, err := http.NewRequest(method, "https://127.0.0.1:443"+path, bytes.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := f.httpClient(t).Do(req.WithContext(f.ctx))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return rawResponse{code: resp.StatusCode, contentType: resp.Header.Get("Content-Type"), header: resp.Header, body: content}
+}
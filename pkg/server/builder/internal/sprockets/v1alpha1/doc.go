@@ -0,0 +1,5 @@
+// Package v1alpha1 is the storage version of the Sprocket resource that the
+// builder tests serve in several versions.
+//
+// +k8s:deepcopy-gen=package
+package v1alpha1
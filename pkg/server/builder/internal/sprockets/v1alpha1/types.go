@@ -0,0 +1,45 @@
+package v1alpha1
+
+import (
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Sprocket struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SprocketSpec `json:"spec,omitempty"`
+}
+
+type SprocketSpec struct {
+	// Size is the number of teeth.
+	Size  int32  `json:"size,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type SprocketList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Sprocket `json:"items"`
+}
+
+var _ resource.Object = &Sprocket{}
+var _ resource.ObjectList = &SprocketList{}
+
+func (in *Sprocket) GetObjectMeta() *metav1.ObjectMeta { return &in.ObjectMeta }
+func (in *Sprocket) NamespaceScoped() bool             { return false }
+func (in *Sprocket) New() runtime.Object               { return &Sprocket{} }
+func (in *Sprocket) NewList() runtime.Object           { return &SprocketList{} }
+func (in *Sprocket) IsStorageVersion() bool            { return true }
+
+func (in *Sprocket) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "core.tilt.dev", Version: "v1alpha1", Resource: "sprockets"}
+}
+
+func (in *SprocketList) GetListMeta() *metav1.ListMeta { return &in.ListMeta }
@@ -0,0 +1,5 @@
+// Package v1beta1 is a version of the Sprocket resource that's converted
+// through its storage version, v1alpha1.
+//
+// +k8s:deepcopy-gen=package
+package v1beta1
@@ -0,0 +1,66 @@
+package v1beta1
+
+import (
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/sprockets/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Sprocket struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SprocketSpec `json:"spec,omitempty"`
+}
+
+type SprocketSpec struct {
+	Teeth int32  `json:"teeth,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type SprocketList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Sprocket `json:"items"`
+}
+
+var _ resource.Object = &Sprocket{}
+var _ resource.MultiVersionObject = &Sprocket{}
+var _ resource.ObjectList = &SprocketList{}
+
+func (in *Sprocket) GetObjectMeta() *metav1.ObjectMeta { return &in.ObjectMeta }
+func (in *Sprocket) NamespaceScoped() bool             { return false }
+func (in *Sprocket) New() runtime.Object               { return &Sprocket{} }
+func (in *Sprocket) NewList() runtime.Object           { return &SprocketList{} }
+func (in *Sprocket) IsStorageVersion() bool            { return false }
+
+func (in *Sprocket) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "core.tilt.dev", Version: "v1beta1", Resource: "sprockets"}
+}
+
+func (in *Sprocket) NewStorageVersionObject() runtime.Object {
+	return &v1alpha1.Sprocket{}
+}
+
+func (in *Sprocket) ConvertToStorageVersion(storageObj runtime.Object) error {
+	out := storageObj.(*v1alpha1.Sprocket)
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec.Size = in.Spec.Teeth
+	out.Spec.Color = in.Spec.Color
+	return nil
+}
+
+func (in *Sprocket) ConvertFromStorageVersion(storageObj runtime.Object) error {
+	from := storageObj.(*v1alpha1.Sprocket)
+	from.ObjectMeta.DeepCopyInto(&in.ObjectMeta)
+	in.Spec.Teeth = from.Spec.Size
+	in.Spec.Color = from.Spec.Color
+	return nil
+}
+
+func (in *SprocketList) GetListMeta() *metav1.ListMeta { return &in.ListMeta }
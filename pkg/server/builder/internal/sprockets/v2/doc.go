@@ -0,0 +1,6 @@
+// Package v2 is a version of the Sprocket resource that drops the color of
+// sprockets, so it can't be converted through the storage version without
+// losing data.
+//
+// +k8s:deepcopy-gen=package
+package v2
@@ -0,0 +1,9 @@
+// Package v1alpha1 has the resources that the builder tests serve to exercise
+// the features of the builder, with generated deepcopy functions and OpenAPI
+// definitions, like the resources of a real server.
+//
+// +k8s:openapi-gen=true
+// +k8s:openapi-model-package=github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/v1alpha1
+// +k8s:deepcopy-gen=package
+// +groupName=core.tilt.dev
+package v1alpha1
@@ -0,0 +1,9 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SchemeGroupVersion is the group version of the test resources, which the
+// builder registers when they're served.
+var SchemeGroupVersion = schema.GroupVersion{Group: "core.tilt.dev", Version: "v1alpha1"}
@@ -0,0 +1,380 @@
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+)
+
+// ScalableManifest is a resource with the scale subresource.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ScalableManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ScalableManifestSpec `json:"spec,omitempty"`
+}
+
+type ScalableManifestSpec struct {
+	Replicas int32 `json:"replicas"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ScalableManifestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ScalableManifest `json:"items"`
+}
+
+var _ resource.ObjectWithScaleSubResource = &ScalableManifest{}
+var _ resource.ObjectList = &ScalableManifestList{}
+
+func (in *ScalableManifest) GetObjectMeta() *metav1.ObjectMeta { return &in.ObjectMeta }
+func (in *ScalableManifest) NamespaceScoped() bool             { return false }
+func (in *ScalableManifest) New() runtime.Object               { return &ScalableManifest{} }
+func (in *ScalableManifest) NewList() runtime.Object           { return &ScalableManifestList{} }
+func (in *ScalableManifest) IsStorageVersion() bool            { return true }
+
+func (in *ScalableManifest) GetGroupVersionResource() schema.GroupVersionResource {
+	return SchemeGroupVersion.WithResource("scalablemanifests")
+}
+
+func (in *ScalableManifest) GetScale() *autoscalingv1.Scale {
+	return &autoscalingv1.Scale{
+		Spec:   autoscalingv1.ScaleSpec{Replicas: in.Spec.Replicas},
+		Status: autoscalingv1.ScaleStatus{Replicas: in.Spec.Replicas},
+	}
+}
+
+func (in *ScalableManifest) SetScale(scale *autoscalingv1.Scale) {
+	in.Spec.Replicas = scale.Spec.Replicas
+}
+
+func (in *ScalableManifestList) GetListMeta() *metav1.ListMeta { return &in.ListMeta }
+
+// Button is a resource with the "click" action subresource.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Button struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ButtonSpec `json:"spec,omitempty"`
+}
+
+type ButtonSpec struct {
+	Clicks     int32  `json:"clicks,omitempty"`
+	LastReason string `json:"lastReason,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ButtonList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Button `json:"items"`
+}
+
+// ButtonClick is the request body of the "click" action.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ButtonClick struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Reason string `json:"reason"`
+}
+
+// ButtonClickResult is the result of the "click" action.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ButtonClickResult struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Clicks int32 `json:"clicks"`
+}
+
+var _ resource.ObjectWithActionSubResources = &Button{}
+var _ resource.ObjectList = &ButtonList{}
+
+func (in *Button) GetObjectMeta() *metav1.ObjectMeta { return &in.ObjectMeta }
+func (in *Button) NamespaceScoped() bool             { return false }
+func (in *Button) New() runtime.Object               { return &Button{} }
+func (in *Button) NewList() runtime.Object           { return &ButtonList{} }
+func (in *Button) IsStorageVersion() bool            { return true }
+
+func (in *Button) GetGroupVersionResource() schema.GroupVersionResource {
+	return SchemeGroupVersion.WithResource("buttons")
+}
+
+func (in *Button) ActionSubResources() []resource.ActionSubResource {
+	return []resource.ActionSubResource{clickAction{}}
+}
+
+func (in *ButtonList) GetListMeta() *metav1.ListMeta { return &in.ListMeta }
+
+// clickAction counts the clicks of a button.
+type clickAction struct{}
+
+func (clickAction) Name() string               { return "click" }
+func (clickAction) NewRequest() runtime.Object { return &ButtonClick{} }
+func (clickAction) NewResult() runtime.Object  { return &ButtonClickResult{} }
+
+func (clickAction) Handle(ctx context.Context, parent resource.Object, request runtime.Object, update resource.ActionUpdateFunc) (runtime.Object, error) {
+	click := request.(*ButtonClick)
+	if click.Reason == "" {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("%s: reason is required", parent.GetObjectMeta().Name))
+	}
+	updated, err := update(ctx, func(parent resource.Object) error {
+		button := parent.(*Button)
+		button.Spec.Clicks++
+		button.Spec.LastReason = click.Reason
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ButtonClickResult{Clicks: updated.(*Button).Spec.Clicks}, nil
+}
+
+// ManifestSummary is computed from a Manifest.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ManifestSummary struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Shout  string `json:"shout"`
+	Length int32  `json:"length"`
+}
+
+// Gadget has a schema with constraints and defaults.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Gadget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GadgetSpec `json:"spec,omitempty"`
+}
+
+type GadgetSpec struct {
+	// Owner is required, and a pointer so that it has no default. Decoding
+	// sets it to null when it's missing.
+	Owner *string `json:"owner"`
+
+	// +default="small"
+	Size GadgetSize `json:"size,omitempty"`
+
+	// +default=1
+	// +k8s:validation:minimum=1
+	// +k8s:validation:maximum=10
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// +k8s:validation:pattern="^[a-z-]+$"
+	Name string `json:"name,omitempty"`
+
+	Expires *metav1.Time `json:"expires,omitempty"`
+}
+
+// +enum
+type GadgetSize string
+
+const (
+	GadgetSizeSmall GadgetSize = "small"
+	GadgetSizeLarge GadgetSize = "large"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type GadgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Gadget `json:"items"`
+}
+
+var _ resource.Object = &Gadget{}
+var _ resource.ObjectList = &GadgetList{}
+
+func (in *Gadget) GetObjectMeta() *metav1.ObjectMeta { return &in.ObjectMeta }
+func (in *Gadget) NamespaceScoped() bool             { return false }
+func (in *Gadget) New() runtime.Object               { return &Gadget{} }
+func (in *Gadget) NewList() runtime.Object           { return &GadgetList{} }
+func (in *Gadget) IsStorageVersion() bool            { return true }
+
+func (in *Gadget) GetGroupVersionResource() schema.GroupVersionResource {
+	return SchemeGroupVersion.WithResource("gadgets")
+}
+
+func (in *GadgetList) GetListMeta() *metav1.ListMeta { return &in.ListMeta }
+
+// Widget declares the merge keys of its lists only in its OpenAPI definition.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WidgetSpec   `json:"spec,omitempty"`
+	Status WidgetStatus `json:"status,omitempty"`
+}
+
+type WidgetSpec struct {
+	Ports []WidgetPort `json:"ports,omitempty"`
+}
+
+type WidgetPort struct {
+	Name string `json:"name"`
+	Port int32  `json:"port,omitempty"`
+}
+
+type WidgetStatus struct {
+	Conditions []WidgetCondition `json:"conditions,omitempty"`
+}
+
+type WidgetCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Widget `json:"items"`
+}
+
+var _ resource.Object = &Widget{}
+var _ resource.ObjectWithStatusSubResource = &Widget{}
+var _ resource.ObjectList = &WidgetList{}
+
+func (in *Widget) GetObjectMeta() *metav1.ObjectMeta { return &in.ObjectMeta }
+func (in *Widget) NamespaceScoped() bool             { return false }
+func (in *Widget) New() runtime.Object               { return &Widget{} }
+func (in *Widget) NewList() runtime.Object           { return &WidgetList{} }
+func (in *Widget) IsStorageVersion() bool            { return true }
+
+func (in *Widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return SchemeGroupVersion.WithResource("widgets")
+}
+
+func (in *Widget) GetStatus() resource.StatusSubResource { return in.Status }
+
+func (in WidgetStatus) CopyTo(parent resource.ObjectWithStatusSubResource) {
+	parent.(*Widget).Status = in
+}
+
+func (in *WidgetList) GetListMeta() *metav1.ListMeta { return &in.ListMeta }
+
+// OpenAPIDefinition merges the ports by name. openapi-gen only publishes patch
+// strategies that match the struct tags, which would also apply them.
+func (WidgetSpec) OpenAPIDefinition() common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"ports": mergeList("name", map[string]spec.Schema{
+						"name": {SchemaProps: spec.SchemaProps{Type: []string{"string"}}},
+						"port": {SchemaProps: spec.SchemaProps{Type: []string{"integer"}, Format: "int32"}},
+					}),
+				},
+			},
+		},
+	}
+}
+
+// OpenAPIDefinition merges the conditions by type.
+func (WidgetStatus) OpenAPIDefinition() common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"conditions": mergeList("type", map[string]spec.Schema{
+						"type":   {SchemaProps: spec.SchemaProps{Type: []string{"string"}}},
+						"status": {SchemaProps: spec.SchemaProps{Type: []string{"string"}}},
+					}),
+				},
+			},
+		},
+	}
+}
+
+// mergeList returns the schema of a list of objects with the properties that's
+// merged by the key.
+func mergeList(key string, props map[string]spec.Schema) spec.Schema {
+	s := spec.Schema{SchemaProps: spec.SchemaProps{
+		Type: []string{"array"},
+		Items: &spec.SchemaOrArray{Schema: &spec.Schema{SchemaProps: spec.SchemaProps{
+			Type:       []string{"object"},
+			Required:   []string{key},
+			Properties: props,
+		}}},
+	}}
+	s.AddExtension("x-kubernetes-patch-strategy", "merge")
+	s.AddExtension("x-kubernetes-patch-merge-key", key)
+	return s
+}
+
+// Gizmo has no generated OpenAPI definitions.
+//
+// +k8s:openapi-gen=false
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Gizmo struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GizmoSpec `json:"spec,omitempty"`
+}
+
+// +k8s:openapi-gen=false
+type GizmoSpec struct {
+	Color    string            `json:"color"`
+	Note     string            `json:"note" openapi:"optional"`
+	Size     *int32            `json:"size,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Parts    []GizmoPart       `json:"parts,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+	LastSeen *metav1.Time      `json:"lastSeen,omitempty"`
+}
+
+// +k8s:openapi-gen=false
+type GizmoPart struct {
+	Name  string `json:"name"`
+	Count int32  `json:"count,omitempty"`
+}
+
+// +k8s:openapi-gen=false
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type GizmoList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Gizmo `json:"items"`
+}
+
+var _ resource.Object = &Gizmo{}
+var _ resource.ObjectList = &GizmoList{}
+
+func (in *Gizmo) GetObjectMeta() *metav1.ObjectMeta { return &in.ObjectMeta }
+func (in *Gizmo) NamespaceScoped() bool             { return false }
+func (in *Gizmo) New() runtime.Object               { return &Gizmo{} }
+func (in *Gizmo) NewList() runtime.Object           { return &GizmoList{} }
+func (in *Gizmo) IsStorageVersion() bool            { return true }
+
+func (in *Gizmo) GetGroupVersionResource() schema.GroupVersionResource {
+	return SchemeGroupVersion.WithResource("gizmos")
+}
+
+func (in *GizmoList) GetListMeta() *metav1.ListMeta { return &in.ListMeta }
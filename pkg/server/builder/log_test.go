@@ -0,0 +1,147 @@
+package builder_test
+
+import (
+	"bufio"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestLogSubResource(t *testing.T) {
+	store := filepath.NewMemoryLogStore(0)
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithLogSubResource(&corev1alpha1.Manifest{}, store))
+	defer f.tearDown()
+
+	_, err := f.client.CoreV1alpha1().Manifests().Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// controllers append through the API, or to the store directly
+	restClient := f.client.CoreV1alpha1().RESTClient()
+	_, err = restClient.Post().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("log").
+		Body([]byte("building\n")).
+		DoRaw(f.ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.Append("", "my-manifest", []byte("built\n")))
+
+	out, err := restClient.Get().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("log").
+		DoRaw(f.ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "building\nbuilt\n", string(out))
+
+	out, err = restClient.Get().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("log").
+		Param("tailLines", "1").
+		DoRaw(f.ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "built\n", string(out))
+
+	_, err = restClient.Get().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("log").
+		Param("tailLines", "many").
+		DoRaw(f.ctx)
+	assert.True(t, apierrors.IsBadRequest(err), "expected bad request, got %v", err)
+
+	_, err = restClient.Get().
+		Resource("manifests").
+		Name("missing-manifest").
+		SubResource("log").
+		DoRaw(f.ctx)
+	assert.True(t, apierrors.IsNotFound(err), "expected not found, got %v", err)
+
+	// followers see lines as they're appended
+	stream, err := restClient.Get().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("log").
+		Param("follow", "true").
+		Param("tailLines", "1").
+		Stream(f.ctx)
+	require.NoError(t, err)
+	defer stream.Close()
+	reader := bufio.NewReader(stream)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "built\n", line)
+
+	require.NoError(t, store.Append("", "my-manifest", []byte("serving\n")))
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "serving\n", line)
+}
+
+func TestLogSubResourceRemovedWithObject(t *testing.T) {
+	store := filepath.NewMemoryLogStore(0)
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithLogSubResource(&corev1alpha1.Manifest{}, store))
+	defer f.tearDown()
+
+	manifests := f.client.CoreV1alpha1().Manifests()
+	restClient := f.client.CoreV1alpha1().RESTClient()
+	manifest := &corev1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"}}
+	_, err := manifests.Create(f.ctx, manifest, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Append("", "my-manifest", []byte("built\n")))
+
+	// a new object of the same name starts with an empty log
+	require.NoError(t, manifests.Delete(f.ctx, "my-manifest", metav1.DeleteOptions{}))
+	_, err = manifests.Create(f.ctx, manifest, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	out, err := restClient.Get().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("log").
+		DoRaw(f.ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(out))
+}
+
+func TestLogSubResourceRemovedWithCollection(t *testing.T) {
+	store := filepath.NewMemoryLogStore(0)
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithLogSubResource(&corev1alpha1.Manifest{}, store))
+	defer f.tearDown()
+
+	manifests := f.client.CoreV1alpha1().Manifests()
+	restClient := f.client.CoreV1alpha1().RESTClient()
+	manifest := &corev1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"}}
+	_, err := manifests.Create(f.ctx, manifest, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Append("", "my-manifest", []byte("built\n")))
+
+	require.NoError(t, manifests.DeleteCollection(f.ctx, metav1.DeleteOptions{}, metav1.ListOptions{}))
+	_, err = manifests.Create(f.ctx, manifest, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	out, err := restClient.Get().
+		Resource("manifests").
+		Name("my-manifest").
+		SubResource("log").
+		DoRaw(f.ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(out))
+}
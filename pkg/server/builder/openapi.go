@@ -0,0 +1,309 @@
+package builder
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	openapicommon "k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/util"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+// WithOpenAPIDefinitionsFromTypes derives the OpenAPI definitions of the
+// resources from their Go types when the server starts, so that the types
+// don't need definitions generated by openapi-gen. The definitions are served
+// as OpenAPI v2 and v3, and used for server-side apply and validation.
+//
+// Fields are named by their json tags. Fields that are omitted when empty, or
+// tagged `openapi:"optional"`, are optional, and other fields are required.
+// The patchStrategy and patchMergeKey tags are published as extensions, like
+// openapi-gen does. Types can customize their definitions with the methods
+// that openapi-gen recognizes, e.g. OpenAPIDefinition or OpenAPISchemaType.
+// Derived definitions have no descriptions, since comments aren't available at
+// runtime.
+//
+// Definitions registered with WithOpenAPIDefinitions take precedence, so
+// generated definitions can be kept for some types.
+func (a *Server) WithOpenAPIDefinitionsFromTypes(name, version string) *Server {
+	a.reflectDefinitions = true
+	return a.withOpenAPIInfo(name, version)
+}
+
+// reflectOpenAPIDefinitions derives the definitions of the types registered in
+// the scheme and of the types they refer to.
+func reflectOpenAPIDefinitions(scheme *runtime.Scheme, ref openapicommon.ReferenceCallback) map[string]openapicommon.OpenAPIDefinition {
+	types := map[string]reflect.Type{}
+	for _, t := range scheme.AllKnownTypes() {
+		types[modelName(t)] = t
+	}
+	for _, obj := range nonResourceTypes {
+		t := reflect.TypeOf(obj)
+		types[modelName(t)] = t
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r := &openAPIReflector{ref: ref, defs: map[string]openapicommon.OpenAPIDefinition{}}
+	for _, name := range names {
+		r.define(types[name])
+	}
+	return r.defs
+}
+
+// nonResourceTypes are the types that the server's own endpoints return, which
+// aren't registered in the scheme.
+var nonResourceTypes = []interface{}{
+	version.Info{},
+	metav1.APIGroup{},
+	metav1.APIGroupList{},
+	metav1.APIResourceList{},
+	metav1.APIVersions{},
+	metav1.Patch{},
+	metav1.Status{},
+}
+
+// openAPIReflector derives OpenAPI definitions from Go types, the way
+// openapi-gen derives them from Go source.
+type openAPIReflector struct {
+	ref  openapicommon.ReferenceCallback
+	defs map[string]openapicommon.OpenAPIDefinition
+}
+
+// define adds the definition of the named type t, and of the types it refers
+// to, and returns its name.
+func (r *openAPIReflector) define(t reflect.Type) string {
+	name := modelName(t)
+	if _, ok := r.defs[name]; ok {
+		return name
+	}
+	if def, ok := customDefinition(t); ok {
+		r.defs[name] = def
+		return name
+	}
+
+	// Reserve the name first, so that recursive types terminate.
+	r.defs[name] = openapicommon.OpenAPIDefinition{}
+	deps := map[string]bool{}
+	schema := r.structSchema(t, deps)
+	r.defs[name] = openapicommon.OpenAPIDefinition{Schema: schema, Dependencies: sortedNames(deps)}
+	return name
+}
+
+// structSchema returns the schema of the struct type t, and adds the
+// definitions it refers to to deps.
+func (r *openAPIReflector) structSchema(t reflect.Type, deps map[string]bool) spec.Schema {
+	schema := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := parseJSONTag(f.Tag.Get("json"))
+		if name == "-" && len(opts) == 0 {
+			continue
+		}
+
+		// Embedded structs without a name are inlined, like encoding/json
+		// does.
+		if f.Anonymous && name == "" && indirect(f.Type).Kind() == reflect.Struct {
+			embedded := r.structSchema(indirect(f.Type), deps)
+			for name, prop := range embedded.Properties {
+				schema.SetProperty(name, prop)
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, ok := r.schema(f.Type, deps)
+		if !ok {
+			continue
+		}
+		omitEmpty := opts["omitempty"] || opts["omitzero"]
+		if def := zeroDefault(f.Type, omitEmpty); def != nil {
+			prop.Default = def
+		}
+		if strategy := f.Tag.Get("patchStrategy"); strategy != "" {
+			prop.AddExtension("x-kubernetes-patch-strategy", strategy)
+		}
+		if key := f.Tag.Get("patchMergeKey"); key != "" {
+			prop.AddExtension("x-kubernetes-patch-merge-key", key)
+		}
+		schema.SetProperty(name, prop)
+
+		_, markers := parseJSONTag("," + f.Tag.Get("openapi"))
+		if !omitEmpty && !markers["optional"] {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// schema returns the schema of a value of type t, and adds the definitions it
+// refers to to deps. It returns false if the type can't be represented in
+// JSON.
+func (r *openAPIReflector) schema(t reflect.Type, deps map[string]bool) (spec.Schema, bool) {
+	t = indirect(t)
+	if t == reflect.TypeOf(time.Time{}) {
+		return simpleSchema("time.Time"), true
+	}
+	if _, ok := customDefinition(t); ok || (t.Kind() == reflect.Struct && t.Name() != "") {
+		name := r.define(t)
+		deps[name] = true
+		return spec.Schema{SchemaProps: spec.SchemaProps{Ref: r.ref(name)}}, true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return r.structSchema(t, deps), true
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return simpleSchema("[]byte"), true
+		}
+		items, ok := r.schema(t.Elem(), deps)
+		if !ok {
+			return spec.Schema{}, false
+		}
+		items.Default = zeroDefault(t.Elem(), false)
+		return spec.Schema{SchemaProps: spec.SchemaProps{
+			Type:  []string{"array"},
+			Items: &spec.SchemaOrArray{Schema: &items},
+		}}, true
+	case reflect.Map:
+		values, ok := r.schema(t.Elem(), deps)
+		if !ok {
+			return spec.Schema{}, false
+		}
+		values.Default = zeroDefault(t.Elem(), false)
+		return spec.Schema{SchemaProps: spec.SchemaProps{
+			Type:                 []string{"object"},
+			AdditionalProperties: &spec.SchemaOrBool{Allows: true, Schema: &values},
+		}}, true
+	case reflect.Interface:
+		schema := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}}}
+		schema.AddExtension("x-kubernetes-preserve-unknown-fields", true)
+		return schema, true
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return simpleSchema(t.Kind().String()), true
+	}
+	return spec.Schema{}, false
+}
+
+// customDefinition returns the definition of a type that customizes it with
+// the methods that openapi-gen recognizes.
+func customDefinition(t reflect.Type) (openapicommon.OpenAPIDefinition, bool) {
+	if t.Kind() == reflect.Interface || t.Kind() == reflect.Ptr {
+		return openapicommon.OpenAPIDefinition{}, false
+	}
+	v := reflect.New(t).Interface()
+	v2, hasV2 := v.(openapicommon.OpenAPIDefinitionGetter)
+	v3, hasV3 := v.(openapicommon.OpenAPIV3DefinitionGetter)
+	switch {
+	case hasV2 && hasV3:
+		return openapicommon.EmbedOpenAPIDefinitionIntoV2Extension(v3.OpenAPIV3Definition(), v2.OpenAPIDefinition()), true
+	case hasV2:
+		return v2.OpenAPIDefinition(), true
+	case hasV3:
+		return v3.OpenAPIV3Definition(), true
+	}
+
+	typer, ok := v.(interface {
+		OpenAPISchemaType() []string
+		OpenAPISchemaFormat() string
+	})
+	if !ok {
+		return openapicommon.OpenAPIDefinition{}, false
+	}
+	def := openapicommon.OpenAPIDefinition{Schema: spec.Schema{SchemaProps: spec.SchemaProps{
+		Type:   typer.OpenAPISchemaType(),
+		Format: typer.OpenAPISchemaFormat(),
+	}}}
+	if oneOf, ok := v.(interface{ OpenAPIV3OneOfTypes() []string }); ok {
+		v3 := openapicommon.OpenAPIDefinition{Schema: spec.Schema{SchemaProps: spec.SchemaProps{
+			OneOf:  openapicommon.GenerateOpenAPIV3OneOfSchema(oneOf.OpenAPIV3OneOfTypes()),
+			Format: typer.OpenAPISchemaFormat(),
+		}}}
+		return openapicommon.EmbedOpenAPIDefinitionIntoV2Extension(v3, def), true
+	}
+	return def, true
+}
+
+// zeroDefault returns the default that openapi-gen sets for a field of type t,
+// i.e. the JSON value of the zero value of t if it's always serialized.
+func zeroDefault(t reflect.Type, omitEmpty bool) interface{} {
+	if _, ok := customDefinition(t); ok || t == reflect.TypeOf(time.Time{}) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return map[string]interface{}{}
+	case reflect.Bool:
+		if !omitEmpty {
+			return false
+		}
+	case reflect.String:
+		if !omitEmpty {
+			return ""
+		}
+	case reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !omitEmpty {
+			return 0
+		}
+	}
+	return nil
+}
+
+// simpleSchema returns the schema of a Go builtin type.
+func simpleSchema(typeName string) spec.Schema {
+	typ, format := openapicommon.OpenAPITypeFormat(typeName)
+	return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{typ}, Format: format}}
+}
+
+// modelName returns the name of the definition of t, which is the name that
+// the OpenAPI builder refers to it by.
+func modelName(t reflect.Type) string {
+	return util.GetCanonicalTypeName(reflect.New(indirect(t)).Interface())
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// parseJSONTag returns the name and the options of a json struct tag.
+func parseJSONTag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	opts := map[string]bool{}
+	for _, opt := range parts[1:] {
+		opts[opt] = true
+	}
+	return parts[0], opts
+}
+
+func sortedNames(names map[string]bool) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,100 @@
+package builder_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/transport"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	testapiv1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/start"
+)
+
+func TestOpenAPIDefinitionsFromTypes(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Gizmo{}, "data").
+		WithOpenAPIDefinitionsFromTypes("tilt", "0.1.0"))
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	gizmos := dc.Resource((&testapiv1alpha1.Gizmo{}).GetGroupVersionResource())
+
+	apply := func(manager string, spec map[string]interface{}) *unstructured.Unstructured {
+		obj, err := gizmos.Apply(f.ctx, "my-gizmo", &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "core.tilt.dev/v1alpha1",
+			"kind":       "Gizmo",
+			"metadata":   map[string]interface{}{"name": "my-gizmo"},
+			"spec":       spec,
+		}}, metav1.ApplyOptions{FieldManager: manager})
+		require.NoError(t, err)
+		return obj
+	}
+
+	// server-side apply merges the parts by name, as the patchMergeKey tag says
+	apply("alice", map[string]interface{}{
+		"color": "red",
+		"parts": []interface{}{map[string]interface{}{"name": "wheel", "count": int64(4)}},
+	})
+	obj := apply("bob", map[string]interface{}{
+		"color": "red",
+		"parts": []interface{}{map[string]interface{}{"name": "door", "count": int64(2)}},
+	})
+	parts, _, err := unstructured.NestedSlice(obj.Object, "spec", "parts")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "wheel", "count": int64(4)},
+		map[string]interface{}{"name": "door", "count": int64(2)},
+	}, parts)
+
+	trConfig, err := f.config.GenericConfig.LoopbackClientConfig.TransportConfig()
+	require.NoError(t, err)
+	tr, err := transport.New(trConfig)
+	require.NoError(t, err)
+	get := func(path string) string {
+		resp, err := (&http.Client{Transport: tr}).Get("https://127.0.0.1:443" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(content))
+		return string(content)
+	}
+
+	for _, path := range []string{"/openapi/v2", "/openapi/v3/apis/core.tilt.dev/v1alpha1"} {
+		content := get(path)
+		assert.Contains(t, content, `"x-kubernetes-group-version-kind":[{"group":"core.tilt.dev","kind":"Gizmo","version":"v1alpha1"}]`, path)
+		assert.Contains(t, content, `"required":["color"]`, path)
+		assert.Contains(t, content, `"x-kubernetes-patch-merge-key":"name","x-kubernetes-patch-strategy":"merge"`, path)
+		// generated definitions take precedence
+		assert.Contains(t, content, `"description":"ObjectMeta is metadata that all persisted resources must have`, path)
+	}
+}
+
+func TestOpenAPIDefinitionsFromTypesOnly(t *testing.T) {
+	f := runFixture(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Gizmo{}, "data").
+		WithOpenAPIDefinitionsFromTypes("tilt", "0.1.0"), func(*start.TiltServerOptions) {})
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	obj, err := dc.Resource((&testapiv1alpha1.Gizmo{}).GetGroupVersionResource()).Apply(f.ctx, "my-gizmo", &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1alpha1",
+		"kind":       "Gizmo",
+		"metadata":   map[string]interface{}{"name": "my-gizmo", "labels": map[string]interface{}{"owner": "me"}},
+		"spec":       map[string]interface{}{"color": "red", "lastSeen": "2020-01-01T00:00:00Z"},
+	}}, metav1.ApplyOptions{FieldManager: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, "me", obj.GetLabels()["owner"])
+	if assert.Len(t, obj.GetManagedFields(), 1) {
+		assert.Contains(t, string(obj.GetManagedFields()[0].FieldsV1.Raw), `"f:lastSeen":{}`)
+	}
+}
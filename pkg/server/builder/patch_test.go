@@ -0,0 +1,154 @@
+package builder_test
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	testapiv1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/v1alpha1"
+)
+
+func TestStrategicMergePatchFromOpenAPI(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Widget{}, "data"))
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	widgets := dc.Resource((&testapiv1alpha1.Widget{}).GetGroupVersionResource())
+
+	obj, err := widgets.Create(f.ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1alpha1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "my-widget"},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{map[string]interface{}{"name": "http", "port": int64(80)}},
+		},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	ports := func(obj *unstructured.Unstructured) []interface{} {
+		ports, _, err := unstructured.NestedSlice(obj.Object, "spec", "ports")
+		require.NoError(t, err)
+		return ports
+	}
+
+	// the ports are merged by name, as the OpenAPI definition says
+	obj, err = widgets.Patch(f.ctx, "my-widget", types.StrategicMergePatchType,
+		[]byte(`{"spec":{"ports":[{"name":"http","port":8080},{"name":"https","port":443}]}}`), metav1.PatchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "http", "port": int64(8080)},
+		map[string]interface{}{"name": "https", "port": int64(443)},
+	}, ports(obj))
+
+	obj, err = widgets.Patch(f.ctx, "my-widget", types.StrategicMergePatchType,
+		[]byte(`{"spec":{"ports":[{"name":"http","$patch":"delete"}]}}`), metav1.PatchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "https", "port": int64(443)},
+	}, ports(obj))
+
+	// directives of merged lists are honored, as sent by kubectl
+	obj, err = widgets.Patch(f.ctx, "my-widget", types.StrategicMergePatchType,
+		[]byte(`{"spec":{"$setElementOrder/ports":[{"name":"ssh"},{"name":"https"}],"ports":[{"name":"ssh","port":22}]}}`), metav1.PatchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "ssh", "port": int64(22)},
+		map[string]interface{}{"name": "https", "port": int64(443)},
+	}, ports(obj))
+
+	// the status subresource is merged too
+	obj, err = widgets.Patch(f.ctx, "my-widget", types.StrategicMergePatchType,
+		[]byte(`{"status":{"conditions":[{"type":"Ready","status":"False"}]}}`), metav1.PatchOptions{}, "status")
+	require.NoError(t, err)
+	obj, err = widgets.Patch(f.ctx, "my-widget", types.StrategicMergePatchType,
+		[]byte(`{"status":{"conditions":[{"type":"Healthy","status":"True"}]}}`), metav1.PatchOptions{}, "status")
+	require.NoError(t, err)
+	conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": "False"},
+		map[string]interface{}{"type": "Healthy", "status": "True"},
+	}, conditions)
+
+	// a resourceVersion in the patch is a precondition
+	_, err = widgets.Patch(f.ctx, "my-widget", types.StrategicMergePatchType,
+		[]byte(`{"metadata":{"resourceVersion":"1"},"spec":{"ports":[{"name":"http","port":80}]}}`), metav1.PatchOptions{})
+	assert.True(t, apierrors.IsConflict(err), "expected conflict, got %v", err)
+
+	_, err = widgets.Patch(f.ctx, "not-found", types.StrategicMergePatchType,
+		[]byte(`{"spec":{"ports":[]}}`), metav1.PatchOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "expected not found, got %v", err)
+}
+
+func TestStrategicMergePatchBeforeAdmission(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Widget{}, "data").
+		WithAdmissionPlugin("PortCounter", func(io.Reader) (admission.Interface, error) {
+			return &portCounter{Handler: admission.NewHandler(admission.Create, admission.Update)}, nil
+		}))
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	widgets := dc.Resource((&testapiv1alpha1.Widget{}).GetGroupVersionResource())
+
+	_, err = widgets.Create(f.ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1alpha1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "my-widget"},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{map[string]interface{}{"name": "http", "port": int64(80)}},
+		},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// mutating admission sees the merged ports, and its changes are kept
+	obj, err := widgets.Patch(f.ctx, "my-widget", types.StrategicMergePatchType,
+		[]byte(`{"spec":{"ports":[{"name":"https","port":443}]}}`), metav1.PatchOptions{FieldManager: "patcher"})
+	require.NoError(t, err)
+	assert.Equal(t, "2", obj.GetLabels()["ports"])
+	ports, _, err := unstructured.NestedSlice(obj.Object, "spec", "ports")
+	require.NoError(t, err)
+	assert.Len(t, ports, 2)
+
+	// the change of the ports is managed by the patcher
+	var managed string
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager == "patcher" {
+			managed = string(entry.FieldsV1.Raw)
+		}
+	}
+	assert.Contains(t, managed, `"f:ports"`)
+}
+
+// portCounter labels widgets with their number of ports.
+type portCounter struct {
+	*admission.Handler
+}
+
+var _ admission.MutationInterface = &portCounter{}
+
+func (c *portCounter) Admit(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	widget, ok := a.GetObject().(*testapiv1alpha1.Widget)
+	if !ok {
+		return nil
+	}
+	if widget.Labels == nil {
+		widget.Labels = map[string]string{}
+	}
+	widget.Labels["ports"] = strconv.Itoa(len(widget.Spec.Ports))
+	return nil
+}
@@ -0,0 +1,125 @@
+package builder_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionregistrationv1client "k8s.io/client-go/kubernetes/typed/admissionregistration/v1"
+
+	corev1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+)
+
+func TestValidatingAdmissionPolicy(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithValidatingAdmissionPolicy())
+	defer f.tearDown()
+
+	client, err := admissionregistrationv1client.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+
+	policy, err := client.ValidatingAdmissionPolicies().Create(f.ctx, &admissionregistrationv1.ValidatingAdmissionPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "require-owner"},
+		Spec: admissionregistrationv1.ValidatingAdmissionPolicySpec{
+			MatchConstraints: &admissionregistrationv1.MatchResources{
+				ResourceRules: []admissionregistrationv1.NamedRuleWithOperations{{
+					RuleWithOperations: admissionregistrationv1.RuleWithOperations{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{"core.tilt.dev"},
+							APIVersions: []string{"*"},
+							Resources:   []string{"manifests"},
+						},
+					},
+				}},
+			},
+			Validations: []admissionregistrationv1.Validation{{
+				Expression: "has(object.metadata.labels) && 'owner' in object.metadata.labels",
+				Message:    "manifests must have an owner",
+			}},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, policy.Spec.FailurePolicy)
+	assert.Equal(t, admissionregistrationv1.Fail, *policy.Spec.FailurePolicy)
+
+	_, err = client.ValidatingAdmissionPolicyBindings().Create(f.ctx, &admissionregistrationv1.ValidatingAdmissionPolicyBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "require-owner"},
+		Spec: admissionregistrationv1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        "require-owner",
+			ValidationActions: []admissionregistrationv1.ValidationAction{admissionregistrationv1.Deny},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	manifests := f.client.CoreV1alpha1().Manifests()
+
+	// policies take effect once the plugin sees them
+	require.Eventually(t, func() bool {
+		_, err := manifests.Create(f.ctx, &corev1alpha1.Manifest{
+			ObjectMeta: metav1.ObjectMeta{Name: "unowned"},
+		}, metav1.CreateOptions{})
+		if err == nil {
+			require.NoError(t, manifests.Delete(f.ctx, "unowned", metav1.DeleteOptions{}))
+			return false
+		}
+		require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+		assert.Contains(t, err.Error(), "manifests must have an owner")
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+
+	_, err = manifests.Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "owned", Labels: map[string]string{"owner": "me"}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func TestValidatingAdmissionPolicyInvalid(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&corev1alpha1.Manifest{}, "data").
+		WithValidatingAdmissionPolicy())
+	defer f.tearDown()
+
+	client, err := admissionregistrationv1client.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+
+	_, err = client.ValidatingAdmissionPolicies().Create(f.ctx, &admissionregistrationv1.ValidatingAdmissionPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "bad-cel"},
+		Spec: admissionregistrationv1.ValidatingAdmissionPolicySpec{
+			ParamKind: &admissionregistrationv1.ParamKind{APIVersion: "v1", Kind: "ConfigMap"},
+			MatchConstraints: &admissionregistrationv1.MatchResources{
+				ResourceRules: []admissionregistrationv1.NamedRuleWithOperations{{
+					RuleWithOperations: admissionregistrationv1.RuleWithOperations{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{"core.tilt.dev"},
+							APIVersions: []string{"*"},
+							Resources:   []string{"manifests"},
+						},
+					},
+				}},
+			},
+			Validations: []admissionregistrationv1.Validation{{Expression: "object.metadata.name =="}},
+		},
+	}, metav1.CreateOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), "spec.paramKind")
+	assert.Contains(t, err.Error(), "spec.validations[0].expression")
+
+	_, err = client.ValidatingAdmissionPolicyBindings().Create(f.ctx, &admissionregistrationv1.ValidatingAdmissionPolicyBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "deny-and-warn"},
+		Spec: admissionregistrationv1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName: "require-owner",
+			ValidationActions: []admissionregistrationv1.ValidationAction{
+				admissionregistrationv1.Deny, admissionregistrationv1.Warn},
+		},
+	}, metav1.CreateOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), "must not contain both Deny and Warn")
+}
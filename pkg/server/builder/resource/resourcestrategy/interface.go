@@ -82,6 +82,35 @@ type PrepareForUpdater interface {
 	PrepareForUpdate(ctx context.Context, old runtime.Object)
 }
 
+// PrinterColumn is a column printed by `kubectl get`, like the additionalPrinterColumns of a
+// CustomResourceDefinition.
+type PrinterColumn struct {
+	// Name is the column header.
+	Name string
+	// Type is the OpenAPI type of the column: integer, number, string, boolean or date.
+	Type string
+	// Format is an optional OpenAPI format of the column, e.g. "name" or "byte".
+	Format string
+	// Description is a human readable description of the column.
+	Description string
+	// Priority is the importance of the column. Columns with a priority greater than 0 are only
+	// printed with `kubectl get -o wide`.
+	Priority int32
+	// JSONPath is a simple JSONPath (i.e. with array notation) evaluated against each object,
+	// e.g. ".spec.message".
+	JSONPath string
+}
+
+// PrinterColumner functions declare the columns printed by `kubectl get`. If PrinterColumns is
+// implemented for a type, the DefaultStrategy prints its columns after the object's name, instead of
+// only printing its name and age. Age is printed as the last column, unless one of the columns
+// is for .metadata.creationTimestamp.
+//
+// PrinterColumner is ignored for types that implement TableConverter.
+type PrinterColumner interface {
+	PrinterColumns() []PrinterColumn
+}
+
 // TableConverter functions are invoked when printing an object from `kubectl get`.
 type TableConverter interface {
 	ConvertToTable(ctx context.Context, tableOptions runtime.Object) (*metav1.Table, error)
@@ -98,3 +127,36 @@ type Validater interface {
 type ValidateUpdater interface {
 	ValidateUpdate(ctx context.Context, obj runtime.Object) field.ErrorList
 }
+
+// ValidationRule is a CEL validation rule, like the x-kubernetes-validations of a
+// CustomResourceDefinition. The rule's self variable is the object, and oldSelf is the
+// object before an update.
+type ValidationRule struct {
+	// Rule is a CEL expression that evaluates to true if the object is valid, e.g.
+	// "self.spec.replicas <= 10". Rules that use oldSelf are only evaluated on updates.
+	Rule string `json:"rule"`
+	// Message is the error message returned when the rule fails, e.g. "too many replicas".
+	// It defaults to "failed rule: " followed by the rule.
+	Message string `json:"message,omitempty"`
+	// MessageExpression is a CEL expression that evaluates to the error message, and takes
+	// precedence over Message unless it evaluates to an empty string.
+	MessageExpression string `json:"messageExpression,omitempty"`
+	// Reason is the type of the error returned when the rule fails: FieldValueInvalid,
+	// FieldValueForbidden, FieldValueRequired or FieldValueDuplicate. It defaults to
+	// FieldValueInvalid.
+	Reason field.ErrorType `json:"reason,omitempty"`
+	// FieldPath is the path of the field reported in the error, relative to self, e.g.
+	// ".spec.replicas". It defaults to the path of self.
+	FieldPath string `json:"fieldPath,omitempty"`
+}
+
+// ValidationRuler functions declare CEL validation rules for an object, in addition to the
+// x-kubernetes-validations of its OpenAPI definitions, e.g. the ones that openapi-gen generates
+// from +k8s:validation:cel markers. If ValidationRules is implemented for a type, the
+// DefaultStrategy evaluates its rules on create and update.
+//
+// Rules are compiled against the OpenAPI definition of the type when the server starts, so
+// the definitions must be registered with the builder's WithOpenAPIDefinitions.
+type ValidationRuler interface {
+	ValidationRules() []ValidationRule
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package resource
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/apiserver"
@@ -95,6 +96,28 @@ type GenericSubResource interface {
 	GetStorageProvider(parentObj Object, rootPath string, parentSP apiserver.StorageProvider) apiserver.StorageProvider
 }
 
+// ActionSubResource defines a POST-only subresource that invokes a Go handler on the parent resource,
+// e.g. to trigger or restart something, instead of writing fields into the parent's spec.
+type ActionSubResource interface {
+	// Name returns the path of the subresource, e.g. "trigger".
+	Name() string
+
+	// NewRequest returns a new instance of the request body -- e.g. &TriggerRequest{}
+	NewRequest() runtime.Object
+
+	// NewResult returns a new instance of the result returned by Handle -- e.g. &TriggerResult{}
+	NewResult() runtime.Object
+
+	// Handle is invoked for each request with the current parent object and the request body, after
+	// the request is authorized and admitted. The update function updates the parent through the
+	// normal update path.
+	Handle(ctx context.Context, parent Object, request runtime.Object, update ActionUpdateFunc) (result runtime.Object, err error)
+}
+
+// ActionUpdateFunc updates the parent of an action subresource. mutate is called with a copy of the
+// current parent, and may be called again if the parent changes concurrently. It returns the updated parent.
+type ActionUpdateFunc func(ctx context.Context, mutate func(parent Object) error) (Object, error)
+
 // ObjectWithStatusSubResource defines an interface for getting and setting the status sub-resource for a resource.
 type ObjectWithStatusSubResource interface {
 	Object
@@ -108,12 +131,33 @@ type ObjectWithScaleSubResource interface {
 	GetScale() (scaleSubResource *autoscalingv1.Scale)
 }
 
+// ObjectWithRevisionHistory retains previous revisions of each object and serves them
+// from the read-only "history" subresource.
+type ObjectWithRevisionHistory interface {
+	Object
+	// RevisionHistoryLimit is the number of revisions to retain for each object, including the current one.
+	RevisionHistoryLimit() int
+}
+
+// ObjectWithRollbackSubResource adds a "rollback" subresource that restores a retained revision.
+type ObjectWithRollbackSubResource interface {
+	ObjectWithRevisionHistory
+	// RollbackTo copies the spec of a previous revision onto the resource.
+	RollbackTo(revision runtime.Object)
+}
+
 // ObjectWithGenericSubResource adds arbitrary subresources to the resource.
 type ObjectWithGenericSubResource interface {
 	Object
 	GenericSubResources() []GenericSubResource
 }
 
+// ObjectWithActionSubResources adds POST-only action subresources to the resource.
+type ObjectWithActionSubResources interface {
+	Object
+	ActionSubResources() []ActionSubResource
+}
+
 // AddToScheme returns a function to add the Objects to the scheme.
 //
 // AddToScheme will register the objects returned by New and NewList under the GroupVersion for each object.
@@ -143,7 +187,7 @@ func AddToScheme(objs ...Object) func(s *runtime.Scheme) error {
 					return err
 				}
 				if err := s.AddConversionFunc(storageVersionObj, obj, func(from, to interface{}, _ conversion.Scope) error {
-					return from.(MultiVersionObject).ConvertFromStorageVersion(to.(runtime.Object))
+					return to.(MultiVersionObject).ConvertFromStorageVersion(from.(runtime.Object))
 				}); err != nil {
 					return err
 				}
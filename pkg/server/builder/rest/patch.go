@@ -0,0 +1,445 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/mergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	kjson "sigs.k8s.io/json"
+)
+
+const (
+	// PatchStrategyExtension is the OpenAPI extension that sets the strategic
+	// merge patch strategy of a field, e.g. "merge" or "retainKeys".
+	PatchStrategyExtension = "x-kubernetes-patch-strategy"
+
+	// PatchMergeKeyExtension is the OpenAPI extension that sets the key that
+	// identifies the items of a list that is merged.
+	PatchMergeKeyExtension = "x-kubernetes-patch-merge-key"
+)
+
+// The same limit the apiserver applies to resource request bodies.
+const maxRequestBytes = 3 * 1024 * 1024
+
+// The same number of times the apiserver retries patches on conflicts.
+const maxRetriesOnConflict = 5
+
+// StrategicMergePatcher applies strategic merge patches to resources using the
+// patch strategies and merge keys of their OpenAPI schemas.
+//
+// The apiserver only reads the patchStrategy and patchMergeKey struct tags of
+// Go types, so types whose merge semantics are only declared in their OpenAPI
+// definitions would have their lists replaced, and patches with directives for
+// those lists would be rejected. The patcher merges the strategic merge patches
+// of those resources and of their status subresource into the current object,
+// and passes the apiserver the result as a JSON merge patch, which replaces
+// lists with the merged ones. The apiserver then handles the patch as any
+// other, with authorization, admission and field management.
+type StrategicMergePatcher struct {
+	schemas map[schema.GroupVersionResource]patchSchema
+
+	mu      sync.RWMutex
+	getters map[schema.GroupVersionResource]rest.Getter
+}
+
+// NewStrategicMergePatcher returns a patcher for no resources.
+func NewStrategicMergePatcher() *StrategicMergePatcher {
+	return &StrategicMergePatcher{
+		schemas: map[schema.GroupVersionResource]patchSchema{},
+		getters: map[schema.GroupVersionResource]rest.Getter{},
+	}
+}
+
+// Register handles the strategic merge patches of the resource, if its
+// schema declares patch strategies or merge keys that the struct tags of obj's
+// type don't, since the apiserver handles the others. Fields the schema doesn't
+// describe fall back to the struct tags of obj's type. The schema must not
+// have refs.
+func (p *StrategicMergePatcher) Register(gvr schema.GroupVersionResource, s *spec.Schema, obj runtime.Object) {
+	var tags strategicpatch.LookupPatchMeta
+	if meta, err := strategicpatch.NewPatchMetaFromStruct(obj); err == nil {
+		tags = meta
+	}
+	if !declaresPatchMeta(s, tags) {
+		return
+	}
+	p.schemas[gvr] = patchSchema{schema: s, tags: tags}
+}
+
+// Enabled returns true if the patcher handles the patches of any resource.
+func (p *StrategicMergePatcher) Enabled() bool {
+	return p != nil && len(p.schemas) > 0
+}
+
+// WithStorage returns a provider of the storage of sp, which the patcher reads
+// the current objects of the resource from. The patches of resources without
+// storage that can get objects are left to the apiserver.
+func (p *StrategicMergePatcher) WithStorage(gvr schema.GroupVersionResource, sp ResourceHandlerProvider) ResourceHandlerProvider {
+	return func(s *runtime.Scheme, g generic.RESTOptionsGetter) (rest.Storage, error) {
+		storage, err := sp(s, g)
+		if err != nil {
+			return nil, err
+		}
+		if getter, ok := storage.(rest.Getter); ok {
+			p.mu.Lock()
+			p.getters[gvr] = getter
+			p.mu.Unlock()
+		}
+		return storage, nil
+	}
+}
+
+// WithHandler returns a handler that passes the strategic merge patches of the
+// registered resources to handler as JSON merge patches, and passes it all
+// other requests. handler must serve the resources.
+func (p *StrategicMergePatcher) WithHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lookup, getter, ok := p.lookup(req)
+		if !ok {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		patch, err := io.ReadAll(io.LimitReader(req.Body, maxRequestBytes+1))
+		if err != nil {
+			writeStatusError(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+		if len(patch) > maxRequestBytes {
+			writeStatusError(w, apierrors.NewRequestEntityTooLargeError(
+				fmt.Sprintf("limit is %d", maxRequestBytes)))
+			return
+		}
+
+		for attempt := 0; ; attempt++ {
+			body, contentType, retryable, err := p.mergePatch(req, getter, lookup, patch)
+			if err != nil {
+				writeStatusError(w, err)
+				return
+			}
+			forwarded := req.Clone(req.Context())
+			forwarded.Body = io.NopCloser(bytes.NewReader(body))
+			forwarded.ContentLength = int64(len(body))
+			forwarded.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			forwarded.Header.Set("Content-Type", contentType)
+			if !retryable || attempt == maxRetriesOnConflict {
+				handler.ServeHTTP(w, forwarded)
+				return
+			}
+
+			// the object changed since it was read, so merge again
+			recorder := newResponseRecorder()
+			handler.ServeHTTP(recorder, forwarded)
+			if recorder.code != http.StatusConflict {
+				recorder.writeTo(w)
+				return
+			}
+		}
+	})
+}
+
+// mergePatch merges the strategic merge patch into the current object with the
+// patch metadata of lookup, and returns the JSON merge patch that changes the
+// object to the result, with the current resourceVersion as a precondition.
+// The patch is retryable if that precondition wasn't part of the strategic
+// merge patch.
+//
+// Patches that can't be merged because they're malformed, or because the object
+// can't be read, are returned as they are, for the apiserver to reject.
+func (p *StrategicMergePatcher) mergePatch(req *http.Request, getter rest.Getter, lookup strategicpatch.LookupPatchMeta, patch []byte) ([]byte, string, bool, error) {
+	unchanged := string(types.StrategicMergePatchType)
+	patchMap := map[string]interface{}{}
+	if err := kjson.UnmarshalCaseSensitivePreserveInts(patch, &patchMap); err != nil {
+		return patch, unchanged, false, nil
+	}
+	info, _ := request.RequestInfoFrom(req.Context())
+	ctx := request.WithNamespace(req.Context(), info.Namespace)
+	current, err := getter.Get(ctx, info.Name, &metav1.GetOptions{})
+	if err != nil {
+		return patch, unchanged, false, nil
+	}
+	currentMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		return nil, "", false, err
+	}
+	merged, err := strategicpatch.StrategicMergeMapPatchUsingLookupPatchMeta(
+		runtime.DeepCopyJSON(currentMap), patchMap, lookup)
+	if err != nil {
+		return nil, "", false, interpretStrategicMergePatchError(err)
+	}
+
+	currentJSON, err := json.Marshal(currentMap)
+	if err != nil {
+		return nil, "", false, err
+	}
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return nil, "", false, err
+	}
+	diff, err := jsonpatch.CreateMergePatch(currentJSON, mergedJSON)
+	if err != nil {
+		return nil, "", false, err
+	}
+	diffMap := map[string]interface{}{}
+	if err := kjson.UnmarshalCaseSensitivePreserveInts(diff, &diffMap); err != nil {
+		return nil, "", false, err
+	}
+
+	// the resourceVersion of the patch, if any, was merged
+	resourceVersion, _, _ := unstructured.NestedString(merged, "metadata", "resourceVersion")
+	_, retryable, _ := unstructured.NestedString(patchMap, "metadata", "resourceVersion")
+	if err := unstructured.SetNestedField(diffMap, resourceVersion, "metadata", "resourceVersion"); err != nil {
+		return nil, "", false, err
+	}
+	body, err := json.Marshal(diffMap)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return body, string(types.MergePatchType), !retryable, nil
+}
+
+// lookup returns the patch metadata and storage of the resource if req is a
+// strategic merge patch of a registered resource.
+func (p *StrategicMergePatcher) lookup(req *http.Request) (patchSchema, rest.Getter, bool) {
+	if req.Method != http.MethodPatch {
+		return patchSchema{}, nil, false
+	}
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || contentType != string(types.StrategicMergePatchType) {
+		return patchSchema{}, nil, false
+	}
+	info, ok := request.RequestInfoFrom(req.Context())
+	if !ok || !info.IsResourceRequest || info.Name == "" {
+		return patchSchema{}, nil, false
+	}
+	if info.Subresource != "" && info.Subresource != "status" {
+		return patchSchema{}, nil, false
+	}
+	gvr := schema.GroupVersionResource{Group: info.APIGroup, Version: info.APIVersion, Resource: info.Resource}
+	lookup, ok := p.schemas[gvr]
+	if !ok {
+		return patchSchema{}, nil, false
+	}
+	p.mu.RLock()
+	getter, ok := p.getters[gvr]
+	p.mu.RUnlock()
+	return lookup, getter, ok
+}
+
+// responseRecorder buffers a response, so that it can be dropped if the
+// request is retried.
+type responseRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: http.Header{}, code: http.StatusOK}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+}
+
+func (r *responseRecorder) writeTo(w http.ResponseWriter) {
+	for key, values := range r.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(r.code)
+	_, _ = w.Write(r.body.Bytes())
+}
+
+// interpretStrategicMergePatchError returns the errors of malformed patches
+// with the same codes as the apiserver.
+func interpretStrategicMergePatchError(err error) error {
+	switch err {
+	case mergepatch.ErrBadJSONDoc, mergepatch.ErrBadPatchFormatForPrimitiveList, mergepatch.ErrBadPatchFormatForRetainKeys,
+		mergepatch.ErrBadPatchFormatForSetElementOrderList, mergepatch.ErrUnsupportedStrategicMergePatchFormat:
+		return apierrors.NewBadRequest(err.Error())
+	case mergepatch.ErrNoListOfLists, mergepatch.ErrPatchContentNotMatchRetainKeys:
+		return apierrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "", schema.GroupResource{}, "", err.Error(), 0, false)
+	}
+	return apierrors.NewBadRequest(err.Error())
+}
+
+func writeStatusError(w http.ResponseWriter, err error) {
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		statusErr = apierrors.NewInternalError(err)
+	}
+	status := statusErr.Status()
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	responsewriters.WriteRawJSON(int(status.Code), status, w)
+}
+
+// patchSchema looks up the patch metadata of fields in an OpenAPI schema, and
+// in the struct tags of a Go type for fields the schema doesn't describe.
+type patchSchema struct {
+	schema *spec.Schema
+	tags   strategicpatch.LookupPatchMeta
+}
+
+var _ strategicpatch.LookupPatchMeta = patchSchema{}
+
+func (s patchSchema) LookupPatchMetadataForStruct(key string) (strategicpatch.LookupPatchMeta, strategicpatch.PatchMeta, error) {
+	var tags strategicpatch.LookupPatchMeta
+	var meta strategicpatch.PatchMeta
+	if s.tags != nil {
+		if t, m, err := s.tags.LookupPatchMetadataForStruct(key); err == nil {
+			tags, meta = t, m
+		}
+	}
+	field := s.field(key)
+	if field == nil && tags == nil {
+		return nil, strategicpatch.PatchMeta{}, fmt.Errorf("unable to find api field in struct %s for the json field %q", s.Name(), key)
+	}
+	if field != nil && hasPatchMeta(field) {
+		meta = patchMetaOf(field)
+	}
+	return patchSchema{schema: field, tags: tags}, meta, nil
+}
+
+func (s patchSchema) LookupPatchMetadataForSlice(key string) (strategicpatch.LookupPatchMeta, strategicpatch.PatchMeta, error) {
+	var tags strategicpatch.LookupPatchMeta
+	var meta strategicpatch.PatchMeta
+	if s.tags != nil {
+		if t, m, err := s.tags.LookupPatchMetadataForSlice(key); err == nil {
+			tags, meta = t, m
+		}
+	}
+	field := s.field(key)
+	if field == nil && tags == nil {
+		return nil, strategicpatch.PatchMeta{}, fmt.Errorf("unable to find api field in struct %s for the json field %q", s.Name(), key)
+	}
+	var items *spec.Schema
+	if field != nil {
+		if hasPatchMeta(field) {
+			meta = patchMetaOf(field)
+		}
+		if field.Items != nil {
+			items = field.Items.Schema
+		}
+	}
+	return patchSchema{schema: items, tags: tags}, meta, nil
+}
+
+func (s patchSchema) Name() string {
+	if s.schema != nil && len(s.schema.Type) > 0 {
+		return s.schema.Type[0]
+	}
+	if s.tags != nil {
+		return s.tags.Name()
+	}
+	return "object"
+}
+
+// field returns the schema of the field of an object, or of the values of a
+// map.
+func (s patchSchema) field(key string) *spec.Schema {
+	if s.schema == nil {
+		return nil
+	}
+	if prop, ok := s.schema.Properties[key]; ok {
+		return &prop
+	}
+	if s.schema.AdditionalProperties != nil {
+		return s.schema.AdditionalProperties.Schema
+	}
+	return nil
+}
+
+func hasPatchMeta(s *spec.Schema) bool {
+	_, hasStrategy := s.Extensions[PatchStrategyExtension]
+	_, hasKey := s.Extensions[PatchMergeKeyExtension]
+	return hasStrategy || hasKey
+}
+
+func patchMetaOf(s *spec.Schema) strategicpatch.PatchMeta {
+	meta := strategicpatch.PatchMeta{}
+	if strategy, ok := s.Extensions.GetString(PatchStrategyExtension); ok && strategy != "" {
+		meta.SetPatchStrategies(strings.Split(strategy, ","))
+	}
+	if key, ok := s.Extensions.GetString(PatchMergeKeyExtension); ok {
+		meta.SetPatchMergeKey(key)
+	}
+	return meta
+}
+
+// declaresPatchMeta returns true if the schema, or the schema of any field
+// of it, declares patch metadata that the struct tags of the type don't.
+func declaresPatchMeta(s *spec.Schema, tags strategicpatch.LookupPatchMeta) bool {
+	if s == nil {
+		return false
+	}
+	for name := range s.Properties {
+		prop := s.Properties[name]
+		var fieldTags, itemTags strategicpatch.LookupPatchMeta
+		var meta strategicpatch.PatchMeta
+		if tags != nil {
+			if t, m, err := tags.LookupPatchMetadataForStruct(name); err == nil {
+				fieldTags, meta = t, m
+			}
+			if t, _, err := tags.LookupPatchMetadataForSlice(name); err == nil {
+				itemTags = t
+			}
+		}
+		if hasPatchMeta(&prop) && !samePatchMeta(patchMetaOf(&prop), meta) {
+			return true
+		}
+		if declaresPatchMeta(&prop, fieldTags) {
+			return true
+		}
+		if prop.Items != nil && declaresPatchMeta(prop.Items.Schema, itemTags) {
+			return true
+		}
+	}
+	if s.AdditionalProperties != nil {
+		values := s.AdditionalProperties.Schema
+		if values != nil && (hasPatchMeta(values) || declaresPatchMeta(values, nil)) {
+			return true
+		}
+	}
+	return false
+}
+
+func samePatchMeta(a, b strategicpatch.PatchMeta) bool {
+	if a.GetPatchMergeKey() != b.GetPatchMergeKey() || len(a.GetPatchStrategies()) != len(b.GetPatchStrategies()) {
+		return false
+	}
+	for i, strategy := range a.GetPatchStrategies() {
+		if b.GetPatchStrategies()[i] != strategy {
+			return false
+		}
+	}
+	return true
+}
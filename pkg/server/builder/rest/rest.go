@@ -48,10 +48,14 @@ func (p StaticHandlerProvider) Get(s *runtime.Scheme, g generic.RESTOptionsGette
 func New(obj resource.Object) ResourceHandlerProvider {
 	return func(scheme *runtime.Scheme, optsGetter generic.RESTOptionsGetter) (rest.Storage, error) {
 		gvr := obj.GetGroupVersionResource()
+		tc, err := NewTableConvertor(obj)
+		if err != nil {
+			return nil, err
+		}
 		s := &DefaultStrategy{
 			Object:         obj,
 			ObjectTyper:    scheme,
-			TableConvertor: rest.NewDefaultTableConvertor(gvr.GroupResource()),
+			TableConvertor: tc,
 		}
 		return newStore(obj.New, obj.NewList, gvr, s, optsGetter, nil)
 	}
@@ -65,10 +69,14 @@ func NewStatus(obj resource.ObjectWithStatusSubResource) (
 	handler ResourceHandlerProvider) {
 	return obj, "status", obj, func(scheme *runtime.Scheme, optsGetter generic.RESTOptionsGetter) (rest.Storage, error) {
 		gvr := obj.GetGroupVersionResource()
+		tc, err := NewTableConvertor(obj)
+		if err != nil {
+			return nil, err
+		}
 		s := &StatusSubResourceStrategy{Strategy: &DefaultStrategy{
 			Object:         obj,
 			ObjectTyper:    scheme,
-			TableConvertor: rest.NewDefaultTableConvertor(gvr.GroupResource()),
+			TableConvertor: tc,
 		}}
 		return newStore(obj.New, obj.NewList, gvr, s, optsGetter, nil)
 	}
@@ -98,10 +106,14 @@ type StoreFn func(*genericregistry.Store, *generic.StoreOptions)
 func NewWithFn(obj resource.Object, fn StoreFn) ResourceHandlerProvider {
 	return func(scheme *runtime.Scheme, optsGetter generic.RESTOptionsGetter) (rest.Storage, error) {
 		gvr := obj.GetGroupVersionResource()
+		tc, err := NewTableConvertor(obj)
+		if err != nil {
+			return nil, err
+		}
 		s := &DefaultStrategy{
 			Object:         obj,
 			ObjectTyper:    scheme,
-			TableConvertor: rest.NewDefaultTableConvertor(gvr.GroupResource()),
+			TableConvertor: tc,
 		}
 		return newStore(obj.New, obj.NewList, gvr, s, optsGetter, fn)
 	}
@@ -112,10 +124,14 @@ func NewWithFn(obj resource.Object, fn StoreFn) ResourceHandlerProvider {
 func NewStatusWithFn(obj resource.Object, fn StoreFn) ResourceHandlerProvider {
 	return func(scheme *runtime.Scheme, optsGetter generic.RESTOptionsGetter) (rest.Storage, error) {
 		gvr := obj.GetGroupVersionResource()
+		tc, err := NewTableConvertor(obj)
+		if err != nil {
+			return nil, err
+		}
 		s := &DefaultStrategy{
 			Object:         obj,
 			ObjectTyper:    scheme,
-			TableConvertor: rest.NewDefaultTableConvertor(gvr.GroupResource()),
+			TableConvertor: tc,
 		}
 		return newStore(obj.New, obj.NewList, gvr, &StatusSubResourceStrategy{Strategy: s}, optsGetter, fn)
 	}
@@ -130,10 +146,14 @@ func newStore(
 		NewListFunc:              list,
 		PredicateFunc:            s.Match,
 		DefaultQualifiedResource: gvr.GroupResource(),
-		TableConvertor:           s,
-		CreateStrategy:           s,
-		UpdateStrategy:           s,
-		DeleteStrategy:           s,
+		SingularQualifiedResource: schema.GroupResource{
+			Group:    gvr.Group,
+			Resource: s.GetSingularName(),
+		},
+		TableConvertor: s,
+		CreateStrategy: s,
+		UpdateStrategy: s,
+		DeleteStrategy: s,
 	}
 
 	options := &generic.StoreOptions{RESTOptions: optsGetter, AttrFunc: GetAttrs}
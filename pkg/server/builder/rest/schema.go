@@ -0,0 +1,261 @@
+package rest
+
+import (
+	"encoding/json"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	openapierrors "k8s.io/kube-openapi/pkg/validation/errors"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+)
+
+// SchemaValidator validates objects against the OpenAPI schema of their
+// resource, i.e. its required fields, enums, patterns, bounds and formats, and
+// sets the defaults of the schema on them.
+//
+// The zero value does nothing until Compile is called, which the builder does
+// when the server starts if schema validation is enabled.
+//
+// Objects are defaulted and validated by the strategy of their resource, after
+// mutating admission, whatever the request that created or changed them. Null
+// values are treated as unset, and only fields that are unset get defaults, so
+// fields that aren't omitted when empty never get defaults and are never
+// missing. The metadata of the object isn't validated against the schema,
+// since the server validates it.
+type SchemaValidator struct {
+	schema    *spec.Schema
+	validator *validate.SchemaValidator
+}
+
+// Compile prepares the validator for the schema. The schema must not have
+// refs, i.e. the definitions it refers to must be inlined.
+func (v *SchemaValidator) Compile(schema *spec.Schema) {
+	v.schema = schema
+	v.validator = validate.NewSchemaValidator(validationSchema(schema, true), nil, "", strfmt.Default)
+}
+
+// Enabled returns true if the validator was compiled.
+func (v *SchemaValidator) Enabled() bool {
+	return v != nil && v.validator != nil
+}
+
+// Default sets the defaults of the schema on the unset fields of obj.
+func (v *SchemaValidator) Default(obj runtime.Object) error {
+	if !v.Enabled() {
+		return nil
+	}
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	if !setDefaults(data, v.schema) {
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(data, obj)
+}
+
+// Validate validates obj against the schema.
+func (v *SchemaValidator) Validate(obj runtime.Object) field.ErrorList {
+	if !v.Enabled() {
+		return nil
+	}
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+	return resultToFieldErrors(v.validator.Validate(pruneNulls(data)))
+}
+
+// setDefaults sets the defaults of the schema and of the schemas of its fields
+// on the unset fields of the value. It returns true if it set any.
+func setDefaults(value interface{}, schema *spec.Schema) bool {
+	changed := false
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, prop := range schema.Properties {
+			if v, ok := value[name]; (!ok || v == nil) && prop.Default != nil {
+				value[name] = runtime.DeepCopyJSONValue(jsonValue(prop.Default))
+				changed = true
+			}
+			if v, ok := value[name]; ok {
+				prop := prop
+				changed = setDefaults(v, &prop) || changed
+			}
+		}
+		if schema.AdditionalProperties != nil && schema.AdditionalProperties.Schema != nil {
+			for _, v := range value {
+				changed = setDefaults(v, schema.AdditionalProperties.Schema) || changed
+			}
+		}
+	case []interface{}:
+		if schema.Items != nil && schema.Items.Schema != nil {
+			for _, v := range value {
+				changed = setDefaults(v, schema.Items.Schema) || changed
+			}
+		}
+	}
+	return changed
+}
+
+// jsonValue converts a default of a schema, which may be any Go value in
+// generated definitions, to a JSON value.
+func jsonValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var result interface{}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return v
+	}
+	return normalizeNumbers(result)
+}
+
+// normalizeNumbers converts whole numbers to int64, like the unstructured
+// converter does.
+func normalizeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalizeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+	}
+	return v
+}
+
+// pruneNulls removes the null fields of objects, so that they're treated as
+// unset.
+func pruneNulls(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			if v == nil {
+				delete(value, k)
+				continue
+			}
+			value[k] = pruneNulls(v)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = pruneNulls(v)
+		}
+	}
+	return value
+}
+
+// validationSchema returns a copy of the schema that the validator can check
+// objects against. The metadata of resources is left out, and int-or-string
+// fields accept either type.
+func validationSchema(schema *spec.Schema, isResourceRoot bool) *spec.Schema {
+	result := *schema
+	if result.Format == (intstr.IntOrString{}).OpenAPISchemaFormat() || isExtension(&result, "x-kubernetes-int-or-string") {
+		result.Type = nil
+		result.Format = ""
+	}
+	isResourceRoot = isResourceRoot || isEmbeddedResource(schema)
+
+	if result.Properties != nil {
+		props := make(map[string]spec.Schema, len(result.Properties))
+		for name, prop := range result.Properties {
+			if isResourceRoot && name == "metadata" {
+				continue
+			}
+			props[name] = *validationSchema(&prop, false)
+		}
+		result.Properties = props
+	}
+	if isResourceRoot && len(result.Required) > 0 {
+		required := []string{}
+		for _, name := range result.Required {
+			if name != "metadata" {
+				required = append(required, name)
+			}
+		}
+		result.Required = required
+	}
+	if result.Items != nil && result.Items.Schema != nil {
+		result.Items = &spec.SchemaOrArray{Schema: validationSchema(result.Items.Schema, false)}
+	}
+	if result.AdditionalProperties != nil && result.AdditionalProperties.Schema != nil {
+		result.AdditionalProperties = &spec.SchemaOrBool{
+			Allows: true,
+			Schema: validationSchema(result.AdditionalProperties.Schema, false),
+		}
+	}
+	return &result
+}
+
+func isExtension(schema *spec.Schema, name string) bool {
+	v, ok := schema.Extensions.GetBool(name)
+	return ok && v
+}
+
+// resultToFieldErrors converts the errors of the validator to field errors,
+// like kube-apiserver does for custom resources.
+func resultToFieldErrors(result *validate.Result) field.ErrorList {
+	errs := field.ErrorList{}
+	for _, err := range result.Errors {
+		verr, ok := err.(*openapierrors.Validation)
+		if !ok {
+			errs = append(errs, field.Invalid(nil, "", err.Error()))
+			continue
+		}
+
+		var path *field.Path
+		if name := strings.TrimPrefix(verr.Name, "."); name != "" {
+			path = field.NewPath(name)
+		}
+		value := verr.Value
+		if value == nil {
+			value = ""
+		}
+
+		switch verr.Code() {
+		case openapierrors.RequiredFailCode:
+			errs = append(errs, field.Required(path, ""))
+		case openapierrors.EnumFailCode:
+			values := []string{}
+			for _, allowed := range verr.Values {
+				if s, ok := allowed.(string); ok {
+					values = append(values, s)
+				} else {
+					b, _ := json.Marshal(allowed)
+					values = append(values, string(b))
+				}
+			}
+			errs = append(errs, field.NotSupported(path, value, values))
+		case openapierrors.TooLongFailCode:
+			max := int64(-1)
+			if i, ok := verr.Valid.(int64); ok {
+				max = i
+			}
+			errs = append(errs, field.TooLong(path, "", int(max)))
+		case openapierrors.MaxItemsFailCode, openapierrors.TooManyPropertiesCode:
+			actual, max := int64(-1), int64(-1)
+			if i, ok := verr.Value.(int64); ok {
+				actual = i
+			}
+			if i, ok := verr.Valid.(int64); ok {
+				max = i
+			}
+			errs = append(errs, field.TooMany(path, int(actual), int(max)))
+		case openapierrors.InvalidTypeCode:
+			errs = append(errs, field.TypeInvalid(path, value, verr.Error()))
+		default:
+			errs = append(errs, field.Invalid(path, value, verr.Error()))
+		}
+	}
+	return errs
+}
@@ -18,6 +18,7 @@ package rest
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource/resourcestrategy"
 
@@ -26,6 +27,7 @@ import (
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/apiserver/pkg/registry/rest"
 	"k8s.io/apiserver/pkg/storage"
@@ -55,6 +57,12 @@ type DefaultStrategy struct {
 	Object runtime.Object
 	runtime.ObjectTyper
 	TableConvertor rest.TableConvertor
+	// SchemaValidator, if set, sets the defaults of the resource's OpenAPI schema on create and update,
+	// and validates objects against the schema before the Validate and ValidateUpdate functions of obj.
+	SchemaValidator *SchemaValidator
+	// CELValidator, if set, evaluates the CEL validation rules of the resource on create and update,
+	// after the Validate and ValidateUpdate functions of obj.
+	CELValidator *CELValidator
 }
 
 // GenerateName generates a new name for a resource without one.
@@ -101,15 +109,19 @@ func (d DefaultStrategy) GetSingularName() string {
 	return ""
 }
 
-// PrepareForCreate calls the PrepareForCreate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+// PrepareForCreate sets the defaults of the resource's schema, and calls the PrepareForCreate function on
+// obj if supported.
+func (d DefaultStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	d.setSchemaDefaults(obj)
 	if v, ok := obj.(resourcestrategy.PrepareForCreater); ok {
 		v.PrepareForCreate(ctx)
 	}
 }
 
-// PrepareForUpdate calls the PrepareForUpdate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+// PrepareForUpdate sets the defaults of the resource's schema, and calls the PrepareForUpdate function on
+// obj if supported.
+func (d DefaultStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	d.setSchemaDefaults(obj)
 	if v, ok := obj.(resource.ObjectWithStatusSubResource); ok {
 		// don't modify the status
 		old.(resource.ObjectWithStatusSubResource).GetStatus().CopyTo(v)
@@ -119,12 +131,20 @@ func (DefaultStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Ob
 	}
 }
 
-// Validate calls the Validate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+func (d DefaultStrategy) setSchemaDefaults(obj runtime.Object) {
+	if err := d.SchemaValidator.Default(obj); err != nil {
+		utilruntime.HandleError(fmt.Errorf("setting schema defaults: %v", err))
+	}
+}
+
+// Validate validates obj against the resource's schema, calls the Validate function on obj if supported,
+// and evaluates the CEL validation rules of the resource.
+func (d DefaultStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	errs := d.SchemaValidator.Validate(obj)
 	if v, ok := obj.(resourcestrategy.Validater); ok {
-		return v.Validate(ctx)
+		errs = append(errs, v.Validate(ctx)...)
 	}
-	return field.ErrorList{}
+	return append(errs, d.CELValidator.Validate(ctx, obj, nil)...)
 }
 
 // AllowCreateOnUpdate is used by the Store
@@ -156,12 +176,14 @@ func (DefaultStrategy) Canonicalize(obj runtime.Object) {
 	}
 }
 
-// ValidateUpdate calls the ValidateUpdate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+// ValidateUpdate validates obj against the resource's schema, calls the ValidateUpdate function on obj if
+// supported, and evaluates the CEL validation rules of the resource.
+func (d DefaultStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	errs := d.SchemaValidator.Validate(obj)
 	if v, ok := obj.(resourcestrategy.ValidateUpdater); ok {
-		return v.ValidateUpdate(ctx, old)
+		errs = append(errs, v.ValidateUpdate(ctx, old)...)
 	}
-	return field.ErrorList{}
+	return append(errs, d.CELValidator.Validate(ctx, obj, old)...)
 }
 
 // Match is the filter used by the generic etcd backend to watch events
@@ -180,6 +202,13 @@ func (d DefaultStrategy) ConvertToTable(
 	if c, ok := obj.(resourcestrategy.TableConverter); ok {
 		return c.ConvertToTable(ctx, tableOptions)
 	}
+	if d.TableConvertor == nil {
+		tc, err := NewTableConvertor(d.Object)
+		if err != nil {
+			return nil, err
+		}
+		return tc.ConvertToTable(ctx, obj, tableOptions)
+	}
 	return d.TableConvertor.ConvertToTable(ctx, obj, tableOptions)
 }
 
@@ -0,0 +1,104 @@
+package rest
+
+import (
+	"fmt"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource/resourcestrategy"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/registry/customresource/tableconvertor"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// NewTableConvertor returns the TableConvertor for objects of obj's type.
+//
+// Objects that declare printer columns with resourcestrategy.PrinterColumner
+// are printed with those columns. Other objects are printed with their name
+// and age.
+func NewTableConvertor(obj runtime.Object) (rest.TableConvertor, error) {
+	if pc, ok := obj.(resourcestrategy.PrinterColumner); ok {
+		return NewPrinterColumnTableConvertor(pc.PrinterColumns())
+	}
+
+	var gr schema.GroupResource
+	if o, ok := obj.(resource.Object); ok {
+		gr = o.GetGroupVersionResource().GroupResource()
+	}
+	return rest.NewDefaultTableConvertor(gr), nil
+}
+
+// NewPrinterColumnTableConvertor returns a TableConvertor that prints the
+// object's name, the given columns, and the object's age, the same way that
+// the additionalPrinterColumns of a CustomResourceDefinition are printed.
+//
+// It returns an error if the JSONPath of a column can't be parsed.
+func NewPrinterColumnTableConvertor(columns []resourcestrategy.PrinterColumn) (rest.TableConvertor, error) {
+	crdColumns := make([]apiextensionsv1.CustomResourceColumnDefinition, 0, len(columns)+1)
+	hasAge := false
+	for _, c := range columns {
+		if c.Name == "" {
+			return nil, fmt.Errorf("printer column %q: name must be set", c.JSONPath)
+		}
+		switch c.Type {
+		case "integer", "number", "string", "boolean", "date":
+		default:
+			return nil, fmt.Errorf("printer column %q: type must be one of: integer, number, string, boolean, date", c.Name)
+		}
+		if c.JSONPath == ".metadata.creationTimestamp" {
+			hasAge = true
+		}
+		crdColumns = append(crdColumns, apiextensionsv1.CustomResourceColumnDefinition{
+			Name:        c.Name,
+			Type:        c.Type,
+			Format:      c.Format,
+			Description: c.Description,
+			Priority:    c.Priority,
+			JSONPath:    c.JSONPath,
+		})
+	}
+	if !hasAge {
+		crdColumns = append(crdColumns, apiextensionsv1.CustomResourceColumnDefinition{
+			Name:        "Age",
+			Type:        "date",
+			Description: metav1.ObjectMeta{}.SwaggerDoc()["creationTimestamp"],
+			JSONPath:    ".metadata.creationTimestamp",
+		})
+	}
+
+	tc, err := tableconvertor.New(crdColumns)
+	if err != nil {
+		return nil, fmt.Errorf("printer columns: %v", err)
+	}
+	return tc, nil
+}
+
+// PrinterColumnsExtension is the OpenAPI extension that lists the printer
+// columns of a type that implements resourcestrategy.PrinterColumner.
+const PrinterColumnsExtension = "x-printer-columns"
+
+// PrinterColumnsExtensionValue returns the value of the PrinterColumnsExtension
+// for the columns, in the same format as additionalPrinterColumns.
+func PrinterColumnsExtensionValue(columns []resourcestrategy.PrinterColumn) []interface{} {
+	value := make([]interface{}, 0, len(columns))
+	for _, c := range columns {
+		column := map[string]interface{}{
+			"name":     c.Name,
+			"type":     c.Type,
+			"jsonPath": c.JSONPath,
+		}
+		if c.Format != "" {
+			column["format"] = c.Format
+		}
+		if c.Description != "" {
+			column["description"] = c.Description
+		}
+		if c.Priority != 0 {
+			column["priority"] = int64(c.Priority)
+		}
+		value = append(value, column)
+	}
+	return value
+}
@@ -0,0 +1,480 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	apiservercel "k8s.io/apiserver/pkg/cel"
+	"k8s.io/apiserver/pkg/cel/environment"
+	"k8s.io/apiserver/pkg/cel/library"
+	"k8s.io/apiserver/pkg/cel/openapi"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource/resourcestrategy"
+)
+
+// ValidationRulesExtension is the OpenAPI extension that lists the CEL
+// validation rules of a schema, like in a CustomResourceDefinition.
+const ValidationRulesExtension = "x-kubernetes-validations"
+
+// ValidationRulesExtensionValue returns the value of the
+// ValidationRulesExtension for the rules.
+func ValidationRulesExtensionValue(rules []resourcestrategy.ValidationRule) []interface{} {
+	value := make([]interface{}, 0, len(rules))
+	for _, r := range rules {
+		rule := map[string]interface{}{
+			"rule": r.Rule,
+		}
+		if r.Message != "" {
+			rule["message"] = r.Message
+		}
+		if r.MessageExpression != "" {
+			rule["messageExpression"] = r.MessageExpression
+		}
+		if r.Reason != "" {
+			rule["reason"] = string(r.Reason)
+		}
+		if r.FieldPath != "" {
+			rule["fieldPath"] = r.FieldPath
+		}
+		value = append(value, rule)
+	}
+	return value
+}
+
+// CELValidator evaluates the CEL validation rules in the OpenAPI schema of a
+// resource, i.e. the x-kubernetes-validations of the schema and of the schemas
+// of its fields.
+//
+// The zero value has no rules until Compile is called, which the builder does
+// when the server starts.
+//
+// Like in a CustomResourceDefinition, rules at the root of the resource can
+// only read the name and generateName of its metadata, unless the schema
+// declares more of it. Rules that use oldSelf are evaluated on updates, when
+// the field was set before the update. Fields in lists aren't correlated with
+// the old object, so rules that use oldSelf are skipped inside lists.
+//
+// A rule can't cost more than a CEL call may in kube-apiserver, and all of the
+// rules evaluated for an object can't cost more than the runtime budget of a
+// custom resource.
+type CELValidator struct {
+	root *celNode
+}
+
+// celNode has the compiled rules of a schema, and of the schemas of its fields.
+// Fields without rules in their schemas are left out.
+type celNode struct {
+	// schema is the schema of the values that the rules are evaluated on.
+	schema               *spec.Schema
+	rules                []*celRule
+	properties           map[string]*celNode
+	items                *celNode
+	additionalProperties *celNode
+}
+
+type celRule struct {
+	resourcestrategy.ValidationRule
+	program        cel.Program
+	messageProgram cel.Program
+	// usesOldSelf is true if the rule is a transition rule.
+	usesOldSelf bool
+	fieldPath   []string
+}
+
+// Compile compiles the rules of the schema. The schema must not have refs,
+// i.e. the definitions it refers to must be inlined.
+func (v *CELValidator) Compile(schema *spec.Schema) error {
+	root, err := compileCELNode(schema, true, nil)
+	if err != nil {
+		return err
+	}
+	v.root = root
+	return nil
+}
+
+// HasRules returns true if the schema has rules.
+func (v *CELValidator) HasRules() bool {
+	return v != nil && v.root != nil
+}
+
+// Validate evaluates the rules on obj, and, if the update isn't a create, the
+// old object.
+func (v *CELValidator) Validate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	if !v.HasRules() {
+		return nil
+	}
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+	var oldData interface{}
+	if old != nil {
+		oldData, err = runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+		if err != nil {
+			return field.ErrorList{field.InternalError(nil, err)}
+		}
+	}
+
+	budget := int64(celconfig.RuntimeCELCostBudget)
+	errs, _ := v.root.validate(ctx, nil, data, oldData, &budget)
+	return errs
+}
+
+// validate evaluates the rules of the node and its fields. It returns false if
+// it ran out of cost budget, and no more rules should be evaluated.
+func (n *celNode) validate(ctx context.Context, fldPath *field.Path, value, old interface{}, budget *int64) (field.ErrorList, bool) {
+	errs := field.ErrorList{}
+	if value == nil {
+		return errs, true
+	}
+
+	if len(n.rules) > 0 {
+		ruleErrs, ok := n.validateRules(ctx, fldPath, value, old, budget)
+		errs = append(errs, ruleErrs...)
+		if !ok {
+			return errs, false
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		oldMap, _ := old.(map[string]interface{})
+		for _, name := range sortedKeys(n.properties) {
+			fieldValue, ok := value[name]
+			if !ok {
+				continue
+			}
+			fieldErrs, ok := n.properties[name].validate(ctx, fldPath.Child(name), fieldValue, oldMap[name], budget)
+			errs = append(errs, fieldErrs...)
+			if !ok {
+				return errs, false
+			}
+		}
+		if n.additionalProperties != nil {
+			for _, key := range sortedKeys(value) {
+				fieldErrs, ok := n.additionalProperties.validate(ctx, fldPath.Key(key), value[key], oldMap[key], budget)
+				errs = append(errs, fieldErrs...)
+				if !ok {
+					return errs, false
+				}
+			}
+		}
+	case []interface{}:
+		if n.items != nil {
+			for i, item := range value {
+				itemErrs, ok := n.items.validate(ctx, fldPath.Index(i), item, nil, budget)
+				errs = append(errs, itemErrs...)
+				if !ok {
+					return errs, false
+				}
+			}
+		}
+	}
+	return errs, true
+}
+
+func (n *celNode) validateRules(ctx context.Context, fldPath *field.Path, value, old interface{}, budget *int64) (field.ErrorList, bool) {
+	errs := field.ErrorList{}
+	self := openapi.UnstructuredToVal(value, n.schema)
+	var oldSelf ref.Val
+	if old != nil {
+		oldSelf = openapi.UnstructuredToVal(old, n.schema)
+	}
+
+	for _, rule := range n.rules {
+		if rule.usesOldSelf && oldSelf == nil {
+			continue
+		}
+		activation := map[string]interface{}{"self": self}
+		if oldSelf != nil {
+			activation["oldSelf"] = oldSelf
+		}
+
+		out, details, err := rule.program.ContextEval(ctx, activation)
+		if !chargeCost(details, budget) {
+			return append(errs, field.Invalid(fldPath, n.schemaType(),
+				"validation failed due to running out of cost budget, no further validation rules will be run")), false
+		}
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "operation cancelled: actual cost limit exceeded") {
+				errs = append(errs, field.Invalid(fldPath, n.schemaType(),
+					fmt.Sprintf("call cost exceeds limit for rule: %s", rule.Rule)))
+			} else {
+				errs = append(errs, field.Invalid(fldPath, n.schemaType(),
+					fmt.Sprintf("rule evaluation error: %s: %v", rule.Rule, err)))
+			}
+			continue
+		}
+		if out == types.True {
+			continue
+		}
+
+		message, ok := rule.message(ctx, activation, budget)
+		if !ok {
+			return append(errs, field.Invalid(fldPath, n.schemaType(),
+				"validation failed due to running out of cost budget, no further validation rules will be run")), false
+		}
+		path := fldPath
+		for _, name := range rule.fieldPath {
+			path = path.Child(name)
+		}
+		errs = append(errs, n.ruleError(rule.Reason, path, message))
+	}
+	return errs, true
+}
+
+// message returns the error message of a failed rule.
+func (r *celRule) message(ctx context.Context, activation map[string]interface{}, budget *int64) (string, bool) {
+	if r.messageProgram != nil {
+		out, details, err := r.messageProgram.ContextEval(ctx, activation)
+		if !chargeCost(details, budget) {
+			return "", false
+		}
+		if err == nil {
+			if s, ok := out.Value().(string); ok && strings.TrimSpace(s) != "" &&
+				len(s) <= celconfig.MaxEvaluatedMessageExpressionSizeBytes && !strings.Contains(s, "\n") {
+				return s, true
+			}
+		}
+	}
+	if r.Message != "" {
+		return r.Message, true
+	}
+	return fmt.Sprintf("failed rule: %s", r.Rule), true
+}
+
+func (n *celNode) ruleError(reason field.ErrorType, fldPath *field.Path, message string) *field.Error {
+	switch reason {
+	case field.ErrorTypeForbidden:
+		return field.Forbidden(fldPath, message)
+	case field.ErrorTypeRequired:
+		return field.Required(fldPath, message)
+	case field.ErrorTypeDuplicate:
+		err := field.Duplicate(fldPath, n.schemaType())
+		err.Detail = message
+		return err
+	}
+	return field.Invalid(fldPath, n.schemaType(), message)
+}
+
+// schemaType is the value that errors report, rather than the whole value.
+func (n *celNode) schemaType() string {
+	if len(n.schema.Type) > 0 {
+		return n.schema.Type[0]
+	}
+	return "object"
+}
+
+// chargeCost subtracts the cost of an evaluation from the budget, and returns
+// false if it's exceeded.
+func chargeCost(details *cel.EvalDetails, budget *int64) bool {
+	if details == nil || details.ActualCost() == nil {
+		return true
+	}
+	cost := *details.ActualCost()
+	if cost > uint64(*budget) {
+		*budget = 0
+		return false
+	}
+	*budget -= int64(cost)
+	return true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compileCELNode compiles the rules of the schema and its fields. It returns
+// nil if none of them have rules.
+func compileCELNode(schema *spec.Schema, isResourceRoot bool, fldPath *field.Path) (*celNode, error) {
+	if isResourceRoot || isEmbeddedResource(schema) {
+		isResourceRoot = true
+		schema = withTypeAndObjectMeta(schema)
+	}
+	n := &celNode{schema: schema}
+
+	rules := []resourcestrategy.ValidationRule{}
+	if err := schema.Extensions.GetObject(ValidationRulesExtension, &rules); err != nil {
+		return nil, fmt.Errorf("%s: invalid %s: %v", pathString(fldPath), ValidationRulesExtension, err)
+	}
+	if len(rules) > 0 {
+		compiled, err := compileCELRules(schema, isResourceRoot, rules, fldPath)
+		if err != nil {
+			return nil, err
+		}
+		n.rules = compiled
+	}
+
+	for _, name := range sortedKeys(schema.Properties) {
+		prop := schema.Properties[name]
+		child, err := compileCELNode(&prop, false, fldPath.Child(name))
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			if n.properties == nil {
+				n.properties = map[string]*celNode{}
+			}
+			n.properties[name] = child
+		}
+	}
+	if schema.Items != nil && schema.Items.Schema != nil {
+		items, err := compileCELNode(schema.Items.Schema, false, fldPath.Index(0))
+		if err != nil {
+			return nil, err
+		}
+		n.items = items
+	}
+	if schema.AdditionalProperties != nil && schema.AdditionalProperties.Schema != nil {
+		additional, err := compileCELNode(schema.AdditionalProperties.Schema, false, fldPath.Key("*"))
+		if err != nil {
+			return nil, err
+		}
+		n.additionalProperties = additional
+	}
+
+	if len(n.rules) == 0 && n.properties == nil && n.items == nil && n.additionalProperties == nil {
+		return nil, nil
+	}
+	return n, nil
+}
+
+var celTypeNames atomic.Int64
+
+func compileCELRules(schema *spec.Schema, isResourceRoot bool, rules []resourcestrategy.ValidationRule, fldPath *field.Path) ([]*celRule, error) {
+	declType := openapi.SchemaDeclType(schema, isResourceRoot)
+	if declType == nil {
+		return nil, fmt.Errorf("%s: rules can't be declared on a schema without a type", pathString(fldPath))
+	}
+	declType = declType.MaybeAssignTypeName(fmt.Sprintf("selfType%d", celTypeNames.Add(1)))
+
+	envSet, err := environment.MustBaseEnvSet(environment.DefaultCompatibilityVersion()).Extend(
+		environment.VersionedOptions{
+			IntroducedVersion: version.MajorMinor(1, 0),
+			EnvOptions: []cel.EnvOption{
+				cel.Variable("self", declType.CelType()),
+				cel.Variable("oldSelf", declType.CelType()),
+			},
+			DeclTypes: []*apiservercel.DeclType{declType},
+		})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pathString(fldPath), err)
+	}
+	env, err := envSet.Env(environment.StoredExpressions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pathString(fldPath), err)
+	}
+
+	result := make([]*celRule, 0, len(rules))
+	for i, rule := range rules {
+		rulePath := fmt.Sprintf("%s %s[%d]", pathString(fldPath), ValidationRulesExtension, i)
+		compiled, err := compileCELRule(env, rule)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", rulePath, err)
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+func compileCELRule(env *cel.Env, rule resourcestrategy.ValidationRule) (*celRule, error) {
+	switch rule.Reason {
+	case "", field.ErrorTypeInvalid, field.ErrorTypeForbidden, field.ErrorTypeRequired, field.ErrorTypeDuplicate:
+	default:
+		return nil, fmt.Errorf("unsupported reason %q", rule.Reason)
+	}
+
+	var fieldPath []string
+	if rule.FieldPath != "" {
+		if !strings.HasPrefix(rule.FieldPath, ".") || strings.ContainsAny(rule.FieldPath, "[]") {
+			return nil, fmt.Errorf("fieldPath %q must be a path of fields that starts with a dot, e.g. .spec.replicas", rule.FieldPath)
+		}
+		fieldPath = strings.Split(strings.TrimPrefix(rule.FieldPath, "."), ".")
+	}
+
+	ast, issues := env.Compile(rule.Rule)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("rule %q: compilation failed: %v", rule.Rule, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("rule %q: must evaluate to a bool", rule.Rule)
+	}
+	program, err := newCELProgram(env, ast)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: %v", rule.Rule, err)
+	}
+
+	compiled := &celRule{
+		ValidationRule: rule,
+		program:        program,
+		usesOldSelf:    usesVariable(ast, "oldSelf"),
+		fieldPath:      fieldPath,
+	}
+	if rule.MessageExpression != "" {
+		ast, issues := env.Compile(rule.MessageExpression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("messageExpression %q: compilation failed: %v", rule.MessageExpression, issues.Err())
+		}
+		if ast.OutputType() != cel.StringType {
+			return nil, fmt.Errorf("messageExpression %q: must evaluate to a string", rule.MessageExpression)
+		}
+		if usesVariable(ast, "oldSelf") && !compiled.usesOldSelf {
+			return nil, fmt.Errorf("messageExpression %q: can't use oldSelf unless the rule does", rule.MessageExpression)
+		}
+		compiled.messageProgram, err = newCELProgram(env, ast)
+		if err != nil {
+			return nil, fmt.Errorf("messageExpression %q: %v", rule.MessageExpression, err)
+		}
+	}
+	return compiled, nil
+}
+
+func newCELProgram(env *cel.Env, ast *cel.Ast) (cel.Program, error) {
+	return env.Program(ast,
+		cel.CostLimit(celconfig.PerCallLimit),
+		cel.CostTracking(&library.CostEstimator{}),
+		cel.InterruptCheckFrequency(celconfig.CheckFrequency))
+}
+
+// usesVariable returns true if the expression refers to the variable.
+func usesVariable(ast *cel.Ast, name string) bool {
+	for _, ref := range ast.NativeRep().ReferenceMap() {
+		if ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmbeddedResource(schema *spec.Schema) bool {
+	return isExtension(schema, "x-kubernetes-embedded-resource")
+}
+
+// withTypeAndObjectMeta makes sure that rules at the root of a resource can
+// read its kind, apiVersion, and the name and generateName of its metadata.
+func withTypeAndObjectMeta(schema *spec.Schema) *spec.Schema {
+	return (&openapi.Schema{Schema: schema}).WithTypeAndObjectMeta().(*openapi.Schema).Schema
+}
+
+func pathString(fldPath *field.Path) string {
+	if fldPath == nil {
+		return "<root>"
+	}
+	return fldPath.String()
+}
@@ -0,0 +1,111 @@
+package builder_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	testapiv1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/v1alpha1"
+)
+
+func TestScale(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.ScalableManifest{}, "data"))
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	client := dc.Resource((&testapiv1alpha1.ScalableManifest{}).GetGroupVersionResource())
+
+	_, err = client.Create(f.ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1alpha1",
+		"kind":       "ScalableManifest",
+		"metadata":   map[string]interface{}{"name": "my-server"},
+		"spec":       map[string]interface{}{"replicas": int64(1)},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	watcher, err := client.Watch(f.ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	defer watcher.Stop()
+	events := make(chan watch.Event, 10)
+	go func() {
+		for e := range watcher.ResultChan() {
+			events <- e
+		}
+	}()
+
+	scale, err := client.Get(f.ctx, "my-server", metav1.GetOptions{}, "scale")
+	require.NoError(t, err)
+	assert.Equal(t, "autoscaling/v1", scale.GetAPIVersion())
+	assert.Equal(t, "Scale", scale.GetKind())
+	assert.Equal(t, int64(1), scale.Object["spec"].(map[string]interface{})["replicas"])
+
+	// update
+	staleVersion := scale.GetResourceVersion()
+	require.NoError(t, unstructured.SetNestedField(scale.Object, int64(3), "spec", "replicas"))
+	scale, err = client.Update(f.ctx, scale, metav1.UpdateOptions{}, "scale")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), scale.Object["spec"].(map[string]interface{})["replicas"])
+	assert.Equal(t, int64(3), scale.Object["status"].(map[string]interface{})["replicas"])
+
+	// optimistic concurrency
+	scale.SetResourceVersion(staleVersion)
+	_, err = client.Update(f.ctx, scale, metav1.UpdateOptions{}, "scale")
+	assert.True(t, apierrors.IsConflict(err), "expected conflict, got %v", err)
+
+	// validation
+	require.NoError(t, unstructured.SetNestedField(scale.Object, int64(-1), "spec", "replicas"))
+	scale.SetResourceVersion("")
+	_, err = client.Update(f.ctx, scale, metav1.UpdateOptions{}, "scale")
+	assert.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+
+	// patch
+	_, err = client.Patch(f.ctx, "my-server", types.MergePatchType,
+		[]byte(`{"spec":{"replicas":5}}`), metav1.PatchOptions{}, "scale")
+	require.NoError(t, err)
+
+	obj, err := client.Get(f.ctx, "my-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), obj.Object["spec"].(map[string]interface{})["replicas"])
+
+	// watchers of the object see each change of scale
+	for i, replicas := range []int64{1, 3, 5} {
+		select {
+		case e := <-events:
+			if i == 0 {
+				assert.Equal(t, watch.Added, e.Type)
+			} else {
+				assert.Equal(t, watch.Modified, e.Type)
+			}
+			obj := e.Object.(*unstructured.Unstructured)
+			assert.Equal(t, replicas, obj.Object["spec"].(map[string]interface{})["replicas"])
+		case <-f.ctx.Done():
+			t.Fatal("context canceled")
+		}
+	}
+
+	resources, err := discovery.NewDiscoveryClientForConfigOrDie(f.config.GenericConfig.LoopbackClientConfig).
+		ServerResourcesForGroupVersion("core.tilt.dev/v1alpha1")
+	require.NoError(t, err)
+	found := false
+	for _, r := range resources.APIResources {
+		if r.Name == "scalablemanifests/scale" {
+			found = true
+			assert.Equal(t, "autoscaling", r.Group)
+			assert.Equal(t, "v1", r.Version)
+			assert.Equal(t, "Scale", r.Kind)
+			assert.ElementsMatch(t, []string{"get", "patch", "update"}, r.Verbs)
+		}
+	}
+	assert.True(t, found, "scale subresource not found in discovery")
+}
@@ -0,0 +1,137 @@
+package builder_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	testapiv1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/testapi/v1alpha1"
+)
+
+func TestSchemaValidation(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Gadget{}, "data").
+		WithSchemaValidation())
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	gadgets := dc.Resource((&testapiv1alpha1.Gadget{}).GetGroupVersionResource())
+
+	newGadget := func(name string, spec map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "core.tilt.dev/v1alpha1",
+			"kind":       "Gadget",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec":       spec,
+		}}
+	}
+
+	obj, err := gadgets.Create(f.ctx, newGadget("my-gadget", map[string]interface{}{"owner": "me"}), metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"owner": "me", "size": "small", "replicas": int64(1)}, obj.Object["spec"])
+
+	_, err = gadgets.Create(f.ctx, newGadget("bad-gadget", map[string]interface{}{
+		"size":     "huge",
+		"replicas": 20,
+		"name":     "Bad Name",
+	}), metav1.CreateOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), `spec.owner: Required value`)
+	assert.Contains(t, err.Error(), `spec.size: Unsupported value: "huge": supported values: "large", "small"`)
+	assert.Contains(t, err.Error(), `spec.replicas: Invalid value: 20: spec.replicas in body should be less than or equal to 10`)
+	assert.Contains(t, err.Error(), `spec.name: Invalid value: "Bad Name": spec.name in body should match '^[a-z-]+$'`)
+
+	// a typed field that can't be decoded fails before validation
+	_, err = gadgets.Create(f.ctx, newGadget("bad-gadget", map[string]interface{}{
+		"owner":   "me",
+		"expires": "nope",
+	}), metav1.CreateOptions{})
+	require.True(t, apierrors.IsBadRequest(err), "expected bad request, got %v", err)
+
+	// updates are validated too
+	spec := obj.Object["spec"].(map[string]interface{})
+	spec["replicas"] = int64(0)
+	_, err = gadgets.Update(f.ctx, obj, metav1.UpdateOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), `spec.replicas: Invalid value: 0: spec.replicas in body should be greater than or equal to 1`)
+
+	// a missing required field is decoded as null, which doesn't satisfy it
+	spec["replicas"] = int64(2)
+	delete(spec, "owner")
+	_, err = gadgets.Update(f.ctx, obj, metav1.UpdateOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), `spec.owner: Required value`)
+}
+
+func TestSchemaValidationAfterAdmission(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Gadget{}, "data").
+		WithSchemaValidation().
+		WithAdmissionPlugin("OwnerSetter", func(io.Reader) (admission.Interface, error) {
+			return &ownerSetter{Handler: admission.NewHandler(admission.Create)}, nil
+		}))
+	defer f.tearDown()
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	gadgets := dc.Resource((&testapiv1alpha1.Gadget{}).GetGroupVersionResource())
+
+	// mutating admission fills the required owner before validation
+	obj, err := gadgets.Create(f.ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1alpha1",
+		"kind":       "Gadget",
+		"metadata":   map[string]interface{}{"name": "my-gadget"},
+		"spec":       map[string]interface{}{},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"owner": "admission", "size": "small", "replicas": int64(1)}, obj.Object["spec"])
+
+	// patches are defaulted and validated like other requests
+	obj, err = gadgets.Patch(f.ctx, "my-gadget", types.MergePatchType,
+		[]byte(`{"spec":{"size":null}}`), metav1.PatchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "small", obj.Object["spec"].(map[string]interface{})["size"])
+
+	_, err = gadgets.Patch(f.ctx, "my-gadget", types.MergePatchType,
+		[]byte(`{"spec":{"size":"huge"}}`), metav1.PatchOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), `spec.size: Unsupported value: "huge"`)
+}
+
+func TestSchemaValidationNeedsDefinitions(t *testing.T) {
+	_, err := builder.NewServerBuilder().
+		WithResourceMemoryStorage(&testapiv1alpha1.Gadget{}, "data").
+		WithSchemaValidation().
+		ToServerOptions()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "schema validation needs the OpenAPI definition of the type")
+	}
+}
+
+// ownerSetter sets the owner of the gadgets that have none.
+type ownerSetter struct {
+	*admission.Handler
+}
+
+var _ admission.MutationInterface = &ownerSetter{}
+
+func (s *ownerSetter) Admit(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	gadget, ok := a.GetObject().(*testapiv1alpha1.Gadget)
+	if !ok || gadget.Spec.Owner != nil {
+		return nil
+	}
+	owner := "admission"
+	gadget.Spec.Owner = &owner
+	return nil
+}
@@ -0,0 +1,159 @@
+package builder_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	corev1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource/resourcestrategy"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+)
+
+func TestValidationRules(t *testing.T) {
+	f := newFixtureWithBuilder(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&ruledManifest{}, "data"))
+	defer f.tearDown()
+
+	manifests := f.client.CoreV1alpha1().Manifests()
+	_, err := manifests.Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "Shout"},
+	}, metav1.CreateOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), `spec.message: Invalid value: "object": message "Shout" is not lowercase`)
+
+	obj, err := manifests.Create(f.ctx, &corev1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-manifest"},
+		Spec:       corev1alpha1.ManifestSpec{Message: "hello"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// transition rules are only evaluated on update
+	obj.Spec.Message = "goodbye"
+	_, err = manifests.Update(f.ctx, obj, metav1.UpdateOptions{})
+	require.True(t, apierrors.IsInvalid(err), "expected invalid, got %v", err)
+	assert.Contains(t, err.Error(), "spec.message: Forbidden: message is immutable")
+}
+
+func TestInvalidValidationRules(t *testing.T) {
+	_, err := builder.NewServerBuilder().
+		WithResourceMemoryStorage(&badRuledManifest{}, "data").
+		WithOpenAPIDefinitions("tilt", "0.1.0", testOpenAPIDefinitions).
+		ToServerOptions()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `validation rules: <root> x-kubernetes-validations[0]: rule "self.spec.nope == 'x'": compilation failed`)
+	}
+
+	_, err = builder.NewServerBuilder().
+		WithResourceMemoryStorage(&ruledManifest{}, "data").
+		ToServerOptions()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "validation rules need the OpenAPI definition of the type")
+	}
+}
+
+func TestValidationRulesOnFields(t *testing.T) {
+	// the schema that openapi-gen generates for +k8s:validation:cel markers on
+	// fields, with refs inlined
+	rule := func(rule, message string) spec.Extensions {
+		return spec.Extensions{rest.ValidationRulesExtension: []interface{}{
+			map[string]interface{}{"rule": rule, "message": message},
+		}}
+	}
+	schema := &spec.Schema{
+		SchemaProps: spec.SchemaProps{
+			Type: []string{"object"},
+			Properties: map[string]spec.Schema{
+				"spec": {
+					SchemaProps: spec.SchemaProps{
+						Type: []string{"object"},
+						Properties: map[string]spec.Schema{
+							"message": {
+								SchemaProps:      spec.SchemaProps{Type: []string{"string"}},
+								VendorExtensible: spec.VendorExtensible{Extensions: rule("self.size() <= 5", "too long")},
+							},
+						},
+					},
+				},
+				"status": {
+					SchemaProps: spec.SchemaProps{
+						Type: []string{"object"},
+						Properties: map[string]spec.Schema{
+							"message": {
+								SchemaProps: spec.SchemaProps{Type: []string{"string"}},
+								VendorExtensible: spec.VendorExtensible{Extensions: rule(
+									"self == '' || [1,2,3,4,5,6,7,8,9,10].all(a, [1,2,3,4,5,6,7,8,9,10].all(b, [1,2,3,4,5,6,7,8,9,10].all(c, "+
+										"[1,2,3,4,5,6,7,8,9,10].all(d, [1,2,3,4,5,6,7,8,9,10].all(e, self.size() + a + b + c + d + e > 0)))))",
+									"expensive")},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	v := &rest.CELValidator{}
+	require.NoError(t, v.Compile(schema))
+	require.True(t, v.HasRules())
+
+	errs := v.Validate(context.Background(), &corev1alpha1.Manifest{
+		Spec: corev1alpha1.ManifestSpec{Message: "hello"},
+	}, nil)
+	assert.Empty(t, errs)
+
+	errs = v.Validate(context.Background(), &corev1alpha1.Manifest{
+		Spec: corev1alpha1.ManifestSpec{Message: "hello world"},
+	}, nil)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "spec.message", errs[0].Field)
+	assert.Equal(t, "too long", errs[0].Detail)
+
+	errs = v.Validate(context.Background(), &corev1alpha1.Manifest{
+		Status: corev1alpha1.ManifestStatus{Message: "hi"},
+	}, nil)
+	require.Len(t, errs, 1)
+	assert.Equal(t, field.ErrorTypeInvalid, errs[0].Type)
+	assert.True(t, strings.HasPrefix(errs[0].Detail, "call cost exceeds limit for rule"), errs[0].Detail)
+}
+
+// ruledManifest declares CEL validation rules for manifests.
+type ruledManifest struct {
+	corev1alpha1.Manifest
+}
+
+var _ resourcestrategy.ValidationRuler = &ruledManifest{}
+
+func (in *ruledManifest) ValidationRules() []resourcestrategy.ValidationRule {
+	return []resourcestrategy.ValidationRule{
+		{
+			Rule:              "self.spec.message == self.spec.message.lowerAscii()",
+			MessageExpression: "'message ' + strings.quote(self.spec.message) + ' is not lowercase'",
+			FieldPath:         ".spec.message",
+		},
+		{
+			Rule:      "self.spec.message == oldSelf.spec.message",
+			Message:   "message is immutable",
+			Reason:    field.ErrorTypeForbidden,
+			FieldPath: ".spec.message",
+		},
+	}
+}
+
+// badRuledManifest has a rule that doesn't compile.
+type badRuledManifest struct {
+	corev1alpha1.Manifest
+}
+
+func (in *badRuledManifest) ValidationRules() []resourcestrategy.ValidationRule {
+	return []resourcestrategy.ValidationRule{{Rule: "self.spec.nope == 'x'"}}
+}
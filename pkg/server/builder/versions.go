@@ -0,0 +1,332 @@
+package builder
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/warning"
+	"sigs.k8s.io/randfill"
+)
+
+// The number of random objects that are converted between each pair of
+// versions of a resource when the server starts.
+const roundTripCount = 20
+
+// resourceVersions are the versions of a resource declared with
+// WithStorageVersion, WithDeprecatedVersion and WithRemovedVersion.
+type resourceVersions struct {
+	storage    string
+	deprecated map[string]string
+	removed    map[string]bool
+}
+
+func (a *Server) resourceVersions(obj resource.Object) *resourceVersions {
+	gr := obj.GetGroupVersionResource().GroupResource()
+	v, ok := a.versions[gr]
+	if !ok {
+		v = &resourceVersions{deprecated: map[string]string{}, removed: map[string]bool{}}
+		a.versions[gr] = v
+	}
+	return v
+}
+
+// WithStorageVersion stores the resource in obj's version, whichever version
+// objects are written in. Without it, objects are stored in the version they
+// were written in.
+//
+// Objects stored in other versions are still read, and are rewritten in the
+// storage version by storage migration (see WithStorageMigration). The version
+// may be removed, but must be registered, e.g. with WithResourceFileStorage.
+func (a *Server) WithStorageVersion(obj resource.Object) *Server {
+	a.resourceVersions(obj).storage = obj.GetGroupVersionResource().Version
+	return a
+}
+
+// WithPreferredVersion makes obj's version the preferred version of its
+// group, which clients like kubectl use unless they're asked for another one.
+// Discovery only has one preferred version per group, so the resources of a
+// group must agree on it.
+//
+// Without it, the served versions are preferred in the order of Kubernetes
+// version precedence, e.g. v2 over v1 over v1beta1 over v1alpha1.
+func (a *Server) WithPreferredVersion(obj resource.Object) *Server {
+	gv := obj.GetGroupVersionResource().GroupVersion()
+	if preferred, ok := a.preferredVersions[gv.Group]; ok && preferred != gv.Version {
+		a.errs = append(a.errs, fmt.Errorf("%s: preferred version %s conflicts with %s",
+			obj.GetGroupVersionResource().GroupResource(), gv.Version, preferred))
+		return a
+	}
+	a.preferredVersions[gv.Group] = gv.Version
+	return a
+}
+
+// WithDeprecatedVersion keeps serving obj's version of the resource, but adds
+// a warning to the responses of every request for it, which kubectl and
+// client-go print. If warning is empty, the warning names the version to use
+// instead.
+func (a *Server) WithDeprecatedVersion(obj resource.Object, warning string) *Server {
+	a.resourceVersions(obj).deprecated[obj.GetGroupVersionResource().Version] = warning
+	return a
+}
+
+// WithRemovedVersion stops serving obj's version of the resource, and its
+// subresources. The version stays registered, so that objects stored in it
+// can still be read and migrated.
+func (a *Server) WithRemovedVersion(obj resource.Object) *Server {
+	a.resourceVersions(obj).removed[obj.GetGroupVersionResource().Version] = true
+	return a
+}
+
+// resolveVersions checks the declared versions, and stops serving the removed
+// ones.
+func (a *Server) resolveVersions() {
+	for gr, versions := range a.versions {
+		declared := []string{versions.storage}
+		for v := range versions.deprecated {
+			declared = append(declared, v)
+		}
+		for v := range versions.removed {
+			declared = append(declared, v)
+		}
+		for _, v := range declared {
+			if _, ok := a.resources[gr.WithVersion(v)]; v != "" && !ok {
+				a.errs = append(a.errs, fmt.Errorf("%s: version %s is not registered", gr, v))
+			}
+		}
+	}
+	for group, v := range a.preferredVersions {
+		if gv := (schema.GroupVersion{Group: group, Version: v}); !a.groupVersions[gv] {
+			a.errs = append(a.errs, fmt.Errorf("%s: preferred version %s is not registered", group, v))
+		}
+	}
+	for gvr := range a.resources {
+		if a.versions[gvr.GroupResource()].isRemoved(gvr.Version) && a.preferredVersions[gvr.Group] == gvr.Version {
+			a.errs = append(a.errs, fmt.Errorf("%s: preferred version %s is removed", gvr.GroupResource(), gvr.Version))
+		}
+	}
+
+	for gvr := range a.apis {
+		gr := schema.GroupResource{Group: gvr.Group, Resource: strings.SplitN(gvr.Resource, "/", 2)[0]}
+		if a.versions[gr].isRemoved(gvr.Version) {
+			delete(a.apis, gvr)
+		}
+	}
+}
+
+func (v *resourceVersions) isRemoved(version string) bool {
+	return v != nil && v.removed[version]
+}
+
+// versionPriority returns the versions of the group from the most preferred
+// to the least: the preferred version, the other served versions in the order
+// of Kubernetes version precedence, and then the versions that aren't served.
+func (a *Server) versionPriority(group string) []schema.GroupVersion {
+	served := map[string]bool{}
+	for gvr := range a.apis {
+		if gvr.Group == group {
+			served[gvr.Version] = true
+		}
+	}
+	rank := func(v string) int {
+		switch {
+		case !served[v]:
+			return 2
+		case v == a.preferredVersions[group]:
+			return 0
+		}
+		return 1
+	}
+
+	gvs := []schema.GroupVersion{}
+	for _, gv := range a.orderedGroupVersions {
+		if gv.Group == group {
+			gvs = append(gvs, gv)
+		}
+	}
+	sort.SliceStable(gvs, func(i, j int) bool {
+		if ri, rj := rank(gvs[i].Version), rank(gvs[j].Version); ri != rj {
+			return ri < rj
+		}
+		return version.CompareKubeAwareVersionStrings(gvs[i].Version, gvs[j].Version) > 0
+	})
+	return gvs
+}
+
+// versionsByResource returns the registered versions of each resource, in the
+// order of Kubernetes version precedence.
+func (a *Server) versionsByResource() map[schema.GroupResource][]resource.Object {
+	result := map[schema.GroupResource][]resource.Object{}
+	for gvr, obj := range a.resources {
+		result[gvr.GroupResource()] = append(result[gvr.GroupResource()], obj)
+	}
+	for _, objs := range result {
+		sort.Slice(objs, func(i, j int) bool {
+			return version.CompareKubeAwareVersionStrings(
+				objs[i].GetGroupVersionResource().Version, objs[j].GetGroupVersionResource().Version) > 0
+		})
+	}
+	return result
+}
+
+// addVersionConversions registers conversions between the versions of each
+// resource that aren't its storage type. resource.AddToScheme only converts
+// them to and from the storage type, so they're converted through it.
+func (a *Server) addVersionConversions(scheme *runtime.Scheme) error {
+	for _, objs := range a.versionsByResource() {
+		for _, from := range objs {
+			for _, to := range objs {
+				fromVersion, ok := from.(resource.MultiVersionObject)
+				if !ok || from.IsStorageVersion() || to.IsStorageVersion() || reflect.TypeOf(from) == reflect.TypeOf(to) {
+					continue
+				}
+				if _, ok := to.(resource.MultiVersionObject); !ok {
+					continue
+				}
+				err := scheme.AddConversionFunc(from, to, func(in, out interface{}, _ conversion.Scope) error {
+					storageObj := fromVersion.NewStorageVersionObject()
+					if err := in.(resource.MultiVersionObject).ConvertToStorageVersion(storageObj); err != nil {
+						return err
+					}
+					return out.(resource.MultiVersionObject).ConvertFromStorageVersion(storageObj)
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkRoundTrips converts random objects of each version of a resource to
+// each other version and back, and records an error if that changes them,
+// e.g. because a conversion drops a field.
+func (a *Server) checkRoundTrips() {
+	for gr, objs := range a.versionsByResource() {
+		for _, from := range objs {
+			for _, to := range objs {
+				if reflect.TypeOf(from) == reflect.TypeOf(to) {
+					continue
+				}
+				if err := roundTrip(a.apiScheme, from, to); err != nil {
+					a.errs = append(a.errs, fmt.Errorf("%s: %v", gr, err))
+				}
+			}
+		}
+	}
+}
+
+func roundTrip(scheme *runtime.Scheme, from, to resource.Object) (err error) {
+	fromVersion, toVersion := from.GetGroupVersionResource().Version, to.GetGroupVersionResource().Version
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("converting %s to %s and back: %v", fromVersion, toVersion, r)
+		}
+	}()
+
+	filler := randfill.NewWithSeed(1).NilChance(0.2).NumElements(1, 2)
+	for i := 0; i < roundTripCount; i++ {
+		in := from.New()
+		filler.Fill(in)
+		in.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+		original := in.DeepCopyObject()
+
+		out := to.New()
+		if err := scheme.Convert(in, out, nil); err != nil {
+			return fmt.Errorf("converting %s to %s: %v", fromVersion, toVersion, err)
+		}
+		back := from.New()
+		if err := scheme.Convert(out, back, nil); err != nil {
+			return fmt.Errorf("converting %s to %s: %v", toVersion, fromVersion, err)
+		}
+		back.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+		if !apiequality.Semantic.DeepEqual(original, back) {
+			return fmt.Errorf("converting %s to %s and back changes the object:\n%s",
+				fromVersion, toVersion, diff.Diff(original, back))
+		}
+	}
+	return nil
+}
+
+// compileDeprecationWarnings builds the warnings for the deprecated versions,
+// and adds them to the responses of requests for them.
+func (a *Server) compileDeprecationWarnings() {
+	warnings := map[schema.GroupVersionResource]string{}
+	for gr, versions := range a.versions {
+		for v, msg := range versions.deprecated {
+			gvr := gr.WithVersion(v)
+			obj, ok := a.resources[gvr]
+			if !ok || versions.isRemoved(v) {
+				continue
+			}
+			if msg == "" {
+				msg = a.deprecationWarning(obj)
+			}
+			warnings[gvr] = msg
+		}
+	}
+	if len(warnings) == 0 {
+		return
+	}
+
+	a.recommendedConfigFns = append(a.recommendedConfigFns, func(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+		buildHandlerChain := config.BuildHandlerChainFunc
+		config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+			return buildHandlerChain(withDeprecationWarnings(apiHandler, warnings), c)
+		}
+		return config
+	})
+}
+
+// deprecationWarning returns the default warning for a deprecated version of
+// a resource, which names the most preferred version that isn't deprecated.
+func (a *Server) deprecationWarning(obj resource.Object) string {
+	gvr := obj.GetGroupVersionResource()
+	msg := fmt.Sprintf("%s %s is deprecated", gvr.GroupVersion(), a.kindOf(obj))
+
+	versions := a.versions[gvr.GroupResource()]
+	for _, gv := range a.versionPriority(gvr.Group) {
+		if _, deprecated := versions.deprecated[gv.Version]; deprecated || versions.removed[gv.Version] {
+			continue
+		}
+		if replacement, ok := a.resources[gvr.GroupResource().WithVersion(gv.Version)]; ok {
+			return fmt.Sprintf("%s; use %s %s", msg, gv, a.kindOf(replacement))
+		}
+	}
+	return msg
+}
+
+// kindOf returns the kind that obj's type is registered as.
+func (a *Server) kindOf(obj runtime.Object) string {
+	if kinds, _, err := a.apiScheme.ObjectKinds(obj); err == nil {
+		return kinds[0].Kind
+	}
+	return reflect.TypeOf(obj).Elem().Name()
+}
+
+// withDeprecationWarnings returns a handler that warns clients that the
+// version of the resource they requested is deprecated.
+func withDeprecationWarnings(handler http.Handler, warnings map[schema.GroupVersionResource]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, ok := request.RequestInfoFrom(req.Context())
+		if ok && info.IsResourceRequest {
+			gvr := schema.GroupVersionResource{Group: info.APIGroup, Version: info.APIVersion, Resource: info.Resource}
+			if msg, ok := warnings[gvr]; ok {
+				warning.AddWarning(req.Context(), "", msg)
+			}
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
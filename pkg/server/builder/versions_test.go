@@ -0,0 +1,155 @@
+package builder_test
+
+import (
+	"net/http"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder"
+	sprocketsv1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/sprockets/v1"
+	sprocketsv1alpha1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/sprockets/v1alpha1"
+	sprocketsv1beta1 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/sprockets/v1beta1"
+	sprocketsv2 "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/internal/sprockets/v2"
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/start"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestVersionPriorityAndStorageVersion(t *testing.T) {
+	dataDir := t.TempDir()
+	fs := filepath.NewRealFS()
+	ws := filepath.NewWatchSet()
+	f := runFixture(t, builder.NewServerBuilder().
+		WithResourceStorage(&sprocketsv1alpha1.Sprocket{}, dataDir, fs, builder.WithWatchSet(ws)).
+		WithResourceStorage(&sprocketsv1beta1.Sprocket{}, dataDir, fs, builder.WithWatchSet(ws)).
+		WithResourceStorage(&sprocketsv1.Sprocket{}, dataDir, fs, builder.WithWatchSet(ws)).
+		WithStorageVersion(&sprocketsv1.Sprocket{}).
+		WithOpenAPIDefinitionsFromTypes("tilt", "0.1.0"), func(*start.TiltServerOptions) {})
+	defer f.tearDown()
+
+	// versions are preferred in the order of Kubernetes version precedence
+	assert.Equal(t, []string{"v1", "v1beta1", "v1alpha1"}, f.servedVersions(t, "core.tilt.dev"))
+
+	dc, err := dynamic.NewForConfig(f.config.GenericConfig.LoopbackClientConfig)
+	require.NoError(t, err)
+	sprockets := func(version string) dynamic.ResourceInterface {
+		return dc.Resource(schema.GroupVersionResource{Group: "core.tilt.dev", Version: version, Resource: "sprockets"})
+	}
+
+	_, err = sprockets("v1beta1").Create(f.ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "core.tilt.dev/v1beta1",
+		"kind":       "Sprocket",
+		"metadata":   map[string]interface{}{"name": "my-sprocket"},
+		"spec":       map[string]interface{}{"teeth": int64(12), "color": "red"},
+	}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// objects written in v1beta1 are converted to v1 through v1alpha1
+	content, err := os.ReadFile(fp.Join(dataDir, "core.tilt.dev", "sprockets", "my-sprocket.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"apiVersion":"core.tilt.dev/v1"`)
+	assert.Contains(t, string(content), `"teeth":12`)
+
+	obj, err := sprockets("v1alpha1").Get(f.ctx, "my-sprocket", metav1.GetOptions{})
+	require.NoError(t, err)
+	size, _, err := unstructured.NestedInt64(obj.Object, "spec", "size")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+}
+
+func TestDeprecatedAndRemovedVersions(t *testing.T) {
+	f := runFixture(t, builder.NewServerBuilder().
+		WithResourceMemoryStorage(&sprocketsv1alpha1.Sprocket{}, "data").
+		WithResourceMemoryStorage(&sprocketsv1beta1.Sprocket{}, "data").
+		WithResourceMemoryStorage(&sprocketsv1.Sprocket{}, "data").
+		WithPreferredVersion(&sprocketsv1beta1.Sprocket{}).
+		WithDeprecatedVersion(&sprocketsv1beta1.Sprocket{}, "").
+		WithRemovedVersion(&sprocketsv1alpha1.Sprocket{}).
+		WithOpenAPIDefinitionsFromTypes("tilt", "0.1.0"), func(*start.TiltServerOptions) {})
+	defer f.tearDown()
+
+	assert.Equal(t, []string{"v1beta1", "v1"}, f.servedVersions(t, "core.tilt.dev"))
+
+	resp := f.do(t, http.MethodPost, "/apis/core.tilt.dev/v1/sprockets",
+		[]byte(`{"apiVersion":"core.tilt.dev/v1","kind":"Sprocket","metadata":{"name":"my-sprocket"},"spec":{"teeth":12}}`),
+		map[string]string{"Content-Type": runtime.ContentTypeJSON})
+	require.Equal(t, http.StatusCreated, resp.code, string(resp.body))
+	assert.Empty(t, resp.header.Get("Warning"))
+
+	// the preferred version is deprecated, so the warning names another one
+	resp = f.do(t, http.MethodGet, "/apis/core.tilt.dev/v1beta1/sprockets/my-sprocket", nil, nil)
+	require.Equal(t, http.StatusOK, resp.code, string(resp.body))
+	assert.Contains(t, resp.header.Get("Warning"), "core.tilt.dev/v1beta1 Sprocket is deprecated; use core.tilt.dev/v1 Sprocket")
+
+	resp = f.do(t, http.MethodGet, "/apis/core.tilt.dev/v1alpha1/sprockets/my-sprocket", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.code, string(resp.body))
+}
+
+func TestVersionErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		builder *builder.Server
+		err     string
+	}{
+		{
+			name: "removed preferred version",
+			builder: builder.NewServerBuilder().
+				WithResourceMemoryStorage(&sprocketsv1alpha1.Sprocket{}, "data").
+				WithResourceMemoryStorage(&sprocketsv1.Sprocket{}, "data").
+				WithPreferredVersion(&sprocketsv1.Sprocket{}).
+				WithRemovedVersion(&sprocketsv1.Sprocket{}),
+			err: "sprockets.core.tilt.dev: preferred version v1 is removed",
+		},
+		{
+			name: "unregistered storage version",
+			builder: builder.NewServerBuilder().
+				WithResourceMemoryStorage(&sprocketsv1alpha1.Sprocket{}, "data").
+				WithStorageVersion(&sprocketsv1.Sprocket{}),
+			err: "sprockets.core.tilt.dev: version v1 is not registered",
+		},
+		{
+			name: "lossy conversion",
+			builder: builder.NewServerBuilder().
+				WithResourceMemoryStorage(&sprocketsv1alpha1.Sprocket{}, "data").
+				WithResourceMemoryStorage(&sprocketsv1.Sprocket{}, "data").
+				WithResourceMemoryStorage(&sprocketsv2.Sprocket{}, "data"),
+			err: "sprockets.core.tilt.dev: converting v1alpha1 to v2 and back changes the object",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.builder.ToServerOptions()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+// servedVersions returns the versions of the group in discovery, from the
+// preferred one.
+func (f *fixture) servedVersions(t *testing.T, group string) []string {
+	groups, err := discovery.NewDiscoveryClientForConfigOrDie(f.config.GenericConfig.LoopbackClientConfig).ServerGroups()
+	require.NoError(t, err)
+	for _, g := range groups.Groups {
+		if g.Name != group {
+			continue
+		}
+		versions := []string{}
+		for _, v := range g.Versions {
+			versions = append(versions, v.Version)
+		}
+		require.Equal(t, versions[0], g.PreferredVersion.Version)
+		return versions
+	}
+	require.Failf(t, "group not found", "group %s not found in discovery", group)
+	return nil
+}
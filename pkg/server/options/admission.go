@@ -0,0 +1,158 @@
+package options
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/admission"
+	admissionmetrics "k8s.io/apiserver/pkg/admission/metrics"
+	apiserverinstall "k8s.io/apiserver/pkg/apis/apiserver/install"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+
+	tiltadmission "github.com/tilt-dev/tilt-apiserver/pkg/server/admission"
+)
+
+// AdmissionOptions chooses the admission plugins that run on requests for
+// every resource, and their config.
+//
+// The flags and their validation are those of the generic apiserver options.
+// Only the chain is built differently, since the plugins of
+// pkg/server/admission don't need clients for the core Kubernetes APIs.
+type AdmissionOptions struct {
+	*genericoptions.AdmissionOptions
+
+	// PluginConfig is the config of plugins that the config file doesn't configure,
+	// keyed by plugin name.
+	PluginConfig map[string][]byte
+}
+
+// NewAdmissionOptions returns options with the plugins of the
+// pkg/server/admission package registered, but not enabled.
+//
+// NamespaceLifecycle runs first, then ValidatingAdmissionPolicy, and
+// ResourceQuota last, like in kube-apiserver.
+func NewAdmissionOptions() *AdmissionOptions {
+	plugins := admission.NewPlugins()
+	tiltadmission.RegisterAllAdmissionPlugins(plugins)
+	order := []string{
+		tiltadmission.PluginNamespaceLifecycle,
+		tiltadmission.PluginValidatingAdmissionPolicy,
+		tiltadmission.PluginResourceQuota,
+	}
+	return &AdmissionOptions{
+		AdmissionOptions: &genericoptions.AdmissionOptions{
+			Plugins:                plugins,
+			Decorators:             admission.Decorators{admission.DecoratorFunc(admissionmetrics.WithControllerMetrics)},
+			RecommendedPluginOrder: order,
+			DefaultOffPlugins:      sets.New(order...),
+		},
+		PluginConfig: map[string][]byte{},
+	}
+}
+
+// AddPlugin registers a plugin that's enabled by default. It runs after the
+// plugins added before it, and before ResourceQuota.
+func (a *AdmissionOptions) AddPlugin(name string, factory admission.Factory) error {
+	if sets.NewString(a.Plugins.Registered()...).Has(name) {
+		return fmt.Errorf("admission plugin %q is already registered", name)
+	}
+	a.Plugins.Register(name, factory)
+
+	order := []string{}
+	for _, existing := range a.RecommendedPluginOrder {
+		if existing == tiltadmission.PluginResourceQuota {
+			order = append(order, name)
+		}
+		order = append(order, existing)
+	}
+	if len(order) == len(a.RecommendedPluginOrder) {
+		order = append(order, name)
+	}
+	a.RecommendedPluginOrder = order
+	return nil
+}
+
+func (a *AdmissionOptions) AddFlags(fs *pflag.FlagSet) {
+	if a == nil {
+		return
+	}
+	a.AdmissionOptions.AddFlags(fs)
+}
+
+func (a *AdmissionOptions) Validate() []error {
+	if a == nil {
+		return nil
+	}
+	return a.AdmissionOptions.Validate()
+}
+
+// EnablePlugin enables a registered plugin by default.
+func (a *AdmissionOptions) EnablePlugin(name string) {
+	a.DefaultOffPlugins.Delete(name)
+}
+
+// EnabledPlugins returns the names of the enabled plugins, in the order they run.
+func (a *AdmissionOptions) EnabledPlugins() []string {
+	disabled := sets.New(a.DisablePlugins...).Union(a.DefaultOffPlugins).
+		Difference(sets.New(a.EnablePlugins...))
+	names := []string{}
+	for _, name := range a.RecommendedPluginOrder {
+		if !disabled.Has(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ApplyTo sets the admission chain of the server to the enabled plugins.
+//
+// If no plugins are enabled, the server config is left alone.
+func (a *AdmissionOptions) ApplyTo(c *genericapiserver.Config, initializers ...admission.PluginInitializer) error {
+	if a == nil {
+		return nil
+	}
+	names := a.EnabledPlugins()
+	if len(names) == 0 {
+		return nil
+	}
+
+	configScheme := runtime.NewScheme()
+	apiserverinstall.Install(configScheme)
+	fileConfig, err := admission.ReadAdmissionConfiguration(names, a.ConfigFile, configScheme)
+	if err != nil {
+		return fmt.Errorf("reading admission config: %v", err)
+	}
+
+	chain, err := a.Plugins.NewFromPlugins(names,
+		pluginConfigProvider{file: fileConfig, config: a.PluginConfig},
+		admission.PluginInitializers(initializers),
+		a.Decorators)
+	if err != nil {
+		return err
+	}
+	c.AdmissionControl = admissionmetrics.WithStepMetrics(chain)
+	return nil
+}
+
+// pluginConfigProvider prefers the config in the config file, and falls back
+// to the config given to the options.
+type pluginConfigProvider struct {
+	file   admission.ConfigProvider
+	config map[string][]byte
+}
+
+func (p pluginConfigProvider) ConfigFor(name string) (io.Reader, error) {
+	reader, err := p.file.ConfigFor(name)
+	if err != nil || reader != nil {
+		return reader, err
+	}
+	if config, ok := p.config[name]; ok {
+		return bytes.NewReader(config), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,97 @@
+package options
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// StorageBackendMemory stores objects in memory, so they're lost when the server stops.
+	StorageBackendMemory = "memory"
+	// StorageBackendFile stores objects as JSON files under the data directory.
+	StorageBackendFile = "file"
+)
+
+var storageBackends = []string{StorageBackendMemory, StorageBackendFile}
+
+// StorageOptions chooses the storage backend of resources registered with
+// builder.Server.WithResourceStorageFromFlags.
+type StorageOptions struct {
+	// Backend is the backend of resources without an override.
+	Backend string
+	// DataDir is the directory that objects are stored under.
+	DataDir string
+	// ResourceBackends overrides the backend of individual resources, keyed
+	// by group resource (e.g., manifests.core.tilt.dev).
+	ResourceBackends map[string]string
+}
+
+func NewStorageOptions() *StorageOptions {
+	return &StorageOptions{
+		Backend:          StorageBackendMemory,
+		DataDir:          "data",
+		ResourceBackends: map[string]string{},
+	}
+}
+
+func (s *StorageOptions) Validate() []error {
+	if s == nil {
+		return nil
+	}
+
+	errors := []error{}
+	if !isStorageBackend(s.Backend) {
+		errors = append(errors, fmt.Errorf("--storage %q must be one of: %s", s.Backend, strings.Join(storageBackends, ", ")))
+	}
+	if s.DataDir == "" {
+		errors = append(errors, fmt.Errorf("--data-dir must be set"))
+	}
+
+	resources := make([]string, 0, len(s.ResourceBackends))
+	for resource := range s.ResourceBackends {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	for _, resource := range resources {
+		if backend := s.ResourceBackends[resource]; !isStorageBackend(backend) {
+			errors = append(errors, fmt.Errorf("--storage-resource %s=%s: backend must be one of: %s",
+				resource, backend, strings.Join(storageBackends, ", ")))
+		}
+	}
+	return errors
+}
+
+func (s *StorageOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.StringVar(&s.Backend, "storage", s.Backend, ""+
+		"The storage backend of resources, one of: "+strings.Join(storageBackends, ", ")+".")
+	fs.StringVar(&s.DataDir, "data-dir", s.DataDir, ""+
+		"The directory where the file storage backend stores objects.")
+	fs.StringToStringVar(&s.ResourceBackends, "storage-resource", s.ResourceBackends, ""+
+		"Overrides the storage backend of a resource, e.g. manifests.core.tilt.dev=file. "+
+		"May be repeated.")
+}
+
+// BackendFor returns the storage backend of the resource.
+func (s *StorageOptions) BackendFor(gr schema.GroupResource) string {
+	if backend, ok := s.ResourceBackends[gr.String()]; ok {
+		return backend
+	}
+	return s.Backend
+}
+
+func isStorageBackend(backend string) bool {
+	for _, b := range storageBackends {
+		if b == backend {
+			return true
+		}
+	}
+	return false
+}
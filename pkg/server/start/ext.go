@@ -17,10 +17,14 @@ limitations under the License.
 package start
 
 import (
+	"strings"
+
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apiserver/pkg/endpoints/openapi"
 	pkgserver "k8s.io/apiserver/pkg/server"
 	openapicommon "k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/util"
+	"k8s.io/kube-openapi/pkg/validation/spec"
 )
 
 type RecommendedConfigFn func(*pkgserver.RecommendedConfig) *pkgserver.RecommendedConfig
@@ -34,13 +38,41 @@ func (o *TiltServerOptions) ApplyRecommendedConfigFns(in *pkgserver.RecommendedC
 
 func SetOpenAPIDefinitionFn(scheme *runtime.Scheme, name, version string, defs openapicommon.GetOpenAPIDefinitions) RecommendedConfigFn {
 	return RecommendedConfigFn(func(config *pkgserver.RecommendedConfig) *pkgserver.RecommendedConfig {
-		config.OpenAPIV3Config = pkgserver.DefaultOpenAPIV3Config(defs, openapi.NewDefinitionNamer(scheme))
+		namer := definitionNamer{openapi.NewDefinitionNamer(scheme)}
+
+		config.OpenAPIV3Config = pkgserver.DefaultOpenAPIV3Config(defs, namer.DefinitionNamer)
 		config.OpenAPIV3Config.Info.Title = name
 		config.OpenAPIV3Config.Info.Version = version
+		config.OpenAPIV3Config.GetDefinitionName = namer.GetDefinitionName
+		config.OpenAPIV3Config.Definitions = defs(func(name string) spec.Ref {
+			defName, _ := namer.GetDefinitionName(name)
+			return spec.MustCreateRef("#/components/schemas/" + openapicommon.EscapeJsonPointer(defName))
+		})
 
-		config.OpenAPIConfig = pkgserver.DefaultOpenAPIConfig(defs, openapi.NewDefinitionNamer(scheme))
+		config.OpenAPIConfig = pkgserver.DefaultOpenAPIConfig(defs, namer.DefinitionNamer)
 		config.OpenAPIConfig.Info.Title = name
 		config.OpenAPIConfig.Info.Version = version
+		config.OpenAPIConfig.GetDefinitionName = namer.GetDefinitionName
 		return config
 	})
 }
+
+// definitionNamer names definitions whose names are Go package paths in the
+// REST-friendly form, so that they can be referred to as JSON pointers. Types
+// that don't declare an OpenAPI model name are named by their package path,
+// and the scheme knows them by the REST-friendly form.
+type definitionNamer struct {
+	*openapi.DefinitionNamer
+}
+
+func (d definitionNamer) GetDefinitionName(name string) (string, spec.Extensions) {
+	if !strings.Contains(name, "/") {
+		return d.DefinitionNamer.GetDefinitionName(name)
+	}
+	friendlyName := util.ToRESTFriendlyName(name)
+	_, extensions := d.DefinitionNamer.GetDefinitionName(name)
+	if extensions == nil {
+		_, extensions = d.DefinitionNamer.GetDefinitionName(friendlyName)
+	}
+	return friendlyName, extensions
+}
@@ -22,12 +22,14 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"path"
 
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/authentication/request/anonymous"
 	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
 	"k8s.io/apiserver/pkg/authorization/union"
@@ -35,11 +37,13 @@ import (
 	"k8s.io/apiserver/pkg/registry/generic"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	genericfilters "k8s.io/apiserver/pkg/server/filters"
 	"k8s.io/apiserver/pkg/storage/storagebackend"
 	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 
+	tiltadmission "github.com/tilt-dev/tilt-apiserver/pkg/server/admission"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/apiserver"
 	"github.com/tilt-dev/tilt-apiserver/pkg/server/options"
 )
@@ -54,6 +58,27 @@ type TiltServerOptions struct {
 	ServingOptions       *options.SecureServingOptions
 	ConnProvider         apiserver.ConnProvider
 
+	// StorageOptions chooses the storage backend of resources registered with
+	// builder.Server.WithResourceStorageFromFlags.
+	StorageOptions *options.StorageOptions
+
+	// AdmissionOptions chooses the admission plugins that run on requests for
+	// every resource.
+	AdmissionOptions *options.AdmissionOptions
+
+	// NonResourceHandlers serves custom endpoints, keyed by path.
+	NonResourceHandlers map[string]http.Handler
+
+	// StorageDecorator creates the storage of resources served by an upstream
+	// genericregistry.Store, e.g., with rest.New.
+	StorageDecorator generic.StorageDecorator
+
+	// ServeCBOR serves resources as CBOR, including watch streams, in addition
+	// to JSON, YAML and protobuf. It enables the CBORServingAndStorage feature
+	// gate, which is process-wide. Servers that don't set it leave the gate as
+	// it is.
+	ServeCBOR bool
+
 	stdout io.Writer
 	stderr io.Writer
 }
@@ -112,6 +137,8 @@ func NewCommandStartTiltServer(defaults *TiltServerOptions, ctx context.Context)
 
 	flags := cmd.Flags()
 	o.ServingOptions.AddFlags(flags)
+	o.StorageOptions.AddFlags(flags)
+	o.AdmissionOptions.AddFlags(flags)
 
 	return cmd
 }
@@ -120,6 +147,8 @@ func NewCommandStartTiltServer(defaults *TiltServerOptions, ctx context.Context)
 func (o TiltServerOptions) Validate(args []string) error {
 	errors := []error{}
 	errors = append(errors, o.ServingOptions.Validate()...)
+	errors = append(errors, o.StorageOptions.Validate()...)
+	errors = append(errors, o.AdmissionOptions.Validate()...)
 	if o.ServingOptions.BindPort == 0 {
 		errors = append(errors, fmt.Errorf("No serve port set"))
 	}
@@ -145,6 +174,12 @@ func (o *TiltServerOptions) Config() (*apiserver.Config, error) {
 	if err := utilfeature.DefaultMutableFeatureGate.Set(string(features.WatchList) + "=false"); err != nil {
 		return nil, fmt.Errorf("failed to disable WatchList feature gate: %w", err)
 	}
+	if o.ServeCBOR {
+		// the gate is process-wide, so leave it alone unless this server needs it
+		if err := utilfeature.DefaultMutableFeatureGate.Set(string(features.CBORServingAndStorage) + "=true"); err != nil {
+			return nil, fmt.Errorf("failed to enable CBORServingAndStorage feature gate: %w", err)
+		}
+	}
 
 	if o.ConnProvider != nil {
 		if o.ServingOptions.BindPort == 0 {
@@ -167,12 +202,33 @@ func (o *TiltServerOptions) Config() (*apiserver.Config, error) {
 	}
 
 	serverConfig := genericapiserver.NewRecommendedConfig(o.codecs)
+	// following logs takes as long as a watch
+	serverConfig.LongRunningFunc = genericfilters.BasicLongRunningRequestCheck(
+		sets.NewString("watch"), sets.NewString("log"))
+
+	serverConfig.Authorization = genericapiserver.AuthorizationInfo{
+		Authorizer: union.New(
+			authorizerfactory.NewPrivilegedGroups("system:masters"),
+			authorizerfactory.NewAlwaysDenyAuthorizer(),
+		),
+	}
+
+	// admission plugins may authorize requests, and watch resources in storage
+	informers := apiserver.NewInformers(o.apis)
+	err = o.AdmissionOptions.ApplyTo(&serverConfig.Config,
+		tiltadmission.NewPluginInitializer(informers, serverConfig.Authorization.Authorizer))
+	if err != nil {
+		return nil, err
+	}
 	serverConfig = o.ApplyRecommendedConfigFns(serverConfig)
 
 	extraConfig := apiserver.ExtraConfig{
-		Scheme: o.scheme,
-		Codecs: o.codecs,
-		APIs:   o.apis,
+		Scheme:              o.scheme,
+		Codecs:              o.codecs,
+		APIs:                o.apis,
+		ParameterCodec:      runtime.NewParameterCodec(o.scheme),
+		NonResourceHandlers: o.NonResourceHandlers,
+		Informers:           informers,
 	}
 
 	err = o.ServingOptions.ApplyTo(&extraConfig.ServingInfo)
@@ -185,12 +241,6 @@ func (o *TiltServerOptions) Config() (*apiserver.Config, error) {
 		return nil, fmt.Errorf("internal error: no serve config")
 	}
 	serverConfig.ExternalAddress = serving.Listener.Addr().String()
-	serverConfig.Authorization = genericapiserver.AuthorizationInfo{
-		Authorizer: union.New(
-			authorizerfactory.NewPrivilegedGroups("system:masters"),
-			authorizerfactory.NewAlwaysDenyAuthorizer(),
-		),
-	}
 	serverConfig.Authentication = genericapiserver.AuthenticationInfo{
 		Authenticator: anonymous.NewAuthenticator(nil),
 	}
@@ -243,6 +293,9 @@ func (o TiltServerOptions) GetRESTOptions(resource schema.GroupResource, obj run
 				Codec: o.codec,
 			},
 		},
+		Decorator:               o.StorageDecorator,
+		ResourcePrefix:          path.Join(resource.Group, resource.Resource),
+		DeleteCollectionWorkers: 1,
 	}, nil
 }
 
@@ -0,0 +1,134 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+var _ rest.NamedCreater = &actionREST{}
+var _ rest.StorageMetadata = &actionREST{}
+
+// NewActionStorageProvider serves an action subresource, which passes the body
+// of each POST request and the current parent object to the action's handler.
+//
+// Requests are authorized and admitted the same way as a create of the
+// subresource. The handler updates the parent through the parent's update path,
+// so updates run the parent's strategy, are retried on conflicts and are seen
+// by watchers. If the parent has the WithAdmission option, they're also
+// admitted as updates of the parent.
+//
+// parentSP must be a provider created by NewJSONFilepathStorageProvider.
+func NewActionStorageProvider(parentSP builderrest.ResourceHandlerProvider, action resource.ActionSubResource) builderrest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (rest.Storage, error) {
+		parent, err := filepathParent(scheme, getter, parentSP)
+		if err != nil {
+			return nil, err
+		}
+		return &actionREST{parent: parent, action: action}, nil
+	}
+}
+
+type actionREST struct {
+	parent *filepathREST
+	action resource.ActionSubResource
+}
+
+func (r *actionREST) New() runtime.Object {
+	return r.action.NewRequest()
+}
+
+func (r *actionREST) Destroy() {
+	// Destroy() is intended for cleaning up client connections. Do nothing.
+}
+
+// ProducesObject documents the result of the action, rather than the request,
+// as the response of the POST.
+func (r *actionREST) ProducesObject(verb string) interface{} {
+	return r.action.NewResult()
+}
+
+func (r *actionREST) ProducesMIMETypes(verb string) []string {
+	return nil
+}
+
+// Create invokes the action's handler on the named parent object.
+func (r *actionREST) Create(
+	ctx context.Context,
+	name string,
+	obj runtime.Object,
+	createValidation rest.ValidateObjectFunc,
+	options *metav1.CreateOptions,
+) (runtime.Object, error) {
+	if reflect.TypeOf(obj) != reflect.TypeOf(r.action.NewRequest()) {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unexpected request body for %s: %T", r.action.Name(), obj))
+	}
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+
+	current, err := r.parent.Get(ctx, name, &metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	parent, ok := current.(resource.Object)
+	if !ok {
+		return nil, apierrors.NewInternalError(fmt.Errorf("not a resource.Object: %T", current))
+	}
+
+	update := func(ctx context.Context, mutate func(parent resource.Object) error) (resource.Object, error) {
+		out, err := r.parent.admittedUpdate(ctx, name, actionObjectInfo{
+			parent: r.parent,
+			name:   name,
+			mutate: mutate,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.(resource.Object), nil
+	}
+
+	result, err := r.action.Handle(ctx, parent, obj, update)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return &metav1.Status{Status: metav1.StatusSuccess}, nil
+	}
+	return result, nil
+}
+
+// actionObjectInfo applies the mutation of an action handler to the current parent.
+type actionObjectInfo struct {
+	parent *filepathREST
+	name   string
+	mutate func(parent resource.Object) error
+}
+
+func (i actionObjectInfo) Preconditions() *metav1.Preconditions {
+	return nil
+}
+
+func (i actionObjectInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	if oldObj == nil {
+		return nil, apierrors.NewNotFound(i.parent.groupResource, i.name)
+	}
+	newObj, ok := oldObj.DeepCopyObject().(resource.Object)
+	if !ok {
+		return nil, apierrors.NewInternalError(fmt.Errorf("not a resource.Object: %T", oldObj))
+	}
+	if err := i.mutate(newObj); err != nil {
+		return nil, err
+	}
+	return newObj, nil
+}
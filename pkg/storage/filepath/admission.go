@@ -0,0 +1,187 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// Admission authorizes and admits the writes that storage makes outside of the
+// generic handlers of a resource, e.g. the operations of a transaction, or the
+// update of the parent of a rollback.
+//
+// The authorizer and the admission chain are the server's, which are only
+// known once the server is configured, so they're set later with SetChain.
+// Until then, writes are neither authorized nor admitted.
+type Admission struct {
+	scheme *runtime.Scheme
+
+	mu         sync.RWMutex
+	authorizer authorizer.Authorizer
+	admit      admission.Interface
+}
+
+func NewAdmission(scheme *runtime.Scheme) *Admission {
+	return &Admission{scheme: scheme}
+}
+
+// WithAdmission authorizes and admits the writes of the resource that don't go
+// through its generic handlers.
+func WithAdmission(a *Admission) RESTOption {
+	return func(f *filepathREST) {
+		f.admission = a
+	}
+}
+
+// SetChain sets the authorizer and admission chain of the server. Either may be nil.
+func (a *Admission) SetChain(authz authorizer.Authorizer, admit admission.Interface) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.authorizer = authz
+	a.admit = admit
+}
+
+func (a *Admission) chain() (authorizer.Authorizer, admission.Interface) {
+	if a == nil {
+		return nil, nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.authorizer, a.admit
+}
+
+// kind returns the served kind of the resource's objects.
+func (f *filepathREST) kind() (schema.GroupVersionKind, error) {
+	kinds, _, err := f.admission.scheme.ObjectKinds(f.newFunc())
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	for _, kind := range kinds {
+		if kind.Group == f.groupResource.Group && kind.Version != runtime.APIVersionInternal {
+			return kind, nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("no kind registered for %s", f.groupResource)
+}
+
+// authorize checks that the user of the request may perform the verb on the
+// named object of the resource.
+func (f *filepathREST) authorize(ctx context.Context, verb, name string) error {
+	authz, _ := f.admission.chain()
+	if authz == nil {
+		return nil
+	}
+	kind, err := f.kind()
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	user, _ := genericapirequest.UserFrom(ctx)
+	namespace, _ := genericapirequest.NamespaceFrom(ctx)
+	attrs := authorizer.AttributesRecord{
+		User:            user,
+		Verb:            verb,
+		Namespace:       namespace,
+		APIGroup:        kind.Group,
+		APIVersion:      kind.Version,
+		Resource:        f.groupResource.Resource,
+		Name:            name,
+		ResourceRequest: true,
+	}
+	decision, reason, err := authz.Authorize(ctx, attrs)
+	if decision == authorizer.DecisionAllow {
+		return nil
+	}
+	if err != nil && reason == "" {
+		reason = err.Error()
+	}
+	return apierrors.NewForbidden(f.groupResource, name, fmt.Errorf("%s", reason))
+}
+
+// mutate runs the mutating admission plugins on a write of the named object,
+// before the storage strategy prepares it.
+//
+// obj is nil for deletes, and oldObj for creates. Plugins may change obj.
+func (f *filepathREST) mutate(ctx context.Context, op admission.Operation, name string, obj, oldObj, options runtime.Object) error {
+	_, admit := f.admission.chain()
+	m, ok := admit.(admission.MutationInterface)
+	if !ok || !m.Handles(op) {
+		return nil
+	}
+	attrs, err := f.admissionAttributes(ctx, op, name, obj, oldObj, options)
+	if err != nil {
+		return err
+	}
+	return m.Admit(ctx, attrs, admission.NewObjectInterfacesFromScheme(f.admission.scheme))
+}
+
+// validate runs the validating admission plugins on a write of the named
+// object, after the storage strategy has validated it.
+func (f *filepathREST) validate(ctx context.Context, op admission.Operation, name string, obj, oldObj, options runtime.Object) error {
+	_, admit := f.admission.chain()
+	v, ok := admit.(admission.ValidationInterface)
+	if !ok || !v.Handles(op) {
+		return nil
+	}
+	attrs, err := f.admissionAttributes(ctx, op, name, obj, oldObj, options)
+	if err != nil {
+		return err
+	}
+	return v.Validate(ctx, attrs, admission.NewObjectInterfacesFromScheme(f.admission.scheme))
+}
+
+func (f *filepathREST) admissionAttributes(ctx context.Context, op admission.Operation, name string, obj, oldObj, options runtime.Object) (admission.Attributes, error) {
+	kind, err := f.kind()
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	user, _ := genericapirequest.UserFrom(ctx)
+	namespace, _ := genericapirequest.NamespaceFrom(ctx)
+	return admission.NewAttributesRecord(obj, oldObj, kind, namespace, name,
+		f.groupResource.WithVersion(kind.Version), "", op, options, false, user), nil
+}
+
+// admittedUpdate updates the named object through the update path, the same
+// way a request to update it would be admitted: the mutating plugins run on the
+// updated object, and the validating plugins once the strategy has validated it.
+//
+// Subresources use it to update their parent, e.g. to roll it back.
+func (f *filepathREST) admittedUpdate(ctx context.Context, name string, objInfo rest.UpdatedObjectInfo) (runtime.Object, error) {
+	options := &metav1.UpdateOptions{}
+	out, _, err := f.Update(ctx, name, admittedObjectInfo{
+		UpdatedObjectInfo: objInfo,
+		f:                 f,
+		name:              name,
+		options:           options,
+	}, nil, func(ctx context.Context, obj, old runtime.Object) error {
+		return f.validate(ctx, admission.Update, name, obj, old, options)
+	}, false, options)
+	return out, err
+}
+
+// admittedObjectInfo runs the mutating admission plugins on the updated object.
+type admittedObjectInfo struct {
+	rest.UpdatedObjectInfo
+	f       *filepathREST
+	name    string
+	options *metav1.UpdateOptions
+}
+
+func (i admittedObjectInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	newObj, err := i.UpdatedObjectInfo.UpdatedObject(ctx, oldObj)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.f.mutate(ctx, admission.Update, i.name, newObj, oldObj, i.options); err != nil {
+		return nil, err
+	}
+	return newObj, nil
+}
@@ -0,0 +1,117 @@
+package filepath
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// The number of decoded objects an FS keeps, across all resources.
+const decodeCacheSize = 4096
+
+// cacheStamp identifies the contents of a file.
+//
+// MemoryFS files are identified by their revision. RealFS files may be changed
+// outside the process, so they're also identified by their modification time
+// and size.
+type cacheStamp struct {
+	version uint64
+	modTime int64
+	size    int64
+}
+
+// decodeCache is an LRU cache of decoded objects, keyed by path.
+//
+// An entry is only a hit for the same stamp, the same decoder, and the same
+// type of object, so the cache never changes what a read returns. Objects are
+// deep-copied in and out of the cache, so callers are free to modify them.
+type decodeCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+type decodeCacheEntry struct {
+	path    string
+	stamp   cacheStamp
+	decoder runtime.Decoder
+	obj     runtime.Object
+}
+
+func newDecodeCache(maxEntries int) *decodeCache {
+	return &decodeCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// cacheable checks if decoders can be compared, so that objects decoded
+// differently (e.g., by a decoder that converts) are never mixed up.
+func cacheable(decoder runtime.Decoder) bool {
+	return decoder != nil && reflect.TypeOf(decoder).Comparable()
+}
+
+// get returns a copy of the object decoded from the file with the stamp.
+func (c *decodeCache) get(path string, stamp cacheStamp, decoder runtime.Decoder, newFunc func() runtime.Object) (runtime.Object, bool) {
+	if !cacheable(decoder) {
+		return nil, false
+	}
+
+	objType := reflect.TypeOf(newFunc())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*decodeCacheEntry)
+	if entry.stamp != stamp || entry.decoder != decoder || reflect.TypeOf(entry.obj) != objType {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.obj.DeepCopyObject(), true
+}
+
+// put caches a copy of the object decoded from the file with the stamp,
+// replacing any object cached for another stamp.
+func (c *decodeCache) put(path string, stamp cacheStamp, decoder runtime.Decoder, obj runtime.Object) {
+	if !cacheable(decoder) || c.maxEntries <= 0 {
+		return
+	}
+
+	entry := &decodeCacheEntry{
+		path:    path,
+		stamp:   stamp,
+		decoder: decoder,
+		obj:     obj.DeepCopyObject(),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[path] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*decodeCacheEntry).path)
+	}
+}
+
+// remove drops the object cached for the path, if any.
+func (c *decodeCache) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, path)
+	}
+}
@@ -0,0 +1,104 @@
+package filepath_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestDecodeCache(t *testing.T) {
+	for _, fs := range fileSystems() {
+		t.Run(fmt.Sprintf("%T", fs), func(t *testing.T) {
+			ctx := context.Background()
+			scheme := runtime.NewScheme()
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+			decoder := &countingDecoder{Decoder: codec}
+			newFunc := func() runtime.Object { return &v1alpha1.Manifest{} }
+
+			dir := t.TempDir()
+			path := fp.Join(dir, "a.json")
+			obj := &v1alpha1.Manifest{
+				ObjectMeta: metav1.ObjectMeta{Name: "a"},
+				Spec:       v1alpha1.ManifestSpec{Message: "hello"},
+			}
+			require.NoError(t, fs.Write(ctx, codec, path, obj, 0))
+
+			first, err := fs.Read(ctx, decoder, path, newFunc)
+			require.NoError(t, err)
+			first.(*v1alpha1.Manifest).Spec.Message = "changed by the caller"
+
+			second, err := fs.Read(ctx, decoder, path, newFunc)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", second.(*v1alpha1.Manifest).Spec.Message)
+			assert.Equal(t, obj.ResourceVersion, second.(*v1alpha1.Manifest).ResourceVersion)
+
+			_, err = fs.VisitDir(ctx, dir, newFunc, decoder, func(string, runtime.Object) error { return nil })
+			require.NoError(t, err)
+			assert.Equal(t, 1, decoder.count, "expected the object to be decoded once")
+
+			// a write invalidates the cached object
+			storageVersion, err := strconv.ParseUint(obj.ResourceVersion, 10, 64)
+			require.NoError(t, err)
+			obj.Spec.Message = "goodbye"
+			require.NoError(t, fs.Write(ctx, codec, path, obj, storageVersion))
+
+			third, err := fs.Read(ctx, decoder, path, newFunc)
+			require.NoError(t, err)
+			assert.Equal(t, "goodbye", third.(*v1alpha1.Manifest).Spec.Message)
+			assert.Equal(t, 2, decoder.count)
+
+			require.NoError(t, fs.Remove(ctx, path))
+			_, err = fs.Read(ctx, decoder, path, newFunc)
+			assert.True(t, filepath.IsNotFound(err), "expected not found, got %v", err)
+		})
+	}
+}
+
+func TestDecodeCache_RealFSExternalChange(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+	newFunc := func() runtime.Object { return &v1alpha1.Manifest{} }
+
+	fs := filepath.NewRealFS()
+	path := fp.Join(t.TempDir(), "a.json")
+	require.NoError(t, fs.Write(ctx, codec, path, &v1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "a"},
+		Spec:       v1alpha1.ManifestSpec{Message: "hello"},
+	}, 0))
+	_, err := fs.Read(ctx, codec, path, newFunc)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"apiVersion":"core.tilt.dev/v1alpha1","kind":"Manifest","metadata":{"name":"a"},"spec":{"message":"edited outside"}}`),
+		0600))
+
+	obj, err := fs.Read(ctx, codec, path, newFunc)
+	require.NoError(t, err)
+	assert.Equal(t, "edited outside", obj.(*v1alpha1.Manifest).Spec.Message)
+}
+
+type countingDecoder struct {
+	runtime.Decoder
+	count int
+}
+
+func (d *countingDecoder) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	d.count++
+	return d.Decoder.Decode(data, defaults, into)
+}
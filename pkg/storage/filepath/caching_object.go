@@ -0,0 +1,95 @@
+package filepath
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// cachingObject wraps an object sent to many watchers, so that the HTTP
+// layer encodes it once per wire format rather than once per watcher.
+//
+// It's modeled on the cachingObject of the upstream watch cache. The wrapped
+// object is never modified, so the encodings stay valid.
+type cachingObject struct {
+	object runtime.Object
+
+	mu             sync.Mutex
+	serializations map[runtime.Identifier]*serializationResult
+}
+
+type serializationResult struct {
+	once sync.Once
+	raw  []byte
+	err  error
+}
+
+var _ runtime.CacheableObject = &cachingObject{}
+
+func newCachingObject(obj runtime.Object) runtime.Object {
+	if _, ok := obj.(runtime.CacheableObject); ok {
+		return obj
+	}
+	return &cachingObject{
+		object:         obj,
+		serializations: make(map[runtime.Identifier]*serializationResult),
+	}
+}
+
+// unwrapCachingObject returns the object wrapped by a cachingObject, without
+// copying it. The result must not be modified.
+func unwrapCachingObject(obj runtime.Object) runtime.Object {
+	if co, ok := obj.(*cachingObject); ok {
+		return co.object
+	}
+	return obj
+}
+
+func (o *cachingObject) serializationResult(id runtime.Identifier) *serializationResult {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result, ok := o.serializations[id]
+	if !ok {
+		result = &serializationResult{}
+		o.serializations[id] = result
+	}
+	return result
+}
+
+// CacheEncode writes the encoding of the object for the encoder with the
+// given identifier, encoding it on first use.
+func (o *cachingObject) CacheEncode(id runtime.Identifier, encode func(runtime.Object, io.Writer) error, w io.Writer) error {
+	result := o.serializationResult(id)
+	result.once.Do(func() {
+		// encoders may set the kind on the object they encode, so encode a copy
+		buf := new(bytes.Buffer)
+		result.err = encode(o.GetObject(), buf)
+		result.raw = buf.Bytes()
+	})
+	if result.err != nil {
+		return result.err
+	}
+	if splice, ok := w.(runtime.Splice); ok {
+		splice.Splice(result.raw)
+		return nil
+	}
+	_, err := w.Write(result.raw)
+	return err
+}
+
+// GetObject returns a copy of the wrapped object.
+func (o *cachingObject) GetObject() runtime.Object {
+	return o.object.DeepCopyObject()
+}
+
+func (o *cachingObject) GetObjectKind() schema.ObjectKind {
+	return o.object.GetObjectKind()
+}
+
+// DeepCopyObject copies the wrapped object, but not its encodings.
+func (o *cachingObject) DeepCopyObject() runtime.Object {
+	return newCachingObject(o.object.DeepCopyObject())
+}
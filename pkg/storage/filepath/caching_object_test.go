@@ -0,0 +1,63 @@
+package filepath_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+)
+
+func TestWatch_EventsSerializedOnce(t *testing.T) {
+	f := newRESTFixture(t)
+	defer f.tearDown()
+
+	w1 := f.watch("test-obj")
+	defer w1.Stop()
+	w2 := f.watch("test-obj")
+	defer w2.Stop()
+
+	// events are sent synchronously, so the watches have to be read while creating
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.mustCreate(&v1alpha1.Manifest{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-obj"},
+			Spec:       v1alpha1.ManifestSpec{Message: "hello"},
+		})
+	}()
+	var events []watch.Event
+	for len(events) < 2 {
+		select {
+		case e := <-w1.ResultChan():
+			events = append(events, e)
+		case e := <-w2.ResultChan():
+			events = append(events, e)
+		}
+	}
+	<-done
+
+	encodes := 0
+	encode := func(obj runtime.Object, w io.Writer) error {
+		encodes++
+		_, err := w.Write([]byte(obj.(*v1alpha1.Manifest).Spec.Message))
+		return err
+	}
+	for _, e := range events {
+		assert.Equal(t, watch.Added, e.Type)
+		co, ok := e.Object.(runtime.CacheableObject)
+		require.True(t, ok, "expected a cacheable object, got %T", e.Object)
+		assert.Equal(t, "hello", co.GetObject().(*v1alpha1.Manifest).Spec.Message)
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, co.CacheEncode("test", encode, buf))
+		assert.Equal(t, "hello", buf.String())
+	}
+	assert.Equal(t, 1, encodes, "expected the event to be encoded once")
+}
@@ -0,0 +1,263 @@
+package filepath
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// ChangeRecord is a single entry in a Changelog.
+type ChangeRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+
+	// The user that made the change, from the request context.
+	User   string   `json:"user,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+
+	// One of create, update or delete.
+	Verb        string `json:"verb"`
+	Subresource string `json:"subresource,omitempty"`
+
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+
+	// Empty on create.
+	OldResourceVersion string `json:"oldResourceVersion,omitempty"`
+	// Empty on delete.
+	NewResourceVersion string `json:"newResourceVersion,omitempty"`
+
+	// A JSON patch (RFC 6902) from the old object to the new object.
+	// Empty on delete.
+	Patch []jsonpatch.Operation `json:"patch,omitempty"`
+}
+
+// ChangelogQuery filters records read from a Changelog. Empty fields match everything.
+type ChangelogQuery struct {
+	User      string
+	Verb      string
+	Resource  string
+	Namespace string
+	Name      string
+}
+
+func (q ChangelogQuery) matches(r ChangeRecord) bool {
+	return (q.User == "" || q.User == r.User) &&
+		(q.Verb == "" || q.Verb == r.Verb) &&
+		(q.Resource == "" || q.Resource == r.Resource) &&
+		(q.Namespace == "" || q.Namespace == r.Namespace) &&
+		(q.Name == "" || q.Name == r.Name)
+}
+
+// Changelog appends a JSONL record of each successful mutation to a file.
+//
+// Once the file grows past maxSize bytes, it's rotated to path.1 (and path.1
+// to path.2, etc.), keeping at most maxBackups rotated files.
+type Changelog struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewChangelog creates a changelog that writes to path. A maxSize of 0 disables rotation.
+func NewChangelog(path string, maxSize int64, maxBackups int) *Changelog {
+	return &Changelog{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+}
+
+// Append writes a record to the changelog, rotating it first if necessary.
+func (c *Changelog) Append(r ChangeRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.open(); err != nil {
+		return err
+	}
+	if c.maxSize > 0 && c.size > 0 && c.size+int64(len(line)) > c.maxSize {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	return err
+}
+
+// Close closes the active changelog file. The changelog re-opens it on the next Append.
+func (c *Changelog) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// Read returns the records that match the query, oldest first, including
+// records in rotated files.
+func (c *Changelog) Read(q ChangelogQuery) ([]ChangeRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := []ChangeRecord{}
+	for i := c.maxBackups; i >= 0; i-- {
+		records, err := readChangelogFile(c.backupPath(i))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, r := range records {
+			if q.matches(r) {
+				result = append(result, r)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Opens the active file for appending. Must hold the mutex.
+func (c *Changelog) open() error {
+	if c.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(c.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	c.file = file
+	c.size = info.Size()
+	return nil
+}
+
+// Shifts each file to the next backup slot and opens a new active file.
+// Must hold the mutex.
+func (c *Changelog) rotate() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	c.file = nil
+
+	if c.maxBackups == 0 {
+		if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return c.open()
+	}
+
+	for i := c.maxBackups - 1; i >= 0; i-- {
+		err := os.Rename(c.backupPath(i), c.backupPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return c.open()
+}
+
+func (c *Changelog) backupPath(i int) string {
+	if i == 0 {
+		return c.path
+	}
+	return fmt.Sprintf("%s.%d", c.path, i)
+}
+
+func readChangelogFile(path string) ([]ChangeRecord, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ChangeRecord{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r ChangeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("reading changelog %s: %v", path, err)
+		}
+		result = append(result, r)
+	}
+	return result, scanner.Err()
+}
+
+// newChangeRecord builds a record of a change from oldObj to newObj.
+// oldObj is nil on create, and newObj is nil on delete.
+func newChangeRecord(ctx context.Context, encoder runtime.Encoder, verb string, oldObj, newObj runtime.Object) (ChangeRecord, error) {
+	r := ChangeRecord{
+		Timestamp: time.Now(),
+		Verb:      verb,
+	}
+	if u, ok := genericapirequest.UserFrom(ctx); ok {
+		r.User = u.GetName()
+		r.Groups = u.GetGroups()
+	}
+	if info, ok := genericapirequest.RequestInfoFrom(ctx); ok {
+		r.Subresource = info.Subresource
+	}
+
+	oldVersion, err := getResourceVersion(oldObj)
+	if err != nil {
+		return r, err
+	}
+	if oldVersion != 0 {
+		r.OldResourceVersion = formatResourceVersion(oldVersion)
+	}
+	if newObj == nil {
+		return r, nil
+	}
+
+	newVersion, err := getResourceVersion(newObj)
+	if err != nil {
+		return r, err
+	}
+	r.NewResourceVersion = formatResourceVersion(newVersion)
+
+	oldJSON := []byte("{}")
+	if oldObj != nil {
+		oldJSON, err = runtime.Encode(encoder, oldObj)
+		if err != nil {
+			return r, err
+		}
+	}
+	newJSON, err := runtime.Encode(encoder, newObj)
+	if err != nil {
+		return r, err
+	}
+	r.Patch, err = jsonpatch.CreatePatch(oldJSON, newJSON)
+	return r, err
+}
@@ -0,0 +1,109 @@
+package filepath_test
+
+import (
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestFilepathREST_Changelog(t *testing.T) {
+	changelog := filepath.NewChangelog(fp.Join(t.TempDir(), "changelog.jsonl"), 0, 0)
+	defer changelog.Close()
+
+	f := newRESTFixtureWithStrategy(t, func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+		return defaultStrategy
+	}, filepath.WithChangelog(changelog))
+	defer f.tearDown()
+	f.rootCtx = genericapirequest.WithUser(f.rootCtx, &user.DefaultInfo{Name: "alice", Groups: []string{"devs"}})
+
+	created := f.mustCreate(&v1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-obj"},
+		Spec:       v1alpha1.ManifestSpec{Message: "hello"},
+	})
+	updated := f.mustUpdate("test-obj", func(obj runtime.Object) {
+		obj.(*v1alpha1.Manifest).Spec.Message = "goodbye"
+	})
+	createdVersion := f.mustMeta(created).GetResourceVersion()
+	updatedVersion := f.mustMeta(updated).GetResourceVersion()
+	_, _, err := f.deleter().Delete(f.rootCtx, "test-obj", nil, nil)
+	require.NoError(t, err)
+
+	records, err := changelog.Read(filepath.ChangelogQuery{})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	create, update, del := records[0], records[1], records[2]
+	assert.Equal(t, "create", create.Verb)
+	assert.Equal(t, "alice", create.User)
+	assert.Equal(t, []string{"devs"}, create.Groups)
+	assert.Equal(t, "manifests", create.Resource)
+	assert.Equal(t, "test-obj", create.Name)
+	assert.Equal(t, "", create.OldResourceVersion)
+	assert.Equal(t, createdVersion, create.NewResourceVersion)
+
+	assert.Equal(t, "update", update.Verb)
+	assert.Equal(t, createdVersion, update.OldResourceVersion)
+	assert.Equal(t, updatedVersion, update.NewResourceVersion)
+	patch := map[string]interface{}{}
+	for _, op := range update.Patch {
+		patch[op.Path] = op.Value
+	}
+	assert.Equal(t, "goodbye", patch["/spec/message"])
+
+	assert.Equal(t, "delete", del.Verb)
+	assert.Equal(t, updatedVersion, del.OldResourceVersion)
+	assert.Equal(t, "", del.NewResourceVersion)
+	assert.Empty(t, del.Patch)
+
+	records, err = changelog.Read(filepath.ChangelogQuery{Verb: "update"})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	records, err = changelog.Read(filepath.ChangelogQuery{User: "bob"})
+	require.NoError(t, err)
+	assert.Len(t, records, 0)
+}
+
+func TestChangelog_Rotate(t *testing.T) {
+	path := fp.Join(t.TempDir(), "changelog.jsonl")
+	changelog := filepath.NewChangelog(path, 200, 2)
+	defer changelog.Close()
+
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, name := range names {
+		require.NoError(t, changelog.Append(filepath.ChangeRecord{
+			Verb:     "create",
+			Resource: "manifests",
+			Name:     name,
+		}))
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(200))
+	}
+	_, err := os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// the oldest records are rotated out, the rest are read oldest first
+	records, err := changelog.Read(filepath.ChangelogQuery{})
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	require.Less(t, len(records), len(names))
+	offset := len(names) - len(records)
+	for i, r := range records {
+		assert.Equal(t, names[offset+i], r.Name)
+	}
+}
@@ -0,0 +1,207 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// ComputeFunc computes the content of a read-only subresource from the
+// current parent object.
+type ComputeFunc func(ctx context.Context, parent runtime.Object) (runtime.Object, error)
+
+var _ rest.Getter = &computedREST{}
+var _ rest.Watcher = &watchableComputedREST{}
+
+// NewComputedStorageProvider serves a read-only subresource whose content is
+// computed from the parent object on each request, rather than stored.
+//
+// newFunc returns an empty result. If watchable is true, the subresource can
+// also be watched (at .../watch/<resource>/<name>/<subresource>), and the
+// result is recomputed each time the parent changes.
+//
+// parentSP must be a provider created by NewJSONFilepathStorageProvider.
+func NewComputedStorageProvider(
+	parentSP builderrest.ResourceHandlerProvider,
+	newFunc func() runtime.Object,
+	compute ComputeFunc,
+	watchable bool,
+) builderrest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (rest.Storage, error) {
+		parent, err := filepathParent(scheme, getter, parentSP)
+		if err != nil {
+			return nil, err
+		}
+		r := &computedREST{parent: parent, newFunc: newFunc, compute: compute}
+		if watchable {
+			return &watchableComputedREST{computedREST: r}, nil
+		}
+		return r, nil
+	}
+}
+
+type computedREST struct {
+	parent  *filepathREST
+	newFunc func() runtime.Object
+	compute ComputeFunc
+}
+
+func (r *computedREST) New() runtime.Object {
+	return r.newFunc()
+}
+
+func (r *computedREST) Destroy() {
+	// Destroy() is intended for cleaning up client connections. Do nothing.
+}
+
+func (r *computedREST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	obj, err := r.parent.Get(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+	return r.computeFrom(ctx, obj)
+}
+
+// computeFrom computes the result from the parent. Unless the result sets
+// them, it has the name, namespace and resourceVersion of the parent, so that
+// clients can tell which revision of the parent it was computed from.
+func (r *computedREST) computeFrom(ctx context.Context, parent runtime.Object) (runtime.Object, error) {
+	result, err := r.compute(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, apierrors.NewInternalError(fmt.Errorf("computed a nil %T", r.newFunc()))
+	}
+
+	parentMeta, err := meta.Accessor(parent)
+	if err != nil {
+		return result, nil
+	}
+	resultMeta, err := meta.Accessor(result)
+	if err != nil {
+		return result, nil
+	}
+	if resultMeta.GetName() == "" {
+		resultMeta.SetName(parentMeta.GetName())
+	}
+	if resultMeta.GetNamespace() == "" {
+		resultMeta.SetNamespace(parentMeta.GetNamespace())
+	}
+	if resultMeta.GetResourceVersion() == "" {
+		resultMeta.SetResourceVersion(parentMeta.GetResourceVersion())
+	}
+	return result, nil
+}
+
+type watchableComputedREST struct {
+	*computedREST
+}
+
+// Watch watches the named parent, and sends the result computed from each
+// revision of it.
+func (r *watchableComputedREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
+	name, ok := computedWatchName(ctx, options)
+	if !ok {
+		return nil, apierrors.NewBadRequest("watching a computed subresource requires a name")
+	}
+
+	parentOptions := &metainternalversion.ListOptions{}
+	if options != nil {
+		parentOptions = options.DeepCopy()
+	}
+	parentOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name", name)
+	parentOptions.LabelSelector = nil
+
+	parentWatch, err := r.parent.Watch(ctx, parentOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan watch.Event)
+	w := watch.NewProxyWatcher(ch)
+	done := make(chan struct{})
+
+	// stop watching the parent as soon as the watch is stopped, even while
+	// there are no events to send
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.StopChan():
+		case <-done:
+		}
+		parentWatch.Stop()
+	}()
+
+	go func() {
+		defer close(ch)
+		defer close(done)
+		for e := range parentWatch.ResultChan() {
+			out, ok := r.computeEvent(ctx, e)
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- out:
+			case <-w.StopChan():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+// computeEvent converts an event on the parent into an event on the result.
+func (r *watchableComputedREST) computeEvent(ctx context.Context, e watch.Event) (watch.Event, bool) {
+	switch e.Type {
+	case watch.Added, watch.Modified, watch.Deleted:
+		result, err := r.computeFrom(ctx, unwrapCachingObject(e.Object))
+		if err != nil {
+			status := apierrors.NewInternalError(err).Status()
+			if apiStatus, ok := err.(apierrors.APIStatus); ok {
+				status = apiStatus.Status()
+			}
+			return watch.Event{Type: watch.Error, Object: &status}, true
+		}
+		return watch.Event{Type: e.Type, Object: result}, true
+	case watch.Bookmark:
+		result := r.newFunc()
+		parentMeta, err := meta.Accessor(unwrapCachingObject(e.Object))
+		if err != nil {
+			return watch.Event{}, false
+		}
+		resultMeta, err := meta.Accessor(result)
+		if err != nil {
+			return watch.Event{}, false
+		}
+		resultMeta.SetResourceVersion(parentMeta.GetResourceVersion())
+		return watch.Event{Type: watch.Bookmark, Object: result}, true
+	default:
+		return e, true
+	}
+}
+
+// computedWatchName returns the name of the parent being watched, which the
+// watch handler passes as the request's name and as a field selector.
+func computedWatchName(ctx context.Context, options *metainternalversion.ListOptions) (string, bool) {
+	if info, ok := genericapirequest.RequestInfoFrom(ctx); ok && info.Name != "" {
+		return info.Name, true
+	}
+	if options != nil && options.FieldSelector != nil {
+		return options.FieldSelector.RequiresExactMatch("metadata.name")
+	}
+	return "", false
+}
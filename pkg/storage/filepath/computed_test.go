@@ -0,0 +1,46 @@
+package filepath_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestComputed_StopWithoutEvents(t *testing.T) {
+	f := newRESTFixture(t)
+	defer f.tearDown()
+
+	f.mustCreate(&v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "test-obj"}})
+	computed := f.subresource(filepath.NewComputedStorageProvider(f.sp,
+		func() runtime.Object { return &v1alpha1.Manifest{} },
+		func(ctx context.Context, parent runtime.Object) (runtime.Object, error) { return parent, nil },
+		true)).(rest.Watcher)
+
+	w, err := computed.Watch(f.rootCtx, &metainternalversion.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", "test-obj"),
+	})
+	require.NoError(t, err)
+
+	// the initial event for the parent
+	<-w.ResultChan()
+
+	// the parent watch is stopped, so the result channel is closed, even
+	// though no event is waiting to be sent
+	w.Stop()
+	select {
+	case _, ok := <-w.ResultChan():
+		require.False(t, ok, "unexpected event after stop")
+	case <-time.After(time.Second):
+		t.Fatal("watch not closed after stop")
+	}
+}
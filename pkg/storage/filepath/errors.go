@@ -0,0 +1,176 @@
+package filepath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrorCode classifies the errors returned by an FS.
+type ErrorCode int
+
+const (
+	// ErrCodeNotFound means the file doesn't exist.
+	ErrCodeNotFound ErrorCode = iota + 1
+	// ErrCodeConflict means the file isn't at the expected storage version.
+	ErrCodeConflict
+	// ErrCodeAlreadyExists means the file was expected not to exist.
+	ErrCodeAlreadyExists
+	// ErrCodeCorrupted means the file exists, but can't be decoded, or the
+	// object can't be encoded.
+	ErrCodeCorrupted
+	// ErrCodeUnavailable means the storage couldn't be read or written.
+	ErrCodeUnavailable
+	// ErrCodeCanceled means the context of the operation was canceled, or its
+	// deadline passed.
+	ErrCodeCanceled
+)
+
+var errCodeNames = map[ErrorCode]string{
+	ErrCodeNotFound:      "not found",
+	ErrCodeConflict:      "conflict",
+	ErrCodeAlreadyExists: "already exists",
+	ErrCodeCorrupted:     "corrupted",
+	ErrCodeUnavailable:   "unavailable",
+	ErrCodeCanceled:      "canceled",
+}
+
+// StorageError is the error returned by FS implementations.
+type StorageError struct {
+	Code ErrorCode
+	Path string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Path, errCodeNames[e.Code])
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, os.ErrNotExist) and errors.Is(err, VersionError)
+// keep working for the matching codes. errors.Is(err, context.Canceled) works
+// through Unwrap.
+func (e *StorageError) Is(target error) bool {
+	switch e.Code {
+	case ErrCodeNotFound:
+		return target == os.ErrNotExist
+	case ErrCodeConflict:
+		return target == VersionError
+	case ErrCodeAlreadyExists:
+		return target == os.ErrExist
+	}
+	return false
+}
+
+func NewNotFoundError(path string) error {
+	return &StorageError{Code: ErrCodeNotFound, Path: path}
+}
+
+func NewConflictError(path string) error {
+	return &StorageError{Code: ErrCodeConflict, Path: path, Err: VersionError}
+}
+
+func NewAlreadyExistsError(path string) error {
+	return &StorageError{Code: ErrCodeAlreadyExists, Path: path}
+}
+
+func NewCorruptedError(path string, err error) error {
+	return &StorageError{Code: ErrCodeCorrupted, Path: path, Err: err}
+}
+
+func NewUnavailableError(path string, err error) error {
+	return &StorageError{Code: ErrCodeUnavailable, Path: path, Err: err}
+}
+
+func NewCanceledError(path string, err error) error {
+	return &StorageError{Code: ErrCodeCanceled, Path: path, Err: err}
+}
+
+func IsNotFound(err error) bool      { return hasCode(err, ErrCodeNotFound) }
+func IsConflict(err error) bool      { return hasCode(err, ErrCodeConflict) }
+func IsAlreadyExists(err error) bool { return hasCode(err, ErrCodeAlreadyExists) }
+func IsCorrupted(err error) bool     { return hasCode(err, ErrCodeCorrupted) }
+func IsUnavailable(err error) bool   { return hasCode(err, ErrCodeUnavailable) }
+func IsCanceled(err error) bool      { return hasCode(err, ErrCodeCanceled) }
+
+func hasCode(err error, code ErrorCode) bool {
+	var storageErr *StorageError
+	return errors.As(err, &storageErr) && storageErr.Code == code
+}
+
+// fileError converts an error from the os package into a StorageError.
+func fileError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return NewCanceledError(path, err)
+	}
+	if os.IsNotExist(err) {
+		return NewNotFoundError(path)
+	}
+	if os.IsExist(err) {
+		return NewAlreadyExistsError(path)
+	}
+	return NewUnavailableError(path, err)
+}
+
+// interpretFSError converts an error from the FS, or from anything else
+// that the REST storage calls, into an API error.
+//
+// API errors are passed through, so that errors returned by strategies and
+// validation keep their status.
+func interpretFSError(err error, gr schema.GroupResource, name string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(apierrors.APIStatus); ok {
+		return err
+	}
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		switch storageErr.Code {
+		case ErrCodeNotFound:
+			return apierrors.NewNotFound(gr, name)
+		case ErrCodeConflict:
+			return newConflictErr(gr, name)
+		case ErrCodeAlreadyExists:
+			return apierrors.NewAlreadyExists(gr, name)
+		case ErrCodeCorrupted:
+			return apierrors.NewInternalError(err)
+		case ErrCodeUnavailable:
+			return apierrors.NewServiceUnavailable(err.Error())
+		case ErrCodeCanceled:
+			return canceledErr(err, gr)
+		}
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return canceledErr(err, gr)
+	}
+	return apierrors.NewInternalError(err)
+}
+
+// canceledErr returns the API error of a request whose context was canceled
+// or timed out while the resource was read or written.
+func canceledErr(err error, gr schema.GroupResource) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return apierrors.NewTimeoutError(fmt.Sprintf("request for %s timed out", gr), 0)
+	}
+	return apierrors.NewTimeoutError(fmt.Sprintf("request for %s was canceled", gr), 0)
+}
@@ -0,0 +1,192 @@
+package filepath_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestFS_TypedErrors(t *testing.T) {
+	for _, fs := range fileSystems() {
+		t.Run(fmt.Sprintf("%T", fs), func(t *testing.T) {
+			ctx := context.Background()
+			scheme := runtime.NewScheme()
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+			newFunc := func() runtime.Object { return &v1alpha1.Manifest{} }
+
+			dir := t.TempDir()
+			require.NoError(t, fs.EnsureDir(ctx, dir))
+			path := fp.Join(dir, "a.json")
+
+			_, err := fs.Read(ctx, codec, path, newFunc)
+			assert.True(t, filepath.IsNotFound(err), "expected not found, got %v", err)
+			assert.True(t, errors.Is(err, os.ErrNotExist))
+
+			err = fs.Remove(ctx, path)
+			assert.True(t, filepath.IsNotFound(err), "expected not found, got %v", err)
+
+			canceledCtx, cancel := context.WithCancel(ctx)
+			cancel()
+			err = fs.Write(canceledCtx, codec, path, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, 0)
+			assert.True(t, filepath.IsCanceled(err), "expected canceled, got %v", err)
+			assert.True(t, errors.Is(err, context.Canceled), "expected canceled, got %v", err)
+
+			require.NoError(t, fs.Write(ctx, codec, path, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, 0))
+			_, err = fs.Read(canceledCtx, codec, path, newFunc)
+			assert.True(t, errors.Is(err, context.Canceled), "expected canceled, got %v", err)
+			_, err = fs.VisitDir(canceledCtx, dir, newFunc, codec, func(string, runtime.Object) error { return nil })
+			assert.True(t, errors.Is(err, context.Canceled), "expected canceled, got %v", err)
+		})
+	}
+}
+
+func TestFS_UnencodableObject(t *testing.T) {
+	for _, fs := range fileSystems() {
+		t.Run(fmt.Sprintf("%T", fs), func(t *testing.T) {
+			ctx := context.Background()
+			// the scheme doesn't know the type, so it can't be encoded
+			codec := serializer.NewCodecFactory(runtime.NewScheme()).LegacyCodec(v1alpha1.SchemeGroupVersion)
+
+			dir := t.TempDir()
+			require.NoError(t, fs.EnsureDir(ctx, dir))
+			obj := &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a", ResourceVersion: "1"}}
+			err := fs.Write(ctx, codec, fp.Join(dir, "a.json"), obj, 0)
+			assert.True(t, filepath.IsCorrupted(err), "expected corrupted, got %v", err)
+			assert.Equal(t, "1", obj.ResourceVersion)
+		})
+	}
+}
+
+func TestMemoryFS_WriteConflicts(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+
+	fs := filepath.NewMemoryFS()
+	path := fp.Join("dir", "a.json")
+	require.NoError(t, fs.Write(ctx, codec, path, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, 0))
+
+	err := fs.Write(ctx, codec, path, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, 0)
+	assert.True(t, filepath.IsAlreadyExists(err), "expected already exists, got %v", err)
+
+	err = fs.Write(ctx, codec, path, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, 100)
+	assert.True(t, filepath.IsConflict(err), "expected conflict, got %v", err)
+	assert.True(t, errors.Is(err, filepath.VersionError))
+
+	err = fs.Write(ctx, codec, fp.Join("dir", "b.json"), &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "b"}}, 100)
+	assert.True(t, filepath.IsNotFound(err), "expected not found, got %v", err)
+}
+
+func TestRealFS_CorruptedFile(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+	newFunc := func() runtime.Object { return &v1alpha1.Manifest{} }
+
+	fs := filepath.NewRealFS()
+	dir := t.TempDir()
+	path := fp.Join(dir, "a.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0600))
+
+	_, err := fs.Read(ctx, codec, path, newFunc)
+	assert.True(t, filepath.IsCorrupted(err), "expected corrupted, got %v", err)
+
+	_, err = fs.VisitDir(ctx, dir, newFunc, codec, func(string, runtime.Object) error { return nil })
+	assert.True(t, filepath.IsCorrupted(err), "expected corrupted, got %v", err)
+
+	// a directory that doesn't exist has no objects
+	_, err = fs.VisitDir(ctx, fp.Join(dir, "missing"), newFunc, codec, func(string, runtime.Object) error {
+		return errors.New("unexpected object")
+	})
+	assert.NoError(t, err)
+}
+
+func TestFilepathREST_StorageErrorsAreAPIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int32
+	}{
+		{"not found", filepath.NewNotFoundError("a.json"), 404},
+		{"corrupted", filepath.NewCorruptedError("a.json", errors.New("bad json")), 500},
+		{"unavailable", filepath.NewUnavailableError("a.json", errors.New("disk on fire")), 503},
+		{"canceled", filepath.NewCanceledError("a.json", context.Canceled), 504},
+		{"deadline exceeded", context.DeadlineExceeded, 504},
+		{"other", errors.New("something else"), 500},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := &faultyFS{FS: filepath.NewMemoryFS()}
+			f := newRESTFixtureWithFS(t, fs, func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+				return defaultStrategy
+			})
+			defer f.tearDown()
+
+			f.mustCreate(&v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "test-obj"}})
+			fs.readErr = tc.err
+
+			_, err := f.get("test-obj")
+			var status apierrors.APIStatus
+			require.True(t, errors.As(err, &status), "expected an API error, got %T: %v", err, err)
+			assert.Equal(t, tc.code, status.Status().Code)
+
+			ctx, cancel := f.ctx()
+			defer cancel()
+			_, _, err = f.updater().Update(ctx, "test-obj", objectUpdater{updateFn: func(obj runtime.Object) {
+				obj.(*v1alpha1.Manifest).Spec.Message = "updated"
+			}}, nil, nil, false, nil)
+			require.True(t, errors.As(err, &status), "expected an API error, got %T: %v", err, err)
+			assert.Equal(t, tc.code, status.Status().Code)
+		})
+	}
+}
+
+func TestFilepathREST_CreateRaceIsAlreadyExists(t *testing.T) {
+	fs := &faultyFS{FS: filepath.NewMemoryFS(), writeErr: filepath.NewAlreadyExistsError("test-obj.json")}
+	f := newRESTFixtureWithFS(t, fs, func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+		return defaultStrategy
+	})
+	defer f.tearDown()
+
+	ctx, cancel := f.ctx()
+	defer cancel()
+	_, err := f.creater().Create(ctx, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "test-obj"}}, nil, nil)
+	assert.True(t, apierrors.IsAlreadyExists(err), "expected already exists, got %v", err)
+}
+
+// faultyFS returns the given errors from reads and writes.
+type faultyFS struct {
+	filepath.FS
+	readErr  error
+	writeErr error
+}
+
+func (fs *faultyFS) Read(ctx context.Context, decoder runtime.Decoder, path string, newFunc func() runtime.Object) (runtime.Object, error) {
+	if fs.readErr != nil {
+		return nil, fs.readErr
+	}
+	return fs.FS.Read(ctx, decoder, path, newFunc)
+}
+
+func (fs *faultyFS) Write(ctx context.Context, encoder runtime.Encoder, path string, obj runtime.Object, storageVersion uint64) error {
+	if fs.writeErr != nil {
+		return fs.writeErr
+	}
+	return fs.FS.Write(ctx, encoder, path, obj, storageVersion)
+}
@@ -2,11 +2,12 @@ package filepath
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -17,78 +18,199 @@ var VersionError = errors.New("incorrect object version")
 
 // A filesystem interface so we can sub out filesystem-based storage
 // with memory-based storage.
+//
+// Methods return the context's error once it's done, and a *StorageError for
+// anything else that goes wrong.
 type FS interface {
-	Remove(filepath string) error
-	Exists(filepath string) bool
-	EnsureDir(dirname string) error
-	Write(encoder runtime.Encoder, filepath string, obj runtime.Object, storageVersion uint64) error
-	Read(decoder runtime.Decoder, path string, newFunc func() runtime.Object) (runtime.Object, error)
-	VisitDir(dirname string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object) error) (uint64, error)
+	Remove(ctx context.Context, filepath string) error
+	Exists(ctx context.Context, filepath string) bool
+	EnsureDir(ctx context.Context, dirname string) error
+	Write(ctx context.Context, encoder runtime.Encoder, filepath string, obj runtime.Object, storageVersion uint64) error
+	Read(ctx context.Context, decoder runtime.Decoder, path string, newFunc func() runtime.Object) (runtime.Object, error)
+	VisitDir(ctx context.Context, dirname string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object) error) (uint64, error)
 }
 
+// RealFS stores objects as files on disk.
+//
+// Writes to the same file are serialized, but nothing else is. Files are
+// replaced atomically, so reads and directory walks never block and never
+// see a partial write.
 type RealFS struct {
-	mu  sync.Mutex
-	rev uint64
+	revs  *revisionAllocator
+	locks *keyedMutex
+
+	// mu guards versions, which has the revision of each file written by this RealFS.
+	mu       sync.Mutex
+	versions map[string]uint64
+
+	cache *decodeCache
 }
 
 func NewRealFS() *RealFS {
-	return &RealFS{rev: 1}
+	return &RealFS{
+		revs:     newRevisionAllocator(1),
+		locks:    newKeyedMutex(),
+		versions: make(map[string]uint64),
+		cache:    newDecodeCache(decodeCacheSize),
+	}
 }
 
 var _ FS = &RealFS{}
 
-func (fs *RealFS) Remove(filepath string) error {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
-	_ = fs.incrementRev()
-	return os.Remove(filepath)
+func (fs *RealFS) Remove(ctx context.Context, path string) error {
+	_, err := fs.remove(ctx, path)
+	return err
 }
 
-func (fs *RealFS) Exists(filepath string) bool {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
-	_, err := os.Stat(filepath)
+// remove removes the file, and returns the revision of the removal.
+func (fs *RealFS) remove(ctx context.Context, path string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fileError(path, err)
+	}
+	path = filepath.Clean(path)
+	unlock := fs.locks.lock(path)
+	defer unlock()
+	rev := fs.revs.allocate()
+	defer fs.revs.done(rev)
+
+	fs.forget(path)
+	if err := os.Remove(path); err != nil {
+		return 0, fileError(path, err)
+	}
+	return rev, nil
+}
+
+func (fs *RealFS) Exists(ctx context.Context, path string) bool {
+	_, err := os.Stat(path)
 	return err == nil
 }
 
-func (fs *RealFS) EnsureDir(dirname string) error {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
+func (fs *RealFS) EnsureDir(ctx context.Context, dirname string) error {
+	if err := ctx.Err(); err != nil {
+		return fileError(dirname, err)
+	}
 	_, err := os.Stat(dirname)
 	if err != nil {
-		return os.MkdirAll(dirname, 0700)
+		return fileError(dirname, os.MkdirAll(dirname, 0700))
 	}
 	return nil
 }
 
-func (fs *RealFS) Write(encoder runtime.Encoder, filepath string, obj runtime.Object, storageVersion uint64) error {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
-	rev := fs.incrementRev()
+func (fs *RealFS) Write(ctx context.Context, encoder runtime.Encoder, path string, obj runtime.Object, storageVersion uint64) error {
+	if err := ctx.Err(); err != nil {
+		return fileError(path, err)
+	}
+	path = filepath.Clean(path)
+	unlock := fs.locks.lock(path)
+	defer unlock()
+	rev := fs.revs.allocate()
+	defer fs.revs.done(rev)
 
 	// TODO(milas): Currently we don't have optimistic concurrency at all.
 	// Each write has last-one-wins semantics.
 	// 	(currently, this isn't a critical priority as our use cases that rely
 	// 	on RealFS do not have simultaneous writers)
-	if err := setResourceVersion(obj, rev); err != nil {
-		return err
+	//
+	// encode a copy with the new resource version, so that the caller's object
+	// only gets it once it's written
+	versionedObj := obj.DeepCopyObject()
+	if err := setResourceVersion(versionedObj, rev); err != nil {
+		return NewCorruptedError(path, err)
 	}
 
 	buf := new(bytes.Buffer)
-	if err := encoder.Encode(obj, buf); err != nil {
+	if err := encoder.Encode(versionedObj, buf); err != nil {
+		return NewCorruptedError(path, err)
+	}
+	fs.forget(path)
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return fileError(path, err)
+	}
+	fs.setVersion(path, rev)
+	if err := setResourceVersion(obj, rev); err != nil {
+		return NewCorruptedError(path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file with one that has the data, so that
+// readers see either the old or the new contents.
+func writeFileAtomic(path string, data []byte) error {
+	// the temp file doesn't end in .json, so VisitDir skips it
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filepath, buf.Bytes(), 0600)
+	tmpPath := f.Name()
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
 }
 
-func (fs *RealFS) Read(decoder runtime.Decoder, path string, newFunc func() runtime.Object) (runtime.Object, error) {
-	fs.mu.Lock()
-	content, err := ioutil.ReadFile(filepath.Clean(path))
-	fs.mu.Unlock()
+func (fs *RealFS) Read(ctx context.Context, decoder runtime.Decoder, path string, newFunc func() runtime.Object) (runtime.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fileError(path, err)
+	}
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
 	if err != nil {
-		return nil, err
+		return nil, fileError(path, err)
+	}
+	stamp := fs.stamp(path, info)
+	if obj, ok := fs.cache.get(path, stamp, decoder, newFunc); ok {
+		return obj, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fileError(path, err)
+	}
+
+	obj, err := fs.decode(decoder, newFunc, content)
+	if err != nil {
+		return nil, NewCorruptedError(path, err)
 	}
-	return fs.decode(decoder, newFunc, content)
+	fs.cache.put(path, stamp, decoder, obj)
+	return obj, nil
+}
+
+// stamp identifies the current contents of the file.
+//
+// If the file is replaced between the stat and the read, the newer contents
+// are cached under the older stamp, which no later read will match.
+func (fs *RealFS) stamp(path string, info os.FileInfo) cacheStamp {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return cacheStamp{
+		version: fs.versions[path],
+		modTime: info.ModTime().UnixNano(),
+		size:    info.Size(),
+	}
+}
+
+// forget drops everything known about the contents of the file.
+func (fs *RealFS) forget(path string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.versions, path)
+	fs.cache.remove(path)
+}
+
+// setVersion records the revision the file was written at.
+func (fs *RealFS) setVersion(path string, rev uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.versions[path] = rev
 }
 
 func (fs *RealFS) decode(decoder runtime.Decoder, newFunc func() runtime.Object, content []byte) (runtime.Object, error) {
@@ -100,12 +222,23 @@ func (fs *RealFS) decode(decoder runtime.Decoder, newFunc func() runtime.Object,
 	return decodedObj, nil
 }
 
-func (fs *RealFS) VisitDir(dirname string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object) error) (uint64, error) {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
+// Walk the directory, reading all objects in it.
+// Return the ResourceVersion of when we started the read.
+//
+// The walk doesn't block writers, so it may also see objects written after
+// the returned version. A directory that doesn't exist has no objects.
+func (fs *RealFS) VisitDir(ctx context.Context, dirname string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object) error) (uint64, error) {
+	rev := fs.revs.current()
 	err := filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			if os.IsNotExist(err) {
+				// removed during the walk
+				return nil
+			}
+			return fileError(path, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return fileError(path, err)
 		}
 		if info.IsDir() {
 			return nil
@@ -113,42 +246,57 @@ func (fs *RealFS) VisitDir(dirname string, newFunc func() runtime.Object, codec
 		if !strings.HasSuffix(info.Name(), ".json") {
 			return nil
 		}
-		content, err := ioutil.ReadFile(filepath.Clean(path))
+		path = filepath.Clean(path)
+		stamp := fs.stamp(path, info)
+		if obj, ok := fs.cache.get(path, stamp, codec, newFunc); ok {
+			return visitFunc(path, obj)
+		}
+		content, err := ioutil.ReadFile(path)
 		if err != nil {
-			return err
+			if os.IsNotExist(err) {
+				// removed during the walk
+				return nil
+			}
+			return fileError(path, err)
 		}
 		newObj, err := fs.decode(codec, newFunc, content)
 		if err != nil {
-			return err
+			return NewCorruptedError(path, err)
 		}
+		fs.cache.put(path, stamp, codec, newObj)
 		return visitFunc(path, newObj)
 	})
 	if err != nil {
 		return 0, err
 	}
-	return fs.rev, nil
-}
-
-// incrementRev increases the revision counter and returns the new value.
-//
-// mu must be held.
-func (fs *RealFS) incrementRev() uint64 {
-	fs.rev++
-	return fs.rev
+	return rev, nil
 }
 
 // An in-memory structure that pretends to be a filesystem,
 // and supports all the storage interfaces that RealFS needs.
+//
+// Each directory has its own lock, so writers to different resources, and
+// readers of the same resource, don't wait for each other. Objects are
+// encoded and decoded outside of any lock.
 type MemoryFS struct {
-	mu  sync.Mutex
-	dir map[string]interface{}
-	rev uint64
+	// mu guards the set of directories, but not their contents.
+	mu   sync.RWMutex
+	dirs map[string]*memoryDir
+
+	revs  *revisionAllocator
+	cache *decodeCache
+}
+
+type memoryDir struct {
+	mu    sync.RWMutex
+	files map[string]versionedData
 }
 
 func NewMemoryFS() *MemoryFS {
 	return &MemoryFS{
-		dir: make(map[string]interface{}),
-		rev: 1,
+		dirs:  make(map[string]*memoryDir),
+		revs:  newRevisionAllocator(1),
+		cache: newDecodeCache(decodeCacheSize),
 	}
 }
 
@@ -159,74 +307,78 @@ type versionedData struct {
 	data    []byte
 }
 
-// Ensures the given directory exists in our in-memory map.
-func (fs *MemoryFS) ensureDir(pathToDir string) (map[string]interface{}, error) {
-	if pathToDir == "." {
-		return fs.dir, nil
+// getDir returns the directory, creating it and its ancestors if create is
+// true. Returns nil if it doesn't exist and create is false.
+func (fs *MemoryFS) getDir(dirname string, create bool) *memoryDir {
+	dirname = filepath.Clean(dirname)
+	fs.mu.RLock()
+	dir := fs.dirs[dirname]
+	fs.mu.RUnlock()
+	if dir != nil || !create {
+		return dir
 	}
 
-	parts := strings.Split(pathToDir, string(filepath.Separator))
-	i := 0
-	dir := fs.dir
-	for i < len(parts) {
-		part := parts[i]
-		nextDir, ok := dir[part]
-		if !ok {
-			nextDir = make(map[string]interface{})
-			dir[part] = nextDir
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for p := dirname; ; p = filepath.Dir(p) {
+		if _, ok := fs.dirs[p]; !ok {
+			fs.dirs[p] = &memoryDir{files: make(map[string]versionedData)}
 		}
-
-		dir, ok = nextDir.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("internal storage error. path conflict. expected map at %s, got: %T", part, nextDir)
+		if parent := filepath.Dir(p); parent == p {
+			break
 		}
-		i++
 	}
-	return dir, nil
+	return fs.dirs[dirname]
 }
 
 // Remove the filepath.
-func (fs *MemoryFS) Remove(p string) error {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
-
-	dir, err := fs.ensureDir(filepath.Dir(p))
-	if err != nil {
-		return err
+func (fs *MemoryFS) Remove(ctx context.Context, p string) error {
+	if err := ctx.Err(); err != nil {
+		return fileError(p, err)
+	}
+	p = filepath.Clean(p)
+	dir := fs.getDir(filepath.Dir(p), false)
+	if dir == nil {
+		return NewNotFoundError(p)
 	}
-	_, exists := dir[filepath.Base(p)]
+
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	_, exists := dir.files[filepath.Base(p)]
 	if !exists {
-		return os.ErrNotExist
+		return NewNotFoundError(p)
 	}
 
-	delete(dir, filepath.Base(p))
+	delete(dir.files, filepath.Base(p))
+	fs.cache.remove(p)
 	return nil
 }
 
 // Check if the filepath exists.
-func (fs *MemoryFS) Exists(p string) bool {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
-
-	dir, err := fs.ensureDir(filepath.Dir(p))
-	if err != nil {
-		return false
+func (fs *MemoryFS) Exists(ctx context.Context, p string) bool {
+	p = filepath.Clean(p)
+	if fs.getDir(p, false) != nil {
+		return true
 	}
-	_, exists := dir[filepath.Base(p)]
-	return exists
+	_, err := fs.readBuffer(p)
+	return err == nil
 }
 
 // Create the directory if it does not exist.
-func (fs *MemoryFS) EnsureDir(dirname string) error {
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
-
-	_, err := fs.ensureDir(dirname)
-	return err
+func (fs *MemoryFS) EnsureDir(ctx context.Context, dirname string) error {
+	if err := ctx.Err(); err != nil {
+		return fileError(dirname, err)
+	}
+	_ = fs.getDir(dirname, true)
+	return nil
 }
 
 // Write a copy of the object to our in-memory filesystem.
-func (fs *MemoryFS) Write(encoder runtime.Encoder, p string, obj runtime.Object, storageVersion uint64) error {
+func (fs *MemoryFS) Write(ctx context.Context, encoder runtime.Encoder, p string, obj runtime.Object, storageVersion uint64) error {
+	if err := ctx.Err(); err != nil {
+		return fileError(p, err)
+	}
+
 	// use a copy of the object w/o a resource version for
 	// serialization, so that objects that are identical besides resource
 	// version serialize the same, allowing us to skip unnecessary writes
@@ -235,67 +387,66 @@ func (fs *MemoryFS) Write(encoder runtime.Encoder, p string, obj runtime.Object,
 	// actually being identical)
 	versionlessObj := obj.DeepCopyObject()
 	if err := clearResourceVersion(versionlessObj); err != nil {
-		return err
+		return NewCorruptedError(p, err)
 	}
 
 	// Encoding the object as bytes ensures that our in-memory filesystem
 	// has the same immutability semantics as a real storage system.
 	buf := new(bytes.Buffer)
 	if err := encoder.Encode(versionlessObj, buf); err != nil {
-		return err
+		return NewCorruptedError(p, err)
 	}
 
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
+	p = filepath.Clean(p)
+	dir := fs.getDir(filepath.Dir(p), true)
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
 
-	if rawObj, err := fs.readBuffer(p); err != nil {
-		if os.IsNotExist(err) {
-			// storageVersion == 0 -> this is a create, so it's expected to not exist (continue)
-			// storageVersion != 0 -> object has been deleted, propagate err to avoid a zombie update
-			if storageVersion != 0 {
-				return err
-			}
-		} else {
-			return err
+	if rawObj, ok := dir.files[filepath.Base(p)]; !ok {
+		// storageVersion == 0 -> this is a create, so it's expected to not exist (continue)
+		// storageVersion != 0 -> object has been deleted, propagate err to avoid a zombie update
+		if storageVersion != 0 {
+			return NewNotFoundError(p)
 		}
+	} else if storageVersion == 0 {
+		// this is a create, but something else got there first
+		return NewAlreadyExistsError(p)
 	} else if rawObj.version != storageVersion {
 		// this write is outdated
-		return VersionError
+		return NewConflictError(p)
 	} else if bytes.Equal(rawObj.data, buf.Bytes()) {
 		// object serialized identically, skip write & version increment
 		return nil
 	}
 
-	dir, err := fs.ensureDir(filepath.Dir(p))
-	if err != nil {
-		return err
-	}
-
 	// increment the resource version - it's applied to the object pointer for
 	// the caller in addition to being used to ensure the write is valid
-	newVersion := fs.incrementRev()
+	newVersion := fs.revs.allocate()
+	defer fs.revs.done(newVersion)
 	if err := setResourceVersion(obj, newVersion); err != nil {
-		return err
+		return NewCorruptedError(p, err)
 	}
 
-	dir[filepath.Base(p)] = versionedData{
+	dir.files[filepath.Base(p)] = versionedData{
 		version: newVersion,
 		data:    buf.Bytes(),
 	}
+	fs.cache.remove(p)
 
 	return nil
 }
 
 // Read a copy of the object from our in-memory filesystem.
-func (fs *MemoryFS) Read(decoder runtime.Decoder, p string, newFunc func() runtime.Object) (runtime.Object, error) {
-	fs.mu.Lock()
+func (fs *MemoryFS) Read(ctx context.Context, decoder runtime.Decoder, p string, newFunc func() runtime.Object) (runtime.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fileError(p, err)
+	}
 	buf, err := fs.readBuffer(p)
-	fs.mu.Unlock()
 	if err != nil {
 		return nil, err
 	}
 
-	obj, err := fs.decodeBuffer(decoder, buf, newFunc)
+	obj, err := fs.decodeBuffer(decoder, p, buf, newFunc)
 	if err != nil {
 		return nil, err
 	}
@@ -304,49 +455,56 @@ func (fs *MemoryFS) Read(decoder runtime.Decoder, p string, newFunc func() runti
 }
 
 func (fs *MemoryFS) readBuffer(p string) (versionedData, error) {
-	dir, err := fs.ensureDir(filepath.Dir(p))
-	if err != nil {
-		return versionedData{}, err
+	p = filepath.Clean(p)
+	dir := fs.getDir(filepath.Dir(p), false)
+	if dir == nil {
+		return versionedData{}, NewNotFoundError(p)
 	}
 
-	contents, ok := dir[filepath.Base(p)]
+	dir.mu.RLock()
+	defer dir.mu.RUnlock()
+	data, ok := dir.files[filepath.Base(p)]
 	if !ok {
-		return versionedData{}, os.ErrNotExist
-	}
-	data, ok := contents.(versionedData)
-	if !ok {
-		return versionedData{}, os.ErrNotExist
+		return versionedData{}, NewNotFoundError(p)
 	}
 	return data, nil
 }
 
-func (fs *MemoryFS) decodeBuffer(decoder runtime.Decoder, rawObj versionedData, newFunc func() runtime.Object) (runtime.Object, error) {
+func (fs *MemoryFS) decodeBuffer(decoder runtime.Decoder, p string, rawObj versionedData, newFunc func() runtime.Object) (runtime.Object, error) {
+	p = filepath.Clean(p)
+	stamp := cacheStamp{version: rawObj.version}
+	if obj, ok := fs.cache.get(p, stamp, decoder, newFunc); ok {
+		return obj, nil
+	}
+
 	newObj := newFunc()
 	decodedObj, _, err := decoder.Decode(rawObj.data, nil, newObj)
 	if err != nil {
-		return nil, err
+		return nil, NewCorruptedError(p, err)
 	}
 	if err := setResourceVersion(decodedObj, rawObj.version); err != nil {
 		return nil, err
 	}
+	fs.cache.put(p, stamp, decoder, decodedObj)
 	return decodedObj, nil
 }
 
 // Walk the directory, reading all objects in it.
-// Return the ResourceVersion of when we did the read.
-func (fs *MemoryFS) VisitDir(dirname string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object) error) (uint64, error) {
-	fs.mu.Lock()
-	keyPaths, buffers, err := fs.readDir(dirname)
-	version := fs.rev
-	fs.mu.Unlock()
-	if err != nil {
-		return 0, err
-	}
+// Return the ResourceVersion of when we started the read.
+//
+// Each directory is read under its own lock, so the walk may also see
+// objects written after the returned version.
+func (fs *MemoryFS) VisitDir(ctx context.Context, dirname string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object) error) (uint64, error) {
+	version := fs.revs.current()
+	keyPaths, buffers := fs.readDir(dirname)
 
 	// Do decoding and visitation outside the lock.
 	for i, keyPath := range keyPaths {
+		if err := ctx.Err(); err != nil {
+			return 0, fileError(keyPath, err)
+		}
 		buf := buffers[i]
-		obj, err := fs.decodeBuffer(codec, buf, newFunc)
+		obj, err := fs.decodeBuffer(codec, keyPath, buf, newFunc)
 		if err != nil {
 			return 0, err
 		}
@@ -358,51 +516,166 @@ func (fs *MemoryFS) VisitDir(dirname string, newFunc func() runtime.Object, code
 	return version, nil
 }
 
-// Internal helper for reading the directory. Must hold the mutex.
-func (fs *MemoryFS) readDir(dirname string) ([]string, []versionedData, error) {
-	dir, err := fs.ensureDir(dirname)
-	if err != nil {
-		return nil, nil, err
+// Internal helper for reading the directory and its subdirectories.
+func (fs *MemoryFS) readDir(dirname string) ([]string, []versionedData) {
+	dirname = filepath.Clean(dirname)
+
+	fs.mu.RLock()
+	dirnames := []string{}
+	dirs := []*memoryDir{}
+	for name, dir := range fs.dirs {
+		if isWithinDir(name, dirname) {
+			dirnames = append(dirnames, name)
+			dirs = append(dirs, dir)
+		}
 	}
+	fs.mu.RUnlock()
 
 	keyPaths := []string{}
 	buffers := []versionedData{}
-
-	var walk func(ancestorPath string, dir map[string]interface{}) error
-	walk = func(ancestorPath string, dir map[string]interface{}) error {
-		for key, val := range dir {
-			keyPath := filepath.Join(ancestorPath, key)
-			innerDir, isDir := val.(map[string]interface{})
-			if isDir {
-				err = walk(keyPath, innerDir)
-				if err != nil {
-					return err
-				}
+	for i, dir := range dirs {
+		dir.mu.RLock()
+		for name, rawObj := range dir.files {
+			if !strings.HasSuffix(name, ".json") {
 				continue
 			}
+			keyPaths = append(keyPaths, filepath.Join(dirnames[i], name))
+			buffers = append(buffers, rawObj)
+		}
+		dir.mu.RUnlock()
+	}
+	return keyPaths, buffers
+}
 
-			if !strings.HasSuffix(key, ".json") {
-				continue
-			}
+// isWithinDir checks if the path is the directory, or is under it.
+func isWithinDir(path, dirname string) bool {
+	if path == dirname || dirname == "." {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dirname, string(filepath.Separator))+string(filepath.Separator))
+}
 
-			rawObj, err := fs.readBuffer(keyPath)
-			if err != nil {
-				return err
+// fsOp is a single change in an atomic MemoryFS commit.
+type fsOp struct {
+	path    string
+	encoder runtime.Encoder
+
+	// obj is the object to write, or nil for removals and checks.
+	obj    runtime.Object
+	remove bool
+
+	// storageVersion is the version the file must be at. For writes, 0 means
+	// the file must not exist. For removals and checks, 0 means the file must
+	// exist at any version.
+	storageVersion uint64
+}
+
+// commit applies all of the ops, or none of them if any op fails its check.
+//
+// All changed files get the same new revision, which is set on the written
+// objects and returned.
+func (fs *MemoryFS) commit(ops []fsOp) (uint64, error) {
+	// encode outside the lock, the same way Write does
+	bufs := make([][]byte, len(ops))
+	for i, op := range ops {
+		if op.obj == nil {
+			continue
+		}
+		versionlessObj := op.obj.DeepCopyObject()
+		if err := clearResourceVersion(versionlessObj); err != nil {
+			return 0, err
+		}
+		buf := new(bytes.Buffer)
+		if err := op.encoder.Encode(versionlessObj, buf); err != nil {
+			return 0, err
+		}
+		bufs[i] = buf.Bytes()
+	}
+
+	// lock every directory involved, in a consistent order so that
+	// concurrent commits can't deadlock
+	dirs := make([]*memoryDir, len(ops))
+	lockedDirs := map[string]*memoryDir{}
+	for i, op := range ops {
+		dirname := filepath.Dir(filepath.Clean(op.path))
+		dirs[i] = fs.getDir(dirname, true)
+		lockedDirs[dirname] = dirs[i]
+	}
+	dirnames := make([]string, 0, len(lockedDirs))
+	for dirname := range lockedDirs {
+		dirnames = append(dirnames, dirname)
+	}
+	sort.Strings(dirnames)
+	for _, dirname := range dirnames {
+		lockedDirs[dirname].mu.Lock()
+		defer lockedDirs[dirname].mu.Unlock()
+	}
+
+	// check everything before changing anything
+	versions := make([]uint64, len(ops))
+	changed := make([]bool, len(ops))
+	anyChanged := false
+	for i, op := range ops {
+		rawObj, exists := dirs[i].files[filepath.Base(op.path)]
+		versions[i] = rawObj.version
+
+		path := filepath.Clean(op.path)
+		if op.obj != nil {
+			if !exists {
+				if op.storageVersion != 0 {
+					return 0, NewNotFoundError(path)
+				}
+			} else if op.storageVersion == 0 {
+				return 0, NewAlreadyExistsError(path)
+			} else if rawObj.version != op.storageVersion {
+				return 0, NewConflictError(path)
 			}
-			keyPaths = append(keyPaths, keyPath)
-			buffers = append(buffers, rawObj)
+			changed[i] = !exists || !bytes.Equal(rawObj.data, bufs[i])
+		} else {
+			if !exists {
+				return 0, NewNotFoundError(path)
+			}
+			if op.storageVersion != 0 && rawObj.version != op.storageVersion {
+				return 0, NewConflictError(path)
+			}
+			changed[i] = op.remove
 		}
-		return nil
+		anyChanged = anyChanged || changed[i]
+	}
+
+	if !anyChanged {
+		return fs.revs.current(), fs.setVersions(ops, versions)
 	}
 
-	err = walk(dirname, dir)
-	return keyPaths, buffers, err
+	newVersion := fs.revs.allocate()
+	defer fs.revs.done(newVersion)
+	for i, op := range ops {
+		if !changed[i] {
+			continue
+		}
+		fs.cache.remove(filepath.Clean(op.path))
+		if op.remove {
+			delete(dirs[i].files, filepath.Base(op.path))
+			continue
+		}
+		dirs[i].files[filepath.Base(op.path)] = versionedData{
+			version: newVersion,
+			data:    bufs[i],
+		}
+		versions[i] = newVersion
+	}
+	return newVersion, fs.setVersions(ops, versions)
 }
 
-// incrementRev increases the revision counter and returns the new value.
-//
-// mu must be held.
-func (fs *MemoryFS) incrementRev() uint64 {
-	fs.rev++
-	return fs.rev
+// setVersions sets the version of each written object. Objects that
+// serialized identically keep their current version.
+func (fs *MemoryFS) setVersions(ops []fsOp, versions []uint64) error {
+	for i, op := range ops {
+		if op.obj != nil {
+			if err := setResourceVersion(op.obj, versions[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
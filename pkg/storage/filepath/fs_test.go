@@ -0,0 +1,139 @@
+package filepath_test
+
+import (
+	"context"
+	"fmt"
+	fp "path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+)
+
+func TestFS_VisitDirDoesNotBlockWriters(t *testing.T) {
+	for _, fs := range fileSystems() {
+		t.Run(fmt.Sprintf("%T", fs), func(t *testing.T) {
+			ctx := context.Background()
+			scheme := runtime.NewScheme()
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+			newFunc := func() runtime.Object { return &v1alpha1.Manifest{} }
+
+			root := t.TempDir()
+			for _, dir := range []string{"a", "b"} {
+				require.NoError(t, fs.EnsureDir(ctx, fp.Join(root, dir)))
+			}
+			require.NoError(t, fs.Write(ctx, codec, fp.Join(root, "a", "obj.json"), &v1alpha1.Manifest{
+				ObjectMeta: metav1.ObjectMeta{Name: "obj"},
+			}, 0))
+
+			// write to the other directory while in the middle of visiting the first one
+			written := make(chan error, 1)
+			_, err := fs.VisitDir(ctx, fp.Join(root, "a"), newFunc, codec, func(string, runtime.Object) error {
+				go func() {
+					written <- fs.Write(ctx, codec, fp.Join(root, "b", "obj.json"), &v1alpha1.Manifest{
+						ObjectMeta: metav1.ObjectMeta{Name: "obj"},
+					}, 0)
+				}()
+				select {
+				case err := <-written:
+					return err
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("timed out waiting for the write")
+				}
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestFS_ConcurrentWritesGetDistinctVersions(t *testing.T) {
+	for _, fs := range fileSystems() {
+		t.Run(fmt.Sprintf("%T", fs), func(t *testing.T) {
+			ctx := context.Background()
+			scheme := runtime.NewScheme()
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+			newFunc := func() runtime.Object { return &v1alpha1.Manifest{} }
+
+			root := t.TempDir()
+			writers := 4
+			writes := 20
+
+			var mu sync.Mutex
+			seen := map[uint64]bool{}
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				dir := fp.Join(root, fmt.Sprintf("resource-%d", i))
+				require.NoError(t, fs.EnsureDir(ctx, dir))
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					path := fp.Join(dir, "obj.json")
+					obj := &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "obj"}}
+					var last uint64
+					for j := 0; j < writes; j++ {
+						obj.Spec.Message = strconv.Itoa(j)
+						if !assert.NoError(t, fs.Write(ctx, codec, path, obj, last)) {
+							return
+						}
+						rv, err := strconv.ParseUint(obj.ResourceVersion, 10, 64)
+						if !assert.NoError(t, err) {
+							return
+						}
+						assert.Greater(t, rv, last)
+						last = rv
+
+						mu.Lock()
+						assert.False(t, seen[rv], "version %d allocated twice", rv)
+						seen[rv] = true
+						mu.Unlock()
+					}
+				}()
+			}
+
+			// list while writing, which must not fail or deadlock
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			for running := true; running; {
+				select {
+				case <-done:
+					running = false
+				default:
+				}
+				_, err := fs.VisitDir(ctx, root, newFunc, codec, func(string, runtime.Object) error { return nil })
+				require.NoError(t, err)
+			}
+
+			var maxVersion uint64
+			for rv := range seen {
+				if rv > maxVersion {
+					maxVersion = rv
+				}
+			}
+			rev, err := fs.VisitDir(ctx, root, newFunc, codec, func(_ string, obj runtime.Object) error {
+				rv, err := strconv.ParseUint(obj.(*v1alpha1.Manifest).ResourceVersion, 10, 64)
+				if err != nil {
+					return err
+				}
+				assert.LessOrEqual(t, rv, maxVersion)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, maxVersion, rev)
+			assert.Len(t, seen, writers*writes)
+		})
+	}
+}
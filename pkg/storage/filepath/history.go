@@ -0,0 +1,91 @@
+package filepath
+
+import (
+	"sort"
+	"sync"
+)
+
+// RevisionHistory retains the most recent revisions of each object written
+// through a filepathREST, so that they can still be served after they've been
+// overwritten.
+//
+// History is kept in memory, even when the objects themselves are stored on disk.
+// It should be shared between a resource and its subresources, just like a WatchSet.
+type RevisionHistory struct {
+	mu    sync.RWMutex
+	limit int
+	revs  map[string][]revision
+}
+
+type revision struct {
+	version uint64
+	data    []byte
+}
+
+// NewRevisionHistory creates a history that retains the last `limit` revisions
+// of each object (including the current one).
+func NewRevisionHistory(limit int) *RevisionHistory {
+	if limit < 1 {
+		limit = 1
+	}
+	return &RevisionHistory{
+		limit: limit,
+		revs:  make(map[string][]revision),
+	}
+}
+
+// Records an encoded revision of the object at path p.
+func (h *RevisionHistory) record(p string, version uint64, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	revs := h.revs[p]
+	for _, r := range revs {
+		if r.version == version {
+			// identical writes don't bump the version, so there's nothing new to record
+			return
+		}
+	}
+
+	// concurrent writers may record out of order, so keep the list sorted
+	// oldest -> newest
+	revs = append(revs, revision{version: version, data: data})
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].version < revs[j].version
+	})
+	if len(revs) > h.limit {
+		revs = append([]revision(nil), revs[len(revs)-h.limit:]...)
+	}
+	h.revs[p] = revs
+}
+
+// Returns the encoded revision of the object at path p, if it's still retained.
+func (h *RevisionHistory) get(p string, version uint64) ([]byte, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, r := range h.revs[p] {
+		if r.version == version {
+			return r.data, true
+		}
+	}
+	return nil, false
+}
+
+// Returns all retained revisions of the object at path p, newest first.
+func (h *RevisionHistory) list(p string) []revision {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	revs := h.revs[p]
+	result := make([]revision, 0, len(revs))
+	for i := len(revs) - 1; i >= 0; i-- {
+		result = append(result, revs[i])
+	}
+	return result
+}
+
+// Drops all revisions of the object at path p.
+func (h *RevisionHistory) forget(p string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.revs, p)
+}
@@ -0,0 +1,99 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+var _ rest.Getter = &historyREST{}
+
+// NewHistoryStorageProvider serves the read-only "history" subresource,
+// which lists the retained revisions of an object, newest first.
+//
+// parentSP must be a provider created by NewJSONFilepathStorageProvider
+// with the WithRevisionHistory option.
+func NewHistoryStorageProvider(parentSP builderrest.ResourceHandlerProvider) builderrest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (rest.Storage, error) {
+		parent, err := historyParent(scheme, getter, parentSP)
+		if err != nil {
+			return nil, err
+		}
+		return &historyREST{parent: parent}, nil
+	}
+}
+
+func historyParent(scheme *runtime.Scheme, getter generic.RESTOptionsGetter, parentSP builderrest.ResourceHandlerProvider) (*filepathREST, error) {
+	parent, err := filepathParent(scheme, getter, parentSP)
+	if err != nil {
+		return nil, err
+	}
+	if parent.history == nil {
+		return nil, fmt.Errorf("history is not enabled for %s", parent.groupResource)
+	}
+	return parent, nil
+}
+
+// filepathParent returns the storage of the parent of a subresource, which
+// must be filepath storage.
+func filepathParent(scheme *runtime.Scheme, getter generic.RESTOptionsGetter, parentSP builderrest.ResourceHandlerProvider) (*filepathREST, error) {
+	storage, err := parentSP(scheme, getter)
+	if err != nil {
+		return nil, err
+	}
+	parent, ok := storage.(*filepathREST)
+	if !ok {
+		return nil, fmt.Errorf("subresource requires filepath storage, got: %T", storage)
+	}
+	return parent, nil
+}
+
+type historyREST struct {
+	parent *filepathREST
+}
+
+func (h *historyREST) New() runtime.Object {
+	return h.parent.NewList()
+}
+
+func (h *historyREST) Destroy() {
+	// Destroy() is intended for cleaning up client connections. Do nothing.
+}
+
+// Get returns a list of the retained revisions of the object, newest first.
+func (h *historyREST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	f := h.parent
+	revs := f.history.list(f.objectFileName(ctx, name))
+	if len(revs) == 0 {
+		// objects created before history was enabled have no revisions yet,
+		// but still exist
+		if _, err := f.Get(ctx, name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	list := f.NewList()
+	v, err := getListPrt(list)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revs {
+		obj, err := f.decodeRevision(r.data)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		appendItem(v, obj)
+	}
+	if len(revs) != 0 {
+		if err := setResourceVersion(list, revs[0].version); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,166 @@
+package filepath_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestFilepathREST_History(t *testing.T) {
+	f := newHistoryFixture(t, 3)
+	defer f.tearDown()
+
+	f.mustCreate(&v1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-obj"},
+		Spec:       v1alpha1.ManifestSpec{Message: "v2"},
+	})
+	for _, msg := range []string{"v3", "v4", "v5"} {
+		msg := msg
+		f.mustUpdate("test-obj", func(obj runtime.Object) {
+			obj.(*v1alpha1.Manifest).Spec.Message = msg
+		})
+	}
+
+	// only the last 3 revisions are retained
+	list, err := f.subresource(filepath.NewHistoryStorageProvider(f.sp)).(rest.Getter).Get(f.rootCtx, "test-obj", nil)
+	require.NoError(t, err)
+	items := list.(*v1alpha1.ManifestList).Items
+	require.Len(t, items, 3)
+	assert.Equal(t, []string{"v5", "v4", "v3"},
+		[]string{items[0].Spec.Message, items[1].Spec.Message, items[2].Spec.Message})
+	assert.Equal(t, "5", list.(*v1alpha1.ManifestList).ResourceVersion)
+
+	obj, err := f.getter().Get(f.rootCtx, "test-obj", &metav1.GetOptions{ResourceVersion: "4"})
+	require.NoError(t, err)
+	assert.Equal(t, "v4", obj.(*v1alpha1.Manifest).Spec.Message)
+	assert.Equal(t, "4", obj.(*v1alpha1.Manifest).ResourceVersion)
+
+	// revisions that are no longer retained are served the current object
+	obj, err = f.getter().Get(f.rootCtx, "test-obj", &metav1.GetOptions{ResourceVersion: "2"})
+	require.NoError(t, err)
+	assert.Equal(t, "v5", obj.(*v1alpha1.Manifest).Spec.Message)
+
+	// newer resourceVersions are served the current object
+	obj, err = f.getter().Get(f.rootCtx, "test-obj", &metav1.GetOptions{ResourceVersion: "10"})
+	require.NoError(t, err)
+	assert.Equal(t, "v5", obj.(*v1alpha1.Manifest).Spec.Message)
+}
+
+func TestFilepathREST_Rollback(t *testing.T) {
+	f := newHistoryFixture(t, 10)
+	defer f.tearDown()
+
+	f.mustCreate(&v1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-obj"},
+		Spec:       v1alpha1.ManifestSpec{Message: "good"},
+	})
+	f.mustUpdate("test-obj", func(obj runtime.Object) {
+		obj.(*v1alpha1.Manifest).Spec.Message = "bad"
+	})
+
+	rollback := f.subresource(filepath.NewRollbackStorageProvider(f.sp)).(rest.NamedCreater)
+	obj, err := rollback.Create(f.rootCtx, "test-obj", &filepath.RevisionRollback{Revision: "2"}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "good", obj.(*v1alpha1.Manifest).Spec.Message)
+	assert.Equal(t, "4", obj.(*v1alpha1.Manifest).ResourceVersion)
+
+	obj, err = f.get("test-obj")
+	require.NoError(t, err)
+	assert.Equal(t, "good", obj.(*v1alpha1.Manifest).Spec.Message)
+
+	_, err = rollback.Create(f.rootCtx, "test-obj", &filepath.RevisionRollback{Revision: "1"}, nil, nil)
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsResourceExpired(err), err.Error())
+	}
+}
+
+func TestFilepathREST_RollbackAdmitted(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	a := filepath.NewAdmission(scheme)
+	f := newRESTFixtureWithStrategy(t, func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+		return defaultStrategy
+	}, filepath.WithRevisionHistory(filepath.NewRevisionHistory(10)), filepath.WithAdmission(a))
+	defer f.tearDown()
+
+	f.mustCreate(&v1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-obj"},
+		Spec:       v1alpha1.ManifestSpec{Message: "forbidden"},
+	})
+	f.mustUpdate("test-obj", func(obj runtime.Object) {
+		obj.(*v1alpha1.Manifest).Spec.Message = "good"
+	})
+
+	// admission is only set up once the earlier revisions exist
+	a.SetChain(nil, &messageAdmission{Handler: admission.NewHandler(admission.Update)})
+	rollback := f.subresource(filepath.NewRollbackStorageProvider(f.sp)).(rest.NamedCreater)
+	_, err := rollback.Create(f.rootCtx, "test-obj", &filepath.RevisionRollback{Revision: "2"}, nil, nil)
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsForbidden(err), err.Error())
+	}
+
+	obj, err := rollback.Create(f.rootCtx, "test-obj", &filepath.RevisionRollback{Revision: "3"}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "true", obj.(*v1alpha1.Manifest).Labels["admitted"])
+}
+
+func TestFilepathREST_HistoryForgottenOnDelete(t *testing.T) {
+	f := newHistoryFixture(t, 10)
+	defer f.tearDown()
+
+	f.mustCreate(&v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "test-obj"}})
+	_, _, err := f.deleter().Delete(f.rootCtx, "test-obj", nil, nil)
+	require.NoError(t, err)
+
+	_, err = f.subresource(filepath.NewHistoryStorageProvider(f.sp)).(rest.Getter).Get(f.rootCtx, "test-obj", nil)
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsNotFound(err), err.Error())
+	}
+}
+
+// messageAdmission labels the manifests it admits, and forbids forbidden messages.
+type messageAdmission struct {
+	*admission.Handler
+}
+
+func (m *messageAdmission) Admit(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	obj := a.GetObject().(*v1alpha1.Manifest)
+	if obj.Labels == nil {
+		obj.Labels = map[string]string{}
+	}
+	obj.Labels["admitted"] = "true"
+	return nil
+}
+
+func (m *messageAdmission) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if a.GetObject().(*v1alpha1.Manifest).Spec.Message == "forbidden" {
+		return admission.NewForbidden(a, fmt.Errorf("message is forbidden"))
+	}
+	return nil
+}
+
+func newHistoryFixture(t *testing.T, limit int) *restFixture {
+	t.Helper()
+	return newRESTFixtureWithStrategy(t, func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+		return defaultStrategy
+	}, filepath.WithRevisionHistory(filepath.NewRevisionHistory(limit)))
+}
+
+func (r *restFixture) subresource(sp builderrest.ResourceHandlerProvider) rest.Storage {
+	r.t.Helper()
+	storage, err := sp(r.scheme, r.optsGetter)
+	require.NoError(r.t, err)
+	return storage
+}
@@ -34,7 +34,9 @@ import (
 // watchSet: Storage for watchers to be notified of this resource type. Each type should have its own
 //
 //	WatchSet, but subresources (like the status subresource) should share a WatchSet with their parent.
-func NewJSONFilepathStorageProvider(obj resource.Object, rootPath string, fs FS, watchSet *WatchSet, strategy Strategy) builderrest.ResourceHandlerProvider {
+//
+// opts: Optional behavior (like revision history). Subresources should be given the same options as their parent.
+func NewJSONFilepathStorageProvider(obj resource.Object, rootPath string, fs FS, watchSet *WatchSet, strategy Strategy, opts ...RESTOption) builderrest.ResourceHandlerProvider {
 	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (rest.Storage, error) {
 		gr := obj.GetGroupVersionResource().GroupResource()
 		opt, err := getter.GetRESTOptions(gr, obj)
@@ -51,6 +53,7 @@ func NewJSONFilepathStorageProvider(obj resource.Object, rootPath string, fs FS,
 			rootPath,
 			obj.New,
 			obj.NewList,
+			opts...,
 		), nil
 	}
 }
@@ -1,10 +1,10 @@
 package filepath
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 	"reflect"
 	"time"
@@ -18,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
 	"k8s.io/apiserver/pkg/registry/generic/registry"
@@ -37,6 +38,39 @@ var _ rest.Storage = &filepathREST{}
 var _ rest.ShortNamesProvider = &filepathREST{}
 var _ rest.SingularNameProvider = &filepathREST{}
 
+// RESTOption configures optional behavior of the REST storage created by NewFilepathREST.
+type RESTOption func(*filepathREST)
+
+// WithRevisionHistory records each revision written by the REST storage in the
+// given history, so that previous revisions can be read back.
+func WithRevisionHistory(history *RevisionHistory) RESTOption {
+	return func(f *filepathREST) {
+		f.history = history
+	}
+}
+
+// WithChangelog appends a record of each successful mutation to the changelog.
+func WithChangelog(changelog *Changelog) RESTOption {
+	return func(f *filepathREST) {
+		f.changelog = changelog
+	}
+}
+
+// WithStorageCodec encodes the objects that the REST storage writes to the FS
+// with the given codec, e.g. to store them as CBOR, instead of the codec passed
+// to NewFilepathREST. The codec must decode objects stored in any encoding, so
+// that objects written before the encoding changed can still be read. Since the
+// files of the storage are named and listed as .json files, the codec should
+// only encode another media type in a MemoryFS.
+//
+// Changelog records, revisions and transaction results are still encoded with
+// the codec passed to NewFilepathREST, which must encode JSON.
+func WithStorageCodec(codec runtime.Codec) RESTOption {
+	return func(f *filepathREST) {
+		f.storageCodec = codec
+	}
+}
+
 // NewFilepathREST instantiates a new REST storage.
 func NewFilepathREST(
 	fs FS,
@@ -47,16 +81,25 @@ func NewFilepathREST(
 	rootpath string,
 	newFunc func() runtime.Object,
 	newListFunc func() runtime.Object,
+	opts ...RESTOption,
 ) rest.Storage {
 	objRoot := filepath.Join(rootpath, groupResource.Group, groupResource.Resource)
-	if err := fs.EnsureDir(objRoot); err != nil {
+	if err := fs.EnsureDir(context.Background(), objRoot); err != nil {
 		panic(fmt.Sprintf("unable to write data dir: %s", err))
 	}
 
+	// Strategies that can print tables (e.g., the builder's DefaultStrategy)
+	// convert the objects they store.
+	tableConvertor, ok := strategy.(rest.TableConvertor)
+	if !ok {
+		tableConvertor = rest.NewDefaultTableConvertor(groupResource)
+	}
+
 	// file REST
 	rest := &filepathREST{
-		TableConvertor: rest.NewDefaultTableConvertor(groupResource),
+		TableConvertor: tableConvertor,
 		codec:          codec,
+		storageCodec:   codec,
 		objRootPath:    objRoot,
 		newFunc:        newFunc,
 		newListFunc:    newListFunc,
@@ -65,13 +108,17 @@ func NewFilepathREST(
 		fs:             fs,
 		watchSet:       ws,
 	}
+	for _, opt := range opts {
+		opt(rest)
+	}
 	return rest
 }
 
 type filepathREST struct {
 	rest.TableConvertor
-	codec       runtime.Codec
-	objRootPath string
+	codec        runtime.Codec
+	storageCodec runtime.Codec
+	objRootPath  string
 
 	newFunc     func() runtime.Object
 	newListFunc func() runtime.Object
@@ -80,6 +127,10 @@ type filepathREST struct {
 	groupResource schema.GroupResource
 	fs            FS
 	watchSet      *WatchSet
+	history       *RevisionHistory
+	changelog     *Changelog
+	admission     *Admission
+	logStore      *LogStore
 }
 
 func (f *filepathREST) notifyWatchers(ev watch.Event) {
@@ -115,18 +166,34 @@ func (f *filepathREST) Get(
 	name string,
 	options *metav1.GetOptions,
 ) (runtime.Object, error) {
-	obj, err := f.fs.Read(f.codec, f.objectFileName(ctx, name), f.newFunc)
-	if err != nil && os.IsNotExist(err) {
-		return nil, apierrors.NewNotFound(f.groupResource, name)
+	filename := f.objectFileName(ctx, name)
+
+	// GetOptions has no resourceVersionMatch, so when revision history is
+	// enabled, a resourceVersion that names a retained revision is treated as
+	// an exact match. Any other resourceVersion is served the current object,
+	// like the default NotOlderThan semantics.
+	if f.history != nil && options != nil && options.ResourceVersion != "" {
+		version, err := parseResourceVersion(options.ResourceVersion)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid resourceVersion: %s", options.ResourceVersion))
+		}
+		if data, ok := f.history.get(filename, version); ok {
+			return f.decodeRevision(data)
+		}
 	}
-	return obj, err
+
+	obj, err := f.fs.Read(ctx, f.storageCodec, filename, f.newFunc)
+	if err != nil {
+		return nil, interpretFSError(err, f.groupResource, name)
+	}
+	return obj, nil
 }
 
 func (f *filepathREST) List(
 	ctx context.Context,
 	options *metainternalversion.ListOptions,
 ) (runtime.Object, error) {
-	p := newSelectionPredicate(options)
+	p := f.selectionPredicate(options)
 	newListObj := f.NewList()
 	v, err := getListPrt(newListObj)
 	if err != nil {
@@ -134,7 +201,7 @@ func (f *filepathREST) List(
 	}
 
 	dirname := f.objectDirName(ctx)
-	rev, err := f.fs.VisitDir(dirname, f.newFunc, f.codec, func(path string, obj runtime.Object) error {
+	rev, err := f.fs.VisitDir(ctx, dirname, f.newFunc, f.storageCodec, func(path string, obj runtime.Object) error {
 		ok, err := p.Matches(obj)
 		if err != nil {
 			return err
@@ -146,7 +213,7 @@ func (f *filepathREST) List(
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed walking filepath %v: %v", dirname, err)
+		return nil, interpretFSError(fmt.Errorf("failed walking filepath %v: %w", dirname, err), f.groupResource, "")
 	}
 
 	err = setResourceVersion(newListObj, rev)
@@ -183,26 +250,24 @@ func (f *filepathREST) Create(
 		// ensures namespace dir
 		ns, ok := genericapirequest.NamespaceFrom(ctx)
 		if !ok {
-			return nil, ErrNamespaceNotExists
+			return nil, apierrors.NewBadRequest(ErrNamespaceNotExists.Error())
 		}
-		if err := f.fs.EnsureDir(filepath.Join(f.objRootPath, ns)); err != nil {
-			return nil, err
+		if err := f.fs.EnsureDir(ctx, filepath.Join(f.objRootPath, ns)); err != nil {
+			return nil, interpretFSError(err, f.groupResource, accessor.GetName())
 		}
 	}
 
 	filename := f.objectFileName(ctx, accessor.GetName())
 
-	if f.fs.Exists(filename) {
+	if f.fs.Exists(ctx, filename) {
 		return nil, apierrors.NewAlreadyExists(f.groupResource, accessor.GetName())
 	}
 
-	if err := f.fs.Write(f.codec, filename, obj, 0); err != nil {
-		if errors.Is(err, VersionError) {
-			err = f.conflictErr(accessor.GetName())
-		}
-		return nil, err
+	if err := f.write(ctx, filename, obj, 0); err != nil {
+		return nil, interpretFSError(err, f.groupResource, accessor.GetName())
 	}
 
+	f.logChange(ctx, "create", accessor.GetName(), nil, obj)
 	f.notifyWatchers(watch.Event{
 		Type:   watch.Added,
 		Object: obj,
@@ -222,14 +287,19 @@ func (f *filepathREST) Update(
 ) (runtime.Object, bool, error) {
 	var isCreate bool
 	var isDelete bool
+	var oldObj runtime.Object
 	// attempt to update the object, automatically retrying on storage-level conflicts
 	// (see guaranteedUpdate docs for details)
 	obj, err := f.guaranteedUpdate(ctx, name, func(input runtime.Object) (output runtime.Object, err error) {
 		isCreate = false
 		isDelete = false
+		if input != nil && f.changelog != nil {
+			// strategies may modify the input in place, so keep a copy for the changelog
+			oldObj = input.DeepCopyObject()
+		}
 
 		if input == nil {
-			if !forceAllowCreate {
+			if !forceAllowCreate && !f.strategy.AllowCreateOnUpdate() {
 				return nil, apierrors.NewNotFound(f.groupResource, name)
 			}
 			isCreate = true
@@ -244,9 +314,9 @@ func (f *filepathREST) Update(
 			// ensures namespace dir
 			ns, ok := genericapirequest.NamespaceFrom(ctx)
 			if !ok {
-				return nil, ErrNamespaceNotExists
+				return nil, apierrors.NewBadRequest(ErrNamespaceNotExists.Error())
 			}
-			if err := f.fs.EnsureDir(filepath.Join(f.objRootPath, ns)); err != nil {
+			if err := f.fs.EnsureDir(ctx, filepath.Join(f.objRootPath, ns)); err != nil {
 				return nil, err
 			}
 		}
@@ -267,19 +337,27 @@ func (f *filepathREST) Update(
 			return nil, f.conflictErr(name)
 		}
 
-		if err := rest.BeforeUpdate(f.strategy, ctx, output, input); err != nil {
-			return nil, err
-		}
-
 		if isCreate {
+			outputMeta, err := meta.Accessor(output)
+			if err != nil {
+				return nil, err
+			}
+			rest.FillObjectMetaSystemFields(outputMeta)
+			if err := rest.BeforeCreate(f.strategy, ctx, output); err != nil {
+				return nil, err
+			}
 			if createValidation != nil {
-				if err := createValidation(ctx, input); err != nil {
+				if err := createValidation(ctx, output); err != nil {
 					return nil, err
 				}
 			}
 			return output, nil
 		}
 
+		if err := rest.BeforeUpdate(f.strategy, ctx, output, input); err != nil {
+			return nil, err
+		}
+
 		if updateValidation != nil {
 			if err := updateValidation(ctx, output, input); err != nil {
 				return nil, err
@@ -305,17 +383,11 @@ func (f *filepathREST) Update(
 		return output, nil
 	})
 	if err != nil {
-		// TODO(milas): we need a better way of handling standard errors and
-		// 	wrapping any others in generic apierrors - returning plain Go errors
-		// 	(which still happens in some code paths) makes apiserver log out
-		// 	warnings, though it doesn't actually break things so is not critical
-		if os.IsNotExist(err) {
-			return nil, false, apierrors.NewNotFound(f.groupResource, name)
-		}
 		return nil, false, err
 	}
 
 	if isCreate {
+		f.logChange(ctx, "create", name, nil, obj)
 		f.notifyWatchers(watch.Event{
 			Type:   watch.Added,
 			Object: obj,
@@ -323,14 +395,14 @@ func (f *filepathREST) Update(
 		return obj, true, nil
 	}
 
+	f.logChange(ctx, "update", name, oldObj, obj)
+
 	if isDelete {
 		filename := f.objectFileName(ctx, name)
-		if err := f.fs.Remove(filename); err != nil {
-			if os.IsNotExist(err) {
-				return nil, false, apierrors.NewNotFound(f.groupResource, name)
-			}
-			return nil, false, err
+		if err := f.remove(ctx, filename, obj); err != nil {
+			return nil, false, interpretFSError(err, f.groupResource, name)
 		}
+		f.logChange(ctx, "delete", name, obj, nil)
 		f.notifyWatchers(watch.Event{
 			Type:   watch.Deleted,
 			Object: obj,
@@ -367,6 +439,12 @@ func (f *filepathREST) Delete(
 	}
 	// loosely adapted from https://github.com/kubernetes/apiserver/blob/947ebe755ed8aed2e0f0f5d6420caad07fc04cc2/pkg/registry/generic/registry/store.go#L854-L877
 	if len(objMeta.GetFinalizers()) != 0 {
+		// oldObj is modified in place, so keep a copy for the changelog
+		var storedObj runtime.Object
+		if f.changelog != nil {
+			storedObj = oldObj.DeepCopyObject()
+		}
+
 		now := metav1.NewTime(time.Now())
 		// per-contract, deletion timestamps can not be unset and can only be moved _earlier_
 		if objMeta.GetDeletionTimestamp() == nil || now.Before(objMeta.GetDeletionTimestamp()) {
@@ -380,12 +458,10 @@ func (f *filepathREST) Delete(
 			return nil, false, err
 		}
 
-		if err := f.fs.Write(f.codec, filename, oldObj, version); err != nil {
-			if errors.Is(err, VersionError) {
-				err = f.conflictErr(name)
-			}
-			return nil, false, err
+		if err := f.write(ctx, filename, oldObj, version); err != nil {
+			return nil, false, interpretFSError(err, f.groupResource, name)
 		}
+		f.logChange(ctx, "delete", name, storedObj, oldObj)
 
 		f.notifyWatchers(watch.Event{
 			Type:   watch.Modified,
@@ -396,12 +472,10 @@ func (f *filepathREST) Delete(
 		return oldObj, false, nil
 	}
 
-	if err := f.fs.Remove(filename); err != nil {
-		if err != nil && os.IsNotExist(err) {
-			return nil, false, apierrors.NewNotFound(f.groupResource, name)
-		}
-		return nil, false, err
+	if err := f.remove(ctx, filename, oldObj); err != nil {
+		return nil, false, interpretFSError(err, f.groupResource, name)
 	}
+	f.logChange(ctx, "delete", name, oldObj, nil)
 	f.notifyWatchers(watch.Event{
 		Type:   watch.Deleted,
 		Object: oldObj,
@@ -415,26 +489,28 @@ func (f *filepathREST) DeleteCollection(
 	options *metav1.DeleteOptions,
 	listOptions *metainternalversion.ListOptions,
 ) (runtime.Object, error) {
-	p := newSelectionPredicate(listOptions)
+	p := f.selectionPredicate(listOptions)
 	newListObj := f.NewList()
 	v, err := getListPrt(newListObj)
 	if err != nil {
 		return nil, err
 	}
 	dirname := f.objectDirName(ctx)
-	rev, err := f.fs.VisitDir(dirname, f.newFunc, f.codec, func(path string, obj runtime.Object) error {
+	rev, err := f.fs.VisitDir(ctx, dirname, f.newFunc, f.storageCodec, func(path string, obj runtime.Object) error {
 		ok, err := p.Matches(obj)
 		if err != nil {
 			return err
 		}
 		if ok {
-			_ = f.fs.Remove(path)
+			if err := f.remove(ctx, path, obj); err == nil {
+				f.logChange(ctx, "delete", objectName(obj), obj, nil)
+			}
 			appendItem(v, obj)
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed walking filepath %v: %v", dirname, err)
+		return nil, interpretFSError(fmt.Errorf("failed walking filepath %v: %w", dirname, err), f.groupResource, "")
 	}
 
 	err = setResourceVersion(newListObj, rev)
@@ -444,6 +520,99 @@ func (f *filepathREST) DeleteCollection(
 	return newListObj, nil
 }
 
+// write persists the object to the FS and records the new revision in the history.
+func (f *filepathREST) write(ctx context.Context, filename string, obj runtime.Object, storageVersion uint64) error {
+	if err := f.fs.Write(ctx, f.storageCodec, filename, obj, storageVersion); err != nil {
+		return err
+	}
+	return f.recordRevision(filename, obj)
+}
+
+// recordRevision records a written object in the history, if enabled.
+func (f *filepathREST) recordRevision(filename string, obj runtime.Object) error {
+	if f.history == nil {
+		return nil
+	}
+
+	version, err := getResourceVersion(obj)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := f.codec.Encode(obj, buf); err != nil {
+		return err
+	}
+	f.history.record(filename, version, buf.Bytes())
+	return nil
+}
+
+// remove deletes the object from the FS and drops its history and log.
+func (f *filepathREST) remove(ctx context.Context, filename string, obj runtime.Object) error {
+	if err := f.fs.Remove(ctx, filename); err != nil {
+		return err
+	}
+	f.forget(filename, obj)
+	return nil
+}
+
+// forget drops the history and log of a removed object, if enabled.
+func (f *filepathREST) forget(filename string, obj runtime.Object) {
+	if f.history != nil {
+		f.history.forget(filename)
+	}
+	if f.logStore != nil {
+		f.removeLog(obj)
+	}
+}
+
+// logChange appends a record of a successful mutation to the changelog.
+//
+// The change has already been persisted by the time it's logged, so failures
+// are reported but don't fail the request.
+func (f *filepathREST) logChange(ctx context.Context, verb, name string, oldObj, newObj runtime.Object) {
+	if f.changelog == nil {
+		return
+	}
+	if oldObj != nil && newObj != nil {
+		oldVersion, _ := getResourceVersion(oldObj)
+		newVersion, _ := getResourceVersion(newObj)
+		if oldVersion == newVersion {
+			// identical writes are skipped by the FS
+			return
+		}
+	}
+
+	r, err := newChangeRecord(ctx, f.codec, verb, oldObj, newObj)
+	if err == nil {
+		r.Group = f.groupResource.Group
+		r.Resource = f.groupResource.Resource
+		r.Name = name
+		if f.NamespaceScoped() {
+			r.Namespace, _ = genericapirequest.NamespaceFrom(ctx)
+		}
+		err = f.changelog.Append(r)
+	}
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("writing changelog for %s %q: %v", f.groupResource, name, err))
+	}
+}
+
+func objectName(obj runtime.Object) string {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return objMeta.GetName()
+}
+
+func (f *filepathREST) decodeRevision(data []byte) (runtime.Object, error) {
+	obj, _, err := f.codec.Decode(data, nil, f.newFunc())
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
 func (f *filepathREST) objectFileName(ctx context.Context, name string) string {
 	if f.NamespaceScoped() {
 		// FIXME: return error if namespace is not found
@@ -476,6 +645,8 @@ type updateFunc func(input runtime.Object) (output runtime.Object, err error)
 // account the current contents of the object when deciding how the update object
 // should look.
 //
+// Errors are returned as API errors.
+//
 // The "guaranteed" in the name comes from a method of the same name in the
 // Kubernetes apiserver/etcd code. Most of this method comment is copied from
 // its godoc.
@@ -491,13 +662,8 @@ func (f *filepathREST) guaranteedUpdate(ctx context.Context, name string, tryUpd
 	//   until it times out is not great either
 	const maxAttempts = 100
 	for i := 0; i < maxAttempts; i++ {
-		if err := ctx.Err(); err != nil {
-			// the FS layer doesn't use context, so we explicitly check it on
-			// each loop iteration so that we'll stop retrying if the context
-			// gets canceled (e.g. request timeout)
-			return nil, err
-		}
-
+		// the FS returns the context's error, so this stops retrying once the
+		// context is canceled (e.g. request timeout)
 		storageObj, err := f.Get(ctx, name, nil)
 		if err != nil && !apierrors.IsNotFound(err) {
 			// some objects allow create-on-update semantics, so NotFound is not terminal
@@ -510,17 +676,16 @@ func (f *filepathREST) guaranteedUpdate(ctx context.Context, name string, tryUpd
 
 		out, err := tryUpdate(storageObj)
 		if err != nil {
-			// TODO(milas): check error type and wrap if necessary
-			return nil, err
+			return nil, interpretFSError(err, f.groupResource, name)
 		}
 
 		filename := f.objectFileName(ctx, name)
-		if err := f.fs.Write(f.codec, filename, out, storageVersion); err != nil {
-			if errors.Is(err, VersionError) {
-				// storage conflict, retry
+		if err := f.write(ctx, filename, out, storageVersion); err != nil {
+			if IsConflict(err) || IsAlreadyExists(err) {
+				// storage conflict, or created concurrently; retry
 				continue
 			}
-			return nil, err
+			return nil, interpretFSError(err, f.groupResource, name)
 		}
 		return out, nil
 	}
@@ -546,7 +711,7 @@ func getListPrt(listObj runtime.Object) (reflect.Value, error) {
 }
 
 func (f *filepathREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
-	p := newSelectionPredicate(options)
+	p := f.selectionPredicate(options)
 	jw := f.watchSet.newWatch()
 
 	getInitEvents := func() ([]watch.Event, error) {
@@ -586,25 +751,36 @@ func (f *filepathREST) Watch(ctx context.Context, options *metainternalversion.L
 }
 
 func (f *filepathREST) conflictErr(name string) error {
+	return newConflictErr(f.groupResource, name)
+}
+
+func newConflictErr(gr schema.GroupResource, name string) error {
 	return apierrors.NewConflict(
-		f.groupResource,
+		gr,
 		name,
 		errors.New(registry.OptimisticLockErrorMsg))
 }
 
-func newSelectionPredicate(options *metainternalversion.ListOptions) storage.SelectionPredicate {
-	p := storage.SelectionPredicate{
-		Label:    labels.Everything(),
-		Field:    fields.Everything(),
-		GetAttrs: storage.DefaultClusterScopedAttr,
-	}
+// selectionPredicate returns the predicate that filters the objects of list
+// and watch requests, using the strategy's Match if it has one.
+func (f *filepathREST) selectionPredicate(options *metainternalversion.ListOptions) storage.SelectionPredicate {
+	label := labels.Everything()
+	field := fields.Everything()
 	if options != nil {
 		if options.LabelSelector != nil {
-			p.Label = options.LabelSelector
+			label = options.LabelSelector
 		}
 		if options.FieldSelector != nil {
-			p.Field = options.FieldSelector
+			field = options.FieldSelector
 		}
 	}
-	return p
+
+	if m, ok := f.strategy.(Matcher); ok {
+		return m.Match(label, field)
+	}
+	return storage.SelectionPredicate{
+		Label:    label,
+		Field:    field,
+		GetAttrs: storage.DefaultClusterScopedAttr,
+	}
 }
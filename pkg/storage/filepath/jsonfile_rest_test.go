@@ -551,6 +551,10 @@ type restFixture struct {
 	rest    rest.Storage
 	rootCtx context.Context
 	cancel  context.CancelFunc
+
+	sp         builderrest.ResourceHandlerProvider
+	scheme     *runtime.Scheme
+	optsGetter generic.RESTOptionsGetter
 }
 
 func newRESTFixture(t *testing.T) *restFixture {
@@ -561,10 +565,18 @@ func newRESTFixture(t *testing.T) *restFixture {
 }
 
 func newRESTFixtureWithStrategy(t *testing.T,
-	strategyFn func(defaultStrategy builderrest.Strategy) builderrest.Strategy) *restFixture {
+	strategyFn func(defaultStrategy builderrest.Strategy) builderrest.Strategy,
+	opts ...filepath.RESTOption) *restFixture {
+	t.Helper()
+	return newRESTFixtureWithFS(t, filepath.NewMemoryFS(), strategyFn, opts...)
+}
+
+func newRESTFixtureWithFS(t *testing.T,
+	fs filepath.FS,
+	strategyFn func(defaultStrategy builderrest.Strategy) builderrest.Strategy,
+	opts ...filepath.RESTOption) *restFixture {
 	t.Helper()
 
-	fs := filepath.NewMemoryFS()
 	ws := filepath.NewWatchSet()
 
 	dir, err := ioutil.TempDir("", strings.Replace(t.Name(), "/", "_", -1))
@@ -582,21 +594,25 @@ func newRESTFixtureWithStrategy(t *testing.T,
 		dir,
 		fs,
 		ws,
-		strategyFn(defaultStrategy))
+		strategyFn(defaultStrategy),
+		opts...)
 
 	codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
-	opts := &restOptionsGetter{codec: codec}
+	optsGetter := &restOptionsGetter{codec: codec}
 
 	rootCtx, cancel := context.WithCancel(context.Background())
 	rootCtx = genericapirequest.WithNamespace(rootCtx, metav1.NamespaceNone)
 
-	storage, err := sp(scheme, opts)
+	storage, err := sp(scheme, optsGetter)
 	require.NoError(t, err, "Failed to create storage provider for test setup")
 	return &restFixture{
-		t:       t,
-		rootCtx: rootCtx,
-		cancel:  cancel,
-		rest:    storage,
+		t:          t,
+		rootCtx:    rootCtx,
+		cancel:     cancel,
+		rest:       storage,
+		sp:         sp,
+		scheme:     scheme,
+		optsGetter: optsGetter,
 	}
 }
 
@@ -0,0 +1,319 @@
+package filepath
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLogMaxBytes is the size of each object's log kept by a LogStore,
+// unless another size is given.
+const DefaultLogMaxBytes = 1 << 20
+
+// LogStore stores the logs of the objects of one resource.
+//
+// Logs are append-only. Each line is timestamped when it's appended, and the
+// log of each object is capped at a maximum size; once it's full, the oldest
+// lines are dropped.
+//
+// The log of an object is removed when the object is deleted through storage
+// created WithLogStore, which the builder's WithLogSubResource sets up. Logs of
+// objects deleted otherwise, e.g. while the server wasn't running, should be
+// removed with Remove.
+type LogStore struct {
+	// dir is where logs are written, or empty if they're only kept in memory.
+	dir      string
+	maxBytes int
+	now      func() time.Time
+
+	mu     sync.Mutex
+	logs   map[string]*objectLog
+	nextID uint64
+}
+
+// NewMemoryLogStore keeps logs in memory, up to maxBytes per object.
+//
+// If maxBytes isn't positive, DefaultLogMaxBytes is used.
+func NewMemoryLogStore(maxBytes int) *LogStore {
+	return newLogStore("", maxBytes)
+}
+
+// NewFileLogStore keeps logs in memory, up to maxBytes per object, and also
+// writes them to files under dir, so that they're kept across restarts.
+//
+// If maxBytes isn't positive, DefaultLogMaxBytes is used.
+func NewFileLogStore(dir string, maxBytes int) *LogStore {
+	return newLogStore(dir, maxBytes)
+}
+
+func newLogStore(dir string, maxBytes int) *LogStore {
+	if maxBytes <= 0 {
+		maxBytes = DefaultLogMaxBytes
+	}
+	return &LogStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		now:      time.Now,
+		logs:     make(map[string]*objectLog),
+	}
+}
+
+type logLine struct {
+	// seq numbers the lines of a log in the order they were appended.
+	seq  uint64
+	time time.Time
+	// text includes the trailing newline.
+	text string
+}
+
+type objectLog struct {
+	// id tells apart the logs of objects with the same name, e.g. when a log
+	// was removed and appended to again.
+	id      uint64
+	lines   []logLine
+	size    int
+	nextSeq uint64
+
+	// fileSize is the size of the log's file, which grows until it's compacted.
+	fileSize int
+
+	// changed is closed when lines are appended or the log is removed.
+	changed chan struct{}
+}
+
+// Append appends data to the log of the named object.
+//
+// Data is split into lines, and a newline is added to the last line if it
+// doesn't have one. Lines longer than the maximum size of the log are
+// truncated.
+func (s *LogStore) Append(namespace, name string, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log, err := s.load(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	added := []logLine{}
+	for _, text := range splitLogLines(data) {
+		if len(text) > s.maxBytes {
+			text = text[:s.maxBytes-1] + "\n"
+		}
+		added = append(added, logLine{seq: log.nextSeq, time: now, text: text})
+		log.nextSeq++
+	}
+
+	if s.dir != "" {
+		if err := s.appendFile(namespace, name, log, added); err != nil {
+			return err
+		}
+	}
+
+	log.lines = append(log.lines, added...)
+	for _, line := range added {
+		log.size += len(line.text)
+	}
+	s.trim(log)
+
+	close(log.changed)
+	log.changed = make(chan struct{})
+	return nil
+}
+
+// Remove removes the log of the named object.
+//
+// Clients following the log stop.
+func (s *LogStore) Remove(namespace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := logKey(namespace, name)
+	if log, ok := s.logs[key]; ok {
+		close(log.changed)
+		delete(s.logs, key)
+	}
+	if s.dir == "" {
+		return nil
+	}
+	path := s.path(namespace, name)
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return fileError(path, err)
+}
+
+// read returns the lines of the named object's log from the given sequence
+// number on, the id of the log, and a channel that's closed when the log
+// changes.
+func (s *LogStore) read(namespace, name string, from uint64) ([]logLine, uint64, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log, err := s.load(namespace, name)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	lines := []logLine{}
+	for _, line := range log.lines {
+		if line.seq >= from {
+			lines = append(lines, line)
+		}
+	}
+	return lines, log.id, log.changed, nil
+}
+
+// load returns the log of the named object, reading it from its file the
+// first time.
+//
+// Must be called with the lock held.
+func (s *LogStore) load(namespace, name string) (*objectLog, error) {
+	key := logKey(namespace, name)
+	if log, ok := s.logs[key]; ok {
+		return log, nil
+	}
+
+	s.nextID++
+	log := &objectLog{id: s.nextID, changed: make(chan struct{})}
+	if s.dir != "" {
+		path := s.path(namespace, name)
+		content, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fileError(path, err)
+		}
+		log.lines = decodeLogLines(content)
+		log.fileSize = len(content)
+		for i := range log.lines {
+			log.lines[i].seq = log.nextSeq
+			log.nextSeq++
+			log.size += len(log.lines[i].text)
+		}
+		s.trim(log)
+	}
+	s.logs[key] = log
+	return log, nil
+}
+
+// trim drops the oldest lines of the log until it fits in the maximum size.
+func (s *LogStore) trim(log *objectLog) {
+	drop := 0
+	for log.size > s.maxBytes && drop < len(log.lines) {
+		log.size -= len(log.lines[drop].text)
+		drop++
+	}
+	if drop > 0 {
+		log.lines = append([]logLine(nil), log.lines[drop:]...)
+	}
+}
+
+// appendFile appends lines to the log's file.
+//
+// The file keeps the dropped lines until it's twice the maximum size of the
+// log, and is then replaced by one with only the retained lines.
+func (s *LogStore) appendFile(namespace, name string, log *objectLog, added []logLine) error {
+	path := s.path(namespace, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fileError(path, err)
+	}
+
+	data := encodeLogLines(added)
+	if log.fileSize+len(data) > 2*s.maxBytes {
+		retained := &objectLog{lines: append(append([]logLine(nil), log.lines...), added...), size: log.size}
+		for _, line := range added {
+			retained.size += len(line.text)
+		}
+		s.trim(retained)
+		content := encodeLogLines(retained.lines)
+		if err := writeFileAtomic(path, content); err != nil {
+			return fileError(path, err)
+		}
+		log.fileSize = len(content)
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fileError(path, err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fileError(path, err)
+	}
+	log.fileSize += len(data)
+	return nil
+}
+
+func (s *LogStore) path(namespace, name string) string {
+	return filepath.Join(s.dir, namespace, name+".log")
+}
+
+func logKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// splitLogLines splits data into lines that end in a newline.
+func splitLogLines(data []byte) []string {
+	lines := []string{}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data)+"\n")
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// encodeLogLines encodes lines in the format of log files, where each line
+// starts with its RFC 3339 timestamp and a space.
+func encodeLogLines(lines []logLine) []byte {
+	buf := bytes.Buffer{}
+	for _, line := range lines {
+		buf.WriteString(line.time.UTC().Format(time.RFC3339Nano))
+		buf.WriteByte(' ')
+		buf.WriteString(line.text)
+	}
+	return buf.Bytes()
+}
+
+// decodeLogLines decodes the lines of a log file.
+//
+// Lines without a valid timestamp, e.g. a line cut short by a crash, are
+// skipped.
+func decodeLogLines(content []byte) []logLine {
+	lines := []logLine{}
+	reader := bufio.NewReader(bytes.NewReader(content))
+	for {
+		raw, err := reader.ReadString('\n')
+		if err != nil {
+			// a line without a newline was not completely written
+			break
+		}
+		i := strings.IndexByte(raw, ' ')
+		if i < 0 {
+			continue
+		}
+		t, parseErr := time.Parse(time.RFC3339Nano, raw[:i])
+		if parseErr != nil {
+			continue
+		}
+		lines = append(lines, logLine{time: t, text: raw[i+1:]})
+	}
+	return lines
+}
@@ -0,0 +1,316 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// LogOptions are the query parameters of requests for the "log" subresource.
+//
+// They mean the same as the options of `kubectl logs`.
+//
+// +k8s:deepcopy-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type LogOptions struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Follow streams the log until the request is canceled, or the log is removed.
+	Follow bool `json:"follow,omitempty"`
+
+	// SinceSeconds only returns lines appended in the last SinceSeconds seconds.
+	SinceSeconds *int64 `json:"sinceSeconds,omitempty"`
+
+	// SinceTime only returns lines appended at or after SinceTime.
+	SinceTime *metav1.Time `json:"sinceTime,omitempty"`
+
+	// Timestamps prefixes each line with the RFC 3339 time it was appended.
+	Timestamps bool `json:"timestamps,omitempty"`
+
+	// TailLines only returns the last TailLines lines of the log.
+	TailLines *int64 `json:"tailLines,omitempty"`
+
+	// LimitBytes stops the response after LimitBytes bytes.
+	LimitBytes *int64 `json:"limitBytes,omitempty"`
+}
+
+// AddLogOptionsToScheme returns a function that registers LogOptions in the
+// group version, and how to decode them from query parameters.
+func AddLogOptionsToScheme(gv schema.GroupVersion) func(*runtime.Scheme) error {
+	return func(s *runtime.Scheme) error {
+		s.AddKnownTypes(gv, &LogOptions{})
+		return s.AddConversionFunc((*url.Values)(nil), (*LogOptions)(nil), func(a, b interface{}, scope conversion.Scope) error {
+			return convertURLValuesToLogOptions(a.(*url.Values), b.(*LogOptions))
+		})
+	}
+}
+
+func convertURLValuesToLogOptions(in *url.Values, out *LogOptions) error {
+	var err error
+	parseBool := func(key string) bool {
+		v := in.Get(key)
+		if v == "" || err != nil {
+			return false
+		}
+		b, parseErr := strconv.ParseBool(v)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid %s: %q", key, v)
+		}
+		return b
+	}
+	parseInt := func(key string) *int64 {
+		v := in.Get(key)
+		if v == "" || err != nil {
+			return nil
+		}
+		i, parseErr := strconv.ParseInt(v, 10, 64)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid %s: %q", key, v)
+			return nil
+		}
+		return &i
+	}
+
+	out.Follow = parseBool("follow")
+	out.Timestamps = parseBool("timestamps")
+	out.SinceSeconds = parseInt("sinceSeconds")
+	out.TailLines = parseInt("tailLines")
+	out.LimitBytes = parseInt("limitBytes")
+	if v := in.Get("sinceTime"); v != "" && err == nil {
+		t, parseErr := time.Parse(time.RFC3339, v)
+		if parseErr != nil {
+			return fmt.Errorf("invalid sinceTime: %q", v)
+		}
+		out.SinceTime = &metav1.Time{Time: t}
+	}
+	return err
+}
+
+// validateLogOptions returns an error for options that `kubectl logs` would reject.
+func validateLogOptions(opts *LogOptions) error {
+	if opts.SinceSeconds != nil && opts.SinceTime != nil {
+		return fmt.Errorf("at most one of sinceSeconds and sinceTime may be set")
+	}
+	if opts.SinceSeconds != nil && *opts.SinceSeconds < 1 {
+		return fmt.Errorf("sinceSeconds must be greater than 0")
+	}
+	if opts.TailLines != nil && *opts.TailLines < 0 {
+		return fmt.Errorf("tailLines must be greater than or equal to 0")
+	}
+	if opts.LimitBytes != nil && *opts.LimitBytes < 1 {
+		return fmt.Errorf("limitBytes must be greater than 0")
+	}
+	return nil
+}
+
+// WithLogStore removes the logs of the objects of the resource from the store
+// when they're deleted.
+func WithLogStore(store *LogStore) RESTOption {
+	return func(f *filepathREST) {
+		f.logStore = store
+	}
+}
+
+// removeLog removes the log of the deleted object.
+func (f *filepathREST) removeLog(obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if err := f.logStore.Remove(accessor.GetNamespace(), accessor.GetName()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("removing the log of %s %q: %v", f.groupResource, accessor.GetName(), err))
+	}
+}
+
+var _ rest.Connecter = &logREST{}
+
+// NewLogStorageProvider serves the "log" subresource from the store.
+//
+// GET returns the log of the parent object, as plain text. POST appends the
+// request body to it, so that controllers can write logs through the API.
+// Requests for objects that don't exist fail with NotFound.
+//
+// parentSP must be a provider created by NewJSONFilepathStorageProvider.
+func NewLogStorageProvider(parentSP builderrest.ResourceHandlerProvider, store *LogStore) builderrest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (rest.Storage, error) {
+		parent, err := filepathParent(scheme, getter, parentSP)
+		if err != nil {
+			return nil, err
+		}
+		return &logREST{parent: parent, store: store}, nil
+	}
+}
+
+type logREST struct {
+	parent *filepathREST
+	store  *LogStore
+}
+
+// New returns the parent, like the "log" subresource of pods, since the log
+// itself is plain text.
+func (r *logREST) New() runtime.Object {
+	return r.parent.New()
+}
+
+func (r *logREST) Destroy() {
+	// Destroy() is intended for cleaning up client connections. Do nothing.
+}
+
+func (r *logREST) NewConnectOptions() (runtime.Object, bool, string) {
+	return &LogOptions{}, false, ""
+}
+
+func (r *logREST) ConnectMethods() []string {
+	return []string{http.MethodGet, http.MethodPost}
+}
+
+func (r *logREST) Connect(ctx context.Context, name string, options runtime.Object, responder rest.Responder) (http.Handler, error) {
+	opts, ok := options.(*LogOptions)
+	if !ok {
+		return nil, apierrors.NewInternalError(fmt.Errorf("unexpected options: %T", options))
+	}
+	if err := validateLogOptions(opts); err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	if _, err := r.parent.Get(ctx, name, &metav1.GetOptions{}); err != nil {
+		return nil, err
+	}
+
+	namespace := genericapirequest.NamespaceValue(ctx)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			r.appendBody(namespace, name, req, responder)
+			return
+		}
+		r.stream(ctx, namespace, name, opts, w, responder)
+	}), nil
+}
+
+// appendBody appends the request body to the log.
+func (r *logREST) appendBody(namespace, name string, req *http.Request, responder rest.Responder) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, int64(r.store.maxBytes)+1))
+	if err != nil {
+		responder.Error(apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	if len(data) > r.store.maxBytes {
+		responder.Error(apierrors.NewRequestEntityTooLargeError(
+			fmt.Sprintf("limit is %d bytes", r.store.maxBytes)))
+		return
+	}
+	if err := r.store.Append(namespace, name, data); err != nil {
+		responder.Error(interpretFSError(err, r.parent.groupResource, name))
+		return
+	}
+	responder.Object(http.StatusOK, &metav1.Status{Status: metav1.StatusSuccess})
+}
+
+// stream writes the log, and then the lines appended to it if following.
+func (r *logREST) stream(ctx context.Context, namespace, name string, opts *LogOptions, w http.ResponseWriter, responder rest.Responder) {
+	all, id, changed, err := r.store.read(namespace, name, 0)
+	if err != nil {
+		responder.Error(interpretFSError(err, r.parent.groupResource, name))
+		return
+	}
+	next := uint64(0)
+	if len(all) > 0 {
+		next = all[len(all)-1].seq + 1
+	}
+
+	var since time.Time
+	if opts.SinceTime != nil {
+		since = opts.SinceTime.Time
+	} else if opts.SinceSeconds != nil {
+		since = r.store.now().Add(-time.Duration(*opts.SinceSeconds) * time.Second)
+	}
+	lines := []logLine{}
+	for _, line := range all {
+		if !line.time.Before(since) {
+			lines = append(lines, line)
+		}
+	}
+	if opts.TailLines != nil && int64(len(lines)) > *opts.TailLines {
+		lines = lines[int64(len(lines))-*opts.TailLines:]
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	out := &logWriter{w: w, timestamps: opts.Timestamps, limit: -1}
+	if opts.LimitBytes != nil {
+		out.limit = *opts.LimitBytes
+	}
+
+	for {
+		for _, line := range lines {
+			if !out.write(line) {
+				return
+			}
+		}
+		if !opts.Follow {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+
+		var nextID uint64
+		lines, nextID, changed, err = r.store.read(namespace, name, next)
+		if err != nil || nextID != id {
+			// the log was removed
+			return
+		}
+		if len(lines) > 0 {
+			next = lines[len(lines)-1].seq + 1
+		}
+	}
+}
+
+// logWriter writes lines to the response of a log request, up to a limit.
+type logWriter struct {
+	w          http.ResponseWriter
+	timestamps bool
+	// limit is the number of bytes that can still be written, or -1 for no limit.
+	limit int64
+}
+
+// write writes the line, and returns whether more lines can be written.
+func (l *logWriter) write(line logLine) bool {
+	text := line.text
+	if l.timestamps {
+		text = line.time.UTC().Format(time.RFC3339Nano) + " " + text
+	}
+	if l.limit >= 0 && int64(len(text)) > l.limit {
+		text = text[:l.limit]
+	}
+	if _, err := io.WriteString(l.w, text); err != nil {
+		return false
+	}
+	if flusher, ok := l.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	if l.limit >= 0 {
+		l.limit -= int64(len(text))
+		return l.limit > 0
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package filepath_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestLogStore_Options(t *testing.T) {
+	f := newRESTFixture(t)
+	defer f.tearDown()
+	f.mustCreate(&v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "test-obj"}})
+
+	store := filepath.NewMemoryLogStore(0)
+	require.NoError(t, store.Append("", "test-obj", []byte("one\ntwo\n")))
+	require.NoError(t, store.Append("", "test-obj", []byte("three")))
+
+	out, err := f.readLog(store, "test-obj", &filepath.LogOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nthree\n", out)
+
+	tail := int64(2)
+	out, err = f.readLog(store, "test-obj", &filepath.LogOptions{TailLines: &tail})
+	require.NoError(t, err)
+	assert.Equal(t, "two\nthree\n", out)
+
+	limit := int64(5)
+	out, err = f.readLog(store, "test-obj", &filepath.LogOptions{LimitBytes: &limit})
+	require.NoError(t, err)
+	assert.Equal(t, "one\nt", out)
+
+	out, err = f.readLog(store, "test-obj", &filepath.LogOptions{Timestamps: true, TailLines: &tail})
+	require.NoError(t, err)
+	assert.Regexp(t, `^\d{4}-\d\d-\d\dT\S+Z two\n\S+ three\n$`, out)
+
+	future := metav1.NewTime(metav1.Now().Add(3600e9))
+	out, err = f.readLog(store, "test-obj", &filepath.LogOptions{SinceTime: &future})
+	require.NoError(t, err)
+	assert.Equal(t, "", out)
+
+	negative := int64(-1)
+	_, err = f.readLog(store, "test-obj", &filepath.LogOptions{TailLines: &negative})
+	assert.True(t, apierrors.IsBadRequest(err), "expected bad request, got %v", err)
+
+	_, err = f.readLog(store, "missing-obj", &filepath.LogOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "expected not found, got %v", err)
+}
+
+func TestLogStore_MaxBytes(t *testing.T) {
+	f := newRESTFixture(t)
+	defer f.tearDown()
+	f.mustCreate(&v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "test-obj"}})
+
+	// the oldest lines are dropped to keep the log under 10 bytes
+	store := filepath.NewMemoryLogStore(10)
+	require.NoError(t, store.Append("", "test-obj", []byte("aaaa\nbbbb\ncccc\n")))
+	out, err := f.readLog(store, "test-obj", &filepath.LogOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "bbbb\ncccc\n", out)
+
+	// lines longer than the log are truncated
+	require.NoError(t, store.Append("", "test-obj", []byte("0123456789abcdef\n")))
+	out, err = f.readLog(store, "test-obj", &filepath.LogOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "012345678\n", out)
+}
+
+func TestLogStore_File(t *testing.T) {
+	f := newRESTFixture(t)
+	defer f.tearDown()
+	f.mustCreate(&v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "test-obj"}})
+
+	dir, err := ioutil.TempDir("", "TestLogStore_File")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := filepath.NewFileLogStore(dir, 10)
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n"} {
+		require.NoError(t, store.Append("", "test-obj", []byte(line)))
+	}
+
+	// the file is compacted once it's much bigger than the log
+	info, err := os.Stat(dir + "/test-obj.log")
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), int64(200))
+
+	// a new store reads the retained lines from the file
+	store = filepath.NewFileLogStore(dir, 10)
+	out, err := f.readLog(store, "test-obj", &filepath.LogOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "eeee\nffff\n", out)
+
+	require.NoError(t, store.Remove("", "test-obj"))
+	_, err = os.Stat(dir + "/test-obj.log")
+	assert.True(t, os.IsNotExist(err))
+	out, err = f.readLog(store, "test-obj", &filepath.LogOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "", out)
+}
+
+// readLog reads the log of the object through the "log" subresource.
+func (r *restFixture) readLog(store *filepath.LogStore, name string, opts *filepath.LogOptions) (string, error) {
+	r.t.Helper()
+	connecter := r.subresource(filepath.NewLogStorageProvider(r.sp, store)).(rest.Connecter)
+	responder := &fakeResponder{}
+	handler, err := connecter.Connect(r.rootCtx, name, opts, responder)
+	if err != nil {
+		return "", err
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w.Body.String(), responder.err
+}
+
+type fakeResponder struct {
+	err error
+}
+
+func (r *fakeResponder) Object(statusCode int, obj runtime.Object) {}
+
+func (r *fakeResponder) Error(err error) {
+	r.err = err
+}
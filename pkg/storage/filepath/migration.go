@@ -0,0 +1,357 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/klog/v2"
+)
+
+// MigrationStatus reports the progress of a storage migration of one resource.
+type MigrationStatus struct {
+	Group    string `json:"group,omitempty"`
+	Resource string `json:"resource"`
+
+	// StorageVersion is the version that objects are migrated to.
+	StorageVersion string `json:"storageVersion"`
+
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Total is the number of stored objects found, and Pending the number of
+	// those not yet processed.
+	Total   int `json:"total"`
+	Pending int `json:"pending"`
+
+	// Migrated objects were rewritten. UpToDate objects were already stored in
+	// the storage version, or were deleted before they could be rewritten.
+	Migrated int `json:"migrated"`
+	UpToDate int `json:"upToDate"`
+
+	Failures []MigrationFailure `json:"failures,omitempty"`
+
+	// Error is set if the migration couldn't list the stored objects, or was
+	// canceled.
+	Error string `json:"error,omitempty"`
+}
+
+// Queued is true if the migration is waiting for others to finish.
+func (s MigrationStatus) Queued() bool {
+	return s.StartTime == nil
+}
+
+// Running is true if the migration has started, but not finished.
+func (s MigrationStatus) Running() bool {
+	return s.StartTime != nil && s.CompletionTime == nil
+}
+
+// MigrationFailure is an object that couldn't be migrated.
+type MigrationFailure struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Error     string `json:"error"`
+}
+
+// StorageMigrator rewrites stored objects in the current storage version of
+// their resource, e.g., after a new version of a resource.MultiVersionObject
+// became the storage version.
+//
+// Resources join with the WithStorageMigrator option.
+type StorageMigrator struct {
+	mu        sync.Mutex
+	resources map[schema.GroupResource]*filepathREST
+	statuses  map[schema.GroupResource]*MigrationStatus
+
+	// queue has the resources to migrate in the background, in order. wake is
+	// signaled when resources are added.
+	queue []schema.GroupResource
+	wake  chan struct{}
+
+	// held for the duration of a migration, so that a resource isn't migrated
+	// twice at the same time
+	running sync.Mutex
+}
+
+func NewStorageMigrator() *StorageMigrator {
+	return &StorageMigrator{
+		resources: make(map[schema.GroupResource]*filepathREST),
+		statuses:  make(map[schema.GroupResource]*MigrationStatus),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// WithStorageMigrator makes the resource available to storage migrations.
+func WithStorageMigrator(m *StorageMigrator) RESTOption {
+	return func(f *filepathREST) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.resources[f.groupResource] = f
+	}
+}
+
+// Resources returns the resources that can be migrated, in a stable order.
+func (m *StorageMigrator) Resources() []schema.GroupResource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]schema.GroupResource, 0, len(m.resources))
+	for gr := range m.resources {
+		result = append(result, gr)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].String() < result[j].String()
+	})
+	return result
+}
+
+// Statuses returns the status of the latest migration of each resource that
+// has been migrated, including any migration in progress.
+func (m *StorageMigrator) Statuses() []MigrationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]MigrationStatus, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		result = append(result, status.deepCopy())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return schema.GroupResource{Group: result[i].Group, Resource: result[i].Resource}.String() <
+			schema.GroupResource{Group: result[j].Group, Resource: result[j].Resource}.String()
+	})
+	return result
+}
+
+// Enqueue queues the migrations of the resources, which Run migrates one at a
+// time, and returns their statuses. Resources that are already queued or being
+// migrated aren't queued again.
+func (m *StorageMigrator) Enqueue(grs ...schema.GroupResource) ([]MigrationStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, gr := range grs {
+		if _, ok := m.resources[gr]; !ok {
+			return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "storagemigrations"}, gr.String())
+		}
+	}
+
+	result := make([]MigrationStatus, 0, len(grs))
+	for _, gr := range grs {
+		status, ok := m.statuses[gr]
+		if !ok || (!status.Queued() && !status.Running()) {
+			status = &MigrationStatus{Group: gr.Group, Resource: gr.Resource}
+			m.statuses[gr] = status
+			m.queue = append(m.queue, gr)
+		}
+		result = append(result, status.deepCopy())
+	}
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return result, nil
+}
+
+// Run migrates the queued resources until ctx is done.
+func (m *StorageMigrator) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		}
+
+		for gr, ok := m.dequeue(); ok; gr, ok = m.dequeue() {
+			// failures are reported in the migration status
+			if _, err := m.Migrate(ctx, gr); err != nil {
+				klog.Errorf("Storage migration of %s stopped: %v", gr, err)
+			}
+		}
+	}
+}
+
+func (m *StorageMigrator) dequeue() (schema.GroupResource, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.queue) == 0 {
+		return schema.GroupResource{}, false
+	}
+	gr := m.queue[0]
+	m.queue = m.queue[1:]
+	return gr, true
+}
+
+// Migrate rewrites every object of the resource that isn't stored in the
+// current storage version.
+//
+// Objects are rewritten through the normal update path, so they get a new
+// resourceVersion and watchers see a MODIFIED event. Failures of individual
+// objects are reported in the status, and don't stop the migration.
+func (m *StorageMigrator) Migrate(ctx context.Context, gr schema.GroupResource) (MigrationStatus, error) {
+	m.mu.Lock()
+	f, ok := m.resources[gr]
+	m.mu.Unlock()
+	if !ok {
+		return MigrationStatus{}, apierrors.NewNotFound(schema.GroupResource{Resource: "storagemigrations"}, gr.String())
+	}
+
+	m.running.Lock()
+	defer m.running.Unlock()
+
+	now := metav1.Now()
+	status := &MigrationStatus{
+		Group:     gr.Group,
+		Resource:  gr.Resource,
+		StartTime: &now,
+	}
+	m.mu.Lock()
+	m.statuses[gr] = status
+	m.mu.Unlock()
+
+	f.migrate(ctx, func(fn func(status *MigrationStatus)) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		fn(status)
+	})
+
+	m.mu.Lock()
+	completionTime := metav1.Now()
+	status.CompletionTime = &completionTime
+	result := status.deepCopy()
+	m.mu.Unlock()
+
+	klog.Infof("Storage migration of %s finished: %d migrated, %d up to date, %d failed",
+		gr, result.Migrated, result.UpToDate, len(result.Failures))
+	return result, ctx.Err()
+}
+
+func (s *MigrationStatus) deepCopy() MigrationStatus {
+	out := *s
+	out.StartTime = s.StartTime.DeepCopy()
+	out.CompletionTime = s.CompletionTime.DeepCopy()
+	out.Failures = append([]MigrationFailure(nil), s.Failures...)
+	return out
+}
+
+// storedVersionDecoder remembers the version that the last object it decoded was stored in.
+type storedVersionDecoder struct {
+	runtime.Decoder
+	last schema.GroupVersionKind
+}
+
+func (d *storedVersionDecoder) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	obj, gvk, err := d.Decoder.Decode(data, defaults, into)
+	d.last = schema.GroupVersionKind{}
+	if gvk != nil {
+		d.last = *gvk
+	}
+	return obj, gvk, err
+}
+
+// storageVersion is the version that the codec encodes objects in.
+func (f *filepathREST) storageVersion() (schema.GroupVersionKind, error) {
+	d := &storedVersionDecoder{Decoder: f.storageCodec}
+	data, err := runtime.Encode(f.storageCodec, f.newFunc())
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	if _, _, err := d.Decode(data, nil, f.newFunc()); err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	return d.last, nil
+}
+
+// migrate rewrites the objects that aren't stored in the storage version.
+//
+// The status may be read concurrently, so it's only changed through update.
+func (f *filepathREST) migrate(ctx context.Context, update func(func(status *MigrationStatus))) {
+	type storedObject struct {
+		namespace string
+		name      string
+	}
+
+	target, err := f.storageVersion()
+	if err != nil {
+		update(func(status *MigrationStatus) {
+			status.Error = err.Error()
+		})
+		return
+	}
+	update(func(status *MigrationStatus) {
+		status.StorageVersion = target.GroupVersion().String()
+	})
+
+	var stale []storedObject
+	upToDate := 0
+	d := &storedVersionDecoder{Decoder: f.storageCodec}
+	_, err = f.fs.VisitDir(ctx, f.objRootPath, f.newFunc, d, func(path string, obj runtime.Object) error {
+		if d.last == target {
+			upToDate++
+			return nil
+		}
+		objMeta, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		stale = append(stale, storedObject{namespace: objMeta.GetNamespace(), name: objMeta.GetName()})
+		return nil
+	})
+	if err != nil {
+		update(func(status *MigrationStatus) {
+			status.Error = fmt.Sprintf("listing %s: %v", f.groupResource, err)
+		})
+		return
+	}
+	update(func(status *MigrationStatus) {
+		status.Total = upToDate + len(stale)
+		status.UpToDate = upToDate
+		status.Pending = len(stale)
+	})
+
+	for _, o := range stale {
+		if err := ctx.Err(); err != nil {
+			update(func(status *MigrationStatus) {
+				status.Error = err.Error()
+			})
+			return
+		}
+
+		objCtx := genericapirequest.WithNamespace(ctx, o.namespace)
+		obj, err := f.guaranteedUpdate(objCtx, o.name, func(input runtime.Object) (runtime.Object, error) {
+			if input == nil {
+				return nil, apierrors.NewNotFound(f.groupResource, o.name)
+			}
+			// the object was decoded from its stored version, so writing it
+			// back as-is encodes it in the storage version
+			return input, nil
+		})
+		if err == nil {
+			f.notifyWatchers(watch.Event{
+				Type:   watch.Modified,
+				Object: obj,
+			})
+		}
+
+		update(func(status *MigrationStatus) {
+			status.Pending--
+			switch {
+			case err == nil:
+				status.Migrated++
+			case apierrors.IsNotFound(err):
+				status.UpToDate++
+			default:
+				status.Failures = append(status.Failures, MigrationFailure{
+					Namespace: o.namespace,
+					Name:      o.name,
+					Error:     err.Error(),
+				})
+			}
+		})
+	}
+}
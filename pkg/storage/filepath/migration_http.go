@@ -0,0 +1,44 @@
+package filepath
+
+import (
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+)
+
+// StorageMigrationPath is the path of the storage migration endpoint.
+const StorageMigrationPath = "/storage-migration"
+
+var _ http.Handler = &StorageMigrator{}
+
+// ServeHTTP serves the storage migration endpoint.
+//
+// GET responds with the status of each migration. POST queues the migration of
+// the resource named by the "resource" query parameter (e.g.,
+// "manifests.core.tilt.dev"), or of every resource if it's empty, and responds
+// with their statuses right away. The migrations run in the background, and
+// their progress is reported by GET.
+func (m *StorageMigrator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		responsewriters.WriteRawJSON(http.StatusOK, m.Statuses(), w)
+
+	case http.MethodPost:
+		grs := m.Resources()
+		if resource := req.URL.Query().Get("resource"); resource != "" {
+			grs = []schema.GroupResource{schema.ParseGroupResource(resource)}
+		}
+		statuses, err := m.Enqueue(grs...)
+		if err != nil {
+			writeStatusError(w, err)
+			return
+		}
+		responsewriters.WriteRawJSON(http.StatusAccepted, statuses, w)
+
+	default:
+		writeStatusError(w, apierrors.NewMethodNotSupported(
+			schema.GroupResource{Resource: "storagemigrations"}, req.Method))
+	}
+}
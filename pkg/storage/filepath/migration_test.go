@@ -0,0 +1,141 @@
+package filepath_test
+
+import (
+	"context"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestStorageMigrator_Migrate(t *testing.T) {
+	// an older version of the API that stored the same type
+	oldVersion := schema.GroupVersion{Group: v1alpha1.SchemeGroupVersion.Group, Version: "v1alpha0"}
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	scheme.AddKnownTypes(oldVersion, &v1alpha1.Manifest{}, &v1alpha1.ManifestList{})
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+
+	dir := t.TempDir()
+	objDir := fp.Join(dir, "core.tilt.dev", "manifests")
+	require.NoError(t, os.MkdirAll(objDir, 0700))
+	require.NoError(t, os.WriteFile(fp.Join(objDir, "old.json"), []byte(`{
+  "apiVersion": "core.tilt.dev/v1alpha0",
+  "kind": "Manifest",
+  "metadata": {"name": "old"},
+  "spec": {"message": "hello"}
+}`), 0600))
+
+	obj := &v1alpha1.Manifest{}
+	migrator := filepath.NewStorageMigrator()
+	storage := filepath.NewFilepathREST(
+		filepath.NewRealFS(),
+		filepath.NewWatchSet(),
+		builderrest.DefaultStrategy{ObjectTyper: scheme, Object: obj},
+		manifestsResource,
+		codec,
+		dir,
+		obj.New,
+		obj.NewList,
+		filepath.WithStorageMigrator(migrator))
+
+	ctx := genericapirequest.WithNamespace(context.Background(), metav1.NamespaceNone)
+	_, err := storage.(rest.Creater).Create(ctx, &v1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "new"},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	w, err := storage.(rest.Watcher).Watch(ctx, nil)
+	require.NoError(t, err)
+	defer w.Stop()
+	// skip the initial events
+	<-w.ResultChan()
+	<-w.ResultChan()
+
+	var status filepath.MigrationStatus
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		status, err = migrator.Migrate(ctx, manifestsResource)
+	}()
+	e := <-w.ResultChan()
+	<-done
+	require.NoError(t, err)
+
+	assert.Equal(t, watch.Modified, e.Type)
+	assert.Equal(t, "old", e.Object.(*v1alpha1.Manifest).Name)
+	assert.Equal(t, "core.tilt.dev/v1alpha1", status.StorageVersion)
+	assert.Equal(t, 2, status.Total)
+	assert.Equal(t, 1, status.Migrated)
+	assert.Equal(t, 1, status.UpToDate)
+	assert.Equal(t, 0, status.Pending)
+	assert.Empty(t, status.Failures)
+	assert.NotNil(t, status.CompletionTime)
+
+	content, err := os.ReadFile(fp.Join(objDir, "old.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"apiVersion":"core.tilt.dev/v1alpha1"`)
+	assert.Contains(t, string(content), `"message":"hello"`)
+
+	// everything is up to date now
+	status, err = migrator.Migrate(ctx, manifestsResource)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Migrated)
+	assert.Equal(t, 2, status.UpToDate)
+	assert.Equal(t, []filepath.MigrationStatus{status}, migrator.Statuses())
+}
+
+func TestStorageMigrator_Run(t *testing.T) {
+	obj := &v1alpha1.Manifest{}
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+
+	migrator := filepath.NewStorageMigrator()
+	filepath.NewFilepathREST(
+		filepath.NewRealFS(),
+		filepath.NewWatchSet(),
+		builderrest.DefaultStrategy{ObjectTyper: scheme, Object: obj},
+		manifestsResource,
+		codec,
+		t.TempDir(),
+		obj.New,
+		obj.NewList,
+		filepath.WithStorageMigrator(migrator))
+
+	_, err := migrator.Enqueue(schema.GroupResource{Group: "core.tilt.dev", Resource: "nonexistent"})
+	assert.True(t, apierrors.IsNotFound(err), "expected not found, got %v", err)
+
+	// migrations are queued until the migrator runs, and only once
+	statuses, err := migrator.Enqueue(manifestsResource)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.True(t, statuses[0].Queued())
+	_, err = migrator.Enqueue(manifestsResource)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go migrator.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		statuses := migrator.Statuses()
+		return len(statuses) == 1 && !statuses[0].Queued() && !statuses[0].Running()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "core.tilt.dev/v1alpha1", migrator.Statuses()[0].StorageVersion)
+}
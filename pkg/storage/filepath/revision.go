@@ -0,0 +1,92 @@
+package filepath
+
+import (
+	"sync"
+)
+
+// revisionAllocator hands out the revisions of an FS.
+//
+// Writes allocate a revision, store the object, and then mark the revision
+// done. The current revision is the newest one that every earlier write has
+// finished with, so a read that starts at the current revision sees every
+// write up to it, even though writes to different files run concurrently.
+type revisionAllocator struct {
+	mu      sync.Mutex
+	last    uint64
+	pending map[uint64]struct{}
+}
+
+func newRevisionAllocator(start uint64) *revisionAllocator {
+	return &revisionAllocator{
+		last:    start,
+		pending: make(map[uint64]struct{}),
+	}
+}
+
+// allocate returns a new revision, greater than every revision before it.
+//
+// The caller must call done with it, whether or not the write succeeds.
+func (r *revisionAllocator) allocate() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last++
+	r.pending[r.last] = struct{}{}
+	return r.last
+}
+
+func (r *revisionAllocator) done(rev uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, rev)
+}
+
+// current returns the newest revision with no writes in progress at or before it.
+func (r *revisionAllocator) current() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := r.last
+	for rev := range r.pending {
+		if rev <= result {
+			result = rev - 1
+		}
+	}
+	return result
+}
+
+// keyedMutex is a set of mutexes, one per key, that exist only while in use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refCountedMutex
+}
+
+type refCountedMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*refCountedMutex)}
+}
+
+// lock locks the mutex for the key, and returns a function that unlocks it.
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refCountedMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		m.refs--
+		if m.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
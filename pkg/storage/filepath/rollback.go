@@ -0,0 +1,159 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+// RevisionRollback is the request body of the "rollback" subresource.
+//
+// +k8s:deepcopy-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type RevisionRollback struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Revision is the resourceVersion of the retained revision to restore.
+	Revision string `json:"revision"`
+}
+
+// GetOpenAPIDefinitions returns the OpenAPI definitions of the request types
+// used by the subresources in this package.
+func GetOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	return map[string]common.OpenAPIDefinition{
+		"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath.RevisionRollback": {
+			Schema: spec.Schema{
+				SchemaProps: spec.SchemaProps{
+					Description: "RevisionRollback is the request body of the \"rollback\" subresource.",
+					Type:        []string{"object"},
+					Properties: map[string]spec.Schema{
+						"kind": {
+							SchemaProps: spec.SchemaProps{
+								Type: []string{"string"},
+							},
+						},
+						"apiVersion": {
+							SchemaProps: spec.SchemaProps{
+								Type: []string{"string"},
+							},
+						},
+						"revision": {
+							SchemaProps: spec.SchemaProps{
+								Description: "Revision is the resourceVersion of the retained revision to restore.",
+								Type:        []string{"string"},
+							},
+						},
+					},
+					Required: []string{"revision"},
+				},
+			},
+		},
+	}
+}
+
+var _ rest.NamedCreater = &rollbackREST{}
+
+// NewRollbackStorageProvider serves the "rollback" subresource, which restores
+// a retained revision of an object through the normal update path. If the
+// parent has the WithAdmission option, the update is admitted as an update of
+// the object.
+//
+// The object must implement resource.ObjectWithRollbackSubResource, and parentSP
+// must be a provider created by NewJSONFilepathStorageProvider with the
+// WithRevisionHistory option.
+func NewRollbackStorageProvider(parentSP builderrest.ResourceHandlerProvider) builderrest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (rest.Storage, error) {
+		parent, err := historyParent(scheme, getter, parentSP)
+		if err != nil {
+			return nil, err
+		}
+		return &rollbackREST{parent: parent}, nil
+	}
+}
+
+type rollbackREST struct {
+	parent *filepathREST
+}
+
+func (r *rollbackREST) New() runtime.Object {
+	return &RevisionRollback{}
+}
+
+func (r *rollbackREST) Destroy() {
+	// Destroy() is intended for cleaning up client connections. Do nothing.
+}
+
+// Create restores the spec of the requested revision onto the current object.
+func (r *rollbackREST) Create(
+	ctx context.Context,
+	name string,
+	obj runtime.Object,
+	createValidation rest.ValidateObjectFunc,
+	options *metav1.CreateOptions,
+) (runtime.Object, error) {
+	req, ok := obj.(*RevisionRollback)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("not a RevisionRollback: %T", obj))
+	}
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+
+	version, err := parseResourceVersion(req.Revision)
+	if err != nil || version == 0 {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid revision: %q", req.Revision))
+	}
+
+	f := r.parent
+	data, ok := f.history.get(f.objectFileName(ctx, name), version)
+	if !ok {
+		return nil, apierrors.NewResourceExpired(
+			fmt.Sprintf("revision %d of %s %q is no longer retained", version, f.groupResource, name))
+	}
+	target, err := f.decodeRevision(data)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+
+	return f.admittedUpdate(ctx, name, rollbackObjectInfo{
+		groupResource: f.groupResource,
+		name:          name,
+		target:        target,
+	})
+}
+
+// rollbackObjectInfo copies the spec of the target revision onto the current object.
+type rollbackObjectInfo struct {
+	groupResource schema.GroupResource
+	name          string
+	target        runtime.Object
+}
+
+func (i rollbackObjectInfo) Preconditions() *metav1.Preconditions {
+	return nil
+}
+
+func (i rollbackObjectInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	if oldObj == nil {
+		return nil, apierrors.NewNotFound(i.groupResource, i.name)
+	}
+	newObj := oldObj.DeepCopyObject()
+	rollbacker, ok := newObj.(resource.ObjectWithRollbackSubResource)
+	if !ok {
+		return nil, apierrors.NewMethodNotSupported(i.groupResource, "rollback")
+	}
+	rollbacker.RollbackTo(i.target)
+	return newObj, nil
+}
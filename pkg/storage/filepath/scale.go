@@ -0,0 +1,298 @@
+package filepath
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/server/builder/resource"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+// AddScaleToScheme registers autoscaling/v1 Scale, the request body of the
+// "scale" subresource, as both its external and internal version.
+func AddScaleToScheme(s *runtime.Scheme) error {
+	s.AddKnownTypes(autoscalingv1.SchemeGroupVersion, &autoscalingv1.Scale{})
+	s.AddKnownTypes(schema.GroupVersion{
+		Group:   autoscalingv1.GroupName,
+		Version: runtime.APIVersionInternal,
+	}, &autoscalingv1.Scale{})
+	return nil
+}
+
+// GetScaleOpenAPIDefinitions returns the OpenAPI definitions of autoscaling/v1
+// Scale, which aren't generated for the resources.
+func GetScaleOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	return map[string]common.OpenAPIDefinition{
+		autoscalingv1.Scale{}.OpenAPIModelName(): {
+			Schema: spec.Schema{
+				SchemaProps: spec.SchemaProps{
+					Description: "Scale represents a scaling request for a resource.",
+					Type:        []string{"object"},
+					Properties: map[string]spec.Schema{
+						"kind": {
+							SchemaProps: spec.SchemaProps{
+								Type: []string{"string"},
+							},
+						},
+						"apiVersion": {
+							SchemaProps: spec.SchemaProps{
+								Type: []string{"string"},
+							},
+						},
+						"metadata": {
+							SchemaProps: spec.SchemaProps{
+								Default: map[string]interface{}{},
+								Ref:     ref(metav1.ObjectMeta{}.OpenAPIModelName()),
+							},
+						},
+						"spec": {
+							SchemaProps: spec.SchemaProps{
+								Description: "spec defines the behavior of the scale.",
+								Default:     map[string]interface{}{},
+								Ref:         ref(autoscalingv1.ScaleSpec{}.OpenAPIModelName()),
+							},
+						},
+						"status": {
+							SchemaProps: spec.SchemaProps{
+								Description: "status is the current status of the scale. Read-only.",
+								Default:     map[string]interface{}{},
+								Ref:         ref(autoscalingv1.ScaleStatus{}.OpenAPIModelName()),
+							},
+						},
+					},
+				},
+			},
+			Dependencies: []string{
+				autoscalingv1.ScaleSpec{}.OpenAPIModelName(),
+				autoscalingv1.ScaleStatus{}.OpenAPIModelName(),
+				metav1.ObjectMeta{}.OpenAPIModelName(),
+			},
+		},
+		autoscalingv1.ScaleSpec{}.OpenAPIModelName(): {
+			Schema: spec.Schema{
+				SchemaProps: spec.SchemaProps{
+					Description: "ScaleSpec describes the attributes of a scale subresource.",
+					Type:        []string{"object"},
+					Properties: map[string]spec.Schema{
+						"replicas": {
+							SchemaProps: spec.SchemaProps{
+								Description: "replicas is the desired number of instances for the scaled object.",
+								Type:        []string{"integer"},
+								Format:      "int32",
+							},
+						},
+					},
+				},
+			},
+		},
+		autoscalingv1.ScaleStatus{}.OpenAPIModelName(): {
+			Schema: spec.Schema{
+				SchemaProps: spec.SchemaProps{
+					Description: "ScaleStatus represents the current status of a scale subresource.",
+					Type:        []string{"object"},
+					Properties: map[string]spec.Schema{
+						"replicas": {
+							SchemaProps: spec.SchemaProps{
+								Description: "replicas is the actual number of observed instances of the scaled object.",
+								Default:     0,
+								Type:        []string{"integer"},
+								Format:      "int32",
+							},
+						},
+						"selector": {
+							SchemaProps: spec.SchemaProps{
+								Description: "selector is the label query over pods that should match the replicas count.",
+								Type:        []string{"string"},
+							},
+						},
+					},
+					Required: []string{"replicas"},
+				},
+			},
+		},
+	}
+}
+
+var _ rest.Patcher = &scaleREST{}
+var _ rest.GroupVersionKindProvider = &scaleREST{}
+
+// NewScaleStorageProvider serves the "scale" subresource, which reads and
+// updates the scale of an object through the normal get and update paths, so
+// that updates are checked against the object's resourceVersion and watchers
+// of the object are notified.
+//
+// The object must implement resource.ObjectWithScaleSubResource, and parentSP
+// must be a provider created by NewJSONFilepathStorageProvider.
+func NewScaleStorageProvider(parentSP builderrest.ResourceHandlerProvider) builderrest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (rest.Storage, error) {
+		parent, err := filepathParent(scheme, getter, parentSP)
+		if err != nil {
+			return nil, err
+		}
+		return &scaleREST{parent: parent}, nil
+	}
+}
+
+type scaleREST struct {
+	parent *filepathREST
+}
+
+func (r *scaleREST) New() runtime.Object {
+	return &autoscalingv1.Scale{}
+}
+
+func (r *scaleREST) Destroy() {
+	// Destroy() is intended for cleaning up client connections. Do nothing.
+}
+
+// GroupVersionKind serves the subresource as autoscaling/v1 Scale, so that
+// `kubectl scale` and autoscalers can use it.
+func (r *scaleREST) GroupVersionKind(containingGV schema.GroupVersion) schema.GroupVersionKind {
+	return autoscalingv1.SchemeGroupVersion.WithKind("Scale")
+}
+
+func (r *scaleREST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	obj, err := r.parent.Get(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+	return r.scaleFromObject(obj)
+}
+
+// Update sets the scale of the object. If the scale has a resourceVersion,
+// it must match the object's.
+func (r *scaleREST) Update(
+	ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions,
+) (runtime.Object, bool, error) {
+	obj, _, err := r.parent.Update(ctx, name, scaleUpdatedObjectInfo{
+		scaleREST: r,
+		name:      name,
+		objInfo:   objInfo,
+	}, r.scaleCreateValidation(createValidation), r.scaleUpdateValidation(updateValidation), false, options)
+	if err != nil {
+		return nil, false, err
+	}
+	scale, err := r.scaleFromObject(obj)
+	return scale, false, err
+}
+
+// scaleFromObject returns the scale of the object, with the object's metadata.
+func (r *scaleREST) scaleFromObject(obj runtime.Object) (*autoscalingv1.Scale, error) {
+	scaler, ok := obj.(resource.ObjectWithScaleSubResource)
+	if !ok {
+		return nil, apierrors.NewMethodNotSupported(r.parent.groupResource, "scale")
+	}
+	scale := scaler.GetScale()
+	if scale == nil {
+		scale = &autoscalingv1.Scale{}
+	} else {
+		scale = scale.DeepCopy()
+	}
+	objMeta := scaler.GetObjectMeta()
+	scale.ObjectMeta = metav1.ObjectMeta{
+		Name:              objMeta.Name,
+		Namespace:         objMeta.Namespace,
+		UID:               objMeta.UID,
+		ResourceVersion:   objMeta.ResourceVersion,
+		CreationTimestamp: objMeta.CreationTimestamp,
+	}
+	return scale, nil
+}
+
+// scaleCreateValidation validates the scale of the object, rather than the object.
+func (r *scaleREST) scaleCreateValidation(f rest.ValidateObjectFunc) rest.ValidateObjectFunc {
+	if f == nil {
+		return nil
+	}
+	return func(ctx context.Context, obj runtime.Object) error {
+		scale, err := r.scaleFromObject(obj)
+		if err != nil {
+			return err
+		}
+		return f(ctx, scale)
+	}
+}
+
+// scaleUpdateValidation validates the scales of the objects, rather than the objects.
+func (r *scaleREST) scaleUpdateValidation(f rest.ValidateObjectUpdateFunc) rest.ValidateObjectUpdateFunc {
+	if f == nil {
+		return nil
+	}
+	return func(ctx context.Context, obj, old runtime.Object) error {
+		newScale, err := r.scaleFromObject(obj)
+		if err != nil {
+			return err
+		}
+		oldScale, err := r.scaleFromObject(old)
+		if err != nil {
+			return err
+		}
+		return f(ctx, newScale, oldScale)
+	}
+}
+
+// scaleUpdatedObjectInfo applies the updated scale to the current object.
+type scaleUpdatedObjectInfo struct {
+	*scaleREST
+	name    string
+	objInfo rest.UpdatedObjectInfo
+}
+
+func (i scaleUpdatedObjectInfo) Preconditions() *metav1.Preconditions {
+	return i.objInfo.Preconditions()
+}
+
+func (i scaleUpdatedObjectInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	if oldObj == nil {
+		return nil, apierrors.NewNotFound(i.parent.groupResource, i.name)
+	}
+	oldScale, err := i.scaleFromObject(oldObj)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := i.objInfo.UpdatedObject(ctx, oldScale)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, apierrors.NewBadRequest("nil update passed to Scale")
+	}
+	scale, ok := obj.(*autoscalingv1.Scale)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected input object type to be Scale, but %T", obj))
+	}
+	if scale.Spec.Replicas < 0 {
+		return nil, apierrors.NewInvalid(
+			schema.GroupKind{Group: autoscalingv1.GroupName, Kind: "Scale"},
+			i.name,
+			field.ErrorList{field.Invalid(field.NewPath("spec", "replicas"), scale.Spec.Replicas, "must be greater than or equal to 0")})
+	}
+
+	newObj := oldObj.DeepCopyObject()
+	scaler := newObj.(resource.ObjectWithScaleSubResource)
+	scaler.SetScale(scale)
+
+	// An update without a resourceVersion applies to the current object,
+	// the same way `kubectl scale` without --resource-version does.
+	if scale.ResourceVersion != "" {
+		scaler.GetObjectMeta().ResourceVersion = scale.ResourceVersion
+	}
+	return newObj, nil
+}
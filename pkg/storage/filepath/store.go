@@ -0,0 +1,600 @@
+package filepath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/storage"
+	cacherstorage "k8s.io/apiserver/pkg/storage/cacher"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NewStorageDecorator returns a decorator that stores the resources of an
+// upstream genericregistry.Store in the FS, under rootPath.
+//
+// Keys map to files the same way they do for NewJSONFilepathStorageProvider,
+// as long as the resource prefix is "<group>/<resource>".
+//
+// Every storage created for the same resource prefix (e.g., for a resource and
+// its status subresource) shares a WatchSet. Reads and watches are served from
+// a watch cache, like they are for etcd.
+func NewStorageDecorator(fs FS, rootPath string) generic.StorageDecorator {
+	var mu sync.Mutex
+	watchSets := make(map[string]*WatchSet)
+
+	return func(
+		config *storagebackend.ConfigForResource,
+		resourcePrefix string,
+		keyFunc func(obj runtime.Object) (string, error),
+		newFunc func() runtime.Object,
+		newListFunc func() runtime.Object,
+		getAttrsFunc storage.AttrFunc,
+		trigger storage.IndexerFuncs,
+		indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+		mu.Lock()
+		ws, ok := watchSets[resourcePrefix]
+		if !ok {
+			ws = NewWatchSet()
+			watchSets[resourcePrefix] = ws
+		}
+		mu.Unlock()
+
+		s, err := NewStore(fs, ws, config.Codec, rootPath, resourcePrefix, keyFunc, newFunc, newListFunc)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the watch cache keeps the history that the FS doesn't, so watches
+		// from a recent resourceVersion are replayed exactly
+		historyWindow := config.EventsHistoryWindow
+		if historyWindow < cacherstorage.DefaultEventFreshDuration {
+			historyWindow = cacherstorage.DefaultEventFreshDuration
+		}
+		cacher, err := cacherstorage.NewCacherFromConfig(cacherstorage.Config{
+			Storage:             s,
+			Versioner:           storage.APIObjectVersioner{},
+			GroupResource:       config.GroupResource,
+			EventsHistoryWindow: historyWindow,
+			ResourcePrefix:      resourcePrefix,
+			KeyFunc:             keyFunc,
+			NewFunc:             newFunc,
+			NewListFunc:         newListFunc,
+			GetAttrsFunc:        getAttrsFunc,
+			IndexerFuncs:        trigger,
+			Indexers:            indexers,
+			Codec:               config.Codec,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		delegator := cacherstorage.NewCacheDelegator(cacher, s)
+		var once sync.Once
+		return delegator, func() {
+			once.Do(func() {
+				delegator.Stop()
+				cacher.Stop()
+			})
+		}, nil
+	}
+}
+
+// NewStore returns a storage.Interface that stores objects in the FS.
+//
+// Unlike etcd, the FS keeps no history, so:
+//   - a watch from a resourceVersion fails with 410 Gone if the resource has
+//     changed since. The watch cache of NewStorageDecorator keeps the recent
+//     history that watches are replayed from.
+//   - lists are always served at the latest resourceVersion, and don't paginate.
+func NewStore(
+	fs FS,
+	ws *WatchSet,
+	codec runtime.Codec,
+	rootPath string,
+	resourcePrefix string,
+	keyFunc func(obj runtime.Object) (string, error),
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+) (storage.Interface, error) {
+	s := &store{
+		fs:          fs,
+		watchSet:    ws,
+		codec:       codec,
+		rootPath:    rootPath,
+		objRootPath: filepath.Join(rootPath, filepath.FromSlash(resourcePrefix)),
+		keyFunc:     keyFunc,
+		newFunc:     newFunc,
+		newListFunc: newListFunc,
+	}
+	if err := fs.EnsureDir(context.Background(), s.objRootPath); err != nil {
+		return nil, fmt.Errorf("unable to write data dir: %v", err)
+	}
+
+	// changes before now were never sent to watchers, so they can't be replayed
+	rev, err := s.GetCurrentResourceVersion(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	ws.observe(rev)
+	return s, nil
+}
+
+type store struct {
+	fs          FS
+	watchSet    *WatchSet
+	codec       runtime.Codec
+	versioner   storage.APIObjectVersioner
+	rootPath    string
+	objRootPath string
+
+	keyFunc     func(obj runtime.Object) (string, error)
+	newFunc     func() runtime.Object
+	newListFunc func() runtime.Object
+}
+
+var _ storage.Interface = &store{}
+
+func (s *store) Versioner() storage.Versioner {
+	return s.versioner
+}
+
+func (s *store) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	if version, err := s.versioner.ObjectResourceVersion(obj); err == nil && version != 0 {
+		return storage.ErrResourceVersionSetOnCreate
+	}
+
+	path := s.objectFileName(key)
+	if err := s.fs.EnsureDir(ctx, filepath.Dir(path)); err != nil {
+		return interpretStoreError(err, key)
+	}
+	// RealFS doesn't check the storage version, so check for an existing
+	// object explicitly
+	if s.fs.Exists(ctx, path) {
+		return storage.NewKeyExistsError(key, 0)
+	}
+
+	newObj := obj.DeepCopyObject()
+	if err := s.versioner.PrepareObjectForStorage(newObj); err != nil {
+		return err
+	}
+	if err := s.fs.Write(ctx, s.codec, path, newObj, 0); err != nil {
+		return interpretStoreError(err, key)
+	}
+
+	if out != nil {
+		if err := copyInto(out, newObj.DeepCopyObject()); err != nil {
+			return err
+		}
+	}
+	s.watchSet.notifyWatchers(watch.Event{
+		Type:   watch.Added,
+		Object: newObj,
+	})
+	return nil
+}
+
+func (s *store) Delete(
+	ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object, opts storage.DeleteOptions) error {
+	path := s.objectFileName(key)
+
+	// same cap as guaranteedUpdate
+	const maxAttempts = 100
+	for i := 0; i < maxAttempts; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		existing, err := s.fs.Read(ctx, s.codec, path, s.newFunc)
+		if err != nil {
+			if !IsCorrupted(err) || !opts.IgnoreStoreReadError {
+				return interpretStoreError(err, key)
+			}
+			// the object can't be read, so there's nothing to check it against
+			if err := s.fs.Remove(ctx, path); err != nil && !IsNotFound(err) {
+				return interpretStoreError(err, key)
+			}
+			return nil
+		}
+
+		if preconditions != nil {
+			if err := preconditions.Check(key, existing); err != nil {
+				return err
+			}
+		}
+		if validateDeletion != nil {
+			if err := validateDeletion(ctx, existing); err != nil {
+				return err
+			}
+		}
+
+		version, err := s.versioner.ObjectResourceVersion(existing)
+		if err != nil {
+			return err
+		}
+		rev, err := s.removeVersion(ctx, path, version)
+		if err != nil {
+			if IsConflict(err) {
+				// the object changed after we checked it, retry
+				continue
+			}
+			return interpretStoreError(err, key)
+		}
+
+		if out != nil {
+			if err := copyInto(out, existing.DeepCopyObject()); err != nil {
+				return err
+			}
+		}
+		// same as etcd, the event has the revision of the delete
+		if err := s.versioner.UpdateObject(existing, rev); err != nil {
+			return err
+		}
+		s.watchSet.notifyWatchers(watch.Event{
+			Type:   watch.Deleted,
+			Object: existing,
+		})
+		return nil
+	}
+	return storage.NewInternalError(errors.New("failed to delete from storage"))
+}
+
+// removeVersion removes the file if it's still at the given version, and
+// returns the revision of the removal.
+//
+// Only MemoryFS can check the version atomically. Other filesystems remove the
+// file unconditionally.
+func (s *store) removeVersion(ctx context.Context, path string, version uint64) (uint64, error) {
+	switch fs := s.fs.(type) {
+	case *MemoryFS:
+		return fs.commit([]fsOp{{path: path, remove: true, storageVersion: version}})
+	case *RealFS:
+		return fs.remove(ctx, path)
+	}
+	if err := s.fs.Remove(ctx, path); err != nil {
+		return 0, err
+	}
+	return s.GetCurrentResourceVersion(ctx)
+}
+
+func (s *store) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	version, err := s.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	p := opts.Predicate
+	jw := s.watchSet.newWatch()
+
+	getInitEvents := func() ([]watch.Event, error) {
+		sendInitialEvents := version == 0
+		if opts.SendInitialEvents != nil {
+			sendInitialEvents = *opts.SendInitialEvents
+		}
+		if !sendInitialEvents {
+			// without history, a watch can only start from a version if
+			// there's nothing to replay. The WatchSet is locked, so changes
+			// after the check are sent to the watch.
+			if sent := s.watchSet.revision.Load(); version < sent {
+				return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", version, sent))
+			}
+			return nil, nil
+		}
+
+		initEvents := []watch.Event{}
+		rev, err := s.visit(ctx, key, opts.Recursive, func(obj runtime.Object) error {
+			ok, err := p.Matches(obj)
+			if err != nil || !ok {
+				return err
+			}
+			initEvents = append(initEvents, watch.Event{
+				Type:   watch.Added,
+				Object: obj,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.SendInitialEvents != nil && *opts.SendInitialEvents && p.AllowWatchBookmarks {
+			bookmark := s.newFunc()
+			if err := s.versioner.UpdateObject(bookmark, rev); err != nil {
+				return nil, err
+			}
+			if err := storage.AnnotateInitialEventsEndBookmark(bookmark); err != nil {
+				return nil, err
+			}
+			initEvents = append(initEvents, watch.Event{
+				Type:   watch.Bookmark,
+				Object: bookmark,
+			})
+		}
+		return initEvents, nil
+	}
+
+	if err := jw.Start(p, getInitEvents); err != nil {
+		jw.Stop()
+		return nil, err
+	}
+
+	// The WatchSet has every change to the resource, so only pass on the
+	// changes under the key. The watch cache expects the objects themselves,
+	// and shares their encoding on its own.
+	return watch.Filter(jw, func(e watch.Event) (watch.Event, bool) {
+		if e.Type == watch.Bookmark || e.Type == watch.Error {
+			return e, true
+		}
+		e.Object = unwrapCachingObject(e.Object)
+		objKey, err := s.keyFunc(e.Object)
+		if err != nil {
+			return e, false
+		}
+		return e, keyMatches(key, objKey, opts.Recursive)
+	}), nil
+}
+
+func (s *store) Get(ctx context.Context, key string, opts storage.GetOptions, objPtr runtime.Object) error {
+	obj, err := s.fs.Read(ctx, s.codec, s.objectFileName(key), s.newFunc)
+	if err != nil {
+		if IsNotFound(err) && opts.IgnoreNotFound {
+			return runtime.SetZeroValue(objPtr)
+		}
+		return interpretStoreError(err, key)
+	}
+	return copyInto(objPtr, obj)
+}
+
+func (s *store) GetList(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	version, err := s.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("invalid resource version: %v", err))
+	}
+
+	v, err := getListPrt(listObj)
+	if err != nil {
+		return err
+	}
+
+	p := opts.Predicate
+	rev, err := s.visit(ctx, key, opts.Recursive, func(obj runtime.Object) error {
+		ok, err := p.Matches(obj)
+		if err != nil || !ok {
+			return err
+		}
+		appendItem(v, obj)
+		return nil
+	})
+	if err != nil {
+		return interpretStoreError(err, key)
+	}
+
+	if version > rev {
+		return storage.NewTooLargeResourceVersionError(version, rev, 1)
+	}
+	if opts.ResourceVersionMatch == metav1.ResourceVersionMatchExact && version != rev {
+		return apierrors.NewResourceExpired(
+			fmt.Sprintf("resourceVersion %d is no longer available, the latest is %d", version, rev))
+	}
+	return s.versioner.UpdateList(listObj, rev, "", nil)
+}
+
+func (s *store) GuaranteedUpdate(
+	ctx context.Context, key string, destination runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	path := s.objectFileName(key)
+
+	// same cap as guaranteedUpdate
+	const maxAttempts = 100
+	for i := 0; i < maxAttempts; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		existing, err := s.fs.Read(ctx, s.codec, path, s.newFunc)
+		exists := err == nil
+		if err != nil {
+			if !IsNotFound(err) || !ignoreNotFound {
+				return interpretStoreError(err, key)
+			}
+			existing = s.newFunc()
+		}
+
+		if exists && preconditions != nil {
+			if err := preconditions.Check(key, existing); err != nil {
+				return err
+			}
+		}
+
+		version, err := s.versioner.ObjectResourceVersion(existing)
+		if err != nil {
+			return err
+		}
+
+		out, _, err := tryUpdate(existing.DeepCopyObject(), storage.ResponseMeta{ResourceVersion: version})
+		if err != nil {
+			return err
+		}
+
+		newObj := out.DeepCopyObject()
+		if err := s.versioner.PrepareObjectForStorage(newObj); err != nil {
+			return err
+		}
+		if err := s.fs.EnsureDir(ctx, filepath.Dir(path)); err != nil {
+			return interpretStoreError(err, key)
+		}
+		if err := s.fs.Write(ctx, s.codec, path, newObj, version); err != nil {
+			if IsConflict(err) || IsNotFound(err) || IsAlreadyExists(err) {
+				// storage conflict, or the object was deleted or created; retry
+				continue
+			}
+			return interpretStoreError(err, key)
+		}
+
+		newVersion, err := s.versioner.ObjectResourceVersion(newObj)
+		if err != nil {
+			return err
+		}
+		if newVersion == 0 {
+			// the object serialized identically, so it wasn't written
+			if err := s.versioner.UpdateObject(newObj, version); err != nil {
+				return err
+			}
+			return copyInto(destination, newObj)
+		}
+
+		if err := copyInto(destination, newObj.DeepCopyObject()); err != nil {
+			return err
+		}
+		eventType := watch.Modified
+		if !exists {
+			eventType = watch.Added
+		}
+		s.watchSet.notifyWatchers(watch.Event{
+			Type:   eventType,
+			Object: newObj,
+		})
+		return nil
+	}
+
+	return storage.NewInternalError(errors.New("failed to persist to storage"))
+}
+
+func (s *store) Stats(ctx context.Context) (storage.Stats, error) {
+	count := int64(0)
+	_, err := s.visit(ctx, s.objRootKey(), true, func(obj runtime.Object) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return storage.Stats{}, interpretStoreError(err, s.objRootKey())
+	}
+	return storage.Stats{ObjectCount: count}, nil
+}
+
+func (s *store) ReadinessCheck() error {
+	return nil
+}
+
+// RequestWatchProgress is a no-op. Watches never lag behind the FS.
+func (s *store) RequestWatchProgress(ctx context.Context) error {
+	return nil
+}
+
+func (s *store) GetCurrentResourceVersion(ctx context.Context) (uint64, error) {
+	rev, err := s.visit(ctx, s.objRootKey(), true, func(obj runtime.Object) error {
+		return nil
+	})
+	return rev, interpretStoreError(err, s.objRootKey())
+}
+
+func (s *store) EnableResourceSizeEstimation(storage.KeysFunc) error {
+	return nil
+}
+
+// CompactRevision returns 0, because the FS never compacts.
+func (s *store) CompactRevision() int64 {
+	return 0
+}
+
+// visit calls visitFunc with the object at the key, or with every object
+// under the key if recursive. Returns the revision of the read.
+func (s *store) visit(ctx context.Context, key string, recursive bool, visitFunc func(obj runtime.Object) error) (uint64, error) {
+	dirname := s.objectDirName(key)
+	path := ""
+	if !recursive {
+		// read the parent directory, so that the read comes with a revision
+		path = s.objectFileName(key)
+		dirname = filepath.Dir(path)
+	}
+
+	if !s.fs.Exists(ctx, dirname) {
+		// e.g., a namespace with no objects
+		return s.fs.VisitDir(ctx, s.objRootPath, s.newFunc, s.codec, func(p string, obj runtime.Object) error {
+			return nil
+		})
+	}
+	return s.fs.VisitDir(ctx, dirname, s.newFunc, s.codec, func(p string, obj runtime.Object) error {
+		if path != "" && p != path {
+			return nil
+		}
+		return visitFunc(obj)
+	})
+}
+
+// interpretStoreError converts an error from the FS into the storage error
+// that the registry expects.
+func interpretStoreError(err error, key string) error {
+	var storageErr *StorageError
+	if !errors.As(err, &storageErr) {
+		return err
+	}
+	switch storageErr.Code {
+	case ErrCodeNotFound:
+		return storage.NewKeyNotFoundError(key, 0)
+	case ErrCodeConflict:
+		return storage.NewResourceVersionConflictsError(key, 0)
+	case ErrCodeAlreadyExists:
+		return storage.NewKeyExistsError(key, 0)
+	case ErrCodeCorrupted:
+		return storage.NewCorruptObjError(key, storageErr.Err)
+	case ErrCodeUnavailable:
+		return storage.NewUnreachableError(key, 0)
+	}
+	return err
+}
+
+func (s *store) objRootKey() string {
+	rel, err := filepath.Rel(s.rootPath, s.objRootPath)
+	if err != nil {
+		return ""
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+func (s *store) objectFileName(key string) string {
+	return s.objectDirName(key) + ".json"
+}
+
+func (s *store) objectDirName(key string) string {
+	return filepath.Join(s.rootPath, filepath.FromSlash(strings.TrimSuffix(key, "/")))
+}
+
+// keyMatches checks if objKey is the key of a single-object watch, or is under
+// the key of a recursive one.
+func keyMatches(key, objKey string, recursive bool) bool {
+	key = strings.TrimSuffix(key, "/")
+	if !recursive {
+		return objKey == key
+	}
+	return strings.HasPrefix(objKey, key+"/")
+}
+
+// copyInto sets the value of out to the value of obj. They must be pointers to
+// the same type.
+func copyInto(out, obj runtime.Object) error {
+	outValue, err := conversion.EnforcePtr(out)
+	if err != nil {
+		return err
+	}
+	objValue, err := conversion.EnforcePtr(obj)
+	if err != nil {
+		return err
+	}
+	if outValue.Type() != objValue.Type() {
+		return fmt.Errorf("unable to copy %v into %v", objValue.Type(), outValue.Type())
+	}
+	outValue.Set(objValue)
+	return nil
+}
@@ -0,0 +1,216 @@
+package filepath_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+func TestStore_RegistryStore(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fs   filepath.FS
+	}{
+		{name: "memory", fs: filepath.NewMemoryFS()},
+		{name: "file", fs: filepath.NewRealFS()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			scheme := runtime.NewScheme()
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+			getter := decoratedRESTOptionsGetter{
+				codec:     codec,
+				decorator: filepath.NewStorageDecorator(tc.fs, dir),
+			}
+
+			obj := &v1alpha1.Manifest{}
+			storage, err := builderrest.New(obj)(scheme, getter)
+			require.NoError(t, err)
+			defer storage.Destroy()
+			ctx := genericapirequest.WithNamespace(context.Background(), metav1.NamespaceNone)
+
+			// watches are served once the watch cache is initialized
+			var w watch.Interface
+			require.Eventually(t, func() bool {
+				w, err = storage.(rest.Watcher).Watch(ctx, &metainternalversion.ListOptions{})
+				return err == nil
+			}, 5*time.Second, 10*time.Millisecond)
+			defer w.Stop()
+
+			var created runtime.Object
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				created, err = storage.(rest.Creater).Create(ctx, &v1alpha1.Manifest{
+					ObjectMeta: metav1.ObjectMeta{Name: "a"},
+					Spec:       v1alpha1.ManifestSpec{Message: "hello"},
+				}, nil, &metav1.CreateOptions{})
+			}()
+			e := <-w.ResultChan()
+			<-done
+			require.NoError(t, err)
+			assert.Equal(t, watch.Added, e.Type)
+			assert.NotEmpty(t, created.(*v1alpha1.Manifest).ResourceVersion)
+			assert.False(t, created.(*v1alpha1.Manifest).CreationTimestamp.IsZero())
+
+			_, err = storage.(rest.Creater).Create(ctx, &v1alpha1.Manifest{
+				ObjectMeta: metav1.ObjectMeta{Name: "a"},
+			}, nil, &metav1.CreateOptions{})
+			if assert.Error(t, err) {
+				assert.True(t, apierrors.IsAlreadyExists(err), err.Error())
+			}
+
+			// the registry store and filepathREST store objects in the same files
+			filepathREST := filepath.NewFilepathREST(tc.fs, filepath.NewWatchSet(),
+				builderrest.DefaultStrategy{ObjectTyper: scheme, Object: obj},
+				manifestsResource, codec, dir, obj.New, obj.NewList)
+			stored, err := filepathREST.(rest.Getter).Get(ctx, "a", &metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, "hello", stored.(*v1alpha1.Manifest).Spec.Message)
+
+			updatedObj := created.DeepCopyObject().(*v1alpha1.Manifest)
+			updatedObj.Spec.Message = "goodbye"
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				_, _, err = storage.(rest.Updater).Update(ctx, "a",
+					rest.DefaultUpdatedObjectInfo(updatedObj), nil, nil, false, &metav1.UpdateOptions{})
+			}()
+			e = <-w.ResultChan()
+			<-done
+			require.NoError(t, err)
+			assert.Equal(t, watch.Modified, e.Type)
+			// the watch cache shares the encoding of events between watchers
+			modified := e.Object.(runtime.CacheableObject).GetObject()
+			assert.Equal(t, "goodbye", modified.(*v1alpha1.Manifest).Spec.Message)
+
+			// a stale update conflicts
+			_, _, err = storage.(rest.Updater).Update(ctx, "a",
+				rest.DefaultUpdatedObjectInfo(updatedObj), nil, nil, false, &metav1.UpdateOptions{})
+			if assert.Error(t, err) {
+				assert.True(t, apierrors.IsConflict(err), err.Error())
+			}
+
+			list, err := storage.(rest.Lister).List(ctx, &metainternalversion.ListOptions{})
+			require.NoError(t, err)
+			items := list.(*v1alpha1.ManifestList).Items
+			require.Len(t, items, 1)
+			assert.Equal(t, "goodbye", items[0].Spec.Message)
+			assert.NotEmpty(t, list.(*v1alpha1.ManifestList).ResourceVersion)
+
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				_, _, err = storage.(rest.GracefulDeleter).Delete(ctx, "a", nil, &metav1.DeleteOptions{})
+			}()
+			e = <-w.ResultChan()
+			<-done
+			require.NoError(t, err)
+			assert.Equal(t, watch.Deleted, e.Type)
+
+			_, err = storage.(rest.Getter).Get(ctx, "a", &metav1.GetOptions{})
+			if assert.Error(t, err) {
+				assert.True(t, apierrors.IsNotFound(err), err.Error())
+			}
+		})
+	}
+}
+
+func TestStore_WatchFromResourceVersion(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(v1alpha1.SchemeGroupVersion)
+	obj := &v1alpha1.Manifest{}
+	s, err := filepath.NewStore(filepath.NewMemoryFS(), filepath.NewWatchSet(), codec, t.TempDir(),
+		"/core.tilt.dev/manifests", keyFunc, obj.New, obj.NewList)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	created := &v1alpha1.Manifest{}
+	require.NoError(t, s.Create(ctx, "/core.tilt.dev/manifests/a", &v1alpha1.Manifest{
+		ObjectMeta: metav1.ObjectMeta{Name: "a"},
+	}, created, 0))
+
+	list := &v1alpha1.ManifestList{}
+	require.NoError(t, s.GetList(ctx, "/core.tilt.dev/manifests", storage.ListOptions{
+		Recursive: true,
+		Predicate: storage.Everything,
+	}, list))
+
+	// nothing changed since the list, so the watch starts from it
+	w, err := s.Watch(ctx, "/core.tilt.dev/manifests", storage.ListOptions{
+		ResourceVersion: list.ResourceVersion,
+		Recursive:       true,
+		Predicate:       storage.Everything,
+	})
+	require.NoError(t, err)
+	defer w.Stop()
+
+	deleted := &v1alpha1.Manifest{}
+	done := make(chan error)
+	go func() {
+		done <- s.Delete(ctx, "/core.tilt.dev/manifests/a", deleted, nil, nil, nil, storage.DeleteOptions{})
+	}()
+	e := <-w.ResultChan()
+	require.NoError(t, <-done)
+	assert.Equal(t, watch.Deleted, e.Type)
+
+	// the delete has a revision of its own
+	eventVersion, err := s.Versioner().ObjectResourceVersion(e.Object)
+	require.NoError(t, err)
+	createdVersion, err := s.Versioner().ObjectResourceVersion(created)
+	require.NoError(t, err)
+	assert.Greater(t, eventVersion, createdVersion)
+
+	// the delete can't be replayed to a watch from before it
+	_, err = s.Watch(ctx, "/core.tilt.dev/manifests", storage.ListOptions{
+		ResourceVersion: list.ResourceVersion,
+		Recursive:       true,
+		Predicate:       storage.Everything,
+	})
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsResourceExpired(err), err.Error())
+	}
+}
+
+func keyFunc(obj runtime.Object) (string, error) {
+	return "/core.tilt.dev/manifests/" + obj.(*v1alpha1.Manifest).Name, nil
+}
+
+type decoratedRESTOptionsGetter struct {
+	codec     runtime.Codec
+	decorator generic.StorageDecorator
+}
+
+func (r decoratedRESTOptionsGetter) GetRESTOptions(resource schema.GroupResource, obj runtime.Object) (generic.RESTOptions, error) {
+	return generic.RESTOptions{
+		StorageConfig: &storagebackend.ConfigForResource{
+			GroupResource: resource,
+			Config: storagebackend.Config{
+				Codec: r.codec,
+			},
+		},
+		Decorator:      r.decorator,
+		ResourcePrefix: resource.Group + "/" + resource.Resource,
+	}, nil
+}
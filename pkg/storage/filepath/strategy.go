@@ -16,7 +16,12 @@ limitations under the License.
 
 package filepath
 
-import "k8s.io/apiserver/pkg/registry/rest"
+import (
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+)
 
 type Strategy interface {
 	rest.RESTUpdateStrategy
@@ -25,3 +30,12 @@ type Strategy interface {
 	rest.ShortNamesProvider
 	rest.SingularNameProvider
 }
+
+// Matcher is implemented by strategies that filter the objects returned by
+// list and watch requests, e.g. to support field selectors on fields other
+// than the object's name.
+//
+// Without it, objects are filtered on their labels and metadata.name.
+type Matcher interface {
+	Match(label labels.Selector, field fields.Selector) storage.SelectionPredicate
+}
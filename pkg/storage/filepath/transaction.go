@@ -0,0 +1,431 @@
+package filepath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/admission"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage/names"
+)
+
+// TransactionPath is the path of the transaction endpoint.
+const TransactionPath = "/transaction"
+
+type TransactionOperationType string
+
+const (
+	TransactionOperationCreate       TransactionOperationType = "create"
+	TransactionOperationUpdate       TransactionOperationType = "update"
+	TransactionOperationDelete       TransactionOperationType = "delete"
+	TransactionOperationPrecondition TransactionOperationType = "precondition"
+)
+
+// Transaction is a batch of operations that are committed together, or not at all.
+type Transaction struct {
+	Operations []TransactionOperation `json:"operations"`
+}
+
+// TransactionOperation is a single operation in a Transaction.
+type TransactionOperation struct {
+	Type TransactionOperationType `json:"type"`
+
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	// Name defaults to the name of the object for creates and updates.
+	Name string `json:"name,omitempty"`
+
+	// Object is the object to create, or the updated object.
+	//
+	// If the updated object has a resourceVersion, the update fails with a
+	// conflict unless it matches the current one.
+	Object runtime.RawExtension `json:"object,omitempty"`
+
+	// Preconditions must hold for an update, delete or precondition operation to succeed.
+	Preconditions *metav1.Preconditions `json:"preconditions,omitempty"`
+}
+
+// TransactionResult is the response to a committed Transaction.
+type TransactionResult struct {
+	// ResourceVersion is the revision of the commit.
+	ResourceVersion string `json:"resourceVersion"`
+
+	// Objects holds the object returned by each operation, in order.
+	//
+	// Deletes return the deleted object, and preconditions the current object.
+	Objects []runtime.RawExtension `json:"objects"`
+}
+
+// Transactor commits transactions across all the resources stored in the same MemoryFS.
+//
+// Resources join with the WithTransactor option. Each operation is authorized
+// as a request for the object it writes, and runs the storage strategy and, for
+// resources with the WithAdmission option, the admission plugins of its resource.
+type Transactor struct {
+	fs *MemoryFS
+
+	mu        sync.RWMutex
+	resources map[schema.GroupResource]*filepathREST
+}
+
+func NewTransactor(fs *MemoryFS) *Transactor {
+	return &Transactor{
+		fs:        fs,
+		resources: make(map[schema.GroupResource]*filepathREST),
+	}
+}
+
+// WithTransactor makes the resource available to transactions.
+//
+// Resources that aren't stored in the transactor's MemoryFS are ignored.
+func WithTransactor(t *Transactor) RESTOption {
+	return func(f *filepathREST) {
+		if fs, ok := f.fs.(*MemoryFS); !ok || fs != t.fs {
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.resources[f.groupResource] = f
+	}
+}
+
+// operationVerbs are the verbs that operations are authorized for.
+var operationVerbs = map[TransactionOperationType]string{
+	TransactionOperationCreate:       "create",
+	TransactionOperationUpdate:       "update",
+	TransactionOperationDelete:       "delete",
+	TransactionOperationPrecondition: "get",
+}
+
+// txnOp is an operation that's been checked against the current state of storage.
+type txnOp struct {
+	f    *filepathREST
+	ctx  context.Context
+	name string
+	verb string
+
+	// oldObj is the stored object, or nil on create.
+	oldObj runtime.Object
+	// obj is the object returned to the caller.
+	obj runtime.Object
+
+	// eventType is the watch event to send after the commit, or empty if none.
+	eventType watch.EventType
+	fsOp      fsOp
+}
+
+// Commit applies all of the operations in the transaction atomically.
+//
+// Watch events for the changed objects are sent after the commit, in order.
+func (t *Transactor) Commit(ctx context.Context, txn *Transaction) (*TransactionResult, error) {
+	if len(txn.Operations) == 0 {
+		return nil, apierrors.NewBadRequest("transaction has no operations")
+	}
+
+	// same as guaranteedUpdate, writes that race with the transaction cause
+	// it to be re-checked against the new state of storage
+	const maxAttempts = 100
+	for i := 0; i < maxAttempts; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ops, err := t.prepare(ctx, txn)
+		if err != nil {
+			return nil, err
+		}
+
+		fsOps := make([]fsOp, len(ops))
+		for i, op := range ops {
+			fsOps[i] = op.fsOp
+		}
+		version, err := t.fs.commit(fsOps)
+		if err != nil {
+			if IsConflict(err) || IsNotFound(err) || IsAlreadyExists(err) {
+				continue
+			}
+			return nil, apierrors.NewInternalError(err)
+		}
+		return t.finish(ops, version)
+	}
+	return nil, apierrors.NewInternalError(errors.New("failed to persist to storage"))
+}
+
+func (t *Transactor) prepare(ctx context.Context, txn *Transaction) ([]txnOp, error) {
+	ops := make([]txnOp, 0, len(txn.Operations))
+	paths := make(map[string]bool, len(txn.Operations))
+	for i, o := range txn.Operations {
+		op, err := t.prepareOp(ctx, o)
+		if err != nil {
+			return nil, operationError(i, err)
+		}
+		if paths[op.fsOp.path] {
+			return nil, operationError(i, apierrors.NewBadRequest(
+				fmt.Sprintf("%s %q appears in more than one operation", op.f.groupResource, op.name)))
+		}
+		paths[op.fsOp.path] = true
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (t *Transactor) prepareOp(ctx context.Context, o TransactionOperation) (txnOp, error) {
+	verb, ok := operationVerbs[o.Type]
+	if !ok {
+		return txnOp{}, apierrors.NewBadRequest(fmt.Sprintf("unknown operation type: %q", o.Type))
+	}
+	gr := schema.GroupResource{Group: o.Group, Resource: o.Resource}
+	t.mu.RLock()
+	f, ok := t.resources[gr]
+	t.mu.RUnlock()
+	if !ok {
+		return txnOp{}, apierrors.NewBadRequest(fmt.Sprintf("resource %s does not support transactions", gr))
+	}
+
+	if f.NamespaceScoped() && o.Namespace == "" {
+		return txnOp{}, apierrors.NewBadRequest(fmt.Sprintf("%s is namespaced, but no namespace was given", gr))
+	}
+	if !f.NamespaceScoped() && o.Namespace != "" {
+		return txnOp{}, apierrors.NewBadRequest(fmt.Sprintf("%s is not namespaced, but namespace %q was given", gr, o.Namespace))
+	}
+	ctx = genericapirequest.WithNamespace(ctx, o.Namespace)
+
+	op := txnOp{f: f, ctx: ctx, name: o.Name}
+	var obj runtime.Object
+	var objMeta metav1.Object
+	if o.Type == TransactionOperationCreate || o.Type == TransactionOperationUpdate {
+		var err error
+		obj, objMeta, err = f.decodeOperationObject(o)
+		if err != nil {
+			return txnOp{}, err
+		}
+		if op.name == "" {
+			op.name = objMeta.GetName()
+		}
+	}
+	if op.name == "" {
+		return txnOp{}, apierrors.NewBadRequest("name is required")
+	}
+	if err := f.authorize(ctx, verb, op.name); err != nil {
+		return txnOp{}, err
+	}
+
+	filename := f.objectFileName(ctx, op.name)
+	current, err := f.fs.Read(ctx, f.storageCodec, filename, f.newFunc)
+	if err != nil && !IsNotFound(err) {
+		return txnOp{}, interpretFSError(err, gr, op.name)
+	}
+	currentVersion, err := getResourceVersion(current)
+	if err != nil {
+		return txnOp{}, err
+	}
+	op.oldObj = current
+	op.fsOp = fsOp{path: filename, encoder: f.storageCodec, storageVersion: currentVersion}
+
+	if o.Type != TransactionOperationCreate {
+		if current == nil {
+			return txnOp{}, apierrors.NewNotFound(gr, op.name)
+		}
+		if err := f.checkPreconditions(op.name, current, o.Preconditions); err != nil {
+			return txnOp{}, err
+		}
+	}
+
+	switch o.Type {
+	case TransactionOperationCreate:
+		if current != nil {
+			return txnOp{}, apierrors.NewAlreadyExists(gr, op.name)
+		}
+		options := &metav1.CreateOptions{}
+		if err := f.mutate(ctx, admission.Create, op.name, obj, nil, options); err != nil {
+			return txnOp{}, err
+		}
+		rest.FillObjectMetaSystemFields(objMeta)
+		if err := rest.BeforeCreate(f.strategy, ctx, obj); err != nil {
+			return txnOp{}, err
+		}
+		if err := f.validate(ctx, admission.Create, op.name, obj, nil, options); err != nil {
+			return txnOp{}, err
+		}
+		op.verb = "create"
+		op.obj = obj
+		op.eventType = watch.Added
+		op.fsOp.obj = obj
+
+	case TransactionOperationUpdate:
+		objVersion, err := getResourceVersion(obj)
+		if err != nil {
+			return txnOp{}, err
+		}
+		if objVersion != 0 && objVersion != currentVersion {
+			return txnOp{}, f.conflictErr(op.name)
+		}
+		options := &metav1.UpdateOptions{}
+		if err := f.mutate(ctx, admission.Update, op.name, obj, current, options); err != nil {
+			return txnOp{}, err
+		}
+		if err := rest.BeforeUpdate(f.strategy, ctx, obj, current); err != nil {
+			return txnOp{}, err
+		}
+		if err := f.validate(ctx, admission.Update, op.name, obj, current, options); err != nil {
+			return txnOp{}, err
+		}
+		op.verb = "update"
+		op.obj = obj
+		op.eventType = watch.Modified
+		op.fsOp.obj = obj
+		if len(objMeta.GetFinalizers()) == 0 && !objMeta.GetDeletionTimestamp().IsZero() {
+			// the last finalizer was removed, so finish the delete
+			op.verb = "delete"
+			op.eventType = watch.Deleted
+			op.fsOp.obj = nil
+			op.fsOp.remove = true
+		}
+
+	case TransactionOperationDelete:
+		options := &metav1.DeleteOptions{}
+		if err := f.mutate(ctx, admission.Delete, op.name, nil, current, options); err != nil {
+			return txnOp{}, err
+		}
+		if err := f.validate(ctx, admission.Delete, op.name, nil, current, options); err != nil {
+			return txnOp{}, err
+		}
+		currentMeta, err := meta.Accessor(current)
+		if err != nil {
+			return txnOp{}, err
+		}
+		op.verb = "delete"
+		if len(currentMeta.GetFinalizers()) == 0 {
+			op.obj = current
+			op.eventType = watch.Deleted
+			op.fsOp.remove = true
+			break
+		}
+
+		// same as Delete, mark the object for deletion and leave it to the
+		// finalizers to remove it
+		obj = current.DeepCopyObject()
+		objMeta, err = meta.Accessor(obj)
+		if err != nil {
+			return txnOp{}, err
+		}
+		now := metav1.NewTime(time.Now())
+		if objMeta.GetDeletionTimestamp() == nil || now.Before(objMeta.GetDeletionTimestamp()) {
+			objMeta.SetDeletionTimestamp(&now)
+		}
+		zero := int64(0)
+		objMeta.SetDeletionGracePeriodSeconds(&zero)
+		op.obj = obj
+		op.eventType = watch.Modified
+		op.fsOp.obj = obj
+
+	case TransactionOperationPrecondition:
+		op.obj = current
+	}
+	return op, nil
+}
+
+// finish does the bookkeeping for a committed transaction, and builds the result.
+func (t *Transactor) finish(ops []txnOp, version uint64) (*TransactionResult, error) {
+	result := &TransactionResult{
+		ResourceVersion: formatResourceVersion(version),
+		Objects:         make([]runtime.RawExtension, 0, len(ops)),
+	}
+	for _, op := range ops {
+		f := op.f
+		if op.fsOp.remove {
+			f.forget(op.fsOp.path, op.obj)
+			f.logChange(op.ctx, op.verb, op.name, op.oldObj, nil)
+		} else if op.fsOp.obj != nil {
+			if err := f.recordRevision(op.fsOp.path, op.obj); err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+			f.logChange(op.ctx, op.verb, op.name, op.oldObj, op.obj)
+		}
+
+		raw, err := runtime.Encode(f.codec, op.obj)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		result.Objects = append(result.Objects, runtime.RawExtension{Raw: raw})
+	}
+
+	for _, op := range ops {
+		if op.eventType != "" {
+			op.f.notifyWatchers(watch.Event{
+				Type:   op.eventType,
+				Object: op.obj,
+			})
+		}
+	}
+	return result, nil
+}
+
+func (f *filepathREST) decodeOperationObject(o TransactionOperation) (runtime.Object, metav1.Object, error) {
+	if len(o.Object.Raw) == 0 {
+		return nil, nil, apierrors.NewBadRequest(fmt.Sprintf("%s operation requires an object", o.Type))
+	}
+	obj, _, err := f.codec.Decode(o.Object.Raw, nil, f.newFunc())
+	if err != nil {
+		return nil, nil, apierrors.NewBadRequest(fmt.Sprintf("decoding object: %v", err))
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	if o.Name != "" && objMeta.GetName() != "" && o.Name != objMeta.GetName() {
+		return nil, nil, apierrors.NewBadRequest(
+			fmt.Sprintf("the name of the object (%s) does not match the name of the operation (%s)", objMeta.GetName(), o.Name))
+	}
+	if o.Name != "" {
+		objMeta.SetName(o.Name)
+	}
+	if objMeta.GetName() == "" && objMeta.GetGenerateName() != "" && o.Type == TransactionOperationCreate {
+		objMeta.SetName(names.SimpleNameGenerator.GenerateName(objMeta.GetGenerateName()))
+	}
+	return obj, objMeta, nil
+}
+
+func (f *filepathREST) checkPreconditions(name string, obj runtime.Object, preconditions *metav1.Preconditions) error {
+	if preconditions == nil {
+		return nil
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if preconditions.UID != nil && *preconditions.UID != objMeta.GetUID() {
+		return apierrors.NewConflict(f.groupResource, name, fmt.Errorf(
+			"Precondition failed: UID in precondition: %v, UID in object meta: %v", *preconditions.UID, objMeta.GetUID()))
+	}
+	if preconditions.ResourceVersion != nil && *preconditions.ResourceVersion != objMeta.GetResourceVersion() {
+		return apierrors.NewConflict(f.groupResource, name, fmt.Errorf(
+			"Precondition failed: ResourceVersion in precondition: %v, ResourceVersion in object meta: %v",
+			*preconditions.ResourceVersion, objMeta.GetResourceVersion()))
+	}
+	return nil
+}
+
+// operationError prefixes the error message with the index of the failed
+// operation, keeping the status of API errors.
+func operationError(i int, err error) error {
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		return apierrors.NewInternalError(fmt.Errorf("operations[%d]: %v", i, err))
+	}
+	status := statusErr.Status()
+	status.Message = fmt.Sprintf("operations[%d]: %s", i, status.Message)
+	return &apierrors.StatusError{ErrStatus: status}
+}
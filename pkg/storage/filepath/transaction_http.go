@@ -0,0 +1,147 @@
+package filepath
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	restclient "k8s.io/client-go/rest"
+)
+
+// The same limit the apiserver applies to resource request bodies.
+const maxTransactionBytes = 3 * 1024 * 1024
+
+var _ http.Handler = &Transactor{}
+
+// ServeHTTP commits a JSON Transaction POSTed to the endpoint, and responds
+// with a TransactionResult, or a Status on failure.
+func (t *Transactor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeStatusError(w, apierrors.NewMethodNotSupported(
+			schema.GroupResource{Resource: "transaction"}, req.Method))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxTransactionBytes+1))
+	if err != nil {
+		writeStatusError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	if len(body) > maxTransactionBytes {
+		writeStatusError(w, apierrors.NewRequestEntityTooLargeError(
+			fmt.Sprintf("limit is %d", maxTransactionBytes)))
+		return
+	}
+
+	txn := &Transaction{}
+	if err := json.Unmarshal(body, txn); err != nil {
+		writeStatusError(w, apierrors.NewBadRequest(fmt.Sprintf("decoding transaction: %v", err)))
+		return
+	}
+
+	result, err := t.Commit(req.Context(), txn)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	responsewriters.WriteRawJSON(http.StatusOK, result, w)
+}
+
+func writeStatusError(w http.ResponseWriter, err error) {
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		statusErr = apierrors.NewInternalError(err)
+	}
+	status := statusErr.Status()
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	responsewriters.WriteRawJSON(int(status.Code), status, w)
+}
+
+// CommitTransaction sends a transaction to the transaction endpoint of the server.
+func CommitTransaction(ctx context.Context, client restclient.Interface, txn *Transaction) (*TransactionResult, error) {
+	body, err := json.Marshal(txn)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := client.Post().
+		AbsPath(TransactionPath).
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TransactionResult{}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return nil, fmt.Errorf("decoding transaction result: %v", err)
+	}
+	return result, nil
+}
+
+// NewCreateOperation returns an operation that creates the object.
+func NewCreateOperation(gr schema.GroupResource, obj runtime.Object) (TransactionOperation, error) {
+	return newObjectOperation(TransactionOperationCreate, gr, obj)
+}
+
+// NewUpdateOperation returns an operation that updates the object.
+//
+// If the object has a resourceVersion, it must match the stored object.
+func NewUpdateOperation(gr schema.GroupResource, obj runtime.Object) (TransactionOperation, error) {
+	return newObjectOperation(TransactionOperationUpdate, gr, obj)
+}
+
+func newObjectOperation(t TransactionOperationType, gr schema.GroupResource, obj runtime.Object) (TransactionOperation, error) {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return TransactionOperation{}, err
+	}
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return TransactionOperation{}, err
+	}
+	return TransactionOperation{
+		Type:      t,
+		Group:     gr.Group,
+		Resource:  gr.Resource,
+		Namespace: objMeta.GetNamespace(),
+		Name:      objMeta.GetName(),
+		Object:    runtime.RawExtension{Raw: raw},
+	}, nil
+}
+
+// NewDeleteOperation returns an operation that deletes the named object.
+func NewDeleteOperation(gr schema.GroupResource, namespace, name string, preconditions *metav1.Preconditions) TransactionOperation {
+	return TransactionOperation{
+		Type:          TransactionOperationDelete,
+		Group:         gr.Group,
+		Resource:      gr.Resource,
+		Namespace:     namespace,
+		Name:          name,
+		Preconditions: preconditions,
+	}
+}
+
+// NewPreconditionOperation returns an operation that fails the transaction
+// unless the named object exists and matches the preconditions.
+func NewPreconditionOperation(gr schema.GroupResource, namespace, name string, preconditions *metav1.Preconditions) TransactionOperation {
+	return TransactionOperation{
+		Type:          TransactionOperationPrecondition,
+		Group:         gr.Group,
+		Resource:      gr.Resource,
+		Namespace:     namespace,
+		Name:          name,
+		Preconditions: preconditions,
+	}
+}
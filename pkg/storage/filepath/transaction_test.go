@@ -0,0 +1,184 @@
+package filepath_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/tilt-dev/tilt-apiserver/pkg/apis/core/v1alpha1"
+	builderrest "github.com/tilt-dev/tilt-apiserver/pkg/server/builder/rest"
+	"github.com/tilt-dev/tilt-apiserver/pkg/storage/filepath"
+)
+
+var manifestsResource = (&v1alpha1.Manifest{}).GetGroupVersionResource().GroupResource()
+
+func TestTransactor_Commit(t *testing.T) {
+	f, transactor := newTransactionFixture(t)
+	defer f.tearDown()
+
+	w := f.watch("a")
+
+	txn := &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			mustCreateOperation(t, &v1alpha1.Manifest{
+				ObjectMeta: metav1.ObjectMeta{Name: "a"},
+				Spec:       v1alpha1.ManifestSpec{Message: "parent"},
+			}),
+			mustCreateOperation(t, &v1alpha1.Manifest{
+				ObjectMeta: metav1.ObjectMeta{Name: "b"},
+				Spec:       v1alpha1.ManifestSpec{Message: "child"},
+			}),
+		},
+	}
+
+	// events are sent synchronously, so the watch has to be read while committing
+	var result *filepath.TransactionResult
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result, err = transactor.Commit(f.rootCtx, txn)
+	}()
+	e := <-w.ResultChan()
+	<-done
+	require.NoError(t, err)
+	require.Len(t, result.Objects, 2)
+
+	// every object in the commit has the same revision
+	for _, name := range []string{"a", "b"} {
+		obj, err := f.get(name)
+		require.NoError(t, err)
+		assert.Equal(t, result.ResourceVersion, f.mustMeta(obj).GetResourceVersion())
+	}
+
+	assert.Equal(t, watch.Added, e.Type)
+	assert.Equal(t, result.ResourceVersion, f.mustMeta(e.Object).GetResourceVersion())
+
+	var a v1alpha1.Manifest
+	require.NoError(t, json.Unmarshal(result.Objects[0].Raw, &a))
+	assert.Equal(t, "parent", a.Spec.Message)
+	a.Spec.Message = "updated parent"
+
+	w.Stop()
+	result, err = transactor.Commit(f.rootCtx, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			mustUpdateOperation(t, &a),
+			filepath.NewDeleteOperation(manifestsResource, "", "b", nil),
+		},
+	})
+	require.NoError(t, err)
+
+	obj, err := f.get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "updated parent", obj.(*v1alpha1.Manifest).Spec.Message)
+	f.mustNotExist("b")
+}
+
+func TestTransactor_FailedOperationCommitsNothing(t *testing.T) {
+	f, transactor := newTransactionFixture(t)
+	defer f.tearDown()
+
+	_, err := transactor.Commit(f.rootCtx, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			mustCreateOperation(t, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}),
+		},
+	})
+	require.NoError(t, err)
+
+	staleVersion := "1"
+	_, err = transactor.Commit(f.rootCtx, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			mustCreateOperation(t, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "b"}}),
+			filepath.NewPreconditionOperation(manifestsResource, "", "a",
+				&metav1.Preconditions{ResourceVersion: &staleVersion}),
+		},
+	})
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsConflict(err), err.Error())
+		assert.Contains(t, err.Error(), "operations[1]")
+	}
+	f.mustNotExist("b")
+
+	_, err = transactor.Commit(f.rootCtx, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			mustCreateOperation(t, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "b"}}),
+			mustCreateOperation(t, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}),
+		},
+	})
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsAlreadyExists(err), err.Error())
+	}
+	f.mustNotExist("b")
+}
+
+func TestTransactor_AuthorizesEachOperation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	a := filepath.NewAdmission(scheme)
+	a.SetChain(authorizer.AuthorizerFunc(func(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+		if attrs.GetVerb() == "delete" && attrs.GetResource() == "manifests" && attrs.GetName() == "a" {
+			return authorizer.DecisionDeny, "a may not be deleted", nil
+		}
+		return authorizer.DecisionAllow, "", nil
+	}), nil)
+
+	fs := filepath.NewMemoryFS()
+	transactor := filepath.NewTransactor(fs)
+	f := newRESTFixtureWithFS(t, fs, func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+		return defaultStrategy
+	}, filepath.WithTransactor(transactor), filepath.WithAdmission(a))
+	defer f.tearDown()
+
+	_, err := transactor.Commit(f.rootCtx, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			mustCreateOperation(t, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "a"}}),
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = transactor.Commit(f.rootCtx, &filepath.Transaction{
+		Operations: []filepath.TransactionOperation{
+			mustCreateOperation(t, &v1alpha1.Manifest{ObjectMeta: metav1.ObjectMeta{Name: "b"}}),
+			filepath.NewDeleteOperation(manifestsResource, "", "a", nil),
+		},
+	})
+	if assert.Error(t, err) {
+		assert.True(t, apierrors.IsForbidden(err), err.Error())
+		assert.Contains(t, err.Error(), "operations[1]")
+	}
+	f.mustNotExist("b")
+	_, err = f.get("a")
+	assert.NoError(t, err)
+}
+
+func newTransactionFixture(t *testing.T) (*restFixture, *filepath.Transactor) {
+	t.Helper()
+	fs := filepath.NewMemoryFS()
+	transactor := filepath.NewTransactor(fs)
+	f := newRESTFixtureWithFS(t, fs, func(defaultStrategy builderrest.Strategy) builderrest.Strategy {
+		return defaultStrategy
+	}, filepath.WithTransactor(transactor))
+	return f, transactor
+}
+
+func mustCreateOperation(t *testing.T, obj *v1alpha1.Manifest) filepath.TransactionOperation {
+	t.Helper()
+	op, err := filepath.NewCreateOperation(manifestsResource, obj)
+	require.NoError(t, err)
+	return op
+}
+
+func mustUpdateOperation(t *testing.T, obj *v1alpha1.Manifest) filepath.TransactionOperation {
+	t.Helper()
+	op, err := filepath.NewUpdateOperation(manifestsResource, obj)
+	require.NoError(t, err)
+	return op
+}
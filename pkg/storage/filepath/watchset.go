@@ -2,6 +2,7 @@ package filepath
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/apiserver/pkg/storage"
@@ -12,6 +13,9 @@ type WatchSet struct {
 	mu      sync.RWMutex
 	nodes   map[int]*watchNode
 	counter int
+
+	// revision is the newest revision of the changes sent to watchers.
+	revision atomic.Uint64
 }
 
 func NewWatchSet() *WatchSet {
@@ -37,8 +41,28 @@ func (s *WatchSet) newWatch() *watchNode {
 	}
 }
 
+// observe records that watchers have been sent every change up to the
+// revision, or that changes before it are unknown.
+func (s *WatchSet) observe(rev uint64) {
+	for {
+		current := s.revision.Load()
+		if rev <= current || s.revision.CompareAndSwap(current, rev) {
+			return
+		}
+	}
+}
+
 func (s *WatchSet) notifyWatchers(ev watch.Event) {
 	s.mu.RLock()
+	// under the lock, so that a watch that starts sees either the revision
+	// of the event or the event itself
+	if rev, err := getResourceVersion(unwrapCachingObject(ev.Object)); err == nil {
+		s.observe(rev)
+	}
+	if len(s.nodes) > 1 {
+		// every watcher gets the same event, so share its encoding
+		ev.Object = newCachingObject(ev.Object)
+	}
 	for _, w := range s.nodes {
 		w.updateCh <- ev
 	}
@@ -52,17 +76,18 @@ type watchNode struct {
 	updateCh chan watch.Event
 	outCh    chan watch.Event
 	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
 // Start sending events to this watch.
 func (w *watchNode) Start(p storage.SelectionPredicate, initEventFactory func() ([]watch.Event, error)) error {
 	w.s.mu.Lock()
 	defer w.s.mu.Unlock()
-	w.s.nodes[w.id] = w
 	initEvents, err := initEventFactory()
 	if err != nil {
 		return err
 	}
+	w.s.nodes[w.id] = w
 
 	go func() {
 		// When writing to outCh, we always check stopCh too
@@ -76,7 +101,7 @@ func (w *watchNode) Start(p storage.SelectionPredicate, initEventFactory func()
 		}
 
 		for e := range w.updateCh {
-			ok, err := p.Matches(e.Object)
+			ok, err := p.Matches(unwrapCachingObject(e.Object))
 			if err != nil {
 				continue
 			}
@@ -97,13 +122,15 @@ func (w *watchNode) Start(p storage.SelectionPredicate, initEventFactory func()
 }
 
 func (w *watchNode) Stop() {
-	close(w.stopCh)
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
 
-	w.s.mu.Lock()
-	delete(w.s.nodes, w.id)
-	w.s.mu.Unlock()
+		w.s.mu.Lock()
+		delete(w.s.nodes, w.id)
+		w.s.mu.Unlock()
 
-	close(w.updateCh)
+		close(w.updateCh)
+	})
 }
 
 func (w *watchNode) ResultChan() <-chan watch.Event {